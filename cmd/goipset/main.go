@@ -0,0 +1,207 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command goipset exercises the go-ipset library from the command line, so the
+// code paths used by daemons embedding the library can be reproduced by hand.
+//
+// Usage:
+//
+//	goipset [-ipset path] <command> [arguments]
+//
+// The commands are:
+//
+//	ensure [set options] NAME        create the set unless it already exists
+//	refresh [set options] NAME FILE  replace the members of the set with the entries in FILE
+//	save [NAME]                      print the set (or all sets) in `ipset save` format
+//	restore [FILE]                   restore sets saved by save, from FILE or stdin
+//	diff NAME FILE                   show how the members of the set differ from FILE
+//	watch [-interval 2s] NAME        print the changes of the set as they happen
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+var (
+	// handle runs the commands, replaced by the tests
+	handle = ipset.NewHandle()
+	// stdout receives the output of the commands
+	stdout io.Writer = os.Stdout
+)
+
+func main() {
+	ipsetBin := flag.String("ipset", "", "path or name of the ipset utility")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	if err := handle.Init(*ipsetBin); err != nil {
+		fatal(err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch cmd {
+	case "ensure":
+		err = ensure(args)
+	case "refresh":
+		err = refresh(args)
+	case "save":
+		err = save(args)
+	case "restore":
+		err = restore(args)
+	case "diff":
+		err = diff(args)
+	case "watch":
+		err = watch(args)
+	default:
+		fmt.Fprintf(os.Stderr, "goipset: unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: goipset [-ipset path] <command> [arguments]
+
+commands:
+  ensure [set options] NAME        create the set unless it already exists
+  refresh [set options] NAME FILE  replace the members of the set with the entries in FILE
+  save [NAME]                      print the set (or all sets) in ipset save format
+  restore [FILE]                   restore sets saved by save, from FILE or stdin
+  diff NAME FILE                   show how the members of the set differ from FILE
+  watch [-interval 2s] NAME        print the changes of the set as they happen
+
+set options:
+  -type hash:ip -family inet -hashsize 1024 -maxelem 65536 -timeout 0
+`)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "goipset: %v\n", err)
+	os.Exit(1)
+}
+
+// setFlags registers the options of ipset.New on fs.
+func setFlags(fs *flag.FlagSet) (hashtype *string, p *ipset.Params) {
+	p = &ipset.Params{}
+	hashtype = fs.String("type", "hash:ip", "set type")
+	fs.StringVar(&p.HashFamily, "family", "", "address family (default inet)")
+	fs.IntVar(&p.HashSize, "hashsize", 0, "initial hash size (default 1024)")
+	fs.IntVar(&p.MaxElem, "maxelem", 0, "maximal number of elements (default 65536)")
+	fs.IntVar(&p.Timeout, "timeout", 0, "default entry timeout in seconds")
+	return
+}
+
+func ensure(args []string) error {
+	fs := flag.NewFlagSet("ensure", flag.ExitOnError)
+	hashtype, p := setFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("ensure: expecting NAME")
+	}
+	_, err := handle.New(fs.Arg(0), *hashtype, p)
+	return err
+}
+
+func refresh(args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	hashtype, p := setFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("refresh: expecting NAME FILE")
+	}
+	entries, err := readEntries(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	s, err := handle.New(fs.Arg(0), *hashtype, p)
+	if err != nil {
+		return err
+	}
+	return s.Refresh(entries)
+}
+
+func save(args []string) error {
+	name := ipset.AllSets
+	switch len(args) {
+	case 0:
+	case 1:
+		name = args[0]
+	default:
+		return fmt.Errorf("save: expecting at most one NAME")
+	}
+	w := bufio.NewWriter(stdout)
+	if err := handle.Save(name, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func restore(args []string) error {
+	var r io.Reader = os.Stdin
+	switch len(args) {
+	case 0:
+	case 1:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return fmt.Errorf("restore: expecting at most one FILE")
+	}
+	return handle.Restore(r)
+}
+
+func diff(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("diff: expecting NAME FILE")
+	}
+	entries, err := readEntries(args[1])
+	if err != nil {
+		return err
+	}
+	added, removed, err := handle.Set(args[0]).Diff(entries)
+	if err != nil {
+		return err
+	}
+	for _, e := range added {
+		fmt.Fprintln(stdout, "+"+e)
+	}
+	for _, e := range removed {
+		fmt.Fprintln(stdout, "-"+e)
+	}
+	return nil
+}
+
+// readEntries reads one entry per line from the named file ("-" for stdin),
+// skipping blank lines and lines starting with '#'.
+func readEntries(path string) ([]string, error) {
+	return ipset.ReadEntriesFile(path, ipset.FileFormat{})
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// useNoop makes the commands run against the no-op backend, their output
+// going to the returned buffer, for the duration of the test.
+func useNoop(t *testing.T) (*ipset.NoopRecorder, *bytes.Buffer) {
+	r := &ipset.NoopRecorder{}
+	out := &bytes.Buffer{}
+	oldHandle, oldStdout := handle, stdout
+	handle, stdout = ipset.NewHandle(ipset.WithNoopBackend(r)), out
+	t.Cleanup(func() { handle, stdout = oldHandle, oldStdout })
+	return r, out
+}
+
+// writeEntries writes the lines to a file of the test and returns its path.
+func writeEntries(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "entries")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// commandLines returns the recorded commands, one per line.
+func commandLines(r *ipset.NoopRecorder) string {
+	var lines []string
+	for _, c := range r.Commands() {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestSetFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	hashtype, p := setFlags(fs)
+	if err := fs.Parse([]string{"-type", "hash:net", "-family", "inet6", "-hashsize", "4096", "-maxelem", "100", "-timeout", "60", "app-a"}); err != nil {
+		t.Fatal(err)
+	}
+	want := ipset.Params{HashFamily: "inet6", HashSize: 4096, MaxElem: 100, Timeout: 60}
+	if *hashtype != "hash:net" || *p != want || fs.Arg(0) != "app-a" {
+		t.Errorf("parsed type %s, params %+v, args %v", *hashtype, *p, fs.Args())
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	setFlags(fs)
+	if err := fs.Parse([]string{"-hashsize", "big"}); err == nil {
+		t.Error("non-numeric hash size accepted")
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	r, _ := useNoop(t)
+	if err := ensure([]string{"-type", "hash:net", "-timeout", "60", "app-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := commandLines(r); !strings.Contains(cmds, "ipset create app-a hash:net") || !strings.Contains(cmds, "timeout 60") {
+		t.Errorf("ensure ran:\n%s", cmds)
+	}
+	r.Reset()
+	for _, args := range [][]string{nil, {"app-a", "app-b"}} {
+		if err := ensure(args); err == nil {
+			t.Errorf("ensure %v accepted", args)
+		}
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("invalid ensure ran %v", cmds)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	r, _ := useNoop(t)
+	file := writeEntries(t, "# allowed clients", "192.0.2.1", "", "192.0.2.2")
+	if err := refresh([]string{"app-a", file}); err != nil {
+		t.Fatal(err)
+	}
+	var input string
+	for _, c := range r.Commands() {
+		input += c.Input
+	}
+	if !strings.Contains(input, " 192.0.2.1") || !strings.Contains(input, " 192.0.2.2") || strings.Contains(input, "allowed") {
+		t.Errorf("refresh restored %q", input)
+	}
+	if err := refresh([]string{"app-a"}); err == nil {
+		t.Error("refresh without FILE accepted")
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	r, out := useNoop(t)
+	if err := save([]string{"app-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := commandLines(r); cmds != "ipset save app-a" || out.Len() != 0 {
+		t.Errorf("save ran %q, printed %q", cmds, out)
+	}
+	if err := save([]string{"app-a", "app-b"}); err == nil {
+		t.Error("save of two sets accepted")
+	}
+
+	r.Reset()
+	saved := "create app-a hash:ip family inet hashsize 1024 maxelem 65536\nadd app-a 192.0.2.1\n"
+	if err := restore([]string{writeEntries(t, strings.TrimSuffix(saved, "\n"))}); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := r.Commands(); len(cmds) != 1 || cmds[0].Args[0] != "restore" || cmds[0].Input != saved {
+		t.Errorf("restore ran %+v", cmds)
+	}
+	if err := restore([]string{filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("restore of a missing file accepted")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	_, out := useNoop(t)
+	// the no-op backend lists the set empty: every entry of the file is new
+	if err := diff([]string{"app-a", writeEntries(t, "192.0.2.2", "192.0.2.1")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "+192.0.2.2\n+192.0.2.1\n" && got != "+192.0.2.1\n+192.0.2.2\n" {
+		t.Errorf("diff printed %q", got)
+	}
+	if err := diff([]string{"app-a"}); err == nil {
+		t.Error("diff without FILE accepted")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// ANSI colors of the watch output
+const (
+	colorReset  = "\033[0m"
+	colorGreen  = "\033[32m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorBold   = "\033[1m"
+)
+
+// watcher prints how a set changes between polls.
+type watcher struct {
+	w        io.Writer
+	color    bool
+	interval time.Duration
+	prev     map[string]ipset.Entry
+	stats    ipset.Stats
+	started  bool
+}
+
+func watch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "time between polls")
+	count := fs.Int("count", 0, "stop after that many polls (default never)")
+	noColor := fs.Bool("no-color", false, "do not color the output")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("watch: expecting NAME")
+	}
+	s := handle.Set(fs.Arg(0))
+	f, ok := stdout.(*os.File)
+	wt := &watcher{w: stdout, color: !*noColor && ok && isTerminal(f), interval: *interval}
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		if err := wt.poll(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poll lists the set and prints the entries added, removed and expired since
+// the previous poll, followed by the entry count and memory size trend.
+func (wt *watcher) poll(s *ipset.IPSet) error {
+	entries, err := s.ListEntries()
+	if err != nil {
+		return err
+	}
+	stats, err := s.Statistics()
+	if err != nil {
+		return err
+	}
+	cur := make(map[string]ipset.Entry, len(entries))
+	for _, e := range entries {
+		cur[e.Element] = e
+	}
+	now := time.Now().Format("15:04:05")
+	if wt.started {
+		var added, gone []string
+		for el := range cur {
+			if _, ok := wt.prev[el]; !ok {
+				added = append(added, el)
+			}
+		}
+		for el := range wt.prev {
+			if _, ok := cur[el]; !ok {
+				gone = append(gone, el)
+			}
+		}
+		sort.Strings(added)
+		sort.Strings(gone)
+		for _, el := range added {
+			wt.printf(colorGreen, "%s + %s\n", now, cur[el])
+		}
+		for _, el := range gone {
+			// an entry due to time out before this poll expired rather than
+			// being deleted
+			if t := wt.prev[el].Timeout; t > 0 && time.Duration(t)*time.Second <= wt.interval {
+				wt.printf(colorYellow, "%s ~ %s (expired)\n", now, el)
+			} else {
+				wt.printf(colorRed, "%s - %s\n", now, el)
+			}
+		}
+	}
+	wt.printf(colorBold, "%s %s: %d entries%s, %d bytes%s\n", now, s.Name,
+		stats.Entries, wt.trend(stats.Entries, wt.stats.Entries), stats.Size, wt.trend(stats.Size, wt.stats.Size))
+	wt.prev, wt.stats, wt.started = cur, stats, true
+	return nil
+}
+
+// trend returns the change from prev to cur, empty on the first poll.
+func (wt *watcher) trend(cur, prev uint64) string {
+	if !wt.started {
+		return ""
+	}
+	return fmt.Sprintf(" (%+d)", int64(cur)-int64(prev))
+}
+
+func (wt *watcher) printf(color, format string, args ...interface{}) {
+	if !wt.color {
+		fmt.Fprintf(wt.w, format, args...)
+		return
+	}
+	// reset before the newline, where terminals would carry the color over
+	line := fmt.Sprintf(format, args...)
+	nl := ""
+	if strings.HasSuffix(line, "\n") {
+		line, nl = line[:len(line)-1], "\n"
+	}
+	fmt.Fprint(wt.w, color+line+colorReset+nl)
+}
+
+// isTerminal tells whether f is a character device, as terminals are.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	_, out := useNoop(t)
+	if err := watch([]string{"-count", "2", "-interval", "1ms", "-no-color", "app-a"}); err != nil {
+		t.Fatal(err)
+	}
+	// the first poll has no trend to show
+	want := regexp.MustCompile(`^\d\d:\d\d:\d\d app-a: 0 entries, 0 bytes\n\d\d:\d\d:\d\d app-a: 0 entries \(\+0\), 0 bytes \(\+0\)\n$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("watch printed %q", out)
+	}
+	if err := watch([]string{"-count", "1"}); err == nil {
+		t.Error("watch without NAME accepted")
+	}
+}
+
+func TestWatcherColor(t *testing.T) {
+	var out bytes.Buffer
+	wt := &watcher{w: &out, color: true}
+	wt.printf(colorGreen, "%s + %s\n", "12:00:00", "192.0.2.1")
+	if want := colorGreen + "12:00:00 + 192.0.2.1" + colorReset + "\n"; out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+}
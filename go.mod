@@ -4,6 +4,9 @@ go 1.15
 
 require (
 	github.com/coreos/go-semver v0.3.0
+	github.com/prometheus/client_golang v1.9.0
 	github.com/sirupsen/logrus v1.7.0
+	golang.org/x/sys v0.0.0-20201214210602-f9fddec55a1e
+	google.golang.org/grpc v1.34.0
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,127 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrHeaderMismatch is returned when creating a set that already exists with
+// a different type or different parameters.
+var ErrHeaderMismatch = errors.New("existing set does not match the requested parameters")
+
+// ErrSetExists is returned by an exclusive creation of a set whose name is taken.
+var ErrSetExists = errors.New("set already exists")
+
+// setExists reports whether the named set exists.
+func (h *Handle) setExists(name string) (bool, error) {
+	names, err := h.listAllSetNames()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mismatches returns how the header of an existing set differs from the
+// parameters of s. The kernel grows the hash of a set as it fills and ipset
+// rounds hash sizes to a power of two, so only a smaller hash size than
+// requested is a mismatch.
+func (s *IPSet) mismatches(hd Header) []string {
+	var diffs []string
+	differ := func(what string, have, want interface{}) {
+		diffs = append(diffs, fmt.Sprintf("%s %v instead of %v", what, have, want))
+	}
+	if hd.Type != s.HashType {
+		differ("type", hd.Type, s.HashType)
+		return diffs
+	}
+	if isBitmapType(s.HashType) {
+		if hd.Range != s.Range {
+			differ("range", hd.Range, s.Range)
+		}
+		return diffs
+	}
+	if hd.Family != "" && hd.Family != s.HashFamily {
+		differ("family", hd.Family, s.HashFamily)
+	}
+	if hd.HashSize != 0 && hd.HashSize < s.HashSize {
+		differ("hashsize", hd.HashSize, s.HashSize)
+	}
+	if hd.MaxElem != 0 && hd.MaxElem != s.MaxElem {
+		differ("maxelem", hd.MaxElem, s.MaxElem)
+	}
+	if s.Comment && !hd.Comment {
+		diffs = append(diffs, "no comment support")
+	}
+	return diffs
+}
+
+// adopt checks that the set, if it exists, matches the parameters of s, and
+// reports whether it is kept. A mismatching set is an ErrHeaderMismatch,
+// unless recreate is set: the set is then replaced with an empty one. If the
+// type and family are unchanged, the new set is swapped in, which keeps
+// iptables rules referencing it valid; otherwise it is destroyed first, which
+// fails while it is referenced.
+func (s *IPSet) adopt(recreate bool) (bool, error) {
+	h := s.handle()
+	exists, err := h.setExists(s.Name)
+	if err != nil || !exists {
+		return false, err
+	}
+	hd, err := h.header(s.Name)
+	if err != nil {
+		return false, err
+	}
+	diffs := s.mismatches(hd)
+	if len(diffs) == 0 {
+		return true, nil
+	}
+	if !recreate {
+		return false, fmt.Errorf("%w: set %s has %s", ErrHeaderMismatch, s.Name, strings.Join(diffs, ", "))
+	}
+	// either way the old set is gone
+	if err := h.checkPolicy(Operation{Op: OpDestroy, Set: s.Name}); err != nil {
+		return false, err
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if hd.Type != s.HashType || (hd.Family != "" && hd.Family != s.HashFamily) {
+		return false, h.destroyIPSet(s.Name)
+	}
+	tmp := tempSetName(s.Name, "-new")
+	if err := h.destroyIPSet(tmp); err != nil {
+		return false, err
+	}
+	if err := s.createHashSet(tmp); err != nil {
+		return false, err
+	}
+	if err := h.swap(tmp, s.Name); err != nil {
+		h.destroyIPSet(tmp)
+		return false, err
+	}
+	return false, h.destroyIPSet(tmp)
+}
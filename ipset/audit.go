@@ -0,0 +1,119 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// AuditRecord describes a mutation of sets attempted through a handle.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Who identifies the actor, see WithAudit.
+	Who string `json:"who"`
+	// Op is the operation: create, add, del, flush, swap, destroy or
+	// destroy-all.
+	Op  string `json:"op"`
+	Set string `json:"set,omitempty"`
+	// Other is the second set of a swap.
+	Other   string   `json:"other,omitempty"`
+	Entries []string `json:"entries,omitempty"`
+	// Error is the error of a failed operation, empty on success.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink records audit records.
+type AuditSink interface {
+	Audit(r AuditRecord) error
+}
+
+// WithAudit records every mutation of sets attempted through the handle,
+// successful or not, in sink. who identifies the actor in the records; when
+// empty, the uid, pid and name of the process are used. Failures to record
+// are logged.
+func WithAudit(sink AuditSink, who string) Option {
+	if who == "" {
+		who = fmt.Sprintf("uid=%d pid=%d %s", os.Getuid(), os.Getpid(), filepath.Base(os.Args[0]))
+	}
+	return func(h *Handle) {
+		h.auditSink = sink
+		h.auditWho = who
+	}
+}
+
+// audit records a mutation with the audit sink of the handle.
+func (h *Handle) audit(c change) {
+	if h.auditSink == nil {
+		return
+	}
+	r := AuditRecord{
+		Time:    time.Now(),
+		Who:     h.auditWho,
+		Op:      c.op.String(),
+		Set:     c.set,
+		Other:   c.other,
+		Entries: c.entries,
+	}
+	if c.err != nil {
+		r.Error = c.err.Error()
+	}
+	if err := h.auditSink.Audit(r); err != nil {
+		log.Errorf("Error recording audit record of %s on set %s: %v", r.Op, r.Set, err)
+	}
+}
+
+// JSONFileAudit is an AuditSink appending records to a file, one JSON object
+// per line.
+type JSONFileAudit struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewJSONFileAudit returns a sink appending to the file at path, created if
+// needed.
+func NewJSONFileAudit(path string) (*JSONFileAudit, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	return &JSONFileAudit{f: f}, nil
+}
+
+// Audit implements AuditSink.
+func (a *JSONFileAudit) Audit(r AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// a single write per record keeps lines whole with O_APPEND
+	_, err = a.f.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (a *JSONFileAudit) Close() error {
+	return a.f.Close()
+}
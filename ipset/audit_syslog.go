@@ -0,0 +1,58 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAudit is an AuditSink sending records to the local syslog daemon,
+// and so to journald where it provides the syslog socket, as JSON messages
+// of the authpriv facility.
+type SyslogAudit struct {
+	w *syslog.Writer
+}
+
+// NewSyslogAudit returns a sink logging with the given tag.
+func NewSyslogAudit(tag string) (*SyslogAudit, error) {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTHPRIV, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAudit{w: w}, nil
+}
+
+// Audit implements AuditSink. Failed operations are logged as warnings.
+func (a *SyslogAudit) Audit(r AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if r.Error != "" {
+		return a.w.Warning(string(data))
+	}
+	return a.w.Notice(string(data))
+}
+
+// Close closes the connection to the syslog daemon.
+func (a *SyslogAudit) Close() error {
+	return a.w.Close()
+}
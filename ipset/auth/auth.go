@@ -0,0 +1,169 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth authenticates and authorizes the clients of the server and
+// httpapi subpackages, so that exposing set management on a socket does not
+// hand every connecting process the privileges of the agent.
+//
+// Clients authenticate with a bearer token or, over TLS, with a client
+// certificate verified by the server, whose common name is their principal.
+// Rules then grant principals a permission on the sets matching a glob:
+//
+//	p := &auth.Policy{
+//		Tokens: map[string]string{os.Getenv("FEED_TOKEN"): "feed"},
+//		Rules: []auth.Rule{
+//			{Principal: "feed", Sets: "blocklist-*", Allow: auth.Mutate},
+//			{Principal: "*", Sets: "*", Allow: auth.Read},
+//		},
+//	}
+//
+// When several teams share an agent, Tenants confines each of them to the
+// sets named with the prefixes of its tenant, see Tenants.
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+var (
+	// ErrUnauthenticated is returned for a client presenting neither a known
+	// token nor a verified certificate.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned when no rule grants the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrInvalidSetName is returned for a set name ipset does not accept,
+	// which rules and tenants cannot safely match, e.g. one with a line break
+	// smuggling commands into a restore. It is an ErrPermissionDenied.
+	ErrInvalidSetName = fmt.Errorf("%w: invalid set name", ErrPermissionDenied)
+)
+
+// Permission is the level of access to a set; each level includes the ones
+// below it.
+type Permission int
+
+const (
+	// Read allows listing and testing the entries and reading statistics.
+	Read Permission = iota + 1
+	// Mutate also allows creating sets, adding, deleting, flushing,
+	// refreshing and swapping.
+	Mutate
+	// Destroy also allows destroying sets.
+	Destroy
+)
+
+func (p Permission) String() string {
+	switch p {
+	case Read:
+		return "read"
+	case Mutate:
+		return "mutate"
+	case Destroy:
+		return "destroy"
+	}
+	return fmt.Sprintf("Permission(%d)", int(p))
+}
+
+// Rule grants a principal a permission on sets.
+type Rule struct {
+	// Principal is the name of the client, "*" for every authenticated one.
+	Principal string
+	// Sets is a path.Match pattern of the set names, "*" for all sets.
+	Sets string
+	// Allow is the highest permission granted.
+	Allow Permission
+}
+
+// Policy authenticates clients and authorizes their operations. A nil Policy
+// allows everything, as the servers did before authentication existed.
+type Policy struct {
+	// Tokens maps the bearer tokens to the principal they authenticate.
+	Tokens map[string]string
+	// Rules are the grants; an operation is allowed when any rule allows it.
+	Rules []Rule
+	// Tenants, if set, further confines each principal to the sets of its
+	// tenant.
+	Tenants *Tenants
+}
+
+// Authenticate returns the principal of a client given its bearer token,
+// empty if none, and its verified certificate chains. The token is tried
+// first, then the common name of the leaf of the first chain.
+func (p *Policy) Authenticate(token string, chains [][]*x509.Certificate) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	if token != "" {
+		for t, principal := range p.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return principal, nil
+			}
+		}
+		return "", fmt.Errorf("%w: unknown token", ErrUnauthenticated)
+	}
+	if len(chains) != 0 && len(chains[0]) != 0 && chains[0][0].Subject.CommonName != "" {
+		return chains[0][0].Subject.CommonName, nil
+	}
+	return "", ErrUnauthenticated
+}
+
+// Authorize checks that the principal holds perm on all the sets, and that
+// they belong to its tenant. Sets whose name ipset does not accept are
+// rejected with ErrInvalidSetName before any rule is matched.
+func (p *Policy) Authorize(principal string, perm Permission, sets ...string) error {
+	if p == nil {
+		return nil
+	}
+	for _, set := range sets {
+		if err := ipset.ValidateSetName(set); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSetName, err)
+		}
+	}
+	for _, set := range sets {
+		if !p.allows(principal, perm, set) {
+			return fmt.Errorf("%w: %s may not %s set %s", ErrPermissionDenied, principal, perm, set)
+		}
+	}
+	return p.Tenants.Check(principal, sets...)
+}
+
+func (p *Policy) allows(principal string, perm Permission, set string) bool {
+	for _, r := range p.Rules {
+		if r.Allow < perm || (r.Principal != "*" && r.Principal != principal) {
+			continue
+		}
+		if ok, err := path.Match(r.Sets, set); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// BearerToken returns the token of an "Authorization: Bearer <token>"
+// header value, empty if it has another form.
+func BearerToken(header string) string {
+	const prefix = "bearer "
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
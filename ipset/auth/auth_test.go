@@ -0,0 +1,119 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+)
+
+var policy = &Policy{
+	Tokens: map[string]string{"s3cret": "feed"},
+	Rules: []Rule{
+		{Principal: "feed", Sets: "blocklist-*", Allow: Mutate},
+		{Principal: "operator", Sets: "*", Allow: Destroy},
+		{Principal: "*", Sets: "*", Allow: Read},
+	},
+}
+
+func TestAuthenticate(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "operator"}}
+	for _, tc := range []struct {
+		token  string
+		chains [][]*x509.Certificate
+		want   string
+	}{
+		{"s3cret", nil, "feed"},
+		{"s3cret", [][]*x509.Certificate{{cert}}, "feed"},
+		{"", [][]*x509.Certificate{{cert}}, "operator"},
+	} {
+		got, err := policy.Authenticate(tc.token, tc.chains)
+		if err != nil || got != tc.want {
+			t.Errorf("Authenticate(%q) = %q, %v, want %q", tc.token, got, err, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		token  string
+		chains [][]*x509.Certificate
+	}{
+		{"wrong", [][]*x509.Certificate{{cert}}},
+		{"", nil},
+		{"", [][]*x509.Certificate{{&x509.Certificate{}}}},
+	} {
+		if p, err := policy.Authenticate(tc.token, tc.chains); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Authenticate(%q) = %q, %v, want ErrUnauthenticated", tc.token, p, err)
+		}
+	}
+	var open *Policy
+	if p, err := open.Authenticate("", nil); err != nil || p != "" {
+		t.Errorf("nil policy: Authenticate = %q, %v", p, err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	for _, tc := range []struct {
+		principal string
+		perm      Permission
+		sets      []string
+		ok        bool
+	}{
+		{"feed", Mutate, []string{"blocklist-a", "blocklist-b"}, true},
+		{"feed", Mutate, []string{"blocklist-a", "allowlist"}, false},
+		{"feed", Destroy, []string{"blocklist-a"}, false},
+		{"feed", Read, []string{"allowlist"}, true},
+		{"lb", Read, []string{"allowlist"}, true},
+		{"lb", Mutate, []string{"allowlist"}, false},
+		{"operator", Destroy, []string{"allowlist"}, true},
+	} {
+		err := policy.Authorize(tc.principal, tc.perm, tc.sets...)
+		if tc.ok && err != nil {
+			t.Errorf("%s %s %v: %v", tc.principal, tc.perm, tc.sets, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s %s %v: got %v, want ErrPermissionDenied", tc.principal, tc.perm, tc.sets, err)
+		}
+	}
+	var open *Policy
+	if err := open.Authorize("anyone", Destroy, "allowlist"); err != nil {
+		t.Errorf("nil policy: Authorize = %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer s3cret":   "s3cret",
+		"bearer  s3cret ": "s3cret",
+		"Basic dXNlcjpw":  "",
+		"Bearer ":         "",
+		"":                "",
+	} {
+		if got := BearerToken(header); got != want {
+			t.Errorf("BearerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestPermissionString(t *testing.T) {
+	if s := Mutate.String(); s != "mutate" {
+		t.Errorf("Mutate formatted as %s", s)
+	}
+	if s := Permission(9).String(); s != "Permission(9)" {
+		t.Errorf("Permission(9) formatted as %s", s)
+	}
+}
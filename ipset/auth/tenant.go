@@ -0,0 +1,83 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrWrongTenant is returned when a principal names a set outside of its
+// tenant. It is an ErrPermissionDenied, which errors.Is reports too.
+var ErrWrongTenant = fmt.Errorf("%w: set outside of tenant", ErrPermissionDenied)
+
+// Tenants confines the principals of the teams sharing an agent to the sets
+// of their tenant, named with one of its prefixes, whatever the rules grant.
+// Temporary sets derived from a name, e.g. by refreshes, keep its prefix.
+//
+//	p.Tenants = &auth.Tenants{
+//		Members:  map[string]string{"feed": "secops", "lb": "netops"},
+//		Prefixes: map[string][]string{"secops": {"sec-"}, "netops": {"net-", "lb-"}},
+//		Admins:   []string{"operator"},
+//	}
+type Tenants struct {
+	// Members maps the principals to their tenant. A principal that is not
+	// listed is its own tenant.
+	Members map[string]string
+	// Prefixes maps the tenants to the prefixes of the names of their sets. A
+	// tenant without prefixes may use no set.
+	Prefixes map[string][]string
+	// Admins are the principals confined to no tenant.
+	Admins []string
+}
+
+// Tenant returns the tenant of the principal.
+func (t *Tenants) Tenant(principal string) string {
+	if tenant, ok := t.Members[principal]; ok {
+		return tenant
+	}
+	return principal
+}
+
+// Check returns ErrWrongTenant unless all the sets belong to the tenant of
+// the principal. A nil Tenants allows every set.
+func (t *Tenants) Check(principal string, sets ...string) error {
+	if t == nil {
+		return nil
+	}
+	for _, a := range t.Admins {
+		if a == principal {
+			return nil
+		}
+	}
+	tenant := t.Tenant(principal)
+	for _, set := range sets {
+		if !t.owns(tenant, set) {
+			return fmt.Errorf("%w: set %s is not one of tenant %s of %s", ErrWrongTenant, set, tenant, principal)
+		}
+	}
+	return nil
+}
+
+func (t *Tenants) owns(tenant, set string) bool {
+	for _, prefix := range t.Prefixes[tenant] {
+		if prefix != "" && strings.HasPrefix(set, prefix) {
+			return true
+		}
+	}
+	return false
+}
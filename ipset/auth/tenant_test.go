@@ -0,0 +1,71 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	tenants := &Tenants{
+		Members:  map[string]string{"feed": "secops", "lb": "netops"},
+		Prefixes: map[string][]string{"secops": {"sec-"}, "netops": {"net-", "lb-"}, "solo": {""}},
+		Admins:   []string{"operator"},
+	}
+	for _, tc := range []struct {
+		principal string
+		sets      []string
+		ok        bool
+	}{
+		{"feed", []string{"sec-blocklist", "sec-blocklist-temp"}, true},
+		{"feed", []string{"sec-blocklist", "net-vips"}, false},
+		{"lb", []string{"net-vips", "lb-backends"}, true},
+		{"operator", []string{"sec-blocklist", "anything"}, true},
+		{"solo", []string{"solo-set"}, false},
+		{"stranger", []string{"sec-blocklist"}, false},
+	} {
+		err := tenants.Check(tc.principal, tc.sets...)
+		if tc.ok && err != nil {
+			t.Errorf("%s %v: %v", tc.principal, tc.sets, err)
+		}
+		if !tc.ok && (!errors.Is(err, ErrWrongTenant) || !errors.Is(err, ErrPermissionDenied)) {
+			t.Errorf("%s %v: got %v, want ErrWrongTenant", tc.principal, tc.sets, err)
+		}
+	}
+	if tenant := tenants.Tenant("stranger"); tenant != "stranger" {
+		t.Errorf("unlisted principal in tenant %s", tenant)
+	}
+	var none *Tenants
+	if err := none.Check("feed", "net-vips"); err != nil {
+		t.Errorf("nil tenants: %v", err)
+	}
+
+	p := &Policy{Rules: []Rule{{Principal: "*", Sets: "*", Allow: Destroy}}, Tenants: tenants}
+	if err := p.Authorize("lb", Mutate, "sec-blocklist"); !errors.Is(err, ErrWrongTenant) {
+		t.Errorf("Authorize outside of the tenant: got %v, want ErrWrongTenant", err)
+	}
+	if err := p.Authorize("lb", Mutate, "lb-backends"); err != nil {
+		t.Errorf("Authorize within the tenant: %v", err)
+	}
+	for _, set := range []string{"lb-x 1.1.1.1\ndestroy sec-blocklist\nadd lb-x", "lb-x sec-blocklist", "lb-" + strings.Repeat("x", 29)} {
+		if err := p.Authorize("lb", Mutate, set); !errors.Is(err, ErrInvalidSetName) || !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Authorize of set %q: got %v, want ErrInvalidSetName", set, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// Batch collects mutations of sets and applies them with a single `ipset
+// restore`, which is orders of magnitude faster than running ipset once per
+// entry. A Batch is not safe for concurrent use.
+type Batch struct {
+	h       *Handle
+	buf     bytes.Buffer
+	n       int
+	changes []change
+	// err is the first invalid set name or entry queued, returned by Commit
+	err error
+}
+
+// NewBatch returns an empty batch run by the default handle.
+func NewBatch() *Batch {
+	return defaultHandle.NewBatch()
+}
+
+// NewBatch returns an empty batch run by the handle.
+func (h *Handle) NewBatch() *Batch {
+	return &Batch{h: h}
+}
+
+// Add queues adding the entry to the set. Entries already in the set are
+// updated with the options of e.
+func (b *Batch) Add(set string, e Entry) {
+	if !b.valid(ValidateSetName(set)) || !b.valid(ValidateEntry(e)) {
+		return
+	}
+	b.buf.WriteString("add " + set + " " + e.String() + "\n")
+	b.record(opAdd, set, e.Element)
+	if e.Nomatch {
+		c := &b.changes[len(b.changes)-1]
+		c.nomatch = append(c.nomatch, e.Element)
+	}
+}
+
+// AddTimeout queues adding the entry to the set with a timeout, as Add of IPSet does.
+func (b *Batch) AddTimeout(set, entry string, timeout int) {
+	if !b.valid(ValidateSetName(set)) || !b.valid(ValidateElement(entry)) {
+		return
+	}
+	b.buf.WriteString("add " + set + " " + entry + " timeout " + strconv.Itoa(timeout) + "\n")
+	b.record(opAdd, set, entry)
+}
+
+// Del queues deleting the entry from the set. Missing entries are ignored.
+func (b *Batch) Del(set, entry string) {
+	if !b.valid(ValidateSetName(set)) || !b.valid(ValidateElement(entry)) {
+		return
+	}
+	b.buf.WriteString("del " + set + " " + entry + "\n")
+	b.record(opDel, set, entry)
+}
+
+// Flush queues removing all entries from the set.
+func (b *Batch) Flush(set string) {
+	if !b.valid(ValidateSetName(set)) {
+		return
+	}
+	b.buf.WriteString("flush " + set + "\n")
+	b.n++
+	b.changes = append(b.changes, change{op: opFlush, set: set})
+}
+
+// Len returns the number of queued commands.
+func (b *Batch) Len() int {
+	return b.n
+}
+
+// Commit applies the queued commands and empties the batch. ipset stops at
+// the first failing command; the commands before it are applied. When ipset
+// tells which command failed, the error is a *MultiError naming its set and
+// entry. If an invalid set name or entry was queued, nothing is applied and
+// its ErrInvalidSetName or ErrInvalidEntry is returned.
+func (b *Batch) Commit() error {
+	if b.n == 0 && b.err == nil {
+		return nil
+	}
+	err := b.err
+	if err == nil {
+		err = b.checkOwned()
+	}
+	if err == nil {
+		err = b.checkPolicy()
+	}
+	if err == nil {
+		err = b.checkQuota()
+	}
+	if err == nil {
+		err = b.h.restore(&b.buf)
+		for _, c := range b.changes {
+			c.err = err
+			b.h.changed(c)
+		}
+		if err != nil {
+			err = b.failure(err)
+		}
+	}
+	b.buf.Reset()
+	b.n = 0
+	b.changes = nil
+	b.err = nil
+	return err
+}
+
+// valid records err, the validation error of a set name or an entry, and
+// reports whether the command may be queued.
+func (b *Batch) valid(err error) bool {
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return err == nil
+}
+
+// checkOwned checks that the handle may change the sets of the batch.
+func (b *Batch) checkOwned() error {
+	for _, c := range b.changes {
+		if err := b.h.checkOwned(c.set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPolicy checks that the destructive policies of the handle allow the
+// flushes of the batch.
+func (b *Batch) checkPolicy() error {
+	for _, c := range b.changes {
+		if c.op != opFlush {
+			continue
+		}
+		if err := b.h.checkPolicy(Operation{Op: OpFlush, Set: c.set}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkQuota checks that the additions of the batch keep the handle within
+// its quota.
+func (b *Batch) checkQuota() error {
+	adds := make(map[string][]string)
+	for _, c := range b.changes {
+		if c.op == opAdd {
+			adds[c.set] = append(adds[c.set], c.entries...)
+		}
+	}
+	return b.h.checkQuotaEntries(adds)
+}
+
+// failure returns the error of the restore running the batch, as a
+// *MultiError if the failing command can be told from the error.
+func (b *Batch) failure(err error) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	n, ok := parse.ErrorLine([]byte(e.Stderr))
+	if !ok {
+		return err
+	}
+	line := 1
+	for _, c := range b.changes {
+		if len(c.entries) == 0 {
+			// flush
+			if line == n {
+				return &MultiError{Msg: "error committing batch", Errors: []*ItemError{{Set: c.set, Err: err}}}
+			}
+			line++
+			continue
+		}
+		if n < line+len(c.entries) {
+			ie := &ItemError{Set: c.set, Entry: c.entries[n-line], Err: err}
+			return &MultiError{Msg: "error committing batch", Errors: []*ItemError{ie}}
+		}
+		line += len(c.entries)
+	}
+	return err
+}
+
+// record notes a mutation of an entry, merging it with the previous one of
+// the same kind and set.
+func (b *Batch) record(op changeOp, set, entry string) {
+	b.n++
+	if l := len(b.changes); l > 0 && b.changes[l-1].op == op && b.changes[l-1].set == set {
+		b.changes[l-1].entries = append(b.changes[l-1].entries, entry)
+		return
+	}
+	b.changes = append(b.changes, change{op: op, set: set, entries: []string{entry}})
+}
@@ -0,0 +1,73 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"testing"
+)
+
+func benchEntries(n int) []string {
+	entries := make([]string, n)
+	for i := range entries {
+		entries[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	return entries
+}
+
+func BenchmarkAdd(b *testing.B) {
+	s := &IPSet{Name: "bench", h: stubHandle(b, nullStub)}
+	entries := benchEntries(b.N)
+	b.ResetTimer()
+	for _, e := range entries {
+		if err := s.Add(e, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAddAll(b *testing.B) {
+	s := &IPSet{Name: "bench", h: stubHandle(b, nullStub)}
+	entries := benchEntries(b.N)
+	b.ResetTimer()
+	if err := s.AddAll(entries, 0); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkDel(b *testing.B) {
+	s := &IPSet{Name: "bench", h: stubHandle(b, nullStub)}
+	entries := benchEntries(b.N)
+	b.ResetTimer()
+	for _, e := range entries {
+		if err := s.Del(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDelAll(b *testing.B) {
+	s := &IPSet{Name: "bench", h: stubHandle(b, nullStub)}
+	entries := benchEntries(b.N)
+	b.ResetTimer()
+	if err := s.DelAll(entries); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxBitmapElems is the largest number of elements a bitmap set can hold.
+const maxBitmapElems = 65536
+
+// isBitmapType reports whether the set type is one of the bitmap types.
+func isBitmapType(settype string) bool {
+	return strings.HasPrefix(settype, "bitmap:")
+}
+
+// validateBitmapRange checks that r is a range bitmap sets of the given type
+// accept: "port1-port2" for bitmap:port, "ip1-ip2" or an IPv4 network for
+// bitmap:ip and bitmap:ip,mac, spanning at most 65536 elements.
+func validateBitmapRange(settype, r string) error {
+	if r == "" {
+		return fmt.Errorf("range is required for sets of type %s", settype)
+	}
+	var size uint64
+	switch settype {
+	case "bitmap:port":
+		from, to, err := parsePortRange(r)
+		if err != nil {
+			return err
+		}
+		size = uint64(to-from) + 1
+	case "bitmap:ip", "bitmap:ip,mac":
+		from, to, err := parseIPv4Range(r)
+		if err != nil {
+			return err
+		}
+		size = uint64(to-from) + 1
+	default:
+		return fmt.Errorf("unknown bitmap type: %s", settype)
+	}
+	if size > maxBitmapElems {
+		return fmt.Errorf("range %s of %d elements exceeds the bitmap limit of %d", r, size, maxBitmapElems)
+	}
+	return nil
+}
+
+func parsePortRange(r string) (uint16, uint16, error) {
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %s: want port1-port2", r)
+	}
+	from, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %s: %w", r, err)
+	}
+	to, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %s: %w", r, err)
+	}
+	if from > to {
+		return 0, 0, fmt.Errorf("invalid port range %s: start after end", r)
+	}
+	return uint16(from), uint16(to), nil
+}
+
+func parseIPv4Range(r string) (uint32, uint32, error) {
+	if strings.Contains(r, "/") {
+		_, n, err := net.ParseCIDR(r)
+		if err != nil || n.IP.To4() == nil {
+			return 0, 0, fmt.Errorf("invalid range %s: not an IPv4 network", r)
+		}
+		from := binary.BigEndian.Uint32(n.IP.To4())
+		ones, _ := n.Mask.Size()
+		return from, from | uint32(uint64(1)<<uint(32-ones)-1), nil
+	}
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid range %s: want ip1-ip2 or an IPv4 network", r)
+	}
+	ips := make([]uint32, 2)
+	for i, p := range parts {
+		ip := net.ParseIP(p).To4()
+		if ip == nil {
+			return 0, 0, fmt.Errorf("invalid range %s: %s is not an IPv4 address", r, p)
+		}
+		ips[i] = binary.BigEndian.Uint32(ip)
+	}
+	if ips[0] > ips[1] {
+		return 0, 0, fmt.Errorf("invalid range %s: start after end", r)
+	}
+	return ips[0], ips[1], nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "testing"
+
+func TestValidateBitmapRange(t *testing.T) {
+	for _, tc := range []struct{ settype, r string }{
+		{"bitmap:port", "0-65535"},
+		{"bitmap:port", "8000-8000"},
+		{"bitmap:ip", "192.168.0.0/16"},
+		{"bitmap:ip", "10.0.0.1-10.0.0.254"},
+		{"bitmap:ip,mac", "192.0.2.0/24"},
+	} {
+		if err := validateBitmapRange(tc.settype, tc.r); err != nil {
+			t.Errorf("%s range %s: %v", tc.settype, tc.r, err)
+		}
+	}
+	for _, tc := range []struct{ settype, r string }{
+		{"bitmap:port", ""},
+		{"bitmap:port", "80"},
+		{"bitmap:port", "90-80"},
+		{"bitmap:port", "0-65536"},
+		{"bitmap:ip", "10.0.0.0/15"},
+		{"bitmap:ip", "10.0.0.0-10.1.0.0"},
+		{"bitmap:ip", "10.0.0.9-10.0.0.1"},
+		{"bitmap:ip", "2001:db8::/120"},
+		{"bitmap:ip,mac", "10.0.0.1"},
+		{"bitmap:ip", "10.0.0.1-host"},
+		{"bitmap:mac", "0-1"},
+	} {
+		if err := validateBitmapRange(tc.settype, tc.r); err == nil {
+			t.Errorf("%s range %q accepted", tc.settype, tc.r)
+		}
+	}
+}
+
+func TestBitmapRangeBounds(t *testing.T) {
+	from, to, err := parseIPv4Range("192.0.2.0/24")
+	if err != nil || from != 0xc0000200 || to != 0xc00002ff {
+		t.Errorf("192.0.2.0/24 = %#x-%#x, %v", from, to, err)
+	}
+	if from, to, err := parseIPv4Range("0.0.0.0/0"); err != nil || from != 0 || to != 0xffffffff {
+		t.Errorf("0.0.0.0/0 = %#x-%#x, %v", from, to, err)
+	}
+	if from, to, err := parsePortRange("1024-2047"); err != nil || from != 1024 || to != 2047 {
+		t.Errorf("1024-2047 = %d-%d, %v", from, to, err)
+	}
+	if !isBitmapType("bitmap:port") || isBitmapType("hash:ip") {
+		t.Error("isBitmapType does not match the bitmap types only")
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrVerifyFailed is returned when a rollout fails its checks; the live set
+// is left untouched.
+var ErrVerifyFailed = errors.New("set verification failed")
+
+// BlueGreenCheck lists the checks a new generation must pass before it goes
+// live.
+type BlueGreenCheck struct {
+	// MinEntries is the least number of entries of the generation.
+	MinEntries int
+	// Present and Absent are elements the generation must and must not match.
+	Present []string
+	Absent  []string
+}
+
+// BlueGreen rolls out the content of a set as alternating generations held
+// in the sets <name>-a and <name>-b. Rules reference <name>, a list:set
+// containing the live generation; a rollout fills and verifies the other
+// one and then replaces the member of the list:set, so the previous
+// generation stays intact for an instant Rollback. The switch adds the new
+// member before removing the old one, so entries present in both never stop
+// matching.
+type BlueGreen struct {
+	// Name is the name of the list:set rules reference.
+	Name string
+	h    *Handle
+	sets [2]*IPSet
+}
+
+// NewBlueGreen creates the sets of a blue/green rollout, see Handle.NewBlueGreen.
+func NewBlueGreen(name, settype string, p *Params) (*BlueGreen, error) {
+	return defaultHandle.NewBlueGreen(name, settype, p)
+}
+
+// NewBlueGreen creates, or adopts, the generation sets of type settype, with
+// parameters p, and the list:set named name holding the live one, <name>-a
+// for a new list:set.
+func (h *Handle) NewBlueGreen(name, settype string, p *Params) (*BlueGreen, error) {
+	bg := &BlueGreen{Name: name, h: h}
+	for i, suffix := range []string{"-a", "-b"} {
+		s, _, err := h.Create(tempSetName(name, suffix), settype, p)
+		if err != nil {
+			return nil, err
+		}
+		bg.sets[i] = s
+	}
+	list, err := h.createListSet(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bg.Active(); errors.Is(err, ErrSetNotFound) {
+		if err := list.addWithOptions(bg.sets[0].Name, AddOptions{}); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return bg, nil
+}
+
+// createListSet creates, or adopts, the list:set named name. Like Create, it
+// tracks the set only if it did not exist.
+func (h *Handle) createListSet(name string) (*IPSet, error) {
+	exists, err := h.setExists(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &IPSet{Name: name, HashType: "list:set", h: h}
+	if err := s.createHashSet(name); err != nil {
+		return nil, err
+	}
+	if !exists {
+		h.track(name)
+	}
+	return s, nil
+}
+
+// Active returns the generation set currently live.
+func (bg *BlueGreen) Active() (*IPSet, error) {
+	members, err := bg.h.list(bg.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		for _, s := range bg.sets {
+			if s.Name == m {
+				return s, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no generation of %s is live", ErrSetNotFound, bg.Name)
+}
+
+// Standby returns the generation set not live, holding the previous
+// generation after a rollout.
+func (bg *BlueGreen) Standby() (*IPSet, error) {
+	active, err := bg.Active()
+	if err != nil {
+		return nil, err
+	}
+	return bg.other(active), nil
+}
+
+func (bg *BlueGreen) other(s *IPSet) *IPSet {
+	if s == bg.sets[0] {
+		return bg.sets[1]
+	}
+	return bg.sets[0]
+}
+
+// Rollout fills the standby generation with entries, checks it and makes it
+// live. If a check fails, an ErrVerifyFailed is returned and the live
+// generation is kept.
+func (bg *BlueGreen) Rollout(entries []Entry, check BlueGreenCheck) error {
+	defer bg.h.lockSet(bg.Name)()
+	active, err := bg.Active()
+	if err != nil {
+		return err
+	}
+	standby := bg.other(active)
+	if err := standby.RefreshEntriesWith(entries, RefreshFlush); err != nil {
+		return err
+	}
+	if err := verifyGeneration(standby, check); err != nil {
+		return err
+	}
+	return bg.switchTo(standby, active)
+}
+
+// Rollback makes the previous generation live again.
+func (bg *BlueGreen) Rollback() error {
+	defer bg.h.lockSet(bg.Name)()
+	active, err := bg.Active()
+	if err != nil {
+		return err
+	}
+	return bg.switchTo(bg.other(active), active)
+}
+
+// switchTo replaces the live generation from with to.
+func (bg *BlueGreen) switchTo(to, from *IPSet) error {
+	return bg.h.switchMember(bg.Name, to.Name, from.Name)
+}
+
+// switchMember replaces the member from of the named list:set with to in a
+// single restore, adding to before removing from. The switch is subject to
+// the policies as a swap of from and to.
+func (h *Handle) switchMember(list, to, from string) error {
+	if err := h.checkOwned(list); err != nil {
+		return err
+	}
+	if err := h.checkPolicy(Operation{Op: OpSwap, Set: from, Other: to}); err != nil {
+		return err
+	}
+	var batch bytes.Buffer
+	batch.WriteString("add " + list + " " + to + " before " + from + "\n")
+	batch.WriteString("del " + list + " " + from + "\n")
+	err := h.restore(&batch)
+	h.changed(change{op: opAdd, set: list, entries: []string{to}, err: err})
+	h.changed(change{op: opDel, set: list, entries: []string{from}, err: err})
+	if err != nil {
+		return fmt.Errorf("error switching %s from %s to %s: %w", list, from, to, err)
+	}
+	return nil
+}
+
+// verifyGeneration runs the checks on the set.
+func verifyGeneration(s *IPSet, check BlueGreenCheck) error {
+	stats, err := s.Statistics()
+	if err != nil {
+		return err
+	}
+	if int(stats.Entries) < check.MinEntries {
+		return fmt.Errorf("%w: set %s has %d entries, expected at least %d", ErrVerifyFailed, s.Name, stats.Entries, check.MinEntries)
+	}
+	for _, e := range check.Present {
+		if found, err := s.Test(e); err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("%w: set %s does not match %s", ErrVerifyFailed, s.Name, e)
+		}
+	}
+	for _, e := range check.Absent {
+		if found, err := s.Test(e); err != nil {
+			return err
+		} else if found {
+			return fmt.Errorf("%w: set %s matches %s", ErrVerifyFailed, s.Name, e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// ErrNotSupported is returned when the installed ipset utility lacks a flag
+// or mode required by the requested operation.
+var ErrNotSupported = errors.New("operation not supported by the installed ipset utility")
+
+// Capabilities describes the flavour and features of the detected ipset utility.
+type Capabilities struct {
+	// BusyBox is set when ipset is provided by the BusyBox applet.
+	BusyBox bool
+	// Version is the version string reported by the utility, e.g. "v7.15".
+	// It is empty for the BusyBox applet, which only reports its own version.
+	Version string
+	// Terse reports whether `ipset list -t` is available.
+	Terse bool
+	// NamesOnly reports whether `ipset list -n` is available.
+	NamesOnly bool
+	// FileOption reports whether the -file option of save and restore is available (6.22).
+	FileOption bool
+	// BucketSize reports whether hash sets accept bucketsize and initval (7.11).
+	BucketSize bool
+	// JSONOutput reports whether `ipset list -o json` is available (7.16).
+	JSONOutput bool
+}
+
+// caps holds the capabilities of the utility found by initCheck.
+// Until detection ran, the full feature set of a regular ipset is assumed.
+var caps = Capabilities{Terse: true, NamesOnly: true}
+
+// GetCapabilities returns the capabilities of the ipset utility in use.
+func GetCapabilities() (Capabilities, error) {
+	if err := initCheck(); err != nil {
+		return Capabilities{}, err
+	}
+	return caps, nil
+}
+
+// detectCapabilities inspects the `--version` output of the utility at ipsetPath.
+// The BusyBox applet does not know `--version` and prints its usage banner
+// instead, so the output is examined even when the command fails.
+func detectCapabilities() error {
+	out, err := defaultHandle.run("--version")
+	if bytes.Contains(out, []byte("BusyBox")) {
+		caps = Capabilities{BusyBox: true}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting ipset version: %w (%s)", err, out)
+	}
+	version, err := parseVersionString(out)
+	if err != nil {
+		return err
+	}
+	caps = Capabilities{Version: version, Terse: true, NamesOnly: true}
+	caps.setVersionFeatures()
+	return nil
+}
+
+// listSetNamesCompat lists the names of all sets by parsing the "Name:" lines of the
+// full listing, for utilities without `list -n`.
+func (h *Handle) listSetNamesCompat() ([]string, error) {
+	out, err := h.run("list")
+	if err != nil {
+		return []string{}, fmt.Errorf("error listing all sets: %w (%s)", err, out)
+	}
+	var names []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if key, val, ok := parse.KeyValue(l); ok && key == "Name" {
+			names = append(names, val)
+		}
+	}
+	return names, nil
+}
+
+// listWithOptsCompat emulates listWithOpts for the BusyBox applet. The terse listing
+// is produced by cutting the full listing at the members; other flags are refused.
+func (h *Handle) listWithOptsCompat(set string, opts ...string) ([]string, error) {
+	terse := false
+	for _, o := range opts {
+		if o != "-t" {
+			return []string{}, fmt.Errorf("error listing set %s: %w: %s", set, ErrNotSupported, o)
+		}
+		terse = true
+	}
+	out, err := h.run("list", set)
+	if err != nil {
+		return []string{}, fmt.Errorf("error listing set %s: %w (%s)", set, err, out)
+	}
+	lines := strings.Split(string(out), "\n")
+	if terse {
+		for i, l := range lines {
+			if parse.IsMembersHeader(l) {
+				return lines[:i], nil
+			}
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "fmt"
+
+// changeOp identifies the kind of a set mutation.
+type changeOp int
+
+const (
+	opAdd changeOp = iota
+	opDel
+	opFlush
+	opSwap
+	opDestroy
+	opDestroyAll
+	// opCreate is reported for a create, which leaves an existing set alone
+	opCreate
+)
+
+func (op changeOp) String() string {
+	switch op {
+	case opAdd:
+		return "add"
+	case opDel:
+		return "del"
+	case opFlush:
+		return "flush"
+	case opSwap:
+		return "swap"
+	case opDestroy:
+		return "destroy"
+	case opDestroyAll:
+		return "destroy-all"
+	case opCreate:
+		return "create"
+	}
+	return fmt.Sprintf("changeOp(%d)", int(op))
+}
+
+// change describes a mutation attempted through a handle. It is reported
+// whether or not the command succeeded, err tells which.
+type change struct {
+	op  changeOp
+	set string
+	// other is the second set of a swap
+	other   string
+	entries []string
+	// nomatch holds the entries added with the nomatch flag
+	nomatch []string
+	err     error
+}
+
+// changed is called after every mutating command of the handle to keep the
+// in-process state derived from sets consistent with them.
+func (h *Handle) changed(c change) {
+	switch c.op {
+	case opSwap:
+		h.testCache.invalidate(c.set, c.other)
+	case opDestroyAll:
+		h.testCache.invalidateAll()
+	default:
+		h.testCache.invalidate(c.set)
+	}
+	h.updateMirrors(c)
+	h.updateLookupMirrors(c)
+	h.updateExpiryTrackers(c)
+	h.updateRegistry(c)
+	h.updateQuota(c)
+	h.updateOpStats(c)
+	h.updateGrowth(c)
+	h.audit(c)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// volatileOptions are the per-entry values changing without the set being
+// modified: remaining timeouts and packet/byte counters.
+var volatileOptions = map[string]bool{"timeout": true, "packets": true, "bytes": true}
+
+// Checksum returns a stable hash of the set header and of its members with their
+// options, so that controllers can cheaply tell whether a set changed since the
+// last reconciliation. Remaining timeouts and counters are left out as they
+// change on their own; the order of the members does not matter.
+func (s *IPSet) Checksum() (string, error) {
+	var buf bytes.Buffer
+	if err := s.handle().save(s.Name, &buf); err != nil {
+		return "", err
+	}
+	return saveChecksum(buf.Bytes())
+}
+
+// saveChecksum returns the checksum of the `ipset save` output data, as
+// Checksum does.
+func saveChecksum(data []byte) (string, error) {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		fields := parse.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "add" {
+			fields = stripOptions(fields, volatileOptions)
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	sort.Strings(lines)
+	sum := sha256.New()
+	for _, l := range lines {
+		sum.Write([]byte(l))
+		sum.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// stripOptions removes the named options and their values from the fields of an
+// entry. Option names are only looked for after the element (and set name).
+func stripOptions(fields []string, drop map[string]bool) []string {
+	out := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		if i >= 3 && drop[fields[i]] && i+1 < len(fields) {
+			i++
+			continue
+		}
+		out = append(out, fields[i])
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "fmt"
+
+// DefaultChunkSize is the number of entries of a chunk when ChunkOptions.Size is zero.
+const DefaultChunkSize = 10000
+
+// ChunkOptions controls how AddChunked splits entries into restore batches.
+type ChunkOptions struct {
+	// Size is the number of entries per `ipset restore`, DefaultChunkSize when zero.
+	Size int
+	// Start is the index of the first entry to add, to resume an interrupted
+	// load with the count returned by AddChunked.
+	Start int
+	// Progress, if set, is called after each chunk with the number of entries
+	// added so far, Start included, and their total.
+	Progress func(done, total int)
+}
+
+// AddChunked adds the entries to the set in chunks of bounded size, one
+// `ipset restore` each, so that a very large load makes steady progress and
+// a failure only loses the chunk it occurred in. It returns the number of
+// entries added, counting from the first: if an error is returned, calling
+// AddChunked again with Start set to that count resumes the load at the
+// failed chunk.
+func (s *IPSet) AddChunked(entries []Entry, opts ChunkOptions) (int, error) {
+	size := opts.Size
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if opts.Start < 0 || opts.Start > len(entries) {
+		return 0, fmt.Errorf("invalid start %d for %d entries", opts.Start, len(entries))
+	}
+	h := s.handle()
+	defer h.lockSet(s.Name)()
+	done := opts.Start
+	for done < len(entries) {
+		end := min(done+size, len(entries))
+		if err := h.addBatch(s.Name, entries[done:end]); err != nil {
+			return done, fmt.Errorf("error adding entries %d to %d of %d: %w", done, end-1, len(entries), err)
+		}
+		done = end
+		if opts.Progress != nil {
+			opts.Progress(done, len(entries))
+		}
+	}
+	return done, nil
+}
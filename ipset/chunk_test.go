@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddChunked(t *testing.T) {
+	var r NoopRecorder
+	s := NewHandle(WithNoopBackend(&r)).Set("app-a")
+	var entries []Entry
+	for _, e := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"} {
+		entries = append(entries, Entry{Element: e})
+	}
+	var progress []int
+	n, err := s.AddChunked(entries, ChunkOptions{Size: 2, Start: 1, Progress: func(done, total int) {
+		if total != len(entries) {
+			t.Errorf("progress total %d, want %d", total, len(entries))
+		}
+		progress = append(progress, done)
+	}})
+	if err != nil || n != len(entries) {
+		t.Fatalf("added %d entries, error %v", n, err)
+	}
+	if want := []int{3, 5}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress %v, want %v", progress, want)
+	}
+	cmds := r.Commands()
+	if len(cmds) != 2 {
+		t.Fatalf("ran %v, want a restore per chunk", cmds)
+	}
+	for i, want := range [][]string{{"192.0.2.2", "192.0.2.3"}, {"192.0.2.4", "192.0.2.5"}} {
+		for _, e := range want {
+			if !strings.Contains(cmds[i].Input, "add app-a "+e) {
+				t.Errorf("chunk %d lacks %s: %q", i, e, cmds[i].Input)
+			}
+		}
+		if strings.Count(cmds[i].Input, "add ") != len(want) {
+			t.Errorf("chunk %d: %q, want %v", i, cmds[i].Input, want)
+		}
+	}
+
+	r.Reset()
+	if _, err := s.AddChunked(entries, ChunkOptions{Start: len(entries) + 1}); err == nil {
+		t.Error("start beyond the entries accepted")
+	}
+	if n, err := s.AddChunked(entries, ChunkOptions{Start: len(entries)}); err != nil || n != len(entries) {
+		t.Errorf("resuming a complete load: added %d entries, error %v", n, err)
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("invalid or complete loads ran %v", cmds)
+	}
+}
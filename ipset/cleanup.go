@@ -0,0 +1,195 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// CleanupAction is what Close does with the sets created through a handle.
+type CleanupAction int
+
+const (
+	// CleanupNone leaves the sets in place.
+	CleanupNone CleanupAction = iota
+	// CleanupDestroy destroys the sets. A set still referenced, e.g. by an
+	// iptables rule, cannot be destroyed and is flushed instead.
+	CleanupDestroy
+	// CleanupFlush removes the entries of the sets, leaving them in place
+	// for the rules referencing them.
+	CleanupFlush
+)
+
+func (a CleanupAction) String() string {
+	switch a {
+	case CleanupNone:
+		return "none"
+	case CleanupDestroy:
+		return "destroy"
+	case CleanupFlush:
+		return "flush"
+	}
+	return fmt.Sprintf("CleanupAction(%d)", int(a))
+}
+
+// errNotShared is returned by Release for a set with no reference left.
+var errNotShared = errors.New("set not retained")
+
+// WithCleanup makes the handle clean up the sets created through it, for
+// per-process sets that must not outlive the process: Close, or Release
+// dropping the last reference to a set, applies action to them. An existing
+// set adopted by Create is only cleaned up once retained.
+func WithCleanup(action CleanupAction) Option {
+	return func(h *Handle) {
+		h.cleanup = action
+	}
+}
+
+// Retain records another reference to the set by a component sharing it, so
+// that it is cleaned up only once every reference is released. Creating the
+// set through the handle holds the first reference; retaining an adopted set
+// makes it subject to the cleanup as if it had been created.
+func (s *IPSet) Retain() {
+	s.handle().retain(s.Name)
+}
+
+// Release drops a reference to the set, see Retain, and cleans it up as
+// configured by WithCleanup when that was the last one.
+func (s *IPSet) Release() error {
+	return s.handle().release(s.Name)
+}
+
+func (h *Handle) retain(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.refs == nil {
+		h.refs = make(map[string]int)
+	}
+	h.refs[name] = h.refsLocked(name) + 1
+	h.owned[name] = true
+}
+
+func (h *Handle) release(name string) error {
+	h.mu.Lock()
+	n := h.refsLocked(name)
+	if n == 0 {
+		h.mu.Unlock()
+		return fmt.Errorf("error releasing set %s: %w", name, errNotShared)
+	}
+	if h.refs == nil {
+		h.refs = make(map[string]int)
+	}
+	h.refs[name] = n - 1
+	h.mu.Unlock()
+	if n > 1 {
+		return nil
+	}
+	return h.cleanupSet(name)
+}
+
+// refsLocked returns the number of references to the named set, the
+// creator's one for a set created through the handle and never retained.
+func (h *Handle) refsLocked(name string) int {
+	if n, ok := h.refs[name]; ok {
+		return n
+	}
+	if h.owned[name] {
+		return 1
+	}
+	return 0
+}
+
+// Close cleans up the sets created through the handle that are still
+// tracked, whatever their references, as configured by WithCleanup; the
+// sets are left in place without it. A *MultiError tells which sets could
+// not be cleaned up.
+func (h *Handle) Close() error {
+	if h.cleanup == CleanupNone {
+		return nil
+	}
+	names := h.ownedSets()
+	errs := make([]error, len(names))
+	h.parallel(len(names), func(i int) {
+		errs[i] = h.cleanupSet(names[i])
+	})
+	h.mu.Lock()
+	h.refs = nil
+	h.mu.Unlock()
+	return itemErrors("error cleaning up sets", names, errs)
+}
+
+// CloseOnSignal closes the handle when the process receives one of the
+// signals, os.Interrupt and SIGTERM when none is given, then delivers the
+// signal again with its default handling, which usually ends the process.
+// The returned function stops watching for the signals; it may be called
+// more than once.
+func (h *Handle) CloseOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sigs...)
+	go func() {
+		select {
+		case sig := <-c:
+			log.Infof("Received %v, cleaning up sets", sig)
+			if err := h.Close(); err != nil {
+				log.Errorf("%v", err)
+			}
+			signal.Reset(sigs...)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-done:
+			signal.Stop(c)
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// cleanupSet applies the cleanup action to the named set.
+func (h *Handle) cleanupSet(name string) error {
+	if h.cleanup == CleanupNone {
+		return nil
+	}
+	defer h.lockSet(name)()
+	if h.cleanup == CleanupDestroy {
+		err := h.destroyChecked(name)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("Error destroying set %s, flushing it instead: %v", name, err)
+	}
+	if err := h.checkPolicy(Operation{Op: OpFlush, Set: name}); err != nil {
+		return err
+	}
+	out, err := h.run("flush", name)
+	h.changed(change{op: opFlush, set: name, err: err})
+	if err != nil {
+		return fmt.Errorf("error flushing set %s: %w (%s)", name, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EncodeComment encodes small structured data, e.g. the provenance of an
+// entry, as a comment: "key=value" pairs sorted by key and separated by ";",
+// such as "source=feedX;ticket=SEC-123". Characters ipset does not store in
+// comments and the separators are escaped as %XX, so that DecodeComment
+// returns the fields as given. It fails with ErrInvalidEntry for an empty key
+// or if the comment exceeds the 255 bytes the kernel stores.
+func EncodeComment(fields map[string]string) (string, error) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k == "" {
+			return "", fmt.Errorf("%w: empty comment field name", ErrInvalidEntry)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		escapeComment(&b, k)
+		b.WriteByte('=')
+		escapeComment(&b, fields[k])
+	}
+	if b.Len() > maxCommentLen {
+		return "", fmt.Errorf("%w: encoded comment longer than %d bytes", ErrInvalidEntry, maxCommentLen)
+	}
+	return b.String(), nil
+}
+
+// DecodeComment returns the fields of a comment encoded by EncodeComment. A
+// comment without "=" is an error, so that free-form comments are told apart.
+func DecodeComment(comment string) (map[string]string, error) {
+	fields := make(map[string]string)
+	if comment == "" {
+		return fields, nil
+	}
+	for _, pair := range strings.Split(comment, ";") {
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("comment %q is not encoded: field %q", comment, pair)
+		}
+		k, err := unescapeComment(pair[:i])
+		if err != nil {
+			return nil, fmt.Errorf("comment %q is not encoded: %w", comment, err)
+		}
+		v, err := unescapeComment(pair[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("comment %q is not encoded: %w", comment, err)
+		}
+		fields[k] = v
+	}
+	return fields, nil
+}
+
+// CommentField returns the named field of the comment of the entry, as
+// encoded by EncodeComment, false if it has none.
+func (o EntryOptions) CommentField(key string) (string, bool) {
+	fields, err := DecodeComment(o.Comment)
+	if err != nil {
+		return "", false
+	}
+	v, ok := fields[key]
+	return v, ok
+}
+
+// escapeComment writes s escaping the separators, '%' and the characters
+// ValidateEntry rejects.
+func escapeComment(b *strings.Builder, s string) {
+	for _, r := range s {
+		switch {
+		case r == ';' || r == '=' || r == '%' || r == '"' || unicode.IsControl(r):
+			var buf [utf8.UTFMax]byte
+			for _, c := range buf[:utf8.EncodeRune(buf[:], r)] {
+				fmt.Fprintf(b, "%%%02X", c)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+func unescapeComment(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("truncated escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeComment(t *testing.T) {
+	for _, tc := range []struct {
+		fields map[string]string
+		want   string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"ticket": "SEC-123", "source": "feedX"}, "source=feedX;ticket=SEC-123"},
+		{map[string]string{"note": `a;b=c "50%"`}, "note=a%3Bb%3Dc %2250%25%22"},
+		{map[string]string{"note": "line\nbreak"}, "note=line%0Abreak"},
+		{map[string]string{"empty": ""}, "empty="},
+	} {
+		got, err := EncodeComment(tc.fields)
+		if err != nil || got != tc.want {
+			t.Errorf("EncodeComment(%v) = %q, %v, want %q", tc.fields, got, err, tc.want)
+			continue
+		}
+		if err := ValidateEntry(Entry{Element: "192.0.2.1", EntryOptions: EntryOptions{Comment: got}}); err != nil {
+			t.Errorf("encoded comment %q rejected: %v", got, err)
+		}
+		back, err := DecodeComment(got)
+		if err != nil || !reflect.DeepEqual(back, tc.fields) {
+			t.Errorf("DecodeComment(%q) = %v, %v, want %v", got, back, err, tc.fields)
+		}
+	}
+	for _, fields := range []map[string]string{{"": "x"}, {"k": strings.Repeat("x", maxCommentLen)}} {
+		if _, err := EncodeComment(fields); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("EncodeComment of %d bytes = %v, want ErrInvalidEntry", len(fields["k"]), err)
+		}
+	}
+}
+
+func TestDecodeComment(t *testing.T) {
+	for _, c := range []string{"free-form comment", "=value", "k=v;bare", "k=%4", "k=%zz"} {
+		if fields, err := DecodeComment(c); err == nil {
+			t.Errorf("DecodeComment(%q) = %v, want an error", c, fields)
+		}
+	}
+	o := EntryOptions{Comment: "source=feedX;ticket=SEC-123"}
+	if v, ok := o.CommentField("ticket"); !ok || v != "SEC-123" {
+		t.Errorf("CommentField(ticket) = %q, %v", v, ok)
+	}
+	if _, ok := o.CommentField("owner"); ok {
+		t.Error("CommentField found a missing field")
+	}
+	if _, ok := (EntryOptions{Comment: "blocked by ops"}).CommentField("source"); ok {
+		t.Error("CommentField decoded a free-form comment")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// WithConcurrency limits the ipset processes the handle runs at once to n,
+// and makes the operations on several sets, such as SaveAll and RefreshMany,
+// work on up to n sets in parallel. A small n suits embedded boxes, a large
+// one big servers with many sets. Without it, processes are not limited and
+// those operations use runtime.NumCPU() workers. The restore processes of
+// sessions, which run for long, are not counted.
+func WithConcurrency(n int) Option {
+	return func(h *Handle) {
+		if n <= 0 {
+			h.procs, h.concurrency = nil, 0
+			return
+		}
+		h.procs = make(chan struct{}, n)
+		h.concurrency = n
+	}
+}
+
+// acquireProc waits until the handle may start an ipset process and returns
+// the function releasing the slot.
+func (h *Handle) acquireProc() func() {
+	if h.procs == nil {
+		return func() {}
+	}
+	h.procs <- struct{}{}
+	return func() { <-h.procs }
+}
+
+// parallel calls fn with each index below n from as many goroutines as the
+// concurrency of the handle allows, and waits for them.
+func (h *Handle) parallel(n int, fn func(i int)) {
+	workers := h.concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// itemErrors returns a MultiError of the non-nil errors of the sets, nil if
+// there are none.
+func itemErrors(msg string, sets []string, errs []error) error {
+	var items []*ItemError
+	for i, err := range errs {
+		if err != nil {
+			items = append(items, &ItemError{Set: sets[i], Err: err})
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return &MultiError{Msg: msg, Errors: items}
+}
+
+// SaveAll snapshots every set of the default handle, see Handle.SaveAll.
+func SaveAll(dir string) ([]SnapshotInfo, error) {
+	return defaultHandle.SaveAll(dir)
+}
+
+// SaveAll snapshots every set into dir, as Snapshot does, to a file named
+// after the set with the ".snap.gz" extension. The sets are saved in parallel,
+// see WithConcurrency. The snapshots taken are returned, sorted by set name,
+// along with a *MultiError telling which sets could not be saved.
+func (h *Handle) SaveAll(dir string) ([]SnapshotInfo, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	names, err := h.ListSetNames(nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	infos := make([]SnapshotInfo, len(names))
+	errs := make([]error, len(names))
+	h.parallel(len(names), func(i int) {
+		s := &IPSet{Name: names[i], h: h}
+		infos[i], errs[i] = s.Snapshot(filepath.Join(dir, names[i]+".snap.gz"))
+	})
+	saved := infos[:0]
+	for i := range infos {
+		if errs[i] == nil {
+			saved = append(saved, infos[i])
+		}
+	}
+	return saved, itemErrors("error saving sets", names, errs)
+}
+
+// AllStatistics returns the statistics of every set of the default handle.
+func AllStatistics() (map[string]Stats, error) {
+	return defaultHandle.AllStatistics()
+}
+
+// AllStatistics returns the statistics of every set, by name, read from a
+// single terse listing of all sets. Like Statistics, it samples the entry
+// counts of the sets for Forecast.
+func (h *Handle) AllStatistics() (map[string]Stats, error) {
+	if err := h.initCheck(); err != nil {
+		return nil, err
+	}
+	all := make(map[string]Stats)
+	var perr error
+	err := h.eachSetDetails(func(details []string) {
+		stats, err := parseListTerse(details)
+		if err != nil {
+			perr = err
+			return
+		}
+		hd := parseHeader(details)
+		all[hd.Name] = stats
+		h.recordGrowth(hd.Name, hd.MaxElem, stats.Entries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if perr != nil {
+		return nil, perr
+	}
+	return all, nil
+}
+
+// SetEntries pairs a set with the entries to refresh it with.
+type SetEntries struct {
+	Set     *IPSet
+	Entries []Entry
+}
+
+// RefreshMany refreshes sets with the default handle, see Handle.RefreshMany.
+func RefreshMany(refreshes []SetEntries) error {
+	return defaultHandle.RefreshMany(refreshes)
+}
+
+// RefreshMany overwrites each set with its entries, as RefreshEntries does,
+// refreshing the sets in parallel, see WithConcurrency. Each set is refreshed
+// with its own handle. A *MultiError tells which sets could not be refreshed.
+func (h *Handle) RefreshMany(refreshes []SetEntries) error {
+	names := make([]string, len(refreshes))
+	errs := make([]error, len(refreshes))
+	h.parallel(len(refreshes), func(i int) {
+		names[i] = refreshes[i].Set.Name
+		errs[i] = refreshes[i].Set.RefreshEntries(refreshes[i].Entries)
+	})
+	return itemErrors("error refreshing sets", names, errs)
+}
@@ -0,0 +1,91 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWithConcurrency(t *testing.T) {
+	// the stub logs when it starts and ends, sleeping in between
+	h := stubHandle(t, `#!/bin/sh
+log="$(dirname "$0")/log"
+echo start >>"$log"
+sleep 0.05
+echo end >>"$log"
+cat >/dev/null
+`, WithConcurrency(1))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.run("flush", "app-a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	out, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(h.runner[0]), "log"))
+	if want := strings.Repeat("start\nend\n", 4); string(out) != want {
+		t.Errorf("processes ran as %q, want one at a time", out)
+	}
+}
+
+func TestParallelConcurrency(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		h := NewHandle(WithConcurrency(n))
+		var mu sync.Mutex
+		active, peak := 0, 0
+		release := make(chan struct{})
+		var started sync.WaitGroup
+		started.Add(n)
+		go func() {
+			// the first n workers are all busy before any of them returns
+			started.Wait()
+			close(release)
+		}()
+		calls := 0
+		h.parallel(10, func(i int) {
+			mu.Lock()
+			active++
+			calls++
+			if active > peak {
+				peak = active
+			}
+			first := calls <= n
+			mu.Unlock()
+			if first {
+				started.Done()
+			}
+			<-release
+			mu.Lock()
+			active--
+			mu.Unlock()
+		})
+		if calls != 10 || peak != n {
+			t.Errorf("WithConcurrency(%d): %d calls, %d at once", n, calls, peak)
+		}
+	}
+}
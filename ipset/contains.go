@@ -0,0 +1,270 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// ContainsAll reports whether the set matches every entry, as Test would,
+// listing the set once and matching the entries in memory. For hash:net sets
+// an address matches the most specific network containing it, unless that
+// network is a nomatch entry; a network matches if it is in the set as is.
+// For hash:ip sets created with a netmask, an address matches the network of
+// its netmask. Entries of other set types are compared verbatim with the
+// members as ipset prints them.
+func (s *IPSet) ContainsAll(entries []string) (bool, error) {
+	m, err := s.handle().matcher(s.Name)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if !m.contains(e) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ContainsAny reports whether the set matches at least one of the entries,
+// matched as by ContainsAll.
+func (s *IPSet) ContainsAny(entries []string) (bool, error) {
+	m, err := s.handle().matcher(s.Name)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if m.contains(e) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matcher matches entries against a copy of the members of a set.
+type matcher struct {
+	// exact holds the members matched verbatim
+	exact map[string]bool
+	// v4 and v6 are nil unless the set type matches addresses by prefix
+	v4, v6 *prefixTable
+	// netmask is the netmask of a hash:ip set, 0 if none
+	netmask int
+}
+
+// prefixTable answers longest-prefix matches for the networks of a family
+// from one hash table per prefix length, so that a lookup costs one probe
+// per distinct length in the set.
+type prefixTable struct {
+	// tables maps a prefix length to the masked networks of that length and
+	// whether they match, false for nomatch entries
+	tables map[int]map[string]bool
+	// lens holds the prefix lengths of tables, longest first
+	lens []int
+}
+
+func newPrefixTable() *prefixTable {
+	return &prefixTable{tables: make(map[int]map[string]bool)}
+}
+
+// add records the network n, matching unless nomatch, and reports whether it
+// was not in the table yet.
+func (t *prefixTable) add(n *net.IPNet, nomatch bool) bool {
+	ones, _ := n.Mask.Size()
+	tab, ok := t.tables[ones]
+	if !ok {
+		tab = make(map[string]bool)
+		t.tables[ones] = tab
+		t.lens = append(t.lens, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(t.lens)))
+	}
+	key := string(n.IP.Mask(n.Mask))
+	_, found := tab[key]
+	tab[key] = !nomatch
+	return !found
+}
+
+// del removes the network n and reports whether it was in the table.
+func (t *prefixTable) del(n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	tab := t.tables[ones]
+	key := string(n.IP.Mask(n.Mask))
+	if _, found := tab[key]; !found {
+		return false
+	}
+	delete(tab, key)
+	if len(tab) == 0 {
+		delete(t.tables, ones)
+		for i, l := range t.lens {
+			if l == ones {
+				t.lens = append(t.lens[:i], t.lens[i+1:]...)
+				break
+			}
+		}
+	}
+	return true
+}
+
+// lookup returns whether the most specific network containing ip matches,
+// and false if none contains it.
+func (t *prefixTable) lookup(ip net.IP) bool {
+	bits := len(ip) * 8
+	for _, l := range t.lens {
+		if match, ok := t.tables[l][string(ip.Mask(net.CIDRMask(l, bits)))]; ok {
+			return match
+		}
+	}
+	return false
+}
+
+// exactly returns whether the network n is in the table as is and matches.
+func (t *prefixTable) exactly(n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	return t.tables[ones][string(n.IP.Mask(n.Mask))]
+}
+
+// table returns the table of the family of ip, which is in its shortest form.
+func (m *matcher) table(ip net.IP) *prefixTable {
+	if len(ip) == net.IPv4len {
+		return m.v4
+	}
+	return m.v6
+}
+
+func (m *matcher) contains(entry string) bool {
+	if m.v4 != nil {
+		if ip := net.ParseIP(entry); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			return m.table(ip).lookup(ip)
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			return m.table(n.IP).exactly(n)
+		}
+	}
+	return m.exact[entry]
+}
+
+// matcher lists the set once and returns a matcher of its members.
+func (h *Handle) matcher(set string) (*matcher, error) {
+	hd, entries, err := h.snapshot(set)
+	if err != nil {
+		return nil, err
+	}
+	return newMatcher(hd, entries), nil
+}
+
+// newMatcher returns a matcher of the entries of a set with header hd.
+func newMatcher(hd Header, entries []Entry) *matcher {
+	m := &matcher{exact: make(map[string]bool, len(entries))}
+	switch hd.Type {
+	case "hash:net", "hash:ip":
+		m.v4, m.v6 = newPrefixTable(), newPrefixTable()
+	}
+	if hd.Type == "hash:ip" {
+		m.netmask = hd.NetMask
+	}
+	for _, e := range entries {
+		m.add(e)
+	}
+	return m
+}
+
+// add records the entry and reports whether it was not a member yet.
+func (m *matcher) add(e Entry) bool {
+	if n, ok := m.network(e.Element); ok {
+		return m.table(n.IP).add(n, e.Nomatch)
+	}
+	_, found := m.exact[e.Element]
+	m.exact[e.Element] = !e.Nomatch
+	return !found
+}
+
+// del removes the member elem and reports whether it was one.
+func (m *matcher) del(elem string) bool {
+	if n, ok := m.network(elem); ok {
+		return m.table(n.IP).del(n)
+	}
+	_, found := m.exact[elem]
+	delete(m.exact, elem)
+	return found
+}
+
+// prefixes reports whether the set type matches addresses by prefix and the
+// elements are all addresses or networks.
+func (m *matcher) prefixes(elems []string) bool {
+	for _, elem := range elems {
+		if _, ok := m.network(elem); !ok {
+			return false
+		}
+	}
+	return m.v4 != nil
+}
+
+// network returns the network elem is stored as when the set type matches
+// addresses by prefix.
+func (m *matcher) network(elem string) (*net.IPNet, bool) {
+	if m.v4 == nil {
+		return nil, false
+	}
+	n, err := parseNet(elem)
+	if err != nil {
+		return nil, false
+	}
+	if m.netmask != 0 {
+		n.Mask = net.CIDRMask(m.netmask, len(n.IP)*8)
+	}
+	return n, true
+}
+
+// snapshot returns the header and the entries of the set read from a single
+// `ipset save`, or `ipset list` for utilities without save.
+func (h *Handle) snapshot(set string) (Header, []Entry, error) {
+	if caps.BusyBox {
+		details, err := h.listWithOpts(set)
+		if err != nil {
+			return Header{}, nil, err
+		}
+		out := []byte(joinLines(details))
+		return ParseHeader(out), ParseMembers(out), nil
+	}
+	var buf bytes.Buffer
+	if err := h.save(set, &buf); err != nil {
+		return Header{}, nil, err
+	}
+	sets, err := ParseSave(buf.Bytes())
+	if err != nil {
+		return Header{}, nil, err
+	}
+	for _, s := range sets {
+		if s.Header.Name == set {
+			return s.Header, s.Entries, nil
+		}
+	}
+	return Header{Name: set}, nil, nil
+}
+
+func joinLines(ls []string) string {
+	var b bytes.Buffer
+	for _, l := range ls {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
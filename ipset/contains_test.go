@@ -0,0 +1,106 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "testing"
+
+func TestMatcherLongestPrefix(t *testing.T) {
+	m := newMatcher(Header{Name: "blocked", Type: "hash:net"}, []Entry{
+		{Element: "10.0.0.0/8"},
+		{Element: "10.1.0.0/16", EntryOptions: EntryOptions{Nomatch: true}},
+		{Element: "10.1.2.0/24"},
+		{Element: "192.0.2.1"},
+		{Element: "2001:db8::/32"},
+		{Element: "2001:db8:1::/48", EntryOptions: EntryOptions{Nomatch: true}},
+	})
+	for _, tc := range []struct {
+		entry string
+		want  bool
+	}{
+		{"10.9.9.9", true},
+		{"10.1.9.9", false},
+		{"10.1.2.3", true},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+		{"172.16.0.1", false},
+		{"::ffff:10.9.9.9", true},
+		{"2001:db8:2::1", true},
+		{"2001:db8:1::1", false},
+		{"10.0.0.0/8", true},
+		{"10.1.2.0/24", true},
+		{"10.1.0.0/16", false},
+		{"10.2.0.0/16", false},
+		{"not-an-address", false},
+	} {
+		if got := m.contains(tc.entry); got != tc.want {
+			t.Errorf("contains(%s) = %v, want %v", tc.entry, got, tc.want)
+		}
+	}
+}
+
+func TestMatcherNetmask(t *testing.T) {
+	m := newMatcher(Header{Name: "hosts", Type: "hash:ip", NetMask: 24}, []Entry{{Element: "192.0.2.0"}})
+	if !m.contains("192.0.2.77") {
+		t.Error("hash:ip with netmask 24 does not match an address of the network")
+	}
+	if m.contains("192.0.3.1") {
+		t.Error("hash:ip with netmask 24 matches an address of another network")
+	}
+}
+
+func TestMatcherVerbatim(t *testing.T) {
+	m := newMatcher(Header{Name: "web", Type: "hash:ip,port"}, []Entry{{Element: "192.0.2.10,tcp:80"}})
+	if !m.contains("192.0.2.10,tcp:80") || m.contains("192.0.2.10,tcp:443") || m.contains("192.0.2.10") {
+		t.Error("hash:ip,port members are not matched verbatim")
+	}
+}
+
+func TestMatcherAddDel(t *testing.T) {
+	m := newMatcher(Header{Name: "blocked", Type: "hash:net"}, []Entry{{Element: "10.0.0.0/8"}})
+	if !m.prefixes([]string{"10.1.0.0/16", "192.0.2.1"}) || m.prefixes([]string{"10.1.0.0/16", "eth0"}) {
+		t.Error("prefixes does not tell addresses and networks apart")
+	}
+	if !m.add(Entry{Element: "10.1.0.0/16", EntryOptions: EntryOptions{Nomatch: true}}) {
+		t.Error("add of a new network reported it as a member")
+	}
+	if m.add(Entry{Element: "10.0.0.0/8"}) {
+		t.Error("add of a member reported it as new")
+	}
+	if m.contains("10.1.2.3") || !m.contains("10.2.0.1") {
+		t.Error("nomatch network added in place is not matched")
+	}
+	if !m.del("10.1.0.0/16") || m.del("10.1.0.0/16") {
+		t.Error("del does not report whether the network was a member")
+	}
+	if !m.contains("10.1.2.3") {
+		t.Error("address of a deleted nomatch network is not matched by the enclosing one")
+	}
+	if !m.del("10.0.0.0/8") || m.contains("10.2.0.1") {
+		t.Error("deleted network still matches")
+	}
+
+	v := newMatcher(Header{Name: "web", Type: "hash:ip,port"}, nil)
+	if v.prefixes([]string{"192.0.2.1"}) {
+		t.Error("hash:ip,port matches by prefix")
+	}
+	if !v.add(Entry{Element: "192.0.2.10,tcp:80"}) || !v.contains("192.0.2.10,tcp:80") {
+		t.Error("verbatim member not added")
+	}
+	if !v.del("192.0.2.10,tcp:80") || v.contains("192.0.2.10,tcp:80") {
+		t.Error("verbatim member not deleted")
+	}
+}
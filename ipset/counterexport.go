@@ -0,0 +1,112 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// CounterSink records the counter samples of a CounterCollector, e.g. for
+// usage reporting pipelines.
+type CounterSink interface {
+	WriteCounters(samples []CounterSample) error
+}
+
+// Export makes the collector write the samples of every periodic pass to
+// sink. Failures to write are logged.
+func (c *CounterCollector) Export(sink CounterSink) {
+	c.Subscribe(func(samples []CounterSample) {
+		if err := sink.WriteCounters(samples); err != nil {
+			log.Errorf("Error exporting %d counter samples: %v", len(samples), err)
+		}
+	})
+}
+
+// counterColumns are the columns of the records of CSVCounterSink.
+var counterColumns = []string{"time", "set", "entry", "packets", "bytes"}
+
+// CSVCounterSink writes samples as CSV records with the columns time, set,
+// entry, packets and bytes, after a header record. Times are in RFC 3339
+// format, UTC. The flat layout loads as is into columnar formats such as
+// Parquet.
+type CSVCounterSink struct {
+	mu     sync.Mutex
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVCounterSink returns a sink writing to w.
+func NewCSVCounterSink(w io.Writer) *CSVCounterSink {
+	return &CSVCounterSink{w: csv.NewWriter(w)}
+}
+
+// WriteCounters implements CounterSink.
+func (s *CSVCounterSink) WriteCounters(samples []CounterSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.header {
+		if err := s.w.Write(counterColumns); err != nil {
+			return err
+		}
+		s.header = true
+	}
+	for _, c := range samples {
+		rec := []string{
+			c.Time.UTC().Format(time.RFC3339Nano),
+			c.Set,
+			c.Entry,
+			strconv.FormatUint(c.Packets, 10),
+			strconv.FormatUint(c.Bytes, 10),
+		}
+		if err := s.w.Write(rec); err != nil {
+			return err
+		}
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// JSONCounterSink writes samples as JSON objects, one per line, with the
+// fields time, set, entry, packets and bytes.
+type JSONCounterSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONCounterSink returns a sink writing to w.
+func NewJSONCounterSink(w io.Writer) *JSONCounterSink {
+	return &JSONCounterSink{enc: json.NewEncoder(w)}
+}
+
+// WriteCounters implements CounterSink.
+func (s *JSONCounterSink) WriteCounters(samples []CounterSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range samples {
+		if err := s.enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// OtherEntry is the entry of the sample aggregating the entries beyond
+// CounterCollector.MaxEntries.
+const OtherEntry = "_other"
+
+// CounterSample holds the counters of one entry of a set created with counters.
+type CounterSample struct {
+	Set     string    `json:"set"`
+	Entry   string    `json:"entry"`
+	Packets uint64    `json:"packets"`
+	Bytes   uint64    `json:"bytes"`
+	Time    time.Time `json:"time"`
+}
+
+// CounterCollector periodically samples the per-entry packet and byte counters
+// of sets created with Params.Counters and hands the samples to subscribers,
+// e.g. a Prometheus collector.
+type CounterCollector struct {
+	// MaxEntries bounds the number of samples per set to control label
+	// cardinality: the entries with the most bytes are kept and the others
+	// summed up into one OtherEntry sample. 0 keeps every entry.
+	MaxEntries int
+	// Filter selects the entries to sample, nil for all of them.
+	Filter func(set string, e Entry) bool
+
+	interval time.Duration
+	sets     []*IPSet
+
+	mu          sync.Mutex
+	latest      []CounterSample
+	subscribers []func([]CounterSample)
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewCounterCollector returns a collector sampling the given sets every interval
+// once started, every minute when zero.
+func NewCounterCollector(interval time.Duration, sets ...*IPSet) *CounterCollector {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &CounterCollector{interval: interval, sets: sets}
+}
+
+// Subscribe registers fn to be called with the samples of every periodic pass.
+func (c *CounterCollector) Subscribe(fn func([]CounterSample)) {
+	c.mu.Lock()
+	c.subscribers = append(c.subscribers, fn)
+	c.mu.Unlock()
+}
+
+// Sample lists the sets once and returns their counters. Sets failing to list
+// are logged and skipped; the error of the last failure is returned along
+// with the samples of the other sets.
+func (c *CounterCollector) Sample() ([]CounterSample, error) {
+	var samples []CounterSample
+	var lastErr error
+	for _, s := range c.sets {
+		entries, err := s.ListEntries()
+		if err != nil {
+			log.Warnf("Error sampling counters of set %s: %v", s.Name, err)
+			lastErr = err
+			continue
+		}
+		samples = append(samples, c.setSamples(s.Name, entries, time.Now())...)
+	}
+	c.mu.Lock()
+	c.latest = samples
+	c.mu.Unlock()
+	return samples, lastErr
+}
+
+func (c *CounterCollector) setSamples(set string, entries []Entry, now time.Time) []CounterSample {
+	samples := make([]CounterSample, 0, len(entries))
+	for _, e := range entries {
+		if c.Filter != nil && !c.Filter(set, e) {
+			continue
+		}
+		samples = append(samples, CounterSample{Set: set, Entry: e.Element, Packets: e.Packets, Bytes: e.Bytes, Time: now})
+	}
+	if c.MaxEntries <= 0 || len(samples) <= c.MaxEntries {
+		return samples
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Bytes > samples[j].Bytes })
+	other := CounterSample{Set: set, Entry: OtherEntry, Time: now}
+	for _, s := range samples[c.MaxEntries:] {
+		other.Packets += s.Packets
+		other.Bytes += s.Bytes
+	}
+	return append(samples[:c.MaxEntries], other)
+}
+
+// Latest returns the samples of the last pass.
+func (c *CounterCollector) Latest() []CounterSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latest
+}
+
+// Start samples the sets every interval until Stop is called.
+func (c *CounterCollector) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go c.loop(c.stop, c.done)
+}
+
+// Stop ends the periodic sampling.
+func (c *CounterCollector) Stop() {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (c *CounterCollector) loop(stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		c.publish()
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *CounterCollector) publish() {
+	samples, _ := c.Sample()
+	c.mu.Lock()
+	subs := append(([]func([]CounterSample))(nil), c.subscribers...)
+	c.mu.Unlock()
+	for _, fn := range subs {
+		fn(samples)
+	}
+}
+
+// CounterOrder selects the counter TopEntries ranks entries by.
+type CounterOrder int
+
+const (
+	// ByBytes ranks entries by matched bytes.
+	ByBytes CounterOrder = iota
+	// ByPackets ranks entries by matched packets.
+	ByPackets
+)
+
+// TopEntries returns the n most hit entries of a set created with counters,
+// ranked by bytes or packets, computed from a single listing. Ties are ordered
+// by element. A non-positive n returns every entry, ranked.
+func (s *IPSet) TopEntries(n int, by CounterOrder) ([]Entry, error) {
+	entries, err := s.ListEntries()
+	if err != nil {
+		return nil, err
+	}
+	key := func(e Entry) uint64 {
+		if by == ByPackets {
+			return e.Packets
+		}
+		return e.Bytes
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if ki, kj := key(entries[i]), key(entries[j]); ki != kj {
+			return ki > kj
+		}
+		return entries[i].Element < entries[j].Element
+	})
+	if n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+	return entries, nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sync"
+	"time"
+)
+
+// CounterDelta is the change of the counters of an entry between two samples.
+type CounterDelta struct {
+	Set     string
+	Entry   string
+	Packets uint64
+	Bytes   uint64
+	// Interval is the time between the two samples.
+	Interval time.Duration
+	// New is set for an entry that appeared since the previous sample; its
+	// counters started from zero when it was added.
+	New bool
+	// Reset is set when the counters went backwards, e.g. because the entry was
+	// deleted and re-added or the set was refreshed; the delta then counts from zero.
+	Reset bool
+}
+
+// PacketRate returns the packets per second over the interval.
+func (d CounterDelta) PacketRate() float64 {
+	if d.Interval <= 0 {
+		return 0
+	}
+	return float64(d.Packets) / d.Interval.Seconds()
+}
+
+// ByteRate returns the bytes per second over the interval.
+func (d CounterDelta) ByteRate() float64 {
+	if d.Interval <= 0 {
+		return 0
+	}
+	return float64(d.Bytes) / d.Interval.Seconds()
+}
+
+type sampleKey struct {
+	set   string
+	entry string
+}
+
+// CounterSampler turns successive cumulative counter samples into per-entry
+// deltas usable for rate dashboards. It is safe for concurrent use.
+type CounterSampler struct {
+	mu      sync.Mutex
+	started bool
+	prev    map[sampleKey]CounterSample
+	// times holds the time of the previous sample of each set
+	times map[string]time.Time
+}
+
+// NewCounterSampler returns a sampler without baseline.
+func NewCounterSampler() *CounterSampler {
+	return &CounterSampler{prev: make(map[sampleKey]CounterSample), times: make(map[string]time.Time)}
+}
+
+// Update records samples and returns the deltas since the previous call. The
+// first call only records the baseline and returns nothing. Entries missing
+// from samples are forgotten, so sample all sets of interest on every call.
+func (cs *CounterSampler) Update(samples []CounterSample) []CounterDelta {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cur := make(map[sampleKey]CounterSample, len(samples))
+	times := make(map[string]time.Time)
+	var deltas []CounterDelta
+	for _, s := range samples {
+		k := sampleKey{s.Set, s.Entry}
+		cur[k] = s
+		if s.Time.After(times[s.Set]) {
+			times[s.Set] = s.Time
+		}
+		if !cs.started {
+			continue
+		}
+		d := CounterDelta{Set: s.Set, Entry: s.Entry}
+		p, ok := cs.prev[k]
+		switch {
+		case !ok:
+			d.New = true
+			d.Packets, d.Bytes = s.Packets, s.Bytes
+			if t, ok := cs.times[s.Set]; ok {
+				d.Interval = s.Time.Sub(t)
+			}
+		case s.Packets < p.Packets || s.Bytes < p.Bytes:
+			d.Reset = true
+			d.Packets, d.Bytes = s.Packets, s.Bytes
+			d.Interval = s.Time.Sub(p.Time)
+		default:
+			d.Packets, d.Bytes = s.Packets-p.Packets, s.Bytes-p.Bytes
+			d.Interval = s.Time.Sub(p.Time)
+		}
+		deltas = append(deltas, d)
+	}
+	cs.prev, cs.times = cur, times
+	cs.started = true
+	return deltas
+}
+
+// Observe lists the sets and records their counters, see Update.
+func (cs *CounterSampler) Observe(sets ...*IPSet) ([]CounterDelta, error) {
+	now := time.Now()
+	var samples []CounterSample
+	for _, s := range sets {
+		entries, err := s.ListEntries()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			samples = append(samples, CounterSample{Set: s.Name, Entry: e.Element, Packets: e.Packets, Bytes: e.Bytes, Time: now})
+		}
+	}
+	return cs.Update(samples), nil
+}
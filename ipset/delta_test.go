@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCounterSampler(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1 := t0.Add(10 * time.Second)
+	cs := NewCounterSampler()
+	if d := cs.Update([]CounterSample{
+		{Set: "web", Entry: "192.0.2.1", Packets: 10, Bytes: 1000, Time: t0},
+		{Set: "web", Entry: "192.0.2.2", Packets: 50, Bytes: 5000, Time: t0},
+		{Set: "web", Entry: "192.0.2.9", Packets: 1, Bytes: 100, Time: t0},
+	}); d != nil {
+		t.Errorf("baseline returned deltas %+v", d)
+	}
+	got := cs.Update([]CounterSample{
+		{Set: "web", Entry: "192.0.2.1", Packets: 30, Bytes: 3000, Time: t1},
+		{Set: "web", Entry: "192.0.2.2", Packets: 5, Bytes: 400, Time: t1},
+		{Set: "web", Entry: "192.0.2.3", Packets: 7, Bytes: 700, Time: t1},
+	})
+	want := []CounterDelta{
+		{Set: "web", Entry: "192.0.2.1", Packets: 20, Bytes: 2000, Interval: 10 * time.Second},
+		{Set: "web", Entry: "192.0.2.2", Packets: 5, Bytes: 400, Interval: 10 * time.Second, Reset: true},
+		{Set: "web", Entry: "192.0.2.3", Packets: 7, Bytes: 700, Interval: 10 * time.Second, New: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got deltas %+v, want %+v", got, want)
+	}
+	if r := got[0].PacketRate(); r != 2 {
+		t.Errorf("packet rate %v, want 2", r)
+	}
+	if r := got[0].ByteRate(); r != 200 {
+		t.Errorf("byte rate %v, want 200", r)
+	}
+	if r := (CounterDelta{Packets: 5}).PacketRate(); r != 0 {
+		t.Errorf("packet rate without interval %v, want 0", r)
+	}
+	// 192.0.2.9 was missing from the previous sample and counts as new again.
+	got = cs.Update([]CounterSample{{Set: "web", Entry: "192.0.2.9", Packets: 3, Bytes: 300, Time: t1.Add(5 * time.Second)}})
+	want = []CounterDelta{{Set: "web", Entry: "192.0.2.9", Packets: 3, Bytes: 300, Interval: 5 * time.Second, New: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got deltas %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxIfaceLen is the longest interface name the kernel accepts (IFNAMSIZ - 1).
+const maxIfaceLen = 15
+
+// physdevPrefix marks the interface of a hash:net,iface element as a bridge port.
+const physdevPrefix = "physdev:"
+
+// NetIface is an element of a hash:net,iface set: a network and an interface
+// name. With Physdev set the interface is matched against the physical bridge
+// port of the packet (the physdev-in/physdev-out of bridged traffic) instead of
+// its ingress or egress device. With Wildcard set, Iface is a prefix matching
+// every interface starting with it, e.g. "veth" for all veth devices; it is an
+// add option rather than part of the element.
+type NetIface struct {
+	Net      *net.IPNet
+	Iface    string
+	Physdev  bool
+	Wildcard bool
+}
+
+// ParseNetIface parses a hash:net,iface element, e.g. "10.0.0.0/8,eth0" or
+// "192.0.2.1,physdev:veth0". An address without a prefix length is a host network.
+func ParseNetIface(elem string) (NetIface, error) {
+	i := strings.LastIndex(elem, ",")
+	if i < 0 {
+		return NetIface{}, fmt.Errorf("invalid net,iface element %s: missing interface", elem)
+	}
+	n, err := parseNet(elem[:i])
+	if err != nil {
+		return NetIface{}, fmt.Errorf("invalid net,iface element %s: %w", elem, err)
+	}
+	ni := NetIface{Net: n, Iface: elem[i+1:]}
+	if strings.HasPrefix(ni.Iface, physdevPrefix) {
+		ni.Physdev = true
+		ni.Iface = strings.TrimPrefix(ni.Iface, physdevPrefix)
+	}
+	if err := ni.Validate(); err != nil {
+		return NetIface{}, err
+	}
+	return ni, nil
+}
+
+// Validate checks that the element is accepted by ipset.
+func (n NetIface) Validate() error {
+	if n.Net == nil {
+		return fmt.Errorf("invalid net,iface element: missing network")
+	}
+	if n.Iface == "" || len(n.Iface) > maxIfaceLen {
+		return fmt.Errorf("invalid interface name %q: want 1 to %d characters", n.Iface, maxIfaceLen)
+	}
+	if strings.ContainsAny(n.Iface, " \t,/") {
+		return fmt.Errorf("invalid interface name %q", n.Iface)
+	}
+	return nil
+}
+
+// String returns the element in ipset syntax, without the wildcard option.
+func (n NetIface) String() string {
+	iface := n.Iface
+	if n.Physdev {
+		iface = physdevPrefix + iface
+	}
+	return netString(n.Net) + "," + iface
+}
+
+// Entry returns the element as an entry, carrying the wildcard option.
+func (n NetIface) Entry() Entry {
+	return Entry{Element: n.String(), EntryOptions: EntryOptions{Wildcard: n.Wildcard}}
+}
+
+// parseNet parses an address or a network in CIDR notation, returning a host
+// network for an address.
+func parseNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither an address nor a network", s)
+	}
+	return n, nil
+}
+
+// AddrPortAddr is an element of the three-dimensional hash:ip,port,ip,
+// hash:ip,port,net and hash:net,port,net sets. A dimension of type ip holds a
+// host network, i.e. a single address.
+type AddrPortAddr struct {
+	First  *net.IPNet
+	Port   Port
+	Second *net.IPNet
+}
+
+// NewAddrPortAddr builds an element of a set of the given type from its
+// dimensions, e.g. ("hash:ip,port,net", "192.0.2.1", "tcp:443", "10.0.0.0/8"),
+// and validates it against the type.
+func NewAddrPortAddr(settype, first, port, second string) (AddrPortAddr, error) {
+	var e AddrPortAddr
+	var err error
+	if e.First, err = parseNet(first); err != nil {
+		return AddrPortAddr{}, err
+	}
+	if e.Port, err = ParsePort(port); err != nil {
+		return AddrPortAddr{}, err
+	}
+	if e.Second, err = parseNet(second); err != nil {
+		return AddrPortAddr{}, err
+	}
+	if err := e.Validate(settype, ""); err != nil {
+		return AddrPortAddr{}, err
+	}
+	return e, nil
+}
+
+// ParseAddrPortAddr parses an element of a set of the given type, e.g.
+// "192.0.2.1,udp:53,192.0.2.2" for hash:ip,port,ip.
+func ParseAddrPortAddr(settype, elem string) (AddrPortAddr, error) {
+	dims := strings.Split(elem, ",")
+	if len(dims) != 3 {
+		return AddrPortAddr{}, fmt.Errorf("invalid element %s: want 3 dimensions", elem)
+	}
+	return NewAddrPortAddr(settype, dims[0], dims[1], dims[2])
+}
+
+// Validate checks that the element fits a set of the given type and family:
+// the ip dimensions are single addresses and both addresses belong to the
+// family, "inet" or "inet6", which is also the one of an icmp or icmpv6 port.
+// An empty family only requires the two to match.
+func (e AddrPortAddr) Validate(settype, family string) error {
+	dims := strings.Split(strings.TrimPrefix(settype, "hash:"), ",")
+	if !strings.HasPrefix(settype, "hash:") || len(dims) != 3 || dims[1] != "port" ||
+		(dims[0] != "ip" && dims[0] != "net") || (dims[2] != "ip" && dims[2] != "net") ||
+		(dims[0] == "net" && dims[2] == "ip") {
+		return fmt.Errorf("not a three-dimensional address,port,address type: %s", settype)
+	}
+	if e.First == nil || e.Second == nil {
+		return fmt.Errorf("invalid %s element: missing address", settype)
+	}
+	for i, n := range []*net.IPNet{e.First, e.Second} {
+		if dims[2*i] == "ip" && !isHostNet(n) {
+			return fmt.Errorf("invalid %s element: %s is not a single address", settype, netString(n))
+		}
+	}
+	first, second := netFamily(e.First), netFamily(e.Second)
+	if first != second {
+		return fmt.Errorf("invalid %s element: %s and %s are of different families", settype, netString(e.First), netString(e.Second))
+	}
+	if family != "" && first != family {
+		return fmt.Errorf("invalid %s element: addresses are not of family %s", settype, family)
+	}
+	if (e.Port.Proto == ProtoICMP && first != "inet") || (e.Port.Proto == ProtoICMPv6 && first != "inet6") {
+		return fmt.Errorf("invalid %s element: %s with %s addresses", settype, e.Port.Proto, first)
+	}
+	return nil
+}
+
+// String returns the element in ipset syntax.
+func (e AddrPortAddr) String() string {
+	return netString(e.First) + "," + e.Port.String() + "," + netString(e.Second)
+}
+
+// isHostNet reports whether the network holds a single address.
+func isHostNet(n *net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+	return ones == bits
+}
+
+// netFamily returns the ipset family of the network, "inet" or "inet6".
+func netFamily(n *net.IPNet) string {
+	if n.IP.To4() != nil {
+		return "inet"
+	}
+	return "inet6"
+}
+
+// netString returns the network in the form ipset lists it, an address for a
+// host network.
+func netString(n *net.IPNet) string {
+	if n == nil {
+		return ""
+	}
+	if isHostNet(n) {
+		return n.IP.String()
+	}
+	return n.String()
+}
+
+// ParseMAC parses an Ethernet address of the hash:mac and hash:ip,mac set
+// types in any form net.ParseMAC accepts, e.g. "00:1a:2b:3c:4d:5e" or
+// "001a.2b3c.4d5e".
+func ParseMAC(s string) (net.HardwareAddr, error) {
+	hw, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %s: not an Ethernet address", s)
+	}
+	return hw, nil
+}
+
+// FormatMAC returns the Ethernet address in the form ipset lists it,
+// e.g. "00:1A:2B:3C:4D:5E".
+func FormatMAC(hw net.HardwareAddr) string {
+	return strings.ToUpper(hw.String())
+}
+
+// IPMAC is an element of a hash:ip,mac set.
+type IPMAC struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+}
+
+// ParseIPMAC parses a hash:ip,mac element, e.g. "192.0.2.1,00:1a:2b:3c:4d:5e".
+func ParseIPMAC(elem string) (IPMAC, error) {
+	i := strings.Index(elem, ",")
+	if i < 0 {
+		return IPMAC{}, fmt.Errorf("invalid ip,mac element %s: missing MAC address", elem)
+	}
+	ip := net.ParseIP(elem[:i])
+	if ip == nil {
+		return IPMAC{}, fmt.Errorf("invalid ip,mac element %s: %s is not an address", elem, elem[:i])
+	}
+	hw, err := ParseMAC(elem[i+1:])
+	if err != nil {
+		return IPMAC{}, fmt.Errorf("invalid ip,mac element %s: %w", elem, err)
+	}
+	return IPMAC{IP: ip, MAC: hw}, nil
+}
+
+// String returns the element in the form ipset lists it.
+func (e IPMAC) String() string {
+	return e.IP.String() + "," + FormatMAC(e.MAC)
+}
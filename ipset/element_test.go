@@ -0,0 +1,177 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNetIface(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+		iface   string
+		physdev bool
+	}{
+		{"10.0.0.0/8,eth0", "10.0.0.0/8,eth0", "eth0", false},
+		{"192.0.2.1,physdev:veth0", "192.0.2.1,physdev:veth0", "veth0", true},
+		{"192.0.2.1/32,eth0", "192.0.2.1,eth0", "eth0", false},
+		{"2001:db8::/32,br0", "2001:db8::/32,br0", "br0", false},
+		{"10.0.0.0/8," + strings.Repeat("i", 15), "10.0.0.0/8," + strings.Repeat("i", 15), strings.Repeat("i", 15), false},
+	} {
+		n, err := ParseNetIface(tc.in)
+		if err != nil {
+			t.Errorf("ParseNetIface(%q): %v", tc.in, err)
+			continue
+		}
+		if n.Iface != tc.iface || n.Physdev != tc.physdev || n.String() != tc.out {
+			t.Errorf("ParseNetIface(%q) = %+v formatted as %q, want interface %s, physdev %v, %q", tc.in, n, n, tc.iface, tc.physdev, tc.out)
+		}
+	}
+	for _, in := range []string{
+		"10.0.0.0/8",
+		"10.0.0.0/8,",
+		"10.0.0.0/8,physdev:",
+		"10.0.0.0/8," + strings.Repeat("i", 16),
+		"not-a-net,eth0",
+		"10.0.0.0/8,eth 0",
+	} {
+		if n, err := ParseNetIface(in); err == nil {
+			t.Errorf("ParseNetIface(%q) = %+v, want an error", in, n)
+		}
+	}
+	if err := (NetIface{Iface: "eth0"}).Validate(); err == nil {
+		t.Error("element without network validated")
+	}
+}
+
+func TestNetIfaceEntry(t *testing.T) {
+	n, err := ParseNetIface("10.0.0.0/8,veth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := n.Entry(); !reflect.DeepEqual(e, Entry{Element: "10.0.0.0/8,veth"}) {
+		t.Errorf("entry %+v", e)
+	}
+	n.Wildcard = true
+	e := n.Entry()
+	if !reflect.DeepEqual(e, Entry{Element: "10.0.0.0/8,veth", EntryOptions: EntryOptions{Wildcard: true}}) {
+		t.Errorf("wildcard entry %+v", e)
+	}
+	if s := e.String(); s != "10.0.0.0/8,veth wildcard" {
+		t.Errorf("wildcard entry formatted as %q", s)
+	}
+}
+
+func TestParseAddrPortAddr(t *testing.T) {
+	for _, tc := range []struct {
+		settype, elem, out string
+	}{
+		{"hash:ip,port,ip", "192.0.2.1,udp:53,192.0.2.2", "192.0.2.1,udp:53,192.0.2.2"},
+		{"hash:ip,port,ip", "192.0.2.1/32,80,192.0.2.2", "192.0.2.1,80,192.0.2.2"},
+		{"hash:ip,port,net", "192.0.2.1,tcp:443,10.0.0.0/8", "192.0.2.1,tcp:443,10.0.0.0/8"},
+		{"hash:net,port,net", "10.0.0.0/8,tcp:443,192.0.2.0/24", "10.0.0.0/8,tcp:443,192.0.2.0/24"},
+		{"hash:ip,port,ip", "2001:db8::1,icmpv6:1/4,2001:db8::2", "2001:db8::1,icmpv6:port-unreachable,2001:db8::2"},
+		{"hash:ip,port,ip", "192.0.2.1,icmp:echo-request,192.0.2.2", "192.0.2.1,icmp:echo-request,192.0.2.2"},
+	} {
+		e, err := ParseAddrPortAddr(tc.settype, tc.elem)
+		if err != nil {
+			t.Errorf("ParseAddrPortAddr(%s, %q): %v", tc.settype, tc.elem, err)
+			continue
+		}
+		if s := e.String(); s != tc.out {
+			t.Errorf("ParseAddrPortAddr(%s, %q) formatted as %q, want %q", tc.settype, tc.elem, s, tc.out)
+		}
+	}
+	for _, tc := range []struct {
+		settype, elem string
+	}{
+		{"hash:net,port,ip", "10.0.0.0/8,tcp:443,192.0.2.1"},
+		{"hash:ip,port", "192.0.2.1,tcp:443,192.0.2.2"},
+		{"hash:ip,port,ip", "192.0.2.1,tcp:443"},
+		{"hash:ip,port,ip", "10.0.0.0/8,tcp:443,192.0.2.2"},
+		{"hash:ip,port,net", "192.0.2.1,tcp:443,2001:db8::/32"},
+		{"hash:ip,port,ip", "2001:db8::1,icmp:echo-request,2001:db8::2"},
+		{"hash:ip,port,ip", "192.0.2.1,icmpv6:1/4,192.0.2.2"},
+		{"hash:ip,port,ip", "192.0.2.1,ftp:21,192.0.2.2"},
+	} {
+		if e, err := ParseAddrPortAddr(tc.settype, tc.elem); err == nil {
+			t.Errorf("ParseAddrPortAddr(%s, %q) = %v, want an error", tc.settype, tc.elem, e)
+		}
+	}
+}
+
+func TestAddrPortAddrFamily(t *testing.T) {
+	e, err := NewAddrPortAddr("hash:ip,port,net", "192.0.2.1", "tcp:443", "10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Validate("hash:ip,port,net", "inet"); err != nil {
+		t.Errorf("inet element rejected for family inet: %v", err)
+	}
+	if err := e.Validate("hash:ip,port,net", "inet6"); err == nil {
+		t.Error("inet element accepted for family inet6")
+	}
+	if err := (AddrPortAddr{Port: Port{Port: 80}}).Validate("hash:ip,port,ip", ""); err == nil {
+		t.Error("element without addresses validated")
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	for _, in := range []string{"00:1a:2b:3c:4d:5e", "00-1A-2B-3C-4D-5E", "001a.2b3c.4d5e"} {
+		hw, err := ParseMAC(in)
+		if err != nil {
+			t.Errorf("ParseMAC(%q): %v", in, err)
+			continue
+		}
+		if s := FormatMAC(hw); s != "00:1A:2B:3C:4D:5E" {
+			t.Errorf("ParseMAC(%q) formatted as %q", in, s)
+		}
+	}
+	for _, in := range []string{"00:1a:2b:3c:4d:5e:6f:70", "0000.5e00.5301.0000", "00:1a:2b:3c:4d", "not-a-mac"} {
+		if hw, err := ParseMAC(in); err == nil {
+			t.Errorf("ParseMAC(%q) = %v, want an error", in, hw)
+		}
+	}
+}
+
+func TestParseIPMAC(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"192.0.2.1,00:1a:2b:3c:4d:5e", "192.0.2.1,00:1A:2B:3C:4D:5E"},
+		{"2001:db8::1,001a.2b3c.4d5e", "2001:db8::1,00:1A:2B:3C:4D:5E"},
+	} {
+		e, err := ParseIPMAC(tc.in)
+		if err != nil {
+			t.Errorf("ParseIPMAC(%q): %v", tc.in, err)
+			continue
+		}
+		if s := e.String(); s != tc.out {
+			t.Errorf("ParseIPMAC(%q) formatted as %q, want %q", tc.in, s, tc.out)
+		}
+		if again, err := ParseIPMAC(e.String()); err != nil || again.String() != tc.out {
+			t.Errorf("ParseIPMAC(%q) = %v, %v after round trip", e, again, err)
+		}
+	}
+	for _, in := range []string{"192.0.2.1", "192.0.2.1,00:1a:2b:3c:4d:5e:6f:70", "192.0.2.0/24,00:1a:2b:3c:4d:5e", "192.0.2.1,"} {
+		if e, err := ParseIPMAC(in); err == nil {
+			t.Errorf("ParseIPMAC(%q) = %v, want an error", in, e)
+		}
+	}
+}
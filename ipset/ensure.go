@@ -0,0 +1,100 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+// EnsureResult tells what EnsureEntry changed.
+type EnsureResult struct {
+	// Added is set when the entry was missing.
+	Added bool
+	// Drifted lists the options of an existing entry that differed and were
+	// updated, e.g. "timeout" or "comment".
+	Drifted []string
+}
+
+// Changed reports whether EnsureEntry issued a command.
+func (r EnsureResult) Changed() bool {
+	return r.Added || len(r.Drifted) != 0
+}
+
+// EnsureEntry makes sure the entry is in the set with the given options,
+// only adding it again when it is missing or its options drifted, so that
+// reconcilers do not reset the timeouts of entries on every pass. A zero
+// Timeout is not checked; otherwise an entry whose remaining timeout does
+// not exceed opts.Timeout is left alone, while a permanent entry that should
+// time out, or the converse, drifted. Counters are never compared. The entry
+// must be given in the canonical form ipset prints, e.g. without a /32
+// prefix, and the set is listed to look it up.
+func (s *IPSet) EnsureEntry(entry string, opts EntryOptions) (EnsureResult, error) {
+	defer s.handle().lockSet(s.Name)()
+	if err := validateAddOptions(entry, opts); err != nil {
+		return EnsureResult{}, err
+	}
+	current, err := s.handle().listEntries(s.Name)
+	if err != nil {
+		return EnsureResult{}, err
+	}
+	var res EnsureResult
+	res.Added = true
+	for _, e := range current {
+		if e.Element == entry {
+			res.Added = false
+			res.Drifted = drift(e.EntryOptions, opts)
+			break
+		}
+	}
+	if !res.Changed() {
+		return res, nil
+	}
+	if err := s.addWithOptions(entry, opts); err != nil {
+		return EnsureResult{}, err
+	}
+	return res, nil
+}
+
+// drift returns the names of the options of have not matching want.
+func drift(have, want EntryOptions) []string {
+	var d []string
+	switch {
+	case want.Timeout == 0:
+	case want.Timeout == TimeoutPermanent:
+		if have.Timeout != TimeoutPermanent && have.Timeout != 0 {
+			d = append(d, "timeout")
+		}
+	case have.Timeout == TimeoutPermanent || have.Timeout == 0 || have.Timeout > want.Timeout:
+		d = append(d, "timeout")
+	}
+	for _, o := range []struct {
+		name       string
+		have, want string
+	}{
+		{"comment", have.Comment, want.Comment},
+		{"skbmark", have.SkbMark, want.SkbMark},
+		{"skbprio", have.SkbPrio, want.SkbPrio},
+		{"skbqueue", have.SkbQueue, want.SkbQueue},
+	} {
+		if o.have != o.want {
+			d = append(d, o.name)
+		}
+	}
+	if have.Nomatch != want.Nomatch {
+		d = append(d, "nomatch")
+	}
+	if have.Wildcard != want.Wildcard {
+		d = append(d, "wildcard")
+	}
+	return d
+}
@@ -0,0 +1,83 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnsureEntry(t *testing.T) {
+	h := stubHandle(t, setStub)
+	s, _, err := h.Create("app-a", "hash:ip", &Params{Timeout: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("192.0.2.1", 30); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	for _, c := range []struct {
+		entry string
+		opts  EntryOptions
+		want  EnsureResult
+	}{
+		{"192.0.2.1", EntryOptions{Timeout: 60}, EnsureResult{}},
+		{"192.0.2.1", EntryOptions{Timeout: 10}, EnsureResult{Drifted: []string{"timeout"}}},
+		{"192.0.2.1", EntryOptions{Timeout: TimeoutPermanent}, EnsureResult{Drifted: []string{"timeout"}}},
+		{"192.0.2.1", EntryOptions{Timeout: TimeoutPermanent}, EnsureResult{}},
+		{"192.0.2.2", EntryOptions{}, EnsureResult{Added: true}},
+		{"192.0.2.2", EntryOptions{}, EnsureResult{}},
+	} {
+		if err := ioutil.WriteFile(log, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.EnsureEntry(c.entry, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, c.want) {
+			t.Errorf("EnsureEntry(%s, %+v) = %+v, want %+v", c.entry, c.opts, res, c.want)
+		}
+		out, _ := ioutil.ReadFile(log)
+		if added := strings.Contains(string(out), "add app-a "+c.entry); added != c.want.Changed() {
+			t.Errorf("EnsureEntry(%s, %+v) ran %q", c.entry, c.opts, out)
+		}
+	}
+}
+
+func TestEnsureEntryNoop(t *testing.T) {
+	var r NoopRecorder
+	s := NewHandle(WithNoopBackend(&r)).Set("app-a")
+	// the no-op backend lists every set empty: the entry is always missing
+	res, err := s.EnsureEntry("192.0.2.1", EntryOptions{Comment: "x"})
+	if err != nil || !res.Added {
+		t.Fatalf("EnsureEntry = %+v, %v, want the entry added", res, err)
+	}
+	if cmds := r.Commands(); len(cmds) != 2 || cmds[1].Args[0] != "add" {
+		t.Errorf("ran %v, want a save and an add", cmds)
+	}
+	if _, err := s.EnsureEntry("192.0.2.1\nflush app-a", EntryOptions{}); err == nil {
+		t.Error("invalid entry accepted")
+	}
+}
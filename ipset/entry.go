@@ -0,0 +1,213 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// TimeoutPermanent is the Timeout of an entry explicitly stored without expiry
+// in a set supporting timeouts, what ipset writes as "timeout 0".
+const TimeoutPermanent = -1
+
+// EntryOptions are the per-entry options of a set member. Zero values mean that
+// the option is absent, i.e. the set defaults apply.
+type EntryOptions struct {
+	// Timeout is the (remaining) lifetime of the entry in seconds, or TimeoutPermanent.
+	Timeout int `json:"timeout,omitempty"`
+	// Packets and Bytes are the counters of a set created with counters.
+	Packets uint64 `json:"packets,omitempty"`
+	Bytes   uint64 `json:"bytes,omitempty"`
+	// Comment is the comment of a set created with comment support.
+	Comment string `json:"comment,omitempty"`
+	// Nomatch marks an exception entry of hash:*net* sets.
+	Nomatch bool `json:"nomatch,omitempty"`
+	// Wildcard makes the interface of a hash:net,iface entry match as a prefix.
+	Wildcard bool `json:"wildcard,omitempty"`
+	// SkbMark, SkbPrio and SkbQueue are the skbinfo extension values, as ipset prints them.
+	SkbMark  string `json:"skbmark,omitempty"`
+	SkbPrio  string `json:"skbprio,omitempty"`
+	SkbQueue string `json:"skbqueue,omitempty"`
+}
+
+// Entry is a member of a set along with its options.
+type Entry struct {
+	Element string `json:"element"`
+	EntryOptions
+}
+
+// String returns the entry in the syntax of `ipset list`.
+func (e Entry) String() string {
+	return strings.Join(append([]string{e.Element}, e.EntryOptions.fields(true)...), " ")
+}
+
+// args returns the options as arguments of `ipset add`.
+func (o EntryOptions) args() []string {
+	return o.fields(false)
+}
+
+// fields returns the options as ipset tokens. With quote set, the comment is
+// enclosed in double quotes as required by the restore format.
+func (o EntryOptions) fields(quote bool) []string {
+	var f []string
+	switch {
+	case o.Timeout == TimeoutPermanent:
+		f = append(f, "timeout", "0")
+	case o.Timeout > 0:
+		f = append(f, "timeout", strconv.Itoa(o.Timeout))
+	}
+	if o.Packets != 0 {
+		f = append(f, "packets", strconv.FormatUint(o.Packets, 10))
+	}
+	if o.Bytes != 0 {
+		f = append(f, "bytes", strconv.FormatUint(o.Bytes, 10))
+	}
+	if o.Comment != "" {
+		if quote {
+			f = append(f, "comment", `"`+o.Comment+`"`)
+		} else {
+			f = append(f, "comment", o.Comment)
+		}
+	}
+	if o.SkbMark != "" {
+		f = append(f, "skbmark", o.SkbMark)
+	}
+	if o.SkbPrio != "" {
+		f = append(f, "skbprio", o.SkbPrio)
+	}
+	if o.SkbQueue != "" {
+		f = append(f, "skbqueue", o.SkbQueue)
+	}
+	if o.Nomatch {
+		f = append(f, "nomatch")
+	}
+	if o.Wildcard {
+		f = append(f, "wildcard")
+	}
+	return f
+}
+
+// parseEntry parses the fields of a member line of `ipset list`, or of an
+// `add` line of `ipset save` without the command and set name. Unknown
+// options are skipped.
+func parseEntry(fields []string) Entry {
+	e, _ := parseEntryStrict(fields)
+	return e
+}
+
+// parseEntryStrict parses an entry like parseEntry, which ignores the options
+// it does not know or cannot parse, and returns the first of them as an
+// ErrInvalidEntry.
+func parseEntryStrict(fields []string) (Entry, error) {
+	var e Entry
+	if len(fields) == 0 {
+		return e, nil
+	}
+	e.Element = fields[0]
+	var err error
+	invalid := func(format string, args ...interface{}) {
+		if err == nil {
+			err = fmt.Errorf("%w: %s of %s", ErrInvalidEntry, fmt.Sprintf(format, args...), e.Element)
+		}
+	}
+	for i := 1; i < len(fields); i++ {
+		key := fields[i]
+		switch key {
+		case "nomatch":
+			e.Nomatch = true
+			continue
+		case "wildcard":
+			e.Wildcard = true
+			continue
+		}
+		if i+1 >= len(fields) {
+			invalid("option %q without value", key)
+			break
+		}
+		val := fields[i+1]
+		i++
+		switch key {
+		case "timeout":
+			if t, perr := strconv.Atoi(val); perr == nil {
+				e.Timeout = t
+				if t == 0 {
+					e.Timeout = TimeoutPermanent
+				}
+			} else {
+				invalid("timeout %q", val)
+			}
+		case "packets", "bytes":
+			n, perr := strconv.ParseUint(val, 10, 64)
+			if perr != nil {
+				invalid("%s %q", key, val)
+			}
+			if key == "packets" {
+				e.Packets = n
+			} else {
+				e.Bytes = n
+			}
+		case "comment":
+			e.Comment = strings.Trim(val, `"`)
+		case "skbmark":
+			e.SkbMark = val
+		case "skbprio":
+			e.SkbPrio = val
+		case "skbqueue":
+			e.SkbQueue = val
+		default:
+			invalid("unknown option %q", key)
+		}
+	}
+	return e, err
+}
+
+// ListEntries returns the members of the set with their options.
+func (s *IPSet) ListEntries() ([]Entry, error) {
+	return s.handle().listEntries(s.Name)
+}
+
+func (h *Handle) listEntries(set string) ([]Entry, error) {
+	if caps.BusyBox {
+		return h.listEntriesCompat(set)
+	}
+	return h.savedEntries(set)
+}
+
+// listEntriesCompat parses the members of the list output, for utilities
+// without save.
+func (h *Handle) listEntriesCompat(set string) ([]Entry, error) {
+	details, err := h.listWithOpts(set)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	inMembers := false
+	for _, l := range details {
+		if !inMembers {
+			inMembers = parse.IsMembersHeader(l)
+			continue
+		}
+		if fields := parse.Fields(l); len(fields) > 0 {
+			entries = append(entries, parseEntry(fields))
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Errors ipset failures are classified as, in addition to ErrSetExists,
+// ErrTypeUnsupported and ErrEntryNotFound. The *Error of a failed command
+// matches its class with errors.Is.
+var (
+	// ErrSetNotFound is returned when the named set does not exist.
+	ErrSetNotFound = errors.New("set does not exist")
+	// ErrSetInUse is returned when destroying a set referenced by the kernel,
+	// e.g. by an iptables rule or a list:set.
+	ErrSetInUse = errors.New("set is in use")
+	// ErrEntryExists is returned when adding an entry already in the set
+	// without -exist.
+	ErrEntryExists = errors.New("entry already in set")
+	// ErrSetFull is returned when adding to a set holding maxelem entries.
+	ErrSetFull = errors.New("set is full")
+	// ErrOutOfRange is returned when adding to a bitmap set an element
+	// outside of its range.
+	ErrOutOfRange = errors.New("element out of the range of the set")
+	// ErrIncompatible is returned when swapping sets of different types or
+	// families, or renaming onto an existing set.
+	ErrIncompatible = errors.New("incompatible sets")
+	// ErrInvalidParameter is returned when ipset rejects the arguments of a
+	// command, exit code 2.
+	ErrInvalidParameter = errors.New("invalid ipset parameters")
+	// ErrProtocol is returned when the ipset utility and the kernel do not
+	// speak the same protocol version, exit code 3.
+	ErrProtocol = errors.New("ipset protocol mismatch between utility and kernel")
+)
+
+type errorPattern struct {
+	pattern string
+	err     error
+}
+
+var (
+	classesMu sync.RWMutex
+	// errorPatterns maps substrings of the ipset output to error classes,
+	// checked in order
+	errorPatterns = []errorPattern{
+		{"The set with the given name does not exist", ErrSetNotFound},
+		{"it is in use by a kernel component", ErrSetInUse},
+		{"Element cannot be added to the set: it's already added", ErrEntryExists},
+		{"Element cannot be deleted from the set: it's not added", ErrEntryNotFound},
+		{"is full, cannot add more elements", ErrSetFull},
+		{"out of the range of the set", ErrOutOfRange},
+		{"set with the same name already exists", ErrSetExists},
+		{"set type not supported", ErrTypeUnsupported},
+		{"their type does not match", ErrIncompatible},
+		{"their INET family does not match", ErrIncompatible},
+		{"set with the new name already exists", ErrIncompatible},
+		{"Operation not permitted", os.ErrPermission},
+		{"You need to be root", os.ErrPermission},
+	}
+	// exitCodes maps the exit codes of ipset to error classes, used when no
+	// pattern matches the output
+	exitCodes = map[int]error{
+		2: ErrInvalidParameter,
+		3: ErrProtocol,
+	}
+)
+
+// RegisterErrorPattern classifies failed commands printing pattern as class,
+// for messages of ipset versions or patches the package does not know.
+// Registered patterns are checked before the built-in ones, the latest first.
+func RegisterErrorPattern(pattern string, class error) {
+	classesMu.Lock()
+	errorPatterns = append([]errorPattern{{pattern, class}}, errorPatterns...)
+	classesMu.Unlock()
+}
+
+// RegisterExitCode classifies failed commands exiting with code as class when
+// their output matches no pattern.
+func RegisterExitCode(code int, class error) {
+	classesMu.Lock()
+	exitCodes[code] = class
+	classesMu.Unlock()
+}
+
+// Error is the error of a failed ipset command, found with errors.As in the
+// errors returned by the package. Its message is the one of its cause, which
+// the callers wrap with the output of the command.
+type Error struct {
+	// Args is the command line, the path of the utility first.
+	Args []string
+	// Stderr is the error output of the command. For the commands whose
+	// output is buffered, it is interleaved with the standard output.
+	Stderr string
+	// ExitCode is the exit code of the command, -1 if it did not exit, e.g.
+	// because it was killed or could not be started.
+	ExitCode int
+	// Err is the cause, usually an *exec.ExitError.
+	Err error
+
+	class error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether the class of the error, e.g. ErrSetNotFound, is target.
+func (e *Error) Is(target error) bool {
+	return e.class != nil && errors.Is(e.class, target)
+}
+
+// commandError returns the *Error of the command with the given command line
+// that failed with err after printing out, nil if err is nil.
+func commandError(argv []string, err error, out []byte) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Args: argv, Stderr: string(out), ExitCode: -1, Err: err, class: errorClass(err, out)}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.ExitCode()
+	}
+	return e
+}
+
+func errorClass(err error, out []byte) error {
+	classesMu.RLock()
+	defer classesMu.RUnlock()
+	s := string(out)
+	for _, p := range errorPatterns {
+		if strings.Contains(s, p.pattern) {
+			return p.err
+		}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitCodes[exitErr.ExitCode()]
+	}
+	return nil
+}
+
+// ItemError is the failure of an operation on one set or entry.
+type ItemError struct {
+	Set string
+	// Entry is the entry the operation failed on, empty for operations on
+	// whole sets.
+	Entry string
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf("ipset(%s): entry %s: %v", e.Set, e.Entry, e.Err)
+	}
+	return fmt.Sprintf("ipset(%s): %v", e.Set, e.Err)
+}
+
+func (e *ItemError) Unwrap() error { return e.Err }
+
+// MultiError is returned by operations on several sets or entries, such as
+// DestroyAll, when some of them failed. errors.Is matches it against the
+// errors of all its items.
+type MultiError struct {
+	// Msg describes the operation.
+	Msg    string
+	Errors []*ItemError
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, ie := range e.Errors {
+		msgs[i] = ie.Error()
+	}
+	return fmt.Sprintf("%s (%s)", e.Msg, strings.Join(msgs, "; "))
+}
+
+// Is reports whether the error of one of the items is target.
+func (e *MultiError) Is(target error) bool {
+	for _, ie := range e.Errors {
+		if errors.Is(ie.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets returns the names of the sets the operation failed on, once each, so
+// that it can be retried for them only.
+func (e *MultiError) Sets() []string {
+	var sets []string
+	seen := make(map[string]bool)
+	for _, ie := range e.Errors {
+		if !seen[ie.Set] {
+			seen[ie.Set] = true
+			sets = append(sets, ie.Set)
+		}
+	}
+	return sets
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// exitError returns the error of a command exiting with code.
+func exitError(t *testing.T, code int) error {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to exit with a code")
+	}
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	if err == nil {
+		t.Fatalf("exit %d succeeded", code)
+	}
+	return err
+}
+
+func TestErrorClass(t *testing.T) {
+	status1 := exitError(t, 1)
+	for _, tc := range []struct {
+		err  error
+		out  string
+		want error
+	}{
+		{status1, "ipset v7.15: The set with the given name does not exist", ErrSetNotFound},
+		{status1, "ipset v7.15: Set cannot be destroyed: it is in use by a kernel component", ErrSetInUse},
+		{status1, "ipset v7.15: Element cannot be added to the set: it's already added", ErrEntryExists},
+		{status1, "ipset v7.15: Element cannot be deleted from the set: it's not added", ErrEntryNotFound},
+		{status1, "ipset v7.15: Hash is full, cannot add more elements", ErrSetFull},
+		{status1, "ipset v7.15: Element is out of the range of the set", ErrOutOfRange},
+		{status1, "ipset v7.15: Set cannot be created: set with the same name already exists", ErrSetExists},
+		{status1, "ipset v7.15: Kernel error received: set type not supported", ErrTypeUnsupported},
+		{status1, "ipset v7.15: The sets cannot be swapped: their type does not match", ErrIncompatible},
+		{status1, "ipset v7.15: The sets cannot be swapped: their INET family does not match", ErrIncompatible},
+		{status1, "ipset v7.15: Set cannot be renamed: a set with the new name already exists", ErrIncompatible},
+		{status1, "ipset v7.15: Kernel error received: Operation not permitted", os.ErrPermission},
+		{status1, "ipset v7.15: You need to be root to perform this command.", os.ErrPermission},
+		{exitError(t, 2), "ipset v7.15: Unknown argument: `-x'", ErrInvalidParameter},
+		{exitError(t, 3), "", ErrProtocol},
+		{status1, "ipset v7.15: something new", nil},
+		{errors.New("exec: not started"), "", nil},
+	} {
+		if got := errorClass(tc.err, []byte(tc.out)); got != tc.want {
+			t.Errorf("errorClass(%v, %q) = %v, want %v", tc.err, tc.out, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterErrorPattern(t *testing.T) {
+	patterns := errorPatterns
+	t.Cleanup(func() {
+		classesMu.Lock()
+		errorPatterns = patterns
+		classesMu.Unlock()
+	})
+	errPatched := errors.New("patched failure")
+	RegisterErrorPattern("does not exist", errPatched)
+	out := []byte("ipset v7.15: The set with the given name does not exist")
+	if got := errorClass(exitError(t, 1), out); got != errPatched {
+		t.Errorf("registered pattern: class %v, want %v", got, errPatched)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	out := []byte("ipset v7.15: The set with the given name does not exist")
+	cmdErr := commandError([]string{"ipset", "list", "blocked"}, exitError(t, 1), out)
+	var e *Error
+	if !errors.As(cmdErr, &e) || e.ExitCode != 1 || e.Stderr != string(out) {
+		t.Fatalf("command error %#v", cmdErr)
+	}
+	wrapped := fmt.Errorf("error listing set blocked: %w (%s)", cmdErr, out)
+	item := &ItemError{Set: "blocked", Err: wrapped}
+	multi := &MultiError{Msg: "error destroying sets", Errors: []*ItemError{
+		{Set: "allowed", Err: errors.New("exit status 1")},
+		item,
+	}}
+	for _, err := range []error{cmdErr, wrapped, item, multi} {
+		if !errors.Is(err, ErrSetNotFound) {
+			t.Errorf("%v does not match ErrSetNotFound", err)
+		}
+		if errors.Is(err, ErrSetInUse) {
+			t.Errorf("%v matches ErrSetInUse", err)
+		}
+	}
+	if errors.Is(commandError(nil, exitError(t, 1), nil), ErrSetNotFound) {
+		t.Error("unclassified error matches ErrSetNotFound")
+	}
+	if commandError(nil, nil, out) != nil {
+		t.Error("command error of a successful command")
+	}
+	want := "error destroying sets (ipset(allowed): exit status 1; ipset(blocked): " + wrapped.Error() + ")"
+	if got := multi.Error(); got != want {
+		t.Errorf("multi error message %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WithEnv adds environment variables, in the "KEY=value" form, to those the
+// ipset utility inherits from the process when run by the handle.
+func WithEnv(env ...string) Option {
+	return func(h *Handle) {
+		h.env = append(h.env, env...)
+	}
+}
+
+// WithDir runs the ipset utility in the given working directory.
+func WithDir(dir string) Option {
+	return func(h *Handle) {
+		h.dir = dir
+	}
+}
+
+// WithExtraArgs passes global options, e.g. "-!", before the command of every
+// ipset invocation of the handle. Options silencing the output, such as -q,
+// also hide the "is NOT in set" message Test relies on and make it fail for
+// missing entries.
+func WithExtraArgs(args ...string) Option {
+	return func(h *Handle) {
+		h.extraArgs = append(h.extraArgs, args...)
+	}
+}
+
+// WithRunner runs ipset through the command line argv, given the arguments
+// of ipset after its own, e.g. "docker", "exec", "-i", CONTAINER, "ipset" to
+// manage the sets of a container. The ipset utility of the host is then not
+// needed; the capabilities detected from it, if any, are assumed.
+func WithRunner(argv ...string) Option {
+	return func(h *Handle) {
+		h.runner = argv
+	}
+}
+
+// args returns the arguments of an ipset invocation running the command with
+// the given arguments.
+func (h *Handle) args(args []string) []string {
+	if len(h.extraArgs) == 0 {
+		return args
+	}
+	return append(append([]string(nil), h.extraArgs...), args...)
+}
+
+// ErrOutputTooLarge is returned when an ipset command prints more than the
+// limit set with WithMaxOutput.
+var ErrOutputTooLarge = errors.New("ipset output exceeds the configured limit")
+
+// WithMaxOutput caps the output of an ipset command the handle buffers in
+// memory to max bytes. Commands exceeding it fail with ErrOutputTooLarge;
+// output streamed to the caller, e.g. by Save, is not limited.
+func WithMaxOutput(max int) Option {
+	return func(h *Handle) {
+		h.maxOutput = max
+	}
+}
+
+// limitedBuffer collects output up to a limit and discards the rest, still
+// accepting it so that the command is not blocked writing. The buffer is not
+// embedded, as its ReadFrom would bypass the limit in io.Copy.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && b.buf.Len()+len(p) > b.max {
+		b.exceeded = true
+		b.buf.Write(p[:b.max-b.buf.Len()])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the collected output.
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// outputErr returns ErrOutputTooLarge if the buffered output of the command
+// with the given arguments was cut.
+func (b *limitedBuffer) outputErr(args []string) error {
+	if !b.exceeded {
+		return nil
+	}
+	return fmt.Errorf("%w: more than %d bytes from ipset %s; use streaming APIs such as ListEntries or Save for large sets",
+		ErrOutputTooLarge, b.max, strings.Join(args, " "))
+}
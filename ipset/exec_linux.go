@@ -0,0 +1,146 @@
+//go:build linux && !ipset_noexec
+// +build linux,!ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// lookIpset resolves the path of the ipset utility.
+func lookIpset(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", errIpsetNotFound
+	}
+	return path, nil
+}
+
+// execRun executes the ipset utility with the given arguments and returns its combined output.
+func (h *Handle) execRun(args ...string) ([]byte, error) {
+	if err := h.ensurePath(); err != nil {
+		return nil, err
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	unlock, err := h.lockFor(args)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	out := &limitedBuffer{max: h.maxOutput}
+	cmd := h.command(args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	start := time.Now()
+	err = h.inNetns(cmd.Run)
+	h.observeCommand(args, start, err)
+	if oerr := out.outputErr(args); oerr != nil {
+		return out.Bytes(), oerr
+	}
+	return out.Bytes(), commandError(cmd.Args, err, out.Bytes())
+}
+
+// execRunIO executes the ipset utility connecting stdin and stdout to the given
+// reader and writer, either of which may be nil. The standard error is returned.
+func (h *Handle) execRunIO(stdin io.Reader, stdout io.Writer, args ...string) ([]byte, error) {
+	if err := h.ensurePath(); err != nil {
+		return nil, err
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	unlock, err := h.lockFor(args)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	stderr := &limitedBuffer{max: h.maxOutput}
+	cmd := h.command(args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	start := time.Now()
+	err = h.inNetns(cmd.Run)
+	h.observeCommand(args, start, err)
+	return stderr.Bytes(), commandError(cmd.Args, err, stderr.Bytes())
+}
+
+// execStart starts the ipset utility reading stdin, with the standard error
+// written to stderr, and returns the function waiting for it to exit. The
+// lock of the handle is held until the process exited.
+func (h *Handle) execStart(stdin io.Reader, stderr io.Writer, args ...string) (func() error, error) {
+	if f, ok := stdin.(*os.File); ok {
+		// the process has its own copy once started
+		defer f.Close()
+	}
+	if err := h.ensurePath(); err != nil {
+		return nil, err
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	unlock, err := h.lockFor(args)
+	if err != nil {
+		return nil, err
+	}
+	cmd := h.command(args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = stderr
+	start := time.Now()
+	if err := h.inNetns(cmd.Start); err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() error {
+		defer unlock()
+		err := cmd.Wait()
+		h.observeCommand(args, start, err)
+		return err
+	}, nil
+}
+
+// command returns the command running the ipset utility with the given
+// arguments, configured by the options of the handle.
+func (h *Handle) command(args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if len(h.runner) != 0 {
+		cmd = exec.Command(h.runner[0], append(h.runner[1:len(h.runner):len(h.runner)], h.args(args)...)...)
+	} else {
+		cmd = exec.Command(ipsetPath, h.args(args)...)
+	}
+	if len(h.env) != 0 {
+		cmd.Env = append(os.Environ(), h.env...)
+	}
+	cmd.Dir = h.dir
+	return cmd
+}
+
+// ensurePath runs the default initialization for callers that did not go through
+// Init or New, e.g. package level functions or an IPSet built as a literal.
+func (h *Handle) ensurePath() error {
+	if ipsetPath != "" || len(h.runner) != 0 {
+		return nil
+	}
+	return initCheck()
+}
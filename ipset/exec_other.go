@@ -0,0 +1,49 @@
+//go:build !linux || ipset_noexec
+// +build !linux ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io"
+	"os"
+)
+
+// lookIpset always fails: ipset is a Linux-only facility, and builds with the
+// ipset_noexec tag leave out the backend executing it.
+func lookIpset(name string) (string, error) {
+	return "", ErrUnsupportedPlatform
+}
+
+// execRun always fails with ErrUnsupportedPlatform.
+func (h *Handle) execRun(args ...string) ([]byte, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// execRunIO always fails with ErrUnsupportedPlatform.
+func (h *Handle) execRunIO(stdin io.Reader, stdout io.Writer, args ...string) ([]byte, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// execStart always fails with ErrUnsupportedPlatform.
+func (h *Handle) execStart(stdin io.Reader, stderr io.Writer, args ...string) (func() error, error) {
+	if f, ok := stdin.(*os.File); ok {
+		f.Close()
+	}
+	return nil, ErrUnsupportedPlatform
+}
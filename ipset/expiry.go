@@ -0,0 +1,309 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// ExpiryReason tells why a watched entry left its set.
+type ExpiryReason int
+
+const (
+	// Expired means the kernel removed the entry once its timeout elapsed.
+	Expired ExpiryReason = iota
+	// Deleted means the entry was deleted, flushed, destroyed or swapped out
+	// through the handle of the set.
+	Deleted
+	// Removed means the entry vanished before its timeout elapsed, e.g. it
+	// was deleted by another process.
+	Removed
+)
+
+func (r ExpiryReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Deleted:
+		return "deleted"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// ExpiryEvent reports a watched entry leaving its set.
+type ExpiryEvent struct {
+	Set    string
+	Entry  string
+	Reason ExpiryReason
+	Time   time.Time
+}
+
+// expirySlack is how early before its deadline a missing entry still counts
+// as expired, to make up for the second granularity of ipset timeouts.
+const expirySlack = time.Second
+
+// ExpiryTracker watches entries of a timeout-enabled set and calls a function
+// once for each watched entry leaving the set. Expiry is noticed by listing
+// the set at the polling interval, so events are delayed by up to that
+// interval. Mutations made through the handle of the set are reported right
+// away as Deleted. An entry is only reported after the tracker saw it in the
+// set; it is no longer watched once reported.
+type ExpiryTracker struct {
+	set *IPSet
+	fn  func(ExpiryEvent)
+
+	mu sync.Mutex
+	// watched maps the canonical form of the entries to their state
+	watched map[string]*watchedEntry
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type watchedEntry struct {
+	entry   string
+	present bool
+	// deadline is when the entry expires, zero for a permanent entry
+	deadline time.Time
+}
+
+// NewExpiryTracker returns a tracker of entries of s calling fn. If interval
+// is positive, the set is polled at that interval until Close is called;
+// otherwise expiry is only noticed when Poll is called. fn is called from the
+// polling goroutine or from the goroutine mutating the set and must not block.
+func NewExpiryTracker(s *IPSet, interval time.Duration, fn func(ExpiryEvent)) *ExpiryTracker {
+	t := &ExpiryTracker{
+		set:     s,
+		fn:      fn,
+		watched: make(map[string]*watchedEntry),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.handle().addExpiryTracker(t)
+	if interval > 0 {
+		go t.loop(interval)
+	} else {
+		close(t.done)
+	}
+	return t
+}
+
+// Watch starts watching the entries and lists the set to learn their timeouts.
+func (t *ExpiryTracker) Watch(entries ...string) error {
+	t.mu.Lock()
+	for _, e := range entries {
+		key := canonicalElement(e)
+		if _, ok := t.watched[key]; !ok {
+			t.watched[key] = &watchedEntry{entry: e}
+		}
+	}
+	t.mu.Unlock()
+	return t.Poll()
+}
+
+// Unwatch stops watching the entries without reporting them.
+func (t *ExpiryTracker) Unwatch(entries ...string) {
+	t.mu.Lock()
+	for _, e := range entries {
+		delete(t.watched, canonicalElement(e))
+	}
+	t.mu.Unlock()
+}
+
+// Watched returns the entries being watched.
+func (t *ExpiryTracker) Watched() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := make([]string, 0, len(t.watched))
+	for _, w := range t.watched {
+		entries = append(entries, w.entry)
+	}
+	return entries
+}
+
+// Close stops polling and detaches the tracker from the set.
+func (t *ExpiryTracker) Close() {
+	t.set.handle().removeExpiryTracker(t)
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	<-t.done
+}
+
+func (t *ExpiryTracker) loop(interval time.Duration) {
+	defer close(t.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := t.Poll(); err != nil {
+				log.Warnf("Error polling expiring entries of set %s: %v", t.set.Name, err)
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Poll lists the set, reporting the watched entries that left it since the
+// previous poll and updating the deadlines of the others.
+func (t *ExpiryTracker) Poll() error {
+	return t.poll(-1)
+}
+
+// poll lists the set and reports the missing entries with reason, or with
+// Expired or Removed according to their deadline if reason is negative.
+func (t *ExpiryTracker) poll(reason ExpiryReason) error {
+	entries, err := t.set.ListEntries()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	current := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		current[canonicalElement(e.Element)] = e
+	}
+	var events []ExpiryEvent
+	t.mu.Lock()
+	for key, w := range t.watched {
+		e, ok := current[key]
+		if ok {
+			w.present = true
+			w.deadline = time.Time{}
+			if e.Timeout > 0 {
+				w.deadline = now.Add(time.Duration(e.Timeout) * time.Second)
+			}
+			continue
+		}
+		if !w.present {
+			continue
+		}
+		r := reason
+		if r < 0 {
+			r = Removed
+			if !w.deadline.IsZero() && !now.Before(w.deadline.Add(-expirySlack)) {
+				r = Expired
+			}
+		}
+		events = append(events, t.event(w, r, now))
+		delete(t.watched, key)
+	}
+	t.mu.Unlock()
+	t.fire(events)
+	return nil
+}
+
+func (t *ExpiryTracker) event(w *watchedEntry, r ExpiryReason, now time.Time) ExpiryEvent {
+	return ExpiryEvent{Set: t.set.Name, Entry: w.entry, Reason: r, Time: now}
+}
+
+func (t *ExpiryTracker) fire(events []ExpiryEvent) {
+	for _, ev := range events {
+		t.fn(ev)
+	}
+}
+
+// apply reports the watched entries removed by a mutation of the set.
+func (t *ExpiryTracker) apply(c change) {
+	switch c.op {
+	case opAdd, opCreate:
+		return
+	case opSwap:
+		// the content came from another set: whatever is gone was replaced
+		if err := t.poll(Deleted); err != nil {
+			log.Warnf("Error polling expiring entries of set %s: %v", t.set.Name, err)
+		}
+		return
+	}
+	now := time.Now()
+	var events []ExpiryEvent
+	t.mu.Lock()
+	if c.op == opDel {
+		for _, e := range c.entries {
+			key := canonicalElement(e)
+			if w, ok := t.watched[key]; ok && w.present {
+				events = append(events, t.event(w, Deleted, now))
+				delete(t.watched, key)
+			}
+		}
+	} else {
+		for key, w := range t.watched {
+			if w.present {
+				events = append(events, t.event(w, Deleted, now))
+				delete(t.watched, key)
+			}
+		}
+	}
+	t.mu.Unlock()
+	t.fire(events)
+}
+
+func (h *Handle) addExpiryTracker(t *ExpiryTracker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.trackers == nil {
+		h.trackers = make(map[string][]*ExpiryTracker)
+	}
+	h.trackers[t.set.Name] = append(h.trackers[t.set.Name], t)
+}
+
+func (h *Handle) removeExpiryTracker(t *ExpiryTracker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ts := h.trackers[t.set.Name]
+	for i := range ts {
+		if ts[i] == t {
+			h.trackers[t.set.Name] = append(ts[:i], ts[i+1:]...)
+			break
+		}
+	}
+	if len(h.trackers[t.set.Name]) == 0 {
+		delete(h.trackers, t.set.Name)
+	}
+}
+
+// updateExpiryTrackers applies a successful mutation to the trackers of the
+// sets involved.
+func (h *Handle) updateExpiryTrackers(c change) {
+	if c.err != nil {
+		return
+	}
+	h.mu.Lock()
+	var ts []*ExpiryTracker
+	switch c.op {
+	case opDestroyAll:
+		for _, l := range h.trackers {
+			ts = append(ts, l...)
+		}
+	case opSwap:
+		ts = append(append(ts, h.trackers[c.set]...), h.trackers[c.other]...)
+	default:
+		ts = append(ts, h.trackers[c.set]...)
+	}
+	h.mu.Unlock()
+	for _, t := range ts {
+		t.apply(c)
+	}
+}
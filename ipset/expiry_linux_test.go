@@ -0,0 +1,81 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExpiryTracker(t *testing.T) {
+	h := stubHandle(t, setStub)
+	s, _, err := h.Create("app-a", "hash:ip", &Params{Timeout: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for entry, timeout := range map[string]int{"192.0.2.1": 1, "192.0.2.2": 600, "192.0.2.3": 600} {
+		if err := s.Add(entry, timeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var events []ExpiryEvent
+	tr := NewExpiryTracker(s, 0, func(ev ExpiryEvent) { events = append(events, ev) })
+	defer tr.Close()
+	if err := tr.Watch("192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Del("192.0.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Entry != "192.0.2.3" || events[0].Reason != Deleted {
+		t.Fatalf("deleting through the handle reported %+v", events)
+	}
+
+	// another process, or the kernel, removes the other entries
+	state := filepath.Join(filepath.Dir(h.runner[0]), "state")
+	if err := ioutil.WriteFile(state, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Entry < events[j].Entry })
+	if len(events) != 2 || events[0].Entry != "192.0.2.1" || events[0].Reason != Expired ||
+		events[1].Entry != "192.0.2.2" || events[1].Reason != Removed {
+		t.Errorf("polling reported %+v, want 192.0.2.1 expired and 192.0.2.2 removed", events)
+	}
+	// 192.0.2.4 was never seen in the set: it is still watched, not reported
+	if w := tr.Watched(); len(w) != 1 || w[0] != "192.0.2.4" {
+		t.Errorf("watching %v, want [192.0.2.4]", w)
+	}
+	if events[0].Set != "app-a" || events[0].Time.IsZero() {
+		t.Errorf("event %+v lacks its set or time", events[0])
+	}
+
+	tr.Unwatch("192.0.2.4")
+	if w := tr.Watched(); len(w) != 0 {
+		t.Errorf("watching %v after Unwatch", strings.Join(w, ", "))
+	}
+}
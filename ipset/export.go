@@ -0,0 +1,122 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ExportVersion is the version of the JSON document written by ExportJSON.
+const ExportVersion = 1
+
+// Export is the JSON document written by ExportJSON and read by ImportJSON:
+//
+//	{
+//	  "version": 1,
+//	  "exported_at": "2021-03-01T12:00:00Z",
+//	  "header": {"name": "blocked", "type": "hash:ip", "revision": 4, "family": "inet",
+//	             "hashsize": 1024, "maxelem": 65536, "timeout": 600, "counters": true, "comment": true},
+//	  "entries": [
+//	    {"element": "192.0.2.1", "timeout": 523, "packets": 12, "bytes": 720, "comment": "ssh brute force"},
+//	    {"element": "198.51.100.0/24", "nomatch": true}
+//	  ]
+//	}
+//
+// Header and entry fields are omitted when absent. A timeout of -1 stands for an
+// entry (or default) without expiry in a set supporting timeouts; entry timeouts
+// are the remaining lifetimes at export time. Counters are informational and
+// restored as exported.
+type Export struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Header     Header    `json:"header"`
+	Entries    []Entry   `json:"entries"`
+}
+
+// ExportJSON writes the header and the entries of the set, with their options,
+// to w as a JSON document described by Export.
+func (s *IPSet) ExportJSON(w io.Writer) error {
+	header, err := s.Header()
+	if err != nil {
+		return err
+	}
+	entries, err := s.ListEntries()
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Export{
+		Version:    ExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Header:     header,
+		Entries:    entries,
+	})
+}
+
+// ImportJSON restores a set from a document written by ExportJSON, see Handle.ImportJSON.
+func ImportJSON(r io.Reader) (*IPSet, error) {
+	return defaultHandle.ImportJSON(r)
+}
+
+// ImportJSON restores a set from a document written by ExportJSON. The set named
+// in the header is created if needed and its content atomically replaced by
+// the entries of the document.
+func (h *Handle) ImportJSON(r io.Reader) (*IPSet, error) {
+	var doc Export
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding set export: %w", err)
+	}
+	if doc.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported set export version %d", doc.Version)
+	}
+	if doc.Header.Name == "" || doc.Header.Type == "" {
+		return nil, fmt.Errorf("set export without set name or type")
+	}
+	exists, err := h.setExists(doc.Header.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateEntries(doc.Entries); err != nil {
+		return nil, fmt.Errorf("error importing set %s: %w", doc.Header.Name, err)
+	}
+	var saved bytes.Buffer
+	saved.WriteString("create " + strings.Join(doc.Header.createArgs(), " ") + "\n")
+	for _, e := range doc.Entries {
+		saved.WriteString("add " + doc.Header.Name + " " + e.String() + "\n")
+	}
+	if err := h.restoreSwapped(doc.Header.Name, saved.Bytes()); err != nil {
+		return nil, fmt.Errorf("error importing set %s: %w", doc.Header.Name, err)
+	}
+	hd := doc.Header
+	s := &IPSet{Name: hd.Name, HashType: hd.Type, HashFamily: hd.Family, HashSize: hd.HashSize, MaxElem: hd.MaxElem, Counters: hd.Counters, Comment: hd.Comment, ForceAdd: hd.ForceAdd, Range: hd.Range, h: h}
+	if hd.Timeout > 0 {
+		s.Timeout = hd.Timeout
+	}
+	if !exists {
+		h.track(hd.Name)
+	}
+	return s, nil
+}
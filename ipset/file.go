@@ -0,0 +1,201 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// FileFormat describes how entries are read from a file.
+// The zero value is the plain format: one entry per line, blank lines and
+// lines starting with '#' ignored.
+type FileFormat struct {
+	// CSV selects comma separated values instead of the plain format.
+	CSV bool
+	// Column is the zero-based CSV column holding the entry.
+	Column int
+	// Comma is the CSV field separator, ',' when zero.
+	Comma rune
+	// SkipHeader ignores the first CSV record.
+	SkipHeader bool
+}
+
+// CSVFormat returns the format of CSV files holding the entries in the given column.
+func CSVFormat(column int) FileFormat {
+	return FileFormat{CSV: true, Column: column}
+}
+
+// ReadEntries reads the entries of r in the given format.
+func ReadEntries(r io.Reader, format FileFormat) ([]string, error) {
+	var entries []string
+	err := scanEntries(r, format, func(_ int, e string) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// scanEntries calls fn with each entry of r in the given format and its line
+// or CSV record number, see FileFormat.position, until fn returns false.
+func scanEntries(r io.Reader, format FileFormat, fn func(int, string) bool) error {
+	if !format.CSV {
+		sc := bufio.NewScanner(r)
+		for n := 1; sc.Scan(); n++ {
+			l := strings.TrimSpace(sc.Text())
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			if !fn(n, l) {
+				return nil
+			}
+		}
+		return sc.Err()
+	}
+	cr := csv.NewReader(r)
+	if format.Comma != 0 {
+		cr.Comma = format.Comma
+	}
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 1 && format.SkipHeader {
+			continue
+		}
+		if format.Column >= len(rec) {
+			return fmt.Errorf("record %d: no column %d", n, format.Column)
+		}
+		if e := strings.TrimSpace(rec[format.Column]); e != "" && !fn(n, e) {
+			return nil
+		}
+	}
+}
+
+// position describes the entry number n of scanEntries, e.g. "line 3".
+func (format FileFormat) position(n int) string {
+	if format.CSV {
+		return fmt.Sprintf("record %d", n)
+	}
+	return fmt.Sprintf("line %d", n)
+}
+
+// ReadEntriesFile reads the entries of the named file, "-" for the standard input.
+func ReadEntriesFile(path string, format FileFormat) ([]string, error) {
+	var entries []string
+	err := scanEntriesFile(path, format, func(_ int, e string) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// scanEntriesFile calls fn with the entries of the named file, "-" for the
+// standard input, as scanEntries does.
+func scanEntriesFile(path string, format FileFormat, fn func(int, string) bool) error {
+	if path == "-" {
+		return scanEntries(os.Stdin, format, fn)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := scanEntries(f, format, fn); err != nil {
+		return fmt.Errorf("error reading entries from %s: %w", path, err)
+	}
+	return nil
+}
+
+// AddFromFile adds the entries of the named file, read in the given format, to
+// the set in a single `ipset restore` batch. Entries already in the set are kept.
+// Plain lines may carry options after the element, in the syntax of `ipset
+// add`; an option that is unknown or has an invalid value fails the call with
+// an ErrInvalidEntry naming its line, and nothing is added. It returns the
+// number of entries read.
+func (s *IPSet) AddFromFile(path string, format FileFormat) (int, error) {
+	var batch []Entry
+	var perr error
+	err := scanEntriesFile(path, format, func(n int, l string) bool {
+		e, err := parseEntryStrict(parse.Fields(l))
+		if err != nil {
+			perr = fmt.Errorf("error reading entries from %s: %s: %w", path, format.position(n), err)
+			return false
+		}
+		batch = append(batch, e)
+		return true
+	})
+	if err == nil {
+		err = perr
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer s.handle().lockSet(s.Name)()
+	if err := s.handle().addBatch(s.Name, batch); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// addBatch adds the entries to the set with a single restore command.
+func (h *Handle) addBatch(set string, entries []Entry) error {
+	return h.restoreAdds(set, entries, true)
+}
+
+// restoreAdds adds the entries to the set with a single restore command,
+// checking them against the quota unless quota is false, e.g. because they
+// are all in the set already.
+func (h *Handle) restoreAdds(set string, entries []Entry, quota bool) error {
+	if err := h.checkOwned(set); err != nil {
+		return err
+	}
+	if err := validateEntries(entries); err != nil {
+		return err
+	}
+	if !quota {
+		h.quotaFresh(set, 0)
+	} else if err := h.checkQuotaAdd(set, elements(entries)); err != nil {
+		return err
+	}
+	var batch bytes.Buffer
+	writeAdds(&batch, set, entries)
+	err := h.restore(&batch)
+	h.changed(change{op: opAdd, set: set, entries: elements(entries), nomatch: nomatchElements(entries), err: err})
+	return err
+}
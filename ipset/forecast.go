@@ -0,0 +1,191 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxGrowthSamples is the number of entry counts kept per set for forecasts.
+const maxGrowthSamples = 256
+
+// CapacityForecast estimates when a set fills up at its current growth.
+type CapacityForecast struct {
+	Set     string `json:"set"`
+	Entries uint64 `json:"entries"`
+	// MaxElem is the capacity of the set, 0 if unknown, e.g. for bitmap sets.
+	MaxElem int `json:"maxelem,omitempty"`
+	// Growth is the number of entries added per hour on average over the
+	// samples, negative for a shrinking set.
+	Growth float64 `json:"growth_per_hour"`
+	// Full is how long until the set reaches MaxElem at that rate, 0 when it
+	// is full already, is not growing or its capacity is unknown.
+	Full time.Duration `json:"full_in,omitempty"`
+	// Samples is the number of entry counts the forecast is based on, and
+	// Since when the oldest was taken.
+	Samples int       `json:"samples"`
+	Since   time.Time `json:"since"`
+}
+
+// Growing reports whether the set is forecast to fill up.
+func (f CapacityForecast) Growing() bool {
+	return f.Growth > 0 && f.MaxElem > 0
+}
+
+func (f CapacityForecast) String() string {
+	switch {
+	case !f.Growing():
+		return fmt.Sprintf("set %s is not filling up (%d entries)", f.Set, f.Entries)
+	case f.Full == 0:
+		return fmt.Sprintf("set %s is full (maxelem %d)", f.Set, f.MaxElem)
+	}
+	return fmt.Sprintf("at current growth, set %s hits maxelem %d in ~%s", f.Set, f.MaxElem, roughDuration(f.Full))
+}
+
+// roughDuration formats d in its largest unit, e.g. "3 days".
+func roughDuration(d time.Duration) string {
+	for _, u := range []struct {
+		d    time.Duration
+		name string
+	}{{24 * time.Hour, "day"}, {time.Hour, "hour"}, {time.Minute, "minute"}} {
+		if d >= u.d {
+			n := int(math.Round(float64(d) / float64(u.d)))
+			if n == 1 {
+				return "1 " + u.name
+			}
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+	}
+	return "1 minute"
+}
+
+// growthSample is the number of entries of a set at some point.
+type growthSample struct {
+	t       time.Time
+	entries uint64
+}
+
+// growth holds the recent entry counts of a set.
+type growth struct {
+	maxElem int
+	samples []growthSample
+}
+
+// Forecast samples the number of entries of the set and forecasts when it
+// reaches its maxelem from the growth over the samples taken so far. Every
+// call of Statistics through the handle is a sample, so calling either
+// periodically, e.g. once an hour, keeps the forecast current; the last
+// samples are kept per set.
+func (s *IPSet) Forecast() (CapacityForecast, error) {
+	if _, err := s.Statistics(); err != nil {
+		return CapacityForecast{}, err
+	}
+	f, _ := s.handle().Forecast(s.Name)
+	return f, nil
+}
+
+// Forecast returns the capacity forecast of the named set from the samples
+// taken by Statistics so far, without running ipset; false if there are none.
+func (h *Handle) Forecast(set string) (CapacityForecast, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	g := h.growth[set]
+	if g == nil || len(g.samples) == 0 {
+		return CapacityForecast{}, false
+	}
+	last := g.samples[len(g.samples)-1]
+	f := CapacityForecast{
+		Set:     set,
+		Entries: last.entries,
+		MaxElem: g.maxElem,
+		Samples: len(g.samples),
+		Since:   g.samples[0].t,
+	}
+	perSecond := growthRate(g.samples)
+	f.Growth = perSecond * 3600
+	if f.Growing() {
+		left := math.Max(float64(g.maxElem)-float64(last.entries), 0)
+		f.Full = time.Duration(left / perSecond * float64(time.Second))
+	}
+	return f, true
+}
+
+// growthRate returns the least-squares slope of the entry counts, in entries
+// per second.
+func growthRate(samples []growthSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	t0 := samples[0].t
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.t.Sub(t0).Seconds()
+		y := float64(s.entries)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(samples))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// recordGrowth records the number of entries of a set.
+func (h *Handle) recordGrowth(set string, maxElem int, entries uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.growth == nil {
+		h.growth = make(map[string]*growth)
+	}
+	g := h.growth[set]
+	if g == nil {
+		g = &growth{}
+		h.growth[set] = g
+	}
+	g.maxElem = maxElem
+	if len(g.samples) == maxGrowthSamples {
+		g.samples = append(g.samples[:0], g.samples[1:]...)
+	}
+	g.samples = append(g.samples, growthSample{t: time.Now(), entries: entries})
+}
+
+// updateGrowth drops the samples of destroyed sets.
+func (h *Handle) updateGrowth(c change) {
+	if c.err != nil {
+		return
+	}
+	switch c.op {
+	case opDestroy, opSwap:
+		// the content of swapped sets is unrelated to their history
+		h.mu.Lock()
+		delete(h.growth, c.set)
+		if c.other != "" {
+			delete(h.growth, c.other)
+		}
+		h.mu.Unlock()
+	case opDestroyAll:
+		h.mu.Lock()
+		h.growth = nil
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampledHandle returns a handle with hourly entry counts of set "blocked".
+func sampledHandle(maxElem int, counts ...uint64) *Handle {
+	t0 := time.Unix(0, 0)
+	g := &growth{maxElem: maxElem}
+	for i, n := range counts {
+		g.samples = append(g.samples, growthSample{t: t0.Add(time.Duration(i) * time.Hour), entries: n})
+	}
+	return &Handle{growth: map[string]*growth{"blocked": g}}
+}
+
+func TestForecast(t *testing.T) {
+	h := sampledHandle(1000, 100, 200, 300)
+	f, ok := h.Forecast("blocked")
+	if !ok {
+		t.Fatal("no forecast from 3 samples")
+	}
+	if f.Entries != 300 || f.MaxElem != 1000 || f.Samples != 3 || !f.Since.Equal(time.Unix(0, 0)) {
+		t.Errorf("forecast %+v", f)
+	}
+	if math.Abs(f.Growth-100) > 1e-9 || !f.Growing() {
+		t.Errorf("growth %v per hour, want 100", f.Growth)
+	}
+	if d := f.Full - 7*time.Hour; d < -time.Second || d > time.Second {
+		t.Errorf("full in %s, want 7h", f.Full)
+	}
+	if s := f.String(); !strings.Contains(s, "maxelem 1000 in ~7 hours") {
+		t.Errorf("forecast formatted as %q", s)
+	}
+
+	if f, _ := sampledHandle(1000, 300, 200, 100).Forecast("blocked"); f.Growing() || f.Full != 0 || f.Growth >= 0 {
+		t.Errorf("shrinking set forecast %+v", f)
+	}
+	if f, _ := sampledHandle(1000, 500).Forecast("blocked"); f.Growing() || f.Growth != 0 {
+		t.Errorf("single sample forecast %+v", f)
+	}
+	if f, _ := sampledHandle(0, 100, 200).Forecast("blocked"); f.Growing() {
+		t.Errorf("set without maxelem forecast to fill up: %+v", f)
+	}
+	if f, _ := sampledHandle(150, 100, 200).Forecast("blocked"); !f.Growing() || f.Full != 0 || !strings.Contains(f.String(), "is full") {
+		t.Errorf("full set forecast %+v, %s", f, f)
+	}
+	if _, ok := h.Forecast("other"); ok {
+		t.Error("forecast of a set without samples")
+	}
+}
+
+func TestForecastForgetsReplacedSets(t *testing.T) {
+	h := sampledHandle(1000, 100, 200)
+	h.updateGrowth(change{op: opAdd, set: "blocked"})
+	if _, ok := h.Forecast("blocked"); !ok {
+		t.Fatal("add dropped the samples")
+	}
+	h.updateGrowth(change{op: opSwap, set: "blocked-temp", other: "blocked"})
+	if _, ok := h.Forecast("blocked"); ok {
+		t.Error("swap kept the samples of the set")
+	}
+	h.recordGrowth("blocked", 1000, 10)
+	h.recordGrowth("blocked", 1000, 20)
+	if f, ok := h.Forecast("blocked"); !ok || f.Samples != 2 || f.Entries != 20 {
+		t.Errorf("recorded samples gave %+v, %v", f, ok)
+	}
+	h.updateGrowth(change{op: opDestroyAll})
+	if _, ok := h.Forecast("blocked"); ok {
+		t.Error("destroy of all sets kept the samples")
+	}
+}
+
+func TestRoughDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second: "1 minute",
+		90 * time.Minute: "2 hours",
+		36 * time.Hour:   "2 days",
+		24 * time.Hour:   "1 day",
+	} {
+		if got := roughDuration(d); got != want {
+			t.Errorf("roughDuration(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
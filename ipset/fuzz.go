@@ -0,0 +1,56 @@
+//go:build gofuzz
+// +build gofuzz
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+// The fuzz targets of the output parsers, for go-fuzz:
+//
+//	go-fuzz-build -func FuzzSave github.com/intuitivelabs/go-ipset/ipset
+//	go-fuzz -bin ipset-fuzz.zip -workdir testdata/fuzz/save
+//
+// The corpus of each target, in testdata/fuzz/<target>/corpus, is seeded
+// with outputs of real ipset releases, which TestFuzzCorpus checks the same
+// way: a crash points at output the package would mishandle.
+
+// FuzzList parses data as `ipset list` output, see fuzzList.
+func FuzzList(data []byte) int {
+	return fuzzResult(fuzzList(data))
+}
+
+// FuzzSave parses data as `ipset save` output, see fuzzSave.
+func FuzzSave(data []byte) int {
+	return fuzzResult(fuzzSave(data))
+}
+
+// FuzzVersion parses data as `ipset --version` output, see fuzzVersion.
+func FuzzVersion(data []byte) int {
+	return fuzzResult(fuzzVersion(data))
+}
+
+// fuzzResult turns the result of a check into the one of a go-fuzz target,
+// crashing on mishandled output.
+func fuzzResult(parsed bool, err error) int {
+	if err != nil {
+		panic(err)
+	}
+	if !parsed {
+		return 0
+	}
+	return 1
+}
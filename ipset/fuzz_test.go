@@ -0,0 +1,53 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestFuzzCorpus runs the checks of the fuzz targets over their seed corpus,
+// which holds outputs of real ipset releases the parsers must accept.
+func TestFuzzCorpus(t *testing.T) {
+	for target, check := range map[string]func([]byte) (bool, error){
+		"list":    fuzzList,
+		"save":    fuzzSave,
+		"version": fuzzVersion,
+	} {
+		seeds, err := filepath.Glob(filepath.Join("testdata", "fuzz", target, "corpus", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(seeds) == 0 {
+			t.Errorf("no seeds for fuzz target %s", target)
+		}
+		for _, seed := range seeds {
+			data, err := ioutil.ReadFile(seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := check(data)
+			if err != nil {
+				t.Errorf("%s: seed %s: %v", target, filepath.Base(seed), err)
+			} else if !parsed {
+				t.Errorf("%s: seed %s rejected", target, filepath.Base(seed))
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "fmt"
+
+// The checks of the fuzz targets of fuzz.go, shared with TestFuzzCorpus as
+// fuzz.go is only built by go-fuzz. Each returns whether data parsed at all,
+// and an error for output the parser mishandles.
+
+// fuzzList parses data as `ipset list` output. Entries printed back must
+// parse the same.
+func fuzzList(data []byte) (bool, error) {
+	hd := ParseHeader(data)
+	ParseStats(data)
+	entries := ParseMembers(data)
+	for _, e := range entries {
+		if again := ParseMembers([]byte("Members:\n" + e.String())); len(again) != 1 || again[0].Element != e.Element {
+			return true, fmt.Errorf("entry %s does not round-trip: %v", e, again)
+		}
+	}
+	return hd.Name != "" || len(entries) != 0, nil
+}
+
+// fuzzSave parses data as `ipset save` output. Every set must have a name
+// and a type.
+func fuzzSave(data []byte) (bool, error) {
+	sets, err := ParseSave(data)
+	for _, s := range sets {
+		if s.Header.Name == "" || s.Header.Type == "" {
+			return true, fmt.Errorf("set without name or type: %+v", s.Header)
+		}
+	}
+	return err == nil && len(sets) != 0, nil
+}
+
+// fuzzVersion parses data as `ipset --version` output. Versions must start
+// with "v".
+func fuzzVersion(data []byte) (bool, error) {
+	v, err := ParseVersion(data)
+	if err != nil {
+		return false, nil
+	}
+	if len(v) < 4 || v[0] != 'v' {
+		return true, fmt.Errorf("malformed version %s", v)
+	}
+	return true, nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Generations rolls out the content of a set as numbered generations held in
+// the sets <name>-gen-<k>, retaining previous ones so that a bad push can be
+// reverted in seconds with Rollback. Like BlueGreen, rules reference <name>,
+// a list:set containing the live generation.
+type Generations struct {
+	// Name is the name of the list:set rules reference.
+	Name string
+	// Keep is the number of previous generations retained.
+	Keep    int
+	h       *Handle
+	settype string
+	params  Params
+}
+
+// NewGenerations creates the list:set of a generational rollout, see
+// Handle.NewGenerations.
+func NewGenerations(name, settype string, p *Params, keep int) (*Generations, error) {
+	return defaultHandle.NewGenerations(name, settype, p, keep)
+}
+
+// NewGenerations creates, or adopts, the list:set named name and returns the
+// rollout creating its generations of type settype with parameters p, keeping
+// keep previous ones.
+func (h *Handle) NewGenerations(name, settype string, p *Params, keep int) (*Generations, error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("invalid number of generations to keep %d", keep)
+	}
+	if p == nil {
+		p = &Params{}
+	}
+	if _, err := h.createListSet(name); err != nil {
+		return nil, err
+	}
+	return &Generations{Name: name, Keep: keep, h: h, settype: settype, params: *p}, nil
+}
+
+// genPrefix returns the prefix of the names of the generation sets, leaving
+// room for 6 digits within the kernel limit.
+func (g *Generations) genPrefix() string {
+	return strings.TrimSuffix(tempSetName(g.Name, "-gen-999999"), "999999")
+}
+
+func (g *Generations) setName(k int) string {
+	return g.genPrefix() + strconv.Itoa(k)
+}
+
+// List returns the numbers of the existing generations, oldest first.
+func (g *Generations) List() ([]int, error) {
+	prefix := g.genPrefix()
+	names, err := g.h.ListSetNames(PrefixFilter(prefix))
+	if err != nil {
+		return nil, err
+	}
+	var gens []int
+	for _, n := range names {
+		if k, err := strconv.Atoi(strings.TrimPrefix(n, prefix)); err == nil {
+			gens = append(gens, k)
+		}
+	}
+	sort.Ints(gens)
+	return gens, nil
+}
+
+// Live returns the number of the live generation, false if none is.
+func (g *Generations) Live() (int, bool, error) {
+	members, err := g.h.list(g.Name)
+	if err != nil {
+		return 0, false, err
+	}
+	prefix := g.genPrefix()
+	for _, m := range members {
+		if !strings.HasPrefix(m, prefix) {
+			continue
+		}
+		if k, err := strconv.Atoi(strings.TrimPrefix(m, prefix)); err == nil {
+			return k, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// Push creates a generation numbered after the newest one with the entries,
+// checks it and makes it live, then destroys the generations beyond the
+// Keep most recent previous ones. If a check fails, the new generation is
+// destroyed and an ErrVerifyFailed returned.
+func (g *Generations) Push(entries []Entry, check BlueGreenCheck) (int, error) {
+	defer g.h.lockSet(g.Name)()
+	gens, err := g.List()
+	if err != nil {
+		return 0, err
+	}
+	k := 1
+	if len(gens) != 0 {
+		k = gens[len(gens)-1] + 1
+	}
+	s, _, err := g.h.Create(g.setName(k), g.settype, &g.params)
+	if err != nil {
+		return 0, err
+	}
+	if err := g.h.addBatch(s.Name, entries); err != nil {
+		g.h.destroyIPSet(s.Name)
+		return 0, err
+	}
+	if err := verifyGeneration(s, check); err != nil {
+		g.h.destroyIPSet(s.Name)
+		return 0, err
+	}
+	live, ok, err := g.Live()
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		err = g.h.switchMember(g.Name, s.Name, g.setName(live))
+	} else {
+		err = (&IPSet{Name: g.Name, h: g.h}).addWithOptions(s.Name, AddOptions{})
+	}
+	if err != nil {
+		return 0, err
+	}
+	return k, g.prune(append(gens, k), k)
+}
+
+// Rollback makes the generation steps before the live one live again, or
+// after it for a negative steps, e.g. to undo a rollback.
+func (g *Generations) Rollback(steps int) (int, error) {
+	defer g.h.lockSet(g.Name)()
+	live, ok, err := g.Live()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: no generation of %s is live", ErrSetNotFound, g.Name)
+	}
+	gens, err := g.List()
+	if err != nil {
+		return 0, err
+	}
+	i := sort.SearchInts(gens, live) - steps
+	if i < 0 || i >= len(gens) {
+		return 0, fmt.Errorf("%w: no generation %d steps from %d of %s", ErrSetNotFound, steps, live, g.Name)
+	}
+	if err := g.h.switchMember(g.Name, g.setName(gens[i]), g.setName(live)); err != nil {
+		return 0, err
+	}
+	return gens[i], nil
+}
+
+// prune destroys the generations older than the Keep most recent ones
+// before live.
+func (g *Generations) prune(gens []int, live int) error {
+	var errs []*ItemError
+	kept := 0
+	for i := len(gens) - 1; i >= 0; i-- {
+		if gens[i] >= live {
+			continue
+		}
+		if kept < g.Keep {
+			kept++
+			continue
+		}
+		if err := g.h.destroyChecked(g.setName(gens[i])); err != nil {
+			errs = append(errs, &ItemError{Set: g.setName(gens[i]), Err: err})
+		}
+	}
+	if len(errs) != 0 {
+		return &MultiError{Msg: "error pruning generations of " + g.Name, Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SetNode is a set with the sets it contains, for a list:set.
+type SetNode struct {
+	Name     string
+	Type     string
+	Children []*SetNode
+}
+
+// SetGraph holds which list:set contains which sets, as listed at one point
+// in time. A set contained in a list:set cannot be destroyed before it.
+type SetGraph struct {
+	types    map[string]string
+	children map[string][]string
+	parents  map[string][]string
+}
+
+// LoadSetGraph lists the existing sets and the members of the list:set ones,
+// see Handle.LoadSetGraph.
+func LoadSetGraph() (*SetGraph, error) {
+	return defaultHandle.LoadSetGraph()
+}
+
+// LoadSetGraph lists the existing sets and the members of the list:set ones.
+func (h *Handle) LoadSetGraph() (*SetGraph, error) {
+	if err := h.initCheck(); err != nil {
+		return nil, err
+	}
+	g := &SetGraph{types: make(map[string]string), children: make(map[string][]string), parents: make(map[string][]string)}
+	names, err := h.listAllSetNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		g.types[name] = ""
+	}
+	lists, err := h.ListAllByType("list:set")
+	if err != nil {
+		return nil, err
+	}
+	for _, hd := range lists {
+		g.types[hd.Name] = hd.Type
+		members, err := h.list(hd.Name)
+		if err != nil {
+			return nil, err
+		}
+		g.children[hd.Name] = members
+		for _, m := range members {
+			g.parents[m] = append(g.parents[m], hd.Name)
+		}
+	}
+	return g, nil
+}
+
+// Children returns the members of the named list:set.
+func (g *SetGraph) Children(name string) []string {
+	return g.children[name]
+}
+
+// Parents returns the list:set sets containing the named set.
+func (g *SetGraph) Parents(name string) []string {
+	return g.parents[name]
+}
+
+// Resolve returns the named set with its members, recursively.
+func (g *SetGraph) Resolve(name string) (*SetNode, error) {
+	if _, ok := g.types[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSetNotFound, name)
+	}
+	return g.resolve(name, map[string]bool{}), nil
+}
+
+func (g *SetGraph) resolve(name string, path map[string]bool) *SetNode {
+	n := &SetNode{Name: name, Type: g.types[name]}
+	if path[name] {
+		// a cycle, which the kernel does not allow: stop here
+		return n
+	}
+	path[name] = true
+	for _, c := range g.children[name] {
+		n.Children = append(n.Children, g.resolve(c, path))
+	}
+	delete(path, name)
+	return n
+}
+
+// Descendants returns the sets the named set contains, recursively, without
+// repetitions.
+func (g *SetGraph) Descendants(name string) []string {
+	var out []string
+	seen := map[string]bool{name: true}
+	var walk func(string)
+	walk = func(n string) {
+		for _, c := range g.children[n] {
+			if !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+				walk(c)
+			}
+		}
+	}
+	walk(name)
+	return out
+}
+
+// CreateOrder sorts the named sets so that each comes after the sets it
+// contains, the order to create them in.
+func (g *SetGraph) CreateOrder(names []string) []string {
+	want := make(map[string]bool, len(names))
+	for _, n := range names {
+		want[n] = true
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	var out []string
+	done := make(map[string]bool)
+	var visit func(string)
+	visit = func(n string) {
+		if done[n] {
+			return
+		}
+		done[n] = true
+		for _, c := range g.children[n] {
+			if want[c] {
+				visit(c)
+			}
+		}
+		out = append(out, n)
+	}
+	for _, n := range sorted {
+		visit(n)
+	}
+	return out
+}
+
+// DestroyOrder sorts the named sets so that each comes before the sets it
+// contains, the order to destroy them in.
+func (g *SetGraph) DestroyOrder(names []string) []string {
+	out := g.CreateOrder(names)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// DestroyHierarchy destroys the named set and the sets it contains,
+// recursively, each before its members, see Handle.DestroyHierarchy.
+func DestroyHierarchy(name string) ([]string, error) {
+	return defaultHandle.DestroyHierarchy(name)
+}
+
+// DestroyHierarchy destroys the named set and the sets it contains,
+// recursively, each before its members, and returns the names of the sets
+// destroyed. Members still contained in a list:set outside of the hierarchy
+// cannot be destroyed; the failures are reported as a *MultiError.
+func (h *Handle) DestroyHierarchy(name string) ([]string, error) {
+	g, err := h.LoadSetGraph()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := g.types[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSetNotFound, name)
+	}
+	var destroyed []string
+	var errs []*ItemError
+	for _, n := range g.DestroyOrder(append([]string{name}, g.Descendants(name)...)) {
+		if err := h.destroyChecked(n); err != nil {
+			errs = append(errs, &ItemError{Set: n, Err: err})
+			continue
+		}
+		destroyed = append(destroyed, n)
+	}
+	if len(errs) != 0 {
+		return destroyed, &MultiError{Msg: "error destroying the hierarchy of set " + name, Errors: errs}
+	}
+	return destroyed, nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testGraph returns a graph of the given list:set sets and their members;
+// the other sets are given in plain.
+func testGraph(lists map[string][]string, plain ...string) *SetGraph {
+	g := &SetGraph{types: make(map[string]string), children: make(map[string][]string), parents: make(map[string][]string)}
+	for _, name := range plain {
+		g.types[name] = "hash:ip"
+	}
+	for name, members := range lists {
+		g.types[name] = "list:set"
+		g.children[name] = members
+		for _, m := range members {
+			g.parents[m] = append(g.parents[m], name)
+		}
+	}
+	return g
+}
+
+func TestSetGraphOrder(t *testing.T) {
+	g := testGraph(map[string][]string{
+		"all": {"web", "dns"},
+		"web": {"blocked", "shared"},
+		"dns": {"shared"},
+	}, "blocked", "shared")
+	names := []string{"all", "blocked", "dns", "shared", "web"}
+	created := g.CreateOrder(names)
+	if want := []string{"blocked", "shared", "web", "dns", "all"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("create order %v, want %v", created, want)
+	}
+	destroyed := g.DestroyOrder(names)
+	if want := []string{"all", "dns", "web", "shared", "blocked"}; !reflect.DeepEqual(destroyed, want) {
+		t.Errorf("destroy order %v, want %v", destroyed, want)
+	}
+	// members outside of the names are not added
+	if got := g.CreateOrder([]string{"web", "all"}); !reflect.DeepEqual(got, []string{"web", "all"}) {
+		t.Errorf("create order of a subset %v", got)
+	}
+	if got := g.Descendants("all"); !reflect.DeepEqual(got, []string{"web", "blocked", "shared", "dns"}) {
+		t.Errorf("descendants %v", got)
+	}
+	if got := g.Parents("shared"); len(got) != 2 {
+		t.Errorf("parents of the shared set %v", got)
+	}
+}
+
+func TestSetGraphCycle(t *testing.T) {
+	g := testGraph(map[string][]string{"a": {"b"}, "b": {"a"}})
+	n, err := g.Resolve("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SetNode{Name: "a", Type: "list:set", Children: []*SetNode{
+		{Name: "b", Type: "list:set", Children: []*SetNode{{Name: "a", Type: "list:set"}}},
+	}}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("resolved %+v", n)
+	}
+	if got := g.CreateOrder([]string{"a", "b"}); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("create order %v", got)
+	}
+	if got := g.Descendants("a"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("descendants %v", got)
+	}
+	if _, err := g.Resolve("missing"); !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("resolving a missing set: %v", err)
+	}
+}
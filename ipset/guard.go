@@ -0,0 +1,171 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPolicyDenied is matched by errors.Is for the *PolicyError of an
+// operation a DestructivePolicy vetoed.
+var ErrPolicyDenied = errors.New("denied by policy")
+
+// DestructiveOp is an operation destructive policies are consulted for.
+type DestructiveOp int
+
+const (
+	// OpDestroy is the destruction of a set by Destroy or DestroyMatching.
+	OpDestroy DestructiveOp = iota + 1
+	// OpDestroyAll is the destruction of a set by DestroyAll.
+	OpDestroyAll
+	// OpFlush is the flush of a set by Flush.
+	OpFlush
+	// OpSwap is the swap of two sets by Swap.
+	OpSwap
+)
+
+func (op DestructiveOp) String() string {
+	switch op {
+	case OpDestroy:
+		return "destroy"
+	case OpDestroyAll:
+		return "destroy all"
+	case OpFlush:
+		return "flush"
+	case OpSwap:
+		return "swap"
+	}
+	return fmt.Sprintf("DestructiveOp(%d)", int(op))
+}
+
+// Operation is a destructive operation submitted to the policies of a handle.
+type Operation struct {
+	Op  DestructiveOp
+	Set string
+	// Other is the set swapped with Set, empty for the other operations.
+	Other string
+}
+
+func (o Operation) String() string {
+	if o.Op == OpSwap {
+		return fmt.Sprintf("swap of sets %s and %s", o.Set, o.Other)
+	}
+	return fmt.Sprintf("%s of set %s", o.Op, o.Set)
+}
+
+// DestructivePolicy is consulted before a destructive operation through h and
+// vetoes it by returning the reason, nil to allow it.
+type DestructivePolicy func(h *Handle, o Operation) error
+
+// PolicyError is the error of an operation a DestructivePolicy vetoed.
+type PolicyError struct {
+	Operation
+	// Err is the reason returned by the policy.
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.Operation, ErrPolicyDenied, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrPolicyDenied.
+func (e *PolicyError) Is(target error) bool { return target == ErrPolicyDenied }
+
+// WithDestructivePolicy makes the handle consult the policies, in order,
+// before destroying, flushing or swapping sets, failing a vetoed operation
+// with a *PolicyError. They apply to Destroy, DestroyAll, DestroyMatching,
+// DestroyHierarchy, Flush, Swap and Restore, to the flushes of batches and
+// sessions, to the swaps of set groups, to the switches of blue/green
+// rollouts and generations, which are checked as swaps of the live and the
+// new set, and to the sets destroyed or flushed by the cleanup, the Janitor,
+// generation pruning and the recreation of an adopted set. The refreshes,
+// snapshot loads, imports, rollbacks and undos replacing a set through a
+// temporary one are checked as swaps of the temporary and the live set, a
+// RefreshFlush as a flush of the set. DestroyAll and DestroyMatching still destroy the
+// sets that are allowed and report the others in their *MultiError. The
+// destruction of the temporary sets afterwards is not subject to the
+// policies.
+func WithDestructivePolicy(policies ...DestructivePolicy) Option {
+	return func(h *Handle) {
+		h.policies = append(h.policies, policies...)
+	}
+}
+
+// checkPolicy returns the *PolicyError of the first policy vetoing o.
+func (h *Handle) checkPolicy(o Operation) error {
+	for _, p := range h.policies {
+		if err := p(h, o); err != nil {
+			return &PolicyError{Operation: o, Err: err}
+		}
+	}
+	return nil
+}
+
+// destroyChecked destroys the named set once allowed by the policies.
+func (h *Handle) destroyChecked(name string) error {
+	if err := h.checkPolicy(Operation{Op: OpDestroy, Set: name}); err != nil {
+		return err
+	}
+	return h.destroyIPSet(name)
+}
+
+// DenyReferenced is a DestructivePolicy vetoing the destruction of sets the
+// kernel references, e.g. from iptables rules or list:set sets, rather than
+// letting ipset fail on them one at a time.
+func DenyReferenced(h *Handle, o Operation) error {
+	if o.Op != OpDestroy && o.Op != OpDestroyAll {
+		return nil
+	}
+	stats, err := h.stats(o.Set)
+	if err != nil {
+		return err
+	}
+	if stats.Refs > 0 {
+		return fmt.Errorf("set is referenced %d times", stats.Refs)
+	}
+	return nil
+}
+
+// DenyFlushLarger returns a DestructivePolicy vetoing the flush of sets
+// holding more than max entries.
+func DenyFlushLarger(max uint64) DestructivePolicy {
+	return func(h *Handle, o Operation) error {
+		if o.Op != OpFlush {
+			return nil
+		}
+		stats, err := h.stats(o.Set)
+		if err != nil {
+			return err
+		}
+		if stats.Entries > max {
+			return fmt.Errorf("set holds %d entries, more than %d", stats.Entries, max)
+		}
+		return nil
+	}
+}
+
+// stats returns the statistics of the named set without sampling its growth.
+func (h *Handle) stats(set string) (Stats, error) {
+	details, err := h.listWithOpts(set, "-t")
+	if err != nil {
+		return Stats{}, err
+	}
+	return parseListTerse(details)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"os"
+	"sync"
+)
+
+// Handle runs the ipset commands of the sets created through it. The package
+// level functions and sets created with New use a default handle. The
+// functions managing sets have Handle method counterparts, which new code
+// should prefer so that it does not depend on package state.
+type Handle struct {
+	// netns is the network namespace commands run in, nil for the one of the process
+	netns *os.File
+	// testCache caches Test results, nil when disabled
+	testCache *testCache
+	// env, dir and extraArgs configure the ipset processes
+	env       []string
+	dir       string
+	extraArgs []string
+	// runner is the command line running ipset, empty to run it directly
+	runner []string
+	// maxOutput caps the buffered output of a command, 0 for no limit
+	maxOutput int
+	// procs holds a token per running ipset process, nil for no limit
+	procs chan struct{}
+	// concurrency is the number of sets operations on several sets work on
+	// at once, 0 for the default
+	concurrency int
+	// flock serializes mutations with other processes, nil for none
+	flock *fileLock
+	// registry records the metadata of the sets, nil for none
+	registry MetadataRegistry
+	// lease is the lease recorded for the owned sets, nil for none
+	lease *Lease
+	// ownerPrefix restricts the sets the handle changes, empty for none
+	ownerPrefix string
+	// quota limits the entries of the owned sets, nil for none
+	quota *quota
+	// policies are consulted before destructive operations
+	policies []DestructivePolicy
+	// cleanup is what Close does with the owned sets
+	cleanup CleanupAction
+	// types caches the set types the utility of the handle supports
+	typesOnce sync.Once
+	types     []TypeRevision
+	typesErr  error
+
+	mu sync.Mutex
+	// owned holds the names of the sets created through the handle
+	owned map[string]bool
+	// refs counts the references to the shared sets, see Retain
+	refs map[string]int
+	// mirrors holds the mirrors of each set, updated on mutations
+	mirrors map[string][]*Mirror
+	// lookups holds the lookup mirrors of each set, reloaded on mutations
+	lookups map[string][]*LookupMirror
+	// trackers holds the expiry trackers of each set, told about mutations
+	trackers map[string][]*ExpiryTracker
+	// sessions holds the open sessions
+	sessions []*Session
+	// setLocks holds the locks of the sets being mutated
+	setLocks map[string]*setLock
+	// allowed holds the sets outside of ownerPrefix the handle may change
+	allowed map[string]bool
+	// opStats holds the operation statistics of each set
+	opStats map[string]*OpStats
+	// growth holds the entry counts of each set sampled by Statistics
+	growth map[string]*growth
+	// templates holds the set templates by name
+	templates map[string]SetTemplate
+	// undo journals the inverses of the last mutations, nil when disabled
+	undo *undoJournal
+	// statsd receives metrics, nil for none
+	statsd *statsd
+	// noop records the commands instead of running them, nil to run them
+	noop *NoopRecorder
+	// auditSink records mutations on behalf of auditWho, nil for none
+	auditSink AuditSink
+	auditWho  string
+}
+
+// Option configures a Handle.
+type Option func(*Handle)
+
+var defaultHandle = NewHandle()
+
+// NewHandle returns a handle configured by opts.
+func NewHandle(opts ...Option) *Handle {
+	h := &Handle{owned: make(map[string]bool)}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+// handle returns the handle running the commands of the set.
+func (s *IPSet) handle() *Handle {
+	if s.h == nil {
+		return defaultHandle
+	}
+	return s.h
+}
+
+// track records that the named set was created through the handle.
+func (h *Handle) track(name string) {
+	h.mu.Lock()
+	h.owned[name] = true
+	h.mu.Unlock()
+}
+
+// untrack forgets the named set, e.g. once it was destroyed.
+func (h *Handle) untrack(name string) {
+	h.mu.Lock()
+	delete(h.owned, name)
+	delete(h.refs, name)
+	h.mu.Unlock()
+}
+
+// isOwned reports whether the named set was created through the handle.
+func (h *Handle) isOwned(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.owned[name]
+}
+
+// ownedSets returns the names of the sets created through the handle.
+func (h *Handle) ownedSets() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.owned))
+	for name := range h.owned {
+		names = append(names, name)
+	}
+	return names
+}
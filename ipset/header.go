@@ -0,0 +1,234 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// Header describes how a set was created, as reported by ipset.
+type Header struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Revision int    `json:"revision,omitempty"`
+	Family   string `json:"family,omitempty"`
+	HashSize int    `json:"hashsize,omitempty"`
+	MaxElem  int    `json:"maxelem,omitempty"`
+	// Timeout is the default entry timeout, TimeoutPermanent for "timeout 0"
+	// and 0 for a set without timeout support.
+	Timeout    int    `json:"timeout,omitempty"`
+	NetMask    int    `json:"netmask,omitempty"`
+	MarkMask   string `json:"markmask,omitempty"`
+	Range      string `json:"range,omitempty"`
+	Size       int    `json:"size,omitempty"`
+	BucketSize int    `json:"bucketsize,omitempty"`
+	Counters   bool   `json:"counters,omitempty"`
+	Comment    bool   `json:"comment,omitempty"`
+	SkbInfo    bool   `json:"skbinfo,omitempty"`
+	ForceAdd   bool   `json:"forceadd,omitempty"`
+}
+
+// parseHeaderOptions fills h from the options of a "Header:" line of `ipset list`
+// or of a `create` line of `ipset save`.
+func (h *Header) parseHeaderOptions(fields []string) {
+	for i := 0; i < len(fields); i++ {
+		switch key := fields[i]; key {
+		case "counters":
+			h.Counters = true
+		case "comment":
+			h.Comment = true
+		case "skbinfo":
+			h.SkbInfo = true
+		case "forceadd":
+			h.ForceAdd = true
+		default:
+			if i+1 >= len(fields) {
+				return
+			}
+			i++
+			val := fields[i]
+			n, _ := strconv.Atoi(val)
+			switch key {
+			case "family":
+				h.Family = val
+			case "hashsize":
+				h.HashSize = n
+			case "maxelem":
+				h.MaxElem = n
+			case "timeout":
+				h.Timeout = n
+				if n == 0 {
+					h.Timeout = TimeoutPermanent
+				}
+			case "netmask":
+				h.NetMask = n
+			case "markmask":
+				h.MarkMask = val
+			case "range":
+				h.Range = val
+			case "size":
+				h.Size = n
+			case "bucketsize":
+				h.BucketSize = n
+			}
+		}
+	}
+}
+
+// createArgs returns the arguments of `ipset create` recreating the set.
+func (h Header) createArgs() []string {
+	args := []string{h.Name, h.Type}
+	add := func(key string, val string) { args = append(args, key, val) }
+	if h.Family != "" {
+		add("family", h.Family)
+	}
+	if h.HashSize != 0 {
+		add("hashsize", strconv.Itoa(h.HashSize))
+	}
+	if h.MaxElem != 0 {
+		add("maxelem", strconv.Itoa(h.MaxElem))
+	}
+	switch {
+	case h.Timeout == TimeoutPermanent:
+		add("timeout", "0")
+	case h.Timeout > 0:
+		add("timeout", strconv.Itoa(h.Timeout))
+	}
+	if h.NetMask != 0 {
+		add("netmask", strconv.Itoa(h.NetMask))
+	}
+	if h.MarkMask != "" {
+		add("markmask", h.MarkMask)
+	}
+	if h.Range != "" {
+		add("range", h.Range)
+	}
+	if h.Size != 0 {
+		add("size", strconv.Itoa(h.Size))
+	}
+	if h.BucketSize != 0 {
+		add("bucketsize", strconv.Itoa(h.BucketSize))
+	}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{h.Counters, "counters"}, {h.Comment, "comment"}, {h.SkbInfo, "skbinfo"}, {h.ForceAdd, "forceadd"}} {
+		if flag.set {
+			args = append(args, flag.name)
+		}
+	}
+	return args
+}
+
+// parseHeader parses the terse listing of a set.
+func parseHeader(details []string) Header {
+	var h Header
+	for _, l := range details {
+		key, val, ok := parse.KeyValue(l)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Name":
+			h.Name = val
+		case "Type":
+			h.Type = val
+		case "Revision":
+			h.Revision, _ = strconv.Atoi(val)
+		case "Header":
+			h.parseHeaderOptions(strings.Fields(val))
+		}
+	}
+	return h
+}
+
+// Header returns the creation header of the set.
+func (s *IPSet) Header() (Header, error) {
+	return s.handle().header(s.Name)
+}
+
+func (h *Handle) header(set string) (Header, error) {
+	details, err := h.listWithOpts(set, "-t")
+	if err != nil {
+		return Header{}, err
+	}
+	return parseHeader(details), nil
+}
+
+// ListAllByType returns the headers of the existing sets of the given type,
+// e.g. "hash:net", read from a single terse listing of all sets.
+func ListAllByType(settype string) ([]Header, error) {
+	return defaultHandle.ListAllByType(settype)
+}
+
+// ListAllByType returns the headers of the sets of the handle of the given
+// type, see ListAllByType.
+func (h *Handle) ListAllByType(settype string) ([]Header, error) {
+	if err := h.initCheck(); err != nil {
+		return nil, err
+	}
+	var headers []Header
+	err := h.eachSetDetails(func(details []string) {
+		if hd := parseHeader(details); hd.Type == settype {
+			headers = append(headers, hd)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// eachSetDetails calls fn with the details of the terse listing of each set,
+// read from a single listing of all sets. details is reused between calls.
+func (h *Handle) eachSetDetails(fn func(details []string)) error {
+	args := []string{"list", "-t"}
+	if !caps.Terse {
+		// the members are skipped below
+		args = args[:1]
+	}
+	var details []string
+	inMembers := false
+	flush := func() {
+		if len(details) == 0 {
+			return
+		}
+		fn(details)
+		details = details[:0]
+	}
+	out, err := h.scanLines(func(l string) {
+		if key, _, ok := parse.KeyValue(l); ok && key == "Name" {
+			flush()
+			inMembers = false
+		}
+		if parse.IsMembersHeader(l) {
+			inMembers = true
+		}
+		if !inMembers {
+			details = append(details, l)
+		}
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("error listing all sets: %w (%s)", err, out)
+	}
+	flush()
+	return nil
+}
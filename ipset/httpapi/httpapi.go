@@ -0,0 +1,416 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpapi provides an embeddable HTTP handler mapping a small JSON API
+// onto the ipset library, for managing sets from non-Go tooling.
+//
+// Routes:
+//
+//	POST   /sets                         create a set: {"name", "type", "family", "hashsize", "maxelem", "timeout"}
+//	GET    /sets/{name}                  statistics of the set
+//	DELETE /sets/{name}                  destroy the set
+//	GET    /sets/{name}/forecast         capacity forecast of the set
+//	POST   /sets/{name}/swap             swap with another set: {"with"}
+//	GET    /sets/{name}/entries          members of the set
+//	POST   /sets/{name}/entries          add an entry: {"entry", "timeout", "comment", "packets", "bytes", "nomatch"}
+//	PUT    /sets/{name}/entries          replace the members: {"entries": [...]}
+//	DELETE /sets/{name}/entries          flush the set
+//	GET    /sets/{name}/entries/{entry}  test an entry: {"found"}
+//	DELETE /sets/{name}/entries/{entry}  delete an entry
+//
+// Entries containing a slash (networks) may be given verbatim or escaped as %2F.
+// Errors are reported as {"error": "..."} with a matching status code.
+//
+// WithPolicy makes the handler authenticate its clients, with an
+// "Authorization: Bearer" header or a verified TLS client certificate, and
+// check their per-set permissions, confining each client to the sets of its
+// tenant if the policy has Tenants; see the auth package.
+package httpapi
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"github.com/intuitivelabs/go-ipset/ipset/auth"
+)
+
+// CreateRequest is the body of POST /sets.
+type CreateRequest struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Family   string `json:"family,omitempty"`
+	HashSize int    `json:"hashsize,omitempty"`
+	MaxElem  int    `json:"maxelem,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+// EntryRequest is the body of POST /sets/{name}/entries. The options are those
+// of ipset.AddOptions: a zero Timeout applies the default timeout of the set
+// and ipset.TimeoutPermanent stores the entry permanently.
+type EntryRequest struct {
+	Entry   string `json:"entry"`
+	Timeout int    `json:"timeout,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	Packets uint64 `json:"packets,omitempty"`
+	Bytes   uint64 `json:"bytes,omitempty"`
+	Nomatch bool   `json:"nomatch,omitempty"`
+}
+
+// EntriesBody is the body of PUT and the response of GET /sets/{name}/entries.
+type EntriesBody struct {
+	Entries []string `json:"entries"`
+}
+
+// SwapRequest is the body of POST /sets/{name}/swap.
+type SwapRequest struct {
+	With string `json:"with"`
+}
+
+// TestResponse is the response of GET /sets/{name}/entries/{entry}.
+type TestResponse struct {
+	Found bool `json:"found"`
+}
+
+// ErrorResponse is the body of every failed request.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler serves the API.
+type Handler struct {
+	mu sync.Mutex
+	// sets created through the handler, needed to re-create temporary sets on refresh
+	sets   map[string]*ipset.IPSet
+	policy *auth.Policy
+	handle *ipset.Handle
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithPolicy makes the handler authenticate and authorize every request with
+// p. Without it, any client able to connect may do anything.
+func WithPolicy(p *auth.Policy) Option {
+	return func(h *Handler) { h.policy = p }
+}
+
+// WithHandle makes the handler run every operation through ih, so that the
+// options of the handle, such as its owner prefix, quota, destructive
+// policies, lock and undo journal, apply to the requests. Without it, the
+// handler uses an ipset handle of its own without options.
+func WithHandle(ih *ipset.Handle) Option {
+	return func(h *Handler) { h.handle = ih }
+}
+
+// NewHandler returns a Handler managing the sets of the local host.
+// Mount it at the root of a server or below a prefix with http.StripPrefix.
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{sets: make(map[string]*ipset.IPSet), handle: ipset.NewHandle()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// authorize checks that the client of the request holds perm on the sets,
+// reporting the failure otherwise.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, perm auth.Permission, sets ...string) bool {
+	if h.policy == nil {
+		return true
+	}
+	var chains [][]*x509.Certificate
+	if r.TLS != nil {
+		chains = r.TLS.VerifiedChains
+	}
+	principal, err := h.policy.Authenticate(auth.BearerToken(r.Header.Get("Authorization")), chains)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return false
+	}
+	if err := h.policy.Authorize(principal, perm, sets...); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+func (h *Handler) lookup(name string) (*ipset.IPSet, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if set, ok := h.sets[name]; ok {
+		return set, true
+	}
+	return h.handle.Set(name), false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, ErrorResponse{Error: msg})
+}
+
+// statusOf maps an error of the ipset package to the HTTP status of its
+// class, http.StatusInternalServerError for the others.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ipset.ErrInvalidEntry):
+		return http.StatusBadRequest
+	case errors.Is(err, ipset.ErrSetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ipset.ErrNotOwned), errors.Is(err, auth.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ipset.ErrPolicyDenied):
+		return http.StatusConflict
+	case errors.Is(err, ipset.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// writeFailure reports the failure of an operation with the status of its
+// class.
+func writeFailure(w http.ResponseWriter, err error) {
+	writeError(w, statusOf(err), err.Error())
+}
+
+// writeResult reports the outcome of an operation without response body.
+func writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// ServeHTTP dispatches the request to the route matching its path and method.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path != "sets" && !strings.HasPrefix(path, "sets/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	// sets/{name}/entries/{entry...}: the entry may itself contain a slash
+	parts := strings.SplitN(path, "/", 4)
+	switch {
+	case len(parts) == 1:
+		h.serveSets(w, r)
+	case len(parts) == 2:
+		h.serveSet(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "swap":
+		h.serveSwap(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "forecast":
+		h.serveForecast(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "entries":
+		h.serveEntries(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "entries":
+		h.serveEntry(w, r, parts[1], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func (h *Handler) serveSets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	var req CreateRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Name == "" || req.Type == "" {
+		writeError(w, http.StatusBadRequest, "set name and type are required")
+		return
+	}
+	if !h.authorize(w, r, auth.Mutate, req.Name) {
+		return
+	}
+	set, err := h.handle.New(req.Name, req.Type, &ipset.Params{
+		HashFamily: req.Family,
+		HashSize:   req.HashSize,
+		MaxElem:    req.MaxElem,
+		Timeout:    req.Timeout,
+	})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	h.mu.Lock()
+	h.sets[req.Name] = set
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) serveSet(w http.ResponseWriter, r *http.Request, name string) {
+	set, _ := h.lookup(name)
+	switch r.Method {
+	case http.MethodGet:
+		if !h.authorize(w, r, auth.Read, name) {
+			return
+		}
+		stats, err := set.Statistics()
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	case http.MethodDelete:
+		if !h.authorize(w, r, auth.Destroy, name) {
+			return
+		}
+		err := set.Destroy()
+		if err == nil {
+			h.mu.Lock()
+			delete(h.sets, name)
+			h.mu.Unlock()
+		}
+		writeResult(w, err)
+	default:
+		methodNotAllowed(w, "GET, DELETE")
+	}
+}
+
+func (h *Handler) serveForecast(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	if !h.authorize(w, r, auth.Read, name) {
+		return
+	}
+	set, _ := h.lookup(name)
+	f, err := set.Forecast()
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+func (h *Handler) serveSwap(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	var req SwapRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if !h.authorize(w, r, auth.Mutate, name, req.With) {
+		return
+	}
+	writeResult(w, h.handle.Swap(name, req.With))
+}
+
+func (h *Handler) serveEntries(w http.ResponseWriter, r *http.Request, name string) {
+	set, managed := h.lookup(name)
+	perm := auth.Mutate
+	if r.Method == http.MethodGet {
+		perm = auth.Read
+	}
+	if !h.authorize(w, r, perm, name) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		members, err := set.Members()
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		if members == nil {
+			members = []string{}
+		}
+		writeJSON(w, http.StatusOK, EntriesBody{Entries: members})
+	case http.MethodPost:
+		var req EntryRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		if req.Entry == "" {
+			writeError(w, http.StatusBadRequest, "entry is required")
+			return
+		}
+		writeResult(w, set.AddWithOptions(req.Entry, ipset.AddOptions{
+			Timeout: req.Timeout,
+			Comment: req.Comment,
+			Packets: req.Packets,
+			Bytes:   req.Bytes,
+			Nomatch: req.Nomatch,
+		}))
+	case http.MethodPut:
+		if !managed {
+			writeError(w, http.StatusConflict, "set "+name+" was not created through this handler")
+			return
+		}
+		var req EntriesBody
+		if !decode(w, r, &req) {
+			return
+		}
+		writeResult(w, set.Refresh(req.Entries))
+	case http.MethodDelete:
+		writeResult(w, set.Flush())
+	default:
+		methodNotAllowed(w, "GET, POST, PUT, DELETE")
+	}
+}
+
+func (h *Handler) serveEntry(w http.ResponseWriter, r *http.Request, name, entry string) {
+	set, _ := h.lookup(name)
+	perm := auth.Mutate
+	if r.Method == http.MethodGet {
+		perm = auth.Read
+	}
+	if !h.authorize(w, r, perm, name) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		found, err := set.Test(entry)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, TestResponse{Found: found})
+	case http.MethodDelete:
+		writeResult(w, set.Del(entry))
+	default:
+		methodNotAllowed(w, "GET, DELETE")
+	}
+}
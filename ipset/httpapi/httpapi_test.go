@@ -0,0 +1,152 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"github.com/intuitivelabs/go-ipset/ipset/auth"
+)
+
+// serve runs the request through h and returns the recorded response.
+func serve(h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerRequests(t *testing.T) {
+	h := NewHandler()
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+		allow              string
+	}{
+		{"GET", "/", "", http.StatusNotFound, ""},
+		{"GET", "/setsx", "", http.StatusNotFound, ""},
+		{"GET", "/sets/blocked/other", "", http.StatusNotFound, ""},
+		{"GET", "/sets", "", http.StatusMethodNotAllowed, "POST"},
+		{"POST", "/sets/blocked", "", http.StatusMethodNotAllowed, "GET, DELETE"},
+		{"GET", "/sets/blocked/swap", "", http.StatusMethodNotAllowed, "POST"},
+		{"PATCH", "/sets/blocked/entries", "", http.StatusMethodNotAllowed, "GET, POST, PUT, DELETE"},
+		{"PUT", "/sets/blocked/entries/10.0.0.0/8", "", http.StatusMethodNotAllowed, "GET, DELETE"},
+		{"POST", "/sets", "{", http.StatusBadRequest, ""},
+		{"POST", "/sets", `{"name": "blocked"}`, http.StatusBadRequest, ""},
+		{"POST", "/sets/blocked/swap", "not json", http.StatusBadRequest, ""},
+		{"POST", "/sets/blocked/entries", `{"timeout": 60}`, http.StatusBadRequest, ""},
+		{"PUT", "/sets/blocked/entries", `{"entries": ["192.0.2.1"]}`, http.StatusConflict, ""},
+	} {
+		w := serve(h, tc.method, tc.path, tc.body)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, w.Code, tc.code)
+		}
+		if allow := w.Header().Get("Allow"); allow != tc.allow {
+			t.Errorf("%s %s: allowed %q, want %q", tc.method, tc.path, allow, tc.allow)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Error == "" {
+			t.Errorf("%s %s: error body %+v, %v", tc.method, tc.path, resp, err)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: content type %q", tc.method, tc.path, ct)
+		}
+	}
+}
+
+func TestHandlerPolicy(t *testing.T) {
+	h := NewHandler(WithPolicy(&auth.Policy{
+		Tokens: map[string]string{"feed-token": "feed"},
+		Rules:  []auth.Rule{{Principal: "feed", Sets: "blocklist-*", Allow: auth.Mutate}},
+	}))
+	w := serve(h, "GET", "/sets/blocklist-a/entries", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("request without token: status %d, challenge %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := serve(h, "GET", "/sets/blocklist-a/entries", "", "Authorization", "Bearer wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("request with unknown token: status %d, want 401", w.Code)
+	}
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		// authorized requests fail validation only after the policy check
+		{"POST", "/sets/blocklist-a/entries", `{}`, http.StatusBadRequest},
+		{"PUT", "/sets/blocklist-a/entries", `{"entries": []}`, http.StatusConflict},
+		{"POST", "/sets/allowlist/entries", `{}`, http.StatusForbidden},
+		{"DELETE", "/sets/blocklist-a", "", http.StatusForbidden},
+		{"POST", "/sets/blocklist-a/swap", `{"with": "allowlist"}`, http.StatusForbidden},
+		{"POST", "/sets", `{"name": "allowlist", "type": "hash:ip"}`, http.StatusForbidden},
+	} {
+		if w := serve(h, tc.method, tc.path, tc.body, "Authorization", "Bearer feed-token"); w.Code != tc.code {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("add: %w", ipset.ErrInvalidEntry), http.StatusBadRequest},
+		{fmt.Errorf("list: %w", ipset.ErrSetNotFound), http.StatusNotFound},
+		{fmt.Errorf("del: %w", ipset.ErrNotOwned), http.StatusForbidden},
+		{auth.ErrWrongTenant, http.StatusForbidden},
+		{fmt.Errorf("destroy: %w", ipset.ErrPolicyDenied), http.StatusConflict},
+		{fmt.Errorf("add: %w", ipset.ErrQuotaExceeded), http.StatusInsufficientStorage},
+		{errors.New("exit status 1"), http.StatusInternalServerError},
+	} {
+		if code := statusOf(tc.err); code != tc.code {
+			t.Errorf("statusOf(%v) = %d, want %d", tc.err, code, tc.code)
+		}
+		w := httptest.NewRecorder()
+		writeResult(w, tc.err)
+		if w.Code != tc.code {
+			t.Errorf("writeResult(%v): status %d, want %d", tc.err, w.Code, tc.code)
+		}
+	}
+}
+
+func TestHandlerHandle(t *testing.T) {
+	var r ipset.NoopRecorder
+	h := NewHandler(WithHandle(ipset.NewHandle(ipset.WithNoopBackend(&r), ipset.WithDestructivePolicy(func(_ *ipset.Handle, o ipset.Operation) error {
+		if o.Op == ipset.OpFlush {
+			return errors.New("flushes are forbidden")
+		}
+		return nil
+	}))))
+	if w := serve(h, "POST", "/sets/blocked/entries", `{"entry": "192.0.2.1"}`); w.Code != http.StatusNoContent {
+		t.Errorf("add: status %d, want 204", w.Code)
+	}
+	if cmds := r.Commands(); len(cmds) != 1 || cmds[0].Args[0] != "add" {
+		t.Errorf("add ran %v through the handle", cmds)
+	}
+	if w := serve(h, "DELETE", "/sets/blocked/entries", ""); w.Code != http.StatusConflict {
+		t.Errorf("flush denied by the handle policy: status %d, want 409", w.Code)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// icmpName is a named ICMP type and code, as known by ipset.
+type icmpName struct {
+	name      string
+	typ, code uint8
+}
+
+// icmpNames and icmpv6Names list the ICMP names ipset accepts, the first name
+// of a type and code being the one ipset lists it with.
+var icmpNames = []icmpName{
+	{"echo-reply", 0, 0},
+	{"pong", 0, 0},
+	{"network-unreachable", 3, 0},
+	{"host-unreachable", 3, 1},
+	{"protocol-unreachable", 3, 2},
+	{"port-unreachable", 3, 3},
+	{"fragmentation-needed", 3, 4},
+	{"source-route-failed", 3, 5},
+	{"network-unknown", 3, 6},
+	{"host-unknown", 3, 7},
+	{"network-prohibited", 3, 9},
+	{"host-prohibited", 3, 10},
+	{"TOS-network-unreachable", 3, 11},
+	{"TOS-host-unreachable", 3, 12},
+	{"communication-prohibited", 3, 13},
+	{"host-precedence-violation", 3, 14},
+	{"precedence-cutoff", 3, 15},
+	{"source-quench", 4, 0},
+	{"network-redirect", 5, 0},
+	{"host-redirect", 5, 1},
+	{"TOS-network-redirect", 5, 2},
+	{"TOS-host-redirect", 5, 3},
+	{"echo-request", 8, 0},
+	{"ping", 8, 0},
+	{"router-advertisement", 9, 0},
+	{"router-solicitation", 10, 0},
+	{"ttl-zero-during-transit", 11, 0},
+	{"ttl-zero-during-reassembly", 11, 1},
+	{"ip-header-bad", 12, 0},
+	{"required-option-missing", 12, 1},
+	{"timestamp-request", 13, 0},
+	{"timestamp-reply", 14, 0},
+	{"address-mask-request", 17, 0},
+	{"address-mask-reply", 18, 0},
+}
+
+var icmpv6Names = []icmpName{
+	{"no-route", 1, 0},
+	{"communication-prohibited", 1, 1},
+	{"address-unreachable", 1, 3},
+	{"port-unreachable", 1, 4},
+	{"packet-too-big", 2, 0},
+	{"ttl-zero-during-transit", 3, 0},
+	{"ttl-zero-during-reassembly", 3, 1},
+	{"bad-header", 4, 0},
+	{"unknown-header-type", 4, 1},
+	{"unknown-option", 4, 2},
+	{"echo-request", 128, 0},
+	{"ping", 128, 0},
+	{"echo-reply", 129, 0},
+	{"pong", 129, 0},
+}
+
+func icmpTable(proto Proto) []icmpName {
+	if proto == ProtoICMPv6 {
+		return icmpv6Names
+	}
+	return icmpNames
+}
+
+// parseICMP parses the ICMP part of an icmp or icmpv6 port dimension, a name
+// or "type/code".
+func parseICMP(proto Proto, spec string) (Port, error) {
+	for _, n := range icmpTable(proto) {
+		if strings.EqualFold(n.name, spec) {
+			return ICMPPort(proto, n.typ, n.code), nil
+		}
+	}
+	tc := strings.SplitN(spec, "/", 2)
+	if len(tc) != 2 {
+		return Port{}, fmt.Errorf("invalid port %s:%s: want an ICMP name or type/code", proto, spec)
+	}
+	typ, err := strconv.ParseUint(tc[0], 10, 8)
+	if err != nil {
+		return Port{}, fmt.Errorf("invalid port %s:%s: %w", proto, spec, err)
+	}
+	code, err := strconv.ParseUint(tc[1], 10, 8)
+	if err != nil {
+		return Port{}, fmt.Errorf("invalid port %s:%s: %w", proto, spec, err)
+	}
+	return ICMPPort(proto, uint8(typ), uint8(code)), nil
+}
+
+// icmpString returns the name of an ICMP type and code, or "type/code".
+func icmpString(proto Proto, typ, code uint8) string {
+	for _, n := range icmpTable(proto) {
+		if n.typ == typ && n.code == code {
+			return n.name
+		}
+	}
+	return strconv.Itoa(int(typ)) + "/" + strconv.Itoa(int(code))
+}
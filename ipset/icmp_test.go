@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "testing"
+
+func TestParseICMP(t *testing.T) {
+	for _, tc := range []struct {
+		in        string
+		typ, code uint8
+		str       string
+	}{
+		{"icmp:echo-request", 8, 0, "icmp:echo-request"},
+		{"icmp:ping", 8, 0, "icmp:echo-request"},
+		{"icmp:Host-Unreachable", 3, 1, "icmp:host-unreachable"},
+		{"icmp:3/8", 3, 8, "icmp:3/8"},
+		{"icmpv6:1/4", 1, 4, "icmpv6:port-unreachable"},
+		{"icmpv6:pong", 129, 0, "icmpv6:echo-reply"},
+	} {
+		p, err := ParsePort(tc.in)
+		if err != nil {
+			t.Errorf("ParsePort(%q): %v", tc.in, err)
+			continue
+		}
+		if p.ICMPType() != tc.typ || p.ICMPCode() != tc.code {
+			t.Errorf("ParsePort(%q) = type %d code %d, want type %d code %d", tc.in, p.ICMPType(), p.ICMPCode(), tc.typ, tc.code)
+		}
+		if s := p.String(); s != tc.str {
+			t.Errorf("ICMP port %q formatted as %q, want %q", tc.in, s, tc.str)
+		}
+	}
+	for _, in := range []string{"icmp:no-such-type", "icmp:3", "icmp:256/0", "icmp:3/x", "icmpv6:host-redirect"} {
+		if p, err := ParsePort(in); err == nil {
+			t.Errorf("ParsePort(%q) = %+v, want an error", in, p)
+		}
+	}
+}
+
+func TestICMPPort(t *testing.T) {
+	p := ICMPPort(ProtoICMP, 3, 4)
+	if p.Port != 3<<8|4 || p.ICMPType() != 3 || p.ICMPCode() != 4 {
+		t.Errorf("ICMPPort(icmp, 3, 4) = %+v", p)
+	}
+	if !p.Proto.IsICMP() || !ProtoICMPv6.IsICMP() || ProtoUDP.IsICMP() {
+		t.Error("IsICMP does not match icmp and icmpv6 only")
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logger holds the logging functions of the ipset package: those of
+// logrus, or of the standard log package in builds with the ipset_noexec
+// tag, which leave logrus out.
+package logger
+
+import "github.com/sirupsen/logrus"
+
+// Debugf, Infof, Warnf and Errorf log at the level of their name.
+var (
+	Debugf = logrus.Debugf
+	Infof  = logrus.Infof
+	Warnf  = logrus.Warnf
+	Errorf = logrus.Errorf
+)
@@ -0,0 +1,40 @@
+//go:build ipset_noexec
+// +build ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logger
+
+import "log"
+
+// Debugf discards the message: the standard logger has no levels.
+func Debugf(format string, args ...interface{}) {}
+
+// Infof logs the message.
+func Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// Warnf logs the message as a warning.
+func Warnf(format string, args ...interface{}) {
+	log.Printf("warning: "+format, args...)
+}
+
+// Errorf logs the message as an error.
+func Errorf(format string, args ...interface{}) {
+	log.Printf("error: "+format, args...)
+}
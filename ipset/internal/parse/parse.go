@@ -0,0 +1,118 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package parse holds the parsers of the output of the ipset utility: list
+// and save lines, version strings and error messages. Patterns are compiled
+// once, when the package is loaded.
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MembersHeader is the line of `ipset list` after which the members follow.
+const MembersHeader = "Members:"
+
+var versionPattern = regexp.MustCompile(`v[0-9]+\.[0-9]+`)
+
+// Version returns the "vX.Y" version found in the `ipset --version` output.
+func Version(out []byte) (string, error) {
+	match := versionPattern.Find(out)
+	if match == nil {
+		return "", fmt.Errorf("no ipset version found in string: %s", out)
+	}
+	return string(match), nil
+}
+
+// Fields splits a list or save line at blanks, keeping double-quoted
+// strings such as comments in one field, quotes included.
+func Fields(line string) []string {
+	var fields []string
+	var cur strings.Builder
+	inQuote, inField := false, false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			inField = true
+			cur.WriteRune(c)
+		case !inQuote && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			inField = true
+			cur.WriteRune(c)
+		}
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// KeyValue splits a "Key: value" line of the terse listing at the first
+// colon, so that values holding colons such as "hash:ip" stay whole.
+func KeyValue(line string) (key, val string, ok bool) {
+	kv := strings.SplitN(line, ":", 2)
+	if len(kv) != 2 {
+		return "", "", false
+	}
+	return strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]), true
+}
+
+// IsMembersHeader reports whether the list line starts the members.
+func IsMembersHeader(line string) bool {
+	return strings.HasPrefix(line, MembersHeader)
+}
+
+// NotInSet reports whether `ipset test` output tells that the entry is missing.
+func NotInSet(out []byte) bool {
+	return strings.Contains(string(out), "is NOT in set")
+}
+
+// NoSuchSet reports whether the output tells that the set does not exist.
+func NoSuchSet(out []byte) bool {
+	return strings.Contains(string(out), "does not exist")
+}
+
+// SetExists reports whether create output tells that the name is taken.
+func SetExists(out []byte) bool {
+	return strings.Contains(string(out), "already exists")
+}
+
+// TypeUnsupported reports whether create output tells that the kernel lacks
+// the set type or the revision needed for the requested options.
+func TypeUnsupported(out []byte) bool {
+	return strings.Contains(string(out), "set type not supported")
+}
+
+var errorLinePattern = regexp.MustCompile(`Error in line ([0-9]+):`)
+
+// ErrorLine returns the number of the input line `ipset restore` failed at.
+func ErrorLine(out []byte) (int, bool) {
+	m := errorLinePattern.FindSubmatch(out)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	return n, err == nil
+}
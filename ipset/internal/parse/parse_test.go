@@ -0,0 +1,265 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parse
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The captures in testdata/<version> are the output of that ipset release
+// for the same two sets: a hash:net blocklist, with counters and comments
+// where supported, and a hash:ip,port set.
+
+type member struct {
+	element string
+	comment string
+}
+
+type set struct {
+	name     string
+	typ      string
+	revision string
+	header   string
+	members  []member
+}
+
+var (
+	web6 = set{"web", "hash:ip,port", "2", "family inet hashsize 1024 maxelem 65536",
+		[]member{{"192.0.2.10,tcp:80", ""}, {"192.0.2.10,tcp:443", ""}}}
+	web7 = set{"web", "hash:ip,port", "5", "family inet hashsize 1024 maxelem 65536",
+		[]member{{"192.0.2.10,tcp:80", ""}, {"192.0.2.10,tcp:443", ""}}}
+	commented = []member{
+		{"10.0.0.0/8", `"rfc1918 block"`},
+		{"192.0.2.1", `"doc, test"`},
+		{"198.51.100.0/24", `"exception"`},
+	}
+)
+
+var captures = []struct {
+	version string
+	sets    []set
+	// errorLine is the line restore-error.txt reports
+	errorLine int
+	noSuchSet bool
+	// typeUnsupported tells whether restore-error.txt reports a missing type
+	typeUnsupported bool
+}{
+	{
+		version: "v6.11",
+		sets: []set{
+			{"blocklist", "hash:net", "3", "family inet hashsize 1024 maxelem 65536 timeout 600",
+				[]member{{"10.0.0.0/8", ""}, {"192.0.2.1", ""}}},
+			web6,
+		},
+		errorLine: 2,
+	},
+	{
+		version: "v6.34",
+		sets: []set{
+			{"blocklist", "hash:net", "6", "family inet hashsize 1024 maxelem 65536 timeout 600 counters comment",
+				[]member{{"10.0.0.0/8", `"rfc1918 block"`}, {"192.0.2.1", `"doc"`}}},
+			web7,
+		},
+		errorLine: 3,
+		noSuchSet: true,
+	},
+	{
+		version: "v7.1",
+		sets: []set{
+			{"blocklist", "hash:net", "6", "family inet hashsize 1024 maxelem 65536 timeout 600 counters comment", commented},
+			web7,
+		},
+		errorLine: 4,
+	},
+	{
+		version: "v7.15",
+		sets: []set{
+			{"blocklist", "hash:net", "7",
+				"family inet hashsize 1024 maxelem 65536 timeout 600 counters comment bucketsize 12 initval 0x4f3c2a1b", commented},
+			{"web", "hash:ip,port", "6", "family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x0e9a71c5", web7.members},
+		},
+		errorLine:       1,
+		typeUnsupported: true,
+	},
+}
+
+func readCapture(t *testing.T, version, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", version, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// memberOf returns the element and comment of a member line, or of the
+// fields of an add line following the set name.
+func memberOf(fields []string) member {
+	m := member{element: fields[0]}
+	for i := 1; i+1 < len(fields); i++ {
+		if fields[i] == "comment" {
+			m.comment = fields[i+1]
+		}
+	}
+	return m
+}
+
+// scanList parses `ipset list` output, terse or not, with KeyValue and
+// IsMembersHeader as the package does. entries holds the "Number of
+// entries" of each set, -1 when the release does not print it.
+func scanList(t *testing.T, out []byte) (sets []set, entries []string) {
+	t.Helper()
+	var cur *set
+	inMembers := false
+	for _, l := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		switch {
+		case l == "":
+			cur, inMembers = nil, false
+		case inMembers:
+			cur.members = append(cur.members, memberOf(Fields(l)))
+		case IsMembersHeader(l):
+			inMembers = true
+		default:
+			key, val, ok := KeyValue(l)
+			if !ok {
+				t.Fatalf("line %q is not a key and value", l)
+			}
+			if cur == nil {
+				sets = append(sets, set{})
+				entries = append(entries, "")
+				cur = &sets[len(sets)-1]
+			}
+			switch key {
+			case "Name":
+				cur.name = val
+			case "Type":
+				cur.typ = val
+			case "Revision":
+				cur.revision = val
+			case "Header":
+				cur.header = val
+			case "Number of entries":
+				entries[len(entries)-1] = val
+			}
+		}
+	}
+	return sets, entries
+}
+
+func TestVersion(t *testing.T) {
+	for _, c := range captures {
+		v, err := Version(readCapture(t, c.version, "version.txt"))
+		if err != nil || v != c.version {
+			t.Errorf("%s: Version() = %q, %v", c.version, v, err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	for _, c := range captures {
+		sets, entries := scanList(t, readCapture(t, c.version, "list.txt"))
+		if !reflect.DeepEqual(sets, c.sets) {
+			t.Errorf("%s: list parsed as\n%+v\nwant\n%+v", c.version, sets, c.sets)
+		}
+		for i, n := range entries {
+			if n != "" && i < len(c.sets) && n != strconv.Itoa(len(c.sets[i].members)) {
+				t.Errorf("%s: set %s has %s entries, %d members listed", c.version, c.sets[i].name, n, len(c.sets[i].members))
+			}
+		}
+	}
+}
+
+func TestListTerse(t *testing.T) {
+	for _, c := range captures {
+		sets, _ := scanList(t, readCapture(t, c.version, "list-t.txt"))
+		want := make([]set, len(c.sets))
+		for i, s := range c.sets {
+			want[i] = s
+			want[i].members = nil
+		}
+		if !reflect.DeepEqual(sets, want) {
+			t.Errorf("%s: terse list parsed as\n%+v\nwant\n%+v", c.version, sets, want)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	for _, c := range captures {
+		var sets []set
+		for _, l := range strings.Split(strings.TrimRight(string(readCapture(t, c.version, "save.txt")), "\n"), "\n") {
+			fields := Fields(l)
+			switch {
+			case len(fields) >= 3 && fields[0] == "create":
+				sets = append(sets, set{name: fields[1], typ: fields[2], header: strings.Join(fields[3:], " ")})
+			case len(fields) >= 3 && fields[0] == "add" && len(sets) != 0 && fields[1] == sets[len(sets)-1].name:
+				s := &sets[len(sets)-1]
+				s.members = append(s.members, memberOf(fields[2:]))
+			default:
+				t.Fatalf("%s: unexpected save line %q", c.version, l)
+			}
+		}
+		want := make([]set, len(c.sets))
+		for i, s := range c.sets {
+			want[i] = s
+			// save does not print the revision
+			want[i].revision = ""
+		}
+		if !reflect.DeepEqual(sets, want) {
+			t.Errorf("%s: save parsed as\n%+v\nwant\n%+v", c.version, sets, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range captures {
+		out := readCapture(t, c.version, "restore-error.txt")
+		if n, ok := ErrorLine(out); !ok || n != c.errorLine {
+			t.Errorf("%s: ErrorLine() = %d, %v, want %d", c.version, n, ok, c.errorLine)
+		}
+		if got := NoSuchSet(out); got != c.noSuchSet {
+			t.Errorf("%s: NoSuchSet() = %v", c.version, got)
+		}
+		if got := TypeUnsupported(out); got != c.typeUnsupported {
+			t.Errorf("%s: TypeUnsupported() = %v", c.version, got)
+		}
+		if !NotInSet(readCapture(t, c.version, "test-missing.txt")) {
+			t.Errorf("%s: NotInSet() = false for a missing entry", c.version)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"add s 10.0.0.1", []string{"add", "s", "10.0.0.1"}},
+		{"  10.0.0.1\ttimeout  5 ", []string{"10.0.0.1", "timeout", "5"}},
+		{`10.0.0.1 comment "two words" nomatch`, []string{"10.0.0.1", "comment", `"two words"`, "nomatch"}},
+		{`10.0.0.1 comment ""`, []string{"10.0.0.1", "comment", `""`}},
+	} {
+		if got := Fields(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Fields(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -20,14 +20,15 @@ package ipset
 import (
 	"errors"
 	"fmt"
-	"os/exec"
+	"path"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/coreos/go-semver/semver"
-	log "github.com/sirupsen/logrus"
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
 )
 
 const (
@@ -38,9 +39,12 @@ const (
 var (
 	ipsetPath            string
 	errIpsetNotFound     = errors.New("Ipset utility not found")
-	errIpsetNotSupported = errors.New("Ipset utility version is not supported, requiring version >= 6.0")
+	errIpsetNotSupported = errors.New("Ipset utility version is not supported")
 )
 
+// ErrUnsupportedPlatform is returned by every operation on platforms without ipset.
+var ErrUnsupportedPlatform = errors.New("ipset is only supported on linux")
+
 // Stats defines the type and metrics of the sets
 type Stats struct {
 	Type    string `ipset:"Type"`
@@ -55,9 +59,30 @@ type Params struct {
 	HashSize   int
 	MaxElem    int
 	Timeout    int
+	// Counters enables per-entry packet and byte counters.
+	Counters bool
+	// Comment enables per-entry comments, see EntryOptions.Comment.
+	Comment bool
+	// ForceAdd makes the kernel evict a random entry when adding to a full
+	// hash set instead of failing the add.
+	ForceAdd bool
+	// Range is the range of elements of a bitmap set, "ip1-ip2" or an IPv4
+	// network for bitmap:ip and bitmap:ip,mac, "port1-port2" for bitmap:port.
+	Range string
+	// Recreate replaces an existing set whose header does not match the
+	// parameters with an empty one instead of failing with ErrHeaderMismatch.
+	Recreate bool
+	// Exclusive fails the creation with ErrSetExists if a set of the same
+	// name exists instead of adopting it.
+	Exclusive bool
+	// Metadata is recorded for the set by the registry of the handle, if
+	// any, see WithRegistry.
+	Metadata *SetMetadata
 }
 
 // IPSet implements an Interface to an set.
+// The mutating methods of sets sharing a name and a handle are serialized, so
+// that e.g. a Refresh racing an Add cannot interleave with it.
 type IPSet struct {
 	Name       string
 	HashType   string
@@ -65,6 +90,13 @@ type IPSet struct {
 	HashSize   int
 	MaxElem    int
 	Timeout    int
+	Counters   bool
+	Comment    bool
+	ForceAdd   bool
+	Range      string
+
+	// h runs the commands of the set, nil for the default handle
+	h *Handle
 }
 
 func initCheck(name ...string) error {
@@ -77,44 +109,87 @@ func initCheck(name ...string) error {
 			checkname = name[0]
 		}
 
-		path, err := exec.LookPath(checkname)
+		path, err := lookIpset(checkname)
 		if err != nil {
-			return errIpsetNotFound
+			return err
 		}
 		ipsetPath = path
-		supportedVersion, err := getIpsetSupportedVersion()
-		if err != nil {
-			log.Warnf("Error checking ipset version, assuming version at least 6.0.0: %v", err)
-			supportedVersion = true
+		if err := detectCapabilities(); err != nil {
+			log.Warnf("Error detecting ipset capabilities, assuming a regular ipset: %v", err)
 		}
-		if supportedVersion {
-			return nil
-		}
-		return errIpsetNotSupported
+		versionErr = checkVersion()
 	}
-	return nil
+	return versionErr
 }
 
 func (s *IPSet) createHashSet(name string) error {
+	return s.createSet(name, true)
+}
+
+// createSet creates the named set with the parameters of s. Without exist, an
+// existing set is not adopted but an ErrSetExists.
+func (s *IPSet) createSet(name string, exist bool) error {
 	/*	out, err := exec.Command("/usr/bin/sudo",
 		ipsetPath, "create", name, s.HashType, "family", s.HashFamily, "hashsize", strconv.Itoa(s.HashSize),
 		"maxelem", strconv.Itoa(s.MaxElem), "timeout", strconv.Itoa(s.Timeout), "-exist").CombinedOutput()*/
-	out, err := exec.Command(ipsetPath, "create", name, s.HashType, "family", s.HashFamily, "hashsize", strconv.Itoa(s.HashSize),
-		"maxelem", strconv.Itoa(s.MaxElem), "timeout", strconv.Itoa(s.Timeout), "-exist").CombinedOutput()
+	args := s.createArgs(name)
+	if exist {
+		args = append(args, "-exist")
+	}
+	out, err := s.handle().run(args...)
+	s.handle().changed(change{op: opCreate, set: name, err: err})
 	if err != nil {
-		return fmt.Errorf("error creating ipset %s with type %s: %v (%s)", name, s.HashType, err, out)
+		if !exist && parse.SetExists(out) {
+			return fmt.Errorf("%w: %s", ErrSetExists, name)
+		}
+		if terr := typeUnsupportedError(s.HashType, out); terr != nil {
+			return fmt.Errorf("error creating ipset %s: %w", name, terr)
+		}
+		return fmt.Errorf("error creating ipset %s with type %s: %w (%s)", name, s.HashType, err, out)
 	}
 	/* do NOT flush existing ipset
 	out, err = exec.Command(ipsetPath, "flush", name).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("error flushing ipset %s: %v (%s)", name, err, out)
+		return fmt.Errorf("error flushing ipset %s: %w (%s)", name, err, out)
 	}
 	*/
 	return nil
 }
 
+// createArgs returns the arguments of the create command of the named set
+// with the parameters of s.
+func (s *IPSet) createArgs(name string) []string {
+	args := []string{"create", name, s.HashType, "family", s.HashFamily, "hashsize", strconv.Itoa(s.HashSize),
+		"maxelem", strconv.Itoa(s.MaxElem), "timeout", strconv.Itoa(s.Timeout)}
+	if isBitmapType(s.HashType) {
+		args = []string{"create", name, s.HashType, "range", s.Range, "timeout", strconv.Itoa(s.Timeout)}
+	}
+	if s.HashType == "list:set" {
+		args = []string{"create", name, s.HashType}
+		if s.Timeout > 0 {
+			args = append(args, "timeout", strconv.Itoa(s.Timeout))
+		}
+	}
+	if s.Counters {
+		args = append(args, "counters")
+	}
+	if s.Comment {
+		args = append(args, "comment")
+	}
+	if s.ForceAdd {
+		args = append(args, "forceadd")
+	}
+	return args
+}
+
 // Init sets up the package with the named ipset or default
 func Init(name string) error {
+	return defaultHandle.Init(name)
+}
+
+// Init sets up the handle with the named ipset or default. The utility and
+// its capabilities are still detected once and shared by all handles.
+func (h *Handle) Init(name string) error {
 	return initCheck(name)
 }
 
@@ -122,6 +197,33 @@ func Init(name string) error {
 // Example:
 // 	testIpset := ipset.New("test", "hash:ip", &ipset.Params{})
 func New(name string, hashtype string, p *Params) (*IPSet, error) {
+	return defaultHandle.New(name, hashtype, p)
+}
+
+// New creates a new set whose commands are run by the handle.
+func (h *Handle) New(name string, hashtype string, p *Params) (*IPSet, error) {
+	s, _, err := h.Create(name, hashtype, p)
+	return s, err
+}
+
+// Set returns the existing set of the given name, its commands run by the
+// handle, without checking that it exists. Its type and parameters are
+// unknown: get sets to Refresh with New, as Refresh creates a temporary set
+// like them.
+func (h *Handle) Set(name string) *IPSet {
+	return &IPSet{Name: name, h: h}
+}
+
+// Create creates a new set like New and reports whether it was created, false
+// meaning that an existing set was adopted. A set replaced because of Recreate
+// counts as created.
+func Create(name string, hashtype string, p *Params) (*IPSet, bool, error) {
+	return defaultHandle.Create(name, hashtype, p)
+}
+
+// Create creates a new set whose commands are run by the handle and reports
+// whether it was created.
+func (h *Handle) Create(name string, hashtype string, p *Params) (*IPSet, bool, error) {
 	// Using the ipset utilities default values here
 	if p.HashSize == 0 {
 		p.HashSize = 1024
@@ -135,42 +237,111 @@ func New(name string, hashtype string, p *Params) (*IPSet, error) {
 		p.HashFamily = "inet"
 	}
 
-	// Check if hashtype is a type of hash
-	if !strings.HasPrefix(hashtype, "hash:") {
-		return nil, fmt.Errorf("not a hash type: %s", hashtype)
+	// Check if hashtype is a type of hash or bitmap
+	switch {
+	case strings.HasPrefix(hashtype, "hash:"):
+	case isBitmapType(hashtype):
+		if err := validateBitmapRange(hashtype, p.Range); err != nil {
+			return nil, false, err
+		}
+		if p.ForceAdd {
+			return nil, false, fmt.Errorf("forceadd is not supported by sets of type %s", hashtype)
+		}
+	default:
+		return nil, false, fmt.Errorf("not a hash or bitmap type: %s", hashtype)
 	}
 
-	if err := initCheck(); err != nil {
-		return nil, err
+	if err := h.initCheck(); err != nil {
+		return nil, false, err
+	}
+	if err := h.checkType(hashtype, p); err != nil {
+		return nil, false, err
 	}
 
-	s := IPSet{name, hashtype, p.HashFamily, p.HashSize, p.MaxElem, p.Timeout}
-	err := s.createHashSet(name)
+	s := IPSet{
+		Name:       name,
+		HashType:   hashtype,
+		HashFamily: p.HashFamily,
+		HashSize:   p.HashSize,
+		MaxElem:    p.MaxElem,
+		Timeout:    p.Timeout,
+		Counters:   p.Counters,
+		Comment:    p.Comment,
+		ForceAdd:   p.ForceAdd,
+		Range:      p.Range,
+		h:          h,
+	}
+	if p.Exclusive {
+		if err := s.createSet(name, false); err != nil {
+			return nil, false, err
+		}
+		h.track(name)
+		if err := h.putMetadata(name, p.Metadata, true); err != nil {
+			return nil, false, err
+		}
+		return &s, true, nil
+	}
+	adopted, err := s.adopt(p.Recreate)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if err := s.createHashSet(name); err != nil {
+		return nil, false, err
+	}
+	if !adopted {
+		h.track(name)
 	}
-	return &s, nil
+	if err := h.putMetadata(name, p.Metadata, !adopted); err != nil {
+		return nil, false, err
+	}
+	return &s, !adopted, nil
 }
 
 // Refresh is used to to overwrite the set with the specified entries.
 // The ipset is updated on the fly by hot swapping it with a temporary set.
-func (s *IPSet) Refresh(entries []string) error {
-	tempName := s.Name + "-temp"
-	err := s.createHashSet(tempName)
+// The temporary set is filled in a single `ipset restore`; should an entry be
+// rejected, the entries are added one by one and the rejected ones skipped.
+func (s *IPSet) Refresh(entries []string) (err error) {
+	defer s.handle().lockSet(s.Name)()
+	defer func(start time.Time) { s.handle().recordRefresh(s.Name, len(entries), start, err) }(time.Now())
+	if err := validateElements(entries); err != nil {
+		return err
+	}
+	unlock, err := s.handle().lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	defer s.handle().undoable("refresh", s.Name, &err)()
+	if err := s.handle().checkQuota(s.Name, len(entries), true); err != nil {
+		return err
+	}
+	tempName := tempSetName(s.Name, "-temp")
+	if err := s.handle().checkPolicy(Operation{Op: OpSwap, Set: tempName, Other: s.Name}); err != nil {
+		return err
+	}
+	err = s.createHashSet(tempName)
+	if err != nil {
+		return err
+	}
+	b := s.handle().NewBatch()
 	for _, entry := range entries {
-		out, err := exec.Command(ipsetPath, "add", tempName, entry, "-exist").CombinedOutput()
-		if err != nil {
-			log.Errorf("error adding entry %s to set %s: %v (%s)", entry, tempName, err, out)
+		b.Add(tempName, Entry{Element: entry})
+	}
+	if err := b.Commit(); err != nil {
+		log.Warnf("Error filling set %s in one batch, adding entries one by one: %v", tempName, err)
+		for _, entry := range entries {
+			out, err := s.handle().run("add", tempName, entry, "-exist")
+			if err != nil {
+				log.Errorf("error adding entry %s to set %s: %v (%s)", entry, tempName, err, out)
+			}
 		}
 	}
-	err = Swap(tempName, s.Name)
+	err = s.handle().swap(tempName, s.Name)
 	if err != nil {
 		return err
 	}
-	err = destroyIPSet(tempName)
+	err = s.handle().destroyIPSet(tempName)
 	if err != nil {
 		return err
 	}
@@ -178,68 +349,250 @@ func (s *IPSet) Refresh(entries []string) error {
 }
 
 // Test is used to check whether the specified entry is in the set or not.
+// A missing entry is reported as false with a nil error, even though ipset
+// exits with an error status for it; the error is only set when the test
+// could not be made, e.g. because the set does not exist.
 func (s *IPSet) Test(entry string) (bool, error) {
-	out, err := exec.Command(ipsetPath, "test", s.Name, entry).CombinedOutput()
-	if err == nil {
-		reg, e := regexp.Compile("NOT")
-		if e == nil && reg.MatchString(string(out)) {
-			return false, nil
-		} else if e == nil {
-			return true, nil
-		} else {
-			return false, fmt.Errorf("error testing entry %s: %v", entry, e)
-		}
-	} else {
-		return false, fmt.Errorf("error testing entry %s: %v (%s)", entry, err, out)
-	}
+	cache := s.handle().testCache
+	if found, ok := cache.get(s.Name, entry); ok {
+		return found, nil
+	}
+	gen := cache.gen(s.Name)
+	out, err := s.handle().run("test", s.Name, entry)
+	// ipset exits with status 1 when the entry is missing
+	if err != nil && !parse.NotInSet(out) {
+		return false, fmt.Errorf("error testing entry %s: %w (%s)", entry, err, out)
+	}
+	found := !parse.NotInSet(out)
+	cache.put(s.Name, entry, found, gen)
+	return found, nil
 }
 
 // Add is used to add the specified entry to the set.
 // A timeout of 0 means that the entry will be stored permanently in the set.
+// Each call runs an ipset process; use AddAll to add many entries.
 func (s *IPSet) Add(entry string, timeout int) error {
-	out, err := exec.Command(ipsetPath, "add", s.Name, entry, "timeout", strconv.Itoa(timeout), "-exist").CombinedOutput()
+	defer s.handle().lockSet(s.Name)()
+	if err := ValidateElement(entry); err != nil {
+		return err
+	}
+	if err := s.handle().checkQuotaAdd(s.Name, []string{entry}); err != nil {
+		return err
+	}
+	prior := s.handle().undoPrior(s.Name, []string{entry})
+	out, err := s.handle().run("add", s.Name, entry, "timeout", strconv.Itoa(timeout), "-exist")
+	s.handle().changed(change{op: opAdd, set: s.Name, entries: []string{entry}, err: err})
 	if err != nil {
-		return fmt.Errorf("error adding entry %s: %v (%s)", entry, err, out)
+		return fmt.Errorf("error adding entry %s: %w (%s)", entry, err, out)
+	}
+	s.handle().journalAdds(s.Name, prior, entry)
+	return nil
+}
+
+// AddAll adds the entries to the set with a timeout, as Add does, in a single
+// `ipset restore` rather than an ipset process per entry, see Batch. Nothing
+// is added if an entry is invalid.
+func (s *IPSet) AddAll(entries []string, timeout int) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	defer s.handle().lockSet(s.Name)()
+	b := s.handle().NewBatch()
+	for _, entry := range entries {
+		b.AddTimeout(s.Name, entry, timeout)
+	}
+	prior := s.handle().undoPrior(s.Name, entries)
+	if err := b.Commit(); err != nil {
+		return err
+	}
+	s.handle().journalAdds(s.Name, prior, entries...)
+	return nil
+}
+
+// AddOptions are the options of an entry added with AddWithOptions: a zero
+// Timeout applies the default timeout of the set, TimeoutPermanent stores the
+// entry permanently.
+type AddOptions = EntryOptions
+
+// maxCommentLen is the longest entry comment the kernel stores.
+const maxCommentLen = 255
+
+// AddWithOptions adds the specified entry to the set with the given options,
+// updating them if the entry is already in the set.
+func (s *IPSet) AddWithOptions(entry string, opts AddOptions) error {
+	defer s.handle().lockSet(s.Name)()
+	return s.addWithOptions(entry, opts)
+}
+
+func (s *IPSet) addWithOptions(entry string, opts AddOptions) error {
+	if err := validateAddOptions(entry, opts); err != nil {
+		return err
+	}
+	if err := s.handle().checkQuotaAdd(s.Name, []string{entry}); err != nil {
+		return err
+	}
+	args := append(append([]string{"add", s.Name, entry}, opts.args()...), "-exist")
+	prior := s.handle().undoPrior(s.Name, []string{entry})
+	out, err := s.handle().run(args...)
+	c := change{op: opAdd, set: s.Name, entries: []string{entry}, err: err}
+	if opts.Nomatch {
+		c.nomatch = c.entries
+	}
+	s.handle().changed(c)
+	if err != nil {
+		return fmt.Errorf("error adding entry %s with options %s: %w (%s)", entry, strings.Join(opts.args(), " "), err, out)
+	}
+	s.handle().journalAdds(s.Name, prior, entry)
+	return nil
+}
+
+// validateAddOptions checks an entry to add and its options.
+func validateAddOptions(entry string, opts AddOptions) error {
+	if err := ValidateEntry(Entry{Element: entry, EntryOptions: opts}); err != nil {
+		return err
+	}
+	if opts.Timeout < TimeoutPermanent {
+		return fmt.Errorf("%w: timeout %d of %s", ErrInvalidEntry, opts.Timeout, entry)
 	}
 	return nil
 }
 
 // AddOption is used to add the specified entry to the set.
 // A timeout of 0 means that the entry will be stored permanently in the set.
+// The option string is split into arguments as in the restore format, so that
+// `comment "two words"` passes a comment with spaces.
+//
+// Deprecated: the option string is passed to ipset unchecked; use
+// AddWithOptions.
 func (s *IPSet) AddOption(entry string, option string, timeout int) error {
-	out, err := exec.Command(ipsetPath, "add", s.Name, entry, option, "timeout", strconv.Itoa(timeout), "-exist").CombinedOutput()
+	defer s.handle().lockSet(s.Name)()
+	if err := ValidateElement(entry); err != nil {
+		return err
+	}
+	if err := s.handle().checkQuotaAdd(s.Name, []string{entry}); err != nil {
+		return err
+	}
+	args := append(append([]string{"add", s.Name, entry}, optionArgs(option)...), "timeout", strconv.Itoa(timeout), "-exist")
+	prior := s.handle().undoPrior(s.Name, []string{entry})
+	out, err := s.handle().run(args...)
+	c := change{op: opAdd, set: s.Name, entries: []string{entry}, err: err}
+	if parseEntry(append([]string{entry}, optionArgs(option)...)).Nomatch {
+		c.nomatch = c.entries
+	}
+	s.handle().changed(c)
 	if err != nil {
-		return fmt.Errorf("error adding entry %s with option %s : %v (%s)", entry, option, err, out)
+		return fmt.Errorf("error adding entry %s with option %s : %w (%s)", entry, option, err, out)
 	}
+	s.handle().journalAdds(s.Name, prior, entry)
 	return nil
 }
 
+// optionArgs splits an option string into arguments, removing the double
+// quotes around a quoted argument.
+func optionArgs(option string) []string {
+	args := parse.Fields(option)
+	for i, a := range args {
+		if len(a) >= 2 && strings.HasPrefix(a, `"`) && strings.HasSuffix(a, `"`) {
+			args[i] = a[1 : len(a)-1]
+		}
+	}
+	return args
+}
+
 // Del is used to delete the specified entry from the set.
+// Each call runs an ipset process; use DelAll to delete many entries.
 func (s *IPSet) Del(entry string) error {
-	out, err := exec.Command(ipsetPath, "del", s.Name, entry, "-exist").CombinedOutput()
+	defer s.handle().lockSet(s.Name)()
+	if err := ValidateElement(entry); err != nil {
+		return err
+	}
+	out, err := s.handle().run("del", s.Name, entry, "-exist")
+	s.handle().changed(change{op: opDel, set: s.Name, entries: []string{entry}, err: err})
 	if err != nil {
-		return fmt.Errorf("error deleting entry %s: %v (%s)", entry, err, out)
+		return fmt.Errorf("error deleting entry %s: %w (%s)", entry, err, out)
 	}
+	s.handle().journalDels(s.Name, DelOptions{}, entry)
+	return nil
+}
+
+// DelAll deletes the entries from the set in a single `ipset restore`, see
+// AddAll. Missing entries are ignored.
+func (s *IPSet) DelAll(entries []string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	defer s.handle().lockSet(s.Name)()
+	b := s.handle().NewBatch()
+	for _, entry := range entries {
+		b.Del(s.Name, entry)
+	}
+	if err := b.Commit(); err != nil {
+		return err
+	}
+	s.handle().journalDels(s.Name, DelOptions{}, entries...)
+	return nil
+}
+
+// DelOptions qualify the entry deleted by DelWithOptions.
+type DelOptions struct {
+	// Nomatch deletes the exception entry of a hash:*net* set.
+	Nomatch bool
+	// Wildcard deletes the wildcard interface entry of a hash:net,iface set.
+	Wildcard bool
+}
+
+// DelWithOptions deletes the specified entry, qualified by opts, from the set.
+func (s *IPSet) DelWithOptions(entry string, opts DelOptions) error {
+	defer s.handle().lockSet(s.Name)()
+	if err := ValidateElement(entry); err != nil {
+		return err
+	}
+	args := []string{"del", s.Name, entry}
+	if opts.Nomatch {
+		args = append(args, "nomatch")
+	}
+	if opts.Wildcard {
+		args = append(args, "wildcard")
+	}
+	out, err := s.handle().run(append(args, "-exist")...)
+	s.handle().changed(change{op: opDel, set: s.Name, entries: []string{entry}, err: err})
+	if err != nil {
+		return fmt.Errorf("error deleting entry %s: %w (%s)", strings.Join(args[2:], " "), err, out)
+	}
+	s.handle().journalDels(s.Name, opts, entry)
 	return nil
 }
 
 // Flush is used to flush all entries in the set.
 func (s *IPSet) Flush() error {
-	out, err := exec.Command(ipsetPath, "flush", s.Name).CombinedOutput()
+	defer s.handle().lockSet(s.Name)()
+	if err := s.handle().checkPolicy(Operation{Op: OpFlush, Set: s.Name}); err != nil {
+		return err
+	}
+	saved := s.handle().undoSaved(s.Name)
+	out, err := s.handle().run("flush", s.Name)
+	s.handle().changed(change{op: opFlush, set: s.Name, err: err})
 	if err != nil {
-		return fmt.Errorf("error flushing set %s: %v (%s)", s.Name, err, out)
+		return fmt.Errorf("error flushing set %s: %w (%s)", s.Name, err, out)
 	}
+	s.handle().journalRestore("flush", s.Name, saved)
 	return nil
 }
 
 // List is used to show the contents of a set
 func (s *IPSet) List() ([]string, error) {
-	return list(s.Name)
+	return s.handle().list(s.Name)
+}
+
+// Members is used to get the elements of a set, without the per-entry options
+// that List reports along with them.
+func (s *IPSet) Members() ([]string, error) {
+	return s.handle().members(s.Name)
 }
 
 // ListTerse is used to show the name and statistics for a set
 func (s *IPSet) ListTerse() ([]string, error) {
-	return listWithOpts(s.Name, "-t")
+	return s.handle().listWithOpts(s.Name, "-t")
 }
 
 // loadStats uses reflection to load information into a Stats data structure.
@@ -282,14 +635,10 @@ func loadStats(stats *Stats, key, val string) error {
 func parseListTerse(details []string) (stats Stats, err error) {
 	// split on white spaces
 	for _, l := range details {
-		// split on ":"
-		values := strings.Split(l, ":")
-		if len(values) < 2 {
+		key, val, ok := parse.KeyValue(l)
+		if !ok {
 			continue
 		}
-		// remove the blanks
-		key := strings.Trim(values[0], " ")
-		val := strings.Trim(values[1], " ")
 		if err = loadStats(&stats, key, val); err != nil {
 			return
 		}
@@ -315,15 +664,26 @@ func (s *IPSet) Statistics() (stats Stats, err error) {
 	if len(details) == 0 {
 		return
 	}
-	return parseListTerse(details)
+	if stats, err = parseListTerse(details); err == nil {
+		s.handle().recordGrowth(s.Name, parseHeader(details).MaxElem, stats.Entries)
+	}
+	return
 }
 
 // Destroy is used to destroy the set.
 func (s *IPSet) Destroy() error {
-	out, err := exec.Command(ipsetPath, "destroy", s.Name).CombinedOutput()
+	defer s.handle().lockSet(s.Name)()
+	if err := s.handle().checkPolicy(Operation{Op: OpDestroy, Set: s.Name}); err != nil {
+		return err
+	}
+	saved := s.handle().undoSaved(s.Name)
+	out, err := s.handle().run("destroy", s.Name)
+	s.handle().changed(change{op: opDestroy, set: s.Name, err: err})
 	if err != nil {
-		return fmt.Errorf("error destroying set %s: %v (%s)", s.Name, err, out)
+		return fmt.Errorf("error destroying set %s: %w (%s)", s.Name, err, out)
 	}
+	s.handle().untrack(s.Name)
+	s.handle().journalRestore("destroy", s.Name, saved)
 	return nil
 }
 
@@ -331,7 +691,8 @@ func (s *IPSet) Destroy() error {
 // The prefix may be a prefix string or the constant ipset.AllSets
 // to specify that all existing sets should be destroyed
 // Note that attempting to destroy a set that is in use will
-// result in an error being returned.
+// result in an error being returned; with a prefix, it is a *MultiError
+// telling which sets could not be destroyed.
 //
 // I use the variadic form here to preserve the original API with no arguments.
 // i.e. DestroyAll() with no arguments will still work.
@@ -339,127 +700,217 @@ func (s *IPSet) Destroy() error {
 // DestroyAll("") and DestroyAll(ipset.AllSets) are equivalent to DestroyAll()
 //
 // DestroyAll("prefix") is new functionality
-// Note that the variadic allows for DestroyAll("prefix1", "prefix2")
-// but all arguments after prefix1 are currently ignored
+// DestroyAll("prefix1", "prefix2") destroys the sets starting with any of
+// the prefixes. ipset.AllSets given along with prefixes, e.g. an empty
+// prefix computed by mistake, is rejected rather than destroying every set.
 //
-func DestroyAll(prefix string) error {
+func DestroyAll(prefixes ...string) error {
+	return defaultHandle.DestroyAll(prefixes...)
+}
 
-	initCheck()
+// DestroyAll destroys the sets of the handle, see DestroyAll.
+func (h *Handle) DestroyAll(prefixes ...string) error {
+	return h.destroyAll(prefixes...)
+}
 
-	if prefix == "" {
-		return exec.Command(ipsetPath, "destroy").Run()
-	}
+func (h *Handle) destroyAll(prefixes ...string) error {
 
-	ips, err := listAllSetNames()
-	if err != nil {
+	h.initCheck()
+
+	all := len(prefixes) == 0
+	for _, prefix := range prefixes {
+		if prefix == AllSets && len(prefixes) > 1 {
+			return fmt.Errorf("refusing to destroy all sets along with prefixes %q", prefixes)
+		}
+		all = all || prefix == AllSets
+	}
+	if all && h.ownerPrefix != "" {
+		// never cross the boundary of the handle
+		_, err := h.destroyMatching(h.owns, "error destroying owned sets "+h.ownerPrefix, OpDestroyAll)
+		return err
+	}
+	if all && len(h.policies) != 0 {
+		// each set is submitted to the policies
+		_, err := h.destroyMatching(nil, "error destroying all sets", OpDestroyAll)
+		return err
+	}
+	if all {
+		_, err := h.run("destroy")
+		h.changed(change{op: opDestroyAll, err: err})
 		return err
 	}
 
-	var errs strings.Builder
-	for _, name := range ips {
-		if strings.HasPrefix(name, prefix) { // AllSets always matches :)
-			if err = destroyIPSet(name); err != nil {
-				errs.WriteString(fmt.Sprintf("ipset(%s): %s\n", name, err.Error()))
-			}
+	match := PrefixFilter(prefixes...)
+	_, err := h.destroyMatching(func(name string) bool {
+		return match(name) && h.owns(name)
+	}, "error destroying prefix sets "+strings.Join(prefixes, ", "), OpDestroyAll)
+	return err
+}
+
+// DestroyMatching destroys the sets whose name matches any of the glob
+// patterns, in the syntax of path.Match, and returns the names of the sets
+// destroyed. Failures are reported as a *MultiError.
+func DestroyMatching(patterns ...string) ([]string, error) {
+	return defaultHandle.DestroyMatching(patterns...)
+}
+
+// DestroyMatching destroys the sets of the handle, see DestroyMatching.
+func (h *Handle) DestroyMatching(patterns ...string) ([]string, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
 		}
 	}
+	return h.destroyMatching(GlobFilter(patterns...), "error destroying sets matching "+strings.Join(patterns, ", "), OpDestroy)
+}
 
-	if len(errs.String()) != 0 { // if errors occured above
-		prefixMsg := func() string {
-			if prefix == AllSets {
-				return "all"
-			}
-			return "prefix"
+// DestroyMatchingRegexp destroys the sets whose name matches re and returns
+// the names of the sets destroyed. Failures are reported as a *MultiError.
+func DestroyMatchingRegexp(re *regexp.Regexp) ([]string, error) {
+	return defaultHandle.DestroyMatchingRegexp(re)
+}
+
+// DestroyMatchingRegexp destroys the sets of the handle, see DestroyMatchingRegexp.
+func (h *Handle) DestroyMatchingRegexp(re *regexp.Regexp) ([]string, error) {
+	return h.destroyMatching(RegexpFilter(re), "error destroying sets matching "+re.String(), OpDestroy)
+}
+
+// destroyMatching destroys the sets whose name match reports, all of them if
+// match is nil, once allowed by the policies for op, returning the names of
+// the sets destroyed and a *MultiError described by msg for those that could
+// not be.
+func (h *Handle) destroyMatching(match func(string) bool, msg string, op DestructiveOp) ([]string, error) {
+	ips, err := h.ListSetNames(match)
+	if err != nil {
+		return nil, err
+	}
+
+	var destroyed []string
+	var errs []*ItemError
+	for _, name := range ips {
+		if err = h.checkPolicy(Operation{Op: op, Set: name}); err != nil {
+			errs = append(errs, &ItemError{Set: name, Err: err})
+			continue
 		}
-		return fmt.Errorf("error destroying %s sets %s (%s)", prefixMsg(), prefix, errs.String())
+		if err = h.destroyIPSet(name); err != nil {
+			errs = append(errs, &ItemError{Set: name, Err: err})
+			continue
+		}
+		destroyed = append(destroyed, name)
 	}
 
-	return nil
+	if len(errs) != 0 { // if errors occured above
+		return destroyed, &MultiError{Msg: msg, Errors: errs}
+	}
+	return destroyed, nil
 }
 
 // Swap is used to hot swap two sets on-the-fly. Use with names of existing sets of the same type.
 func Swap(from, to string) error {
-	out, err := exec.Command(ipsetPath, "swap", from, to).CombinedOutput()
+	return defaultHandle.Swap(from, to)
+}
+
+// Swap hot swaps two sets with the handle, see Swap.
+func (h *Handle) Swap(from, to string) error {
+	if err := h.checkPolicy(Operation{Op: OpSwap, Set: from, Other: to}); err != nil {
+		return err
+	}
+	if err := h.swap(from, to); err != nil {
+		return err
+	}
+	h.journalSwap(from, to)
+	return nil
+}
+
+func (h *Handle) swap(from, to string) error {
+	out, err := h.run("swap", from, to)
+	h.changed(change{op: opSwap, set: from, other: to, err: err})
 	if err != nil {
-		return fmt.Errorf("error swapping ipset %s to %s: %v (%s)", from, to, err, out)
+		return fmt.Errorf("error swapping ipset %s to %s: %w (%s)", from, to, err, out)
 	}
 	return nil
 }
 
-func destroyIPSet(name string) error {
-	out, err := exec.Command(ipsetPath, "destroy", name).CombinedOutput()
-	if err != nil && !strings.Contains(string(out), "does not exist") {
-		return fmt.Errorf("error destroying ipset %s: %v (%s)", name, err, out)
+func (h *Handle) destroyIPSet(name string) error {
+	out, err := h.run("destroy", name)
+	h.changed(change{op: opDestroy, set: name, err: err})
+	if err != nil && !parse.NoSuchSet(out) {
+		return fmt.Errorf("error destroying ipset %s: %w (%s)", name, err, out)
 	}
+	h.untrack(name)
 	return nil
 }
 
-func list(set string) ([]string, error) {
-	out, err := exec.Command(ipsetPath, "list", set).CombinedOutput()
+func (h *Handle) list(set string) ([]string, error) {
+	var fields []string
+	inMembers := false
+	out, err := h.scanLines(func(l string) {
+		if !inMembers {
+			inMembers = parse.IsMembersHeader(l)
+			return
+		}
+		fields = append(fields, strings.FieldsFunc(l, fieldsFunc)...)
+	}, "list", set)
 	if err != nil {
-		return []string{}, fmt.Errorf("error listing set %s: %v (%s)", set, err, out)
+		return []string{}, fmt.Errorf("error listing set %s: %w (%s)", set, err, out)
 	}
-	r := regexp.MustCompile("(?m)^(.*\n)*Members:\n")
-	newlist := r.ReplaceAllString(string(out[:]), "")
-	return strings.FieldsFunc(newlist, fieldsFunc), nil
+	return fields, nil
 }
 
-func listWithOpts(set string, opts ...string) ([]string, error) {
+func (h *Handle) listWithOpts(set string, opts ...string) ([]string, error) {
+	if caps.BusyBox {
+		return h.listWithOptsCompat(set, opts...)
+	}
 	var cmd []string
 	if len(opts) != 0 {
 		cmd = append(cmd, opts...)
 	}
 	cmd = append(cmd, "list")
 	cmd = append(cmd, set)
-	out, err := exec.Command(ipsetPath, cmd...).CombinedOutput()
+	out, err := h.run(cmd...)
 	if err != nil {
-		return []string{}, fmt.Errorf("error listing set %s: %v (%s)", set, err, out)
+		return []string{}, fmt.Errorf("error listing set %s: %w (%s)", set, err, out)
 	}
 	return strings.Split(string(out[:]), "\n"), nil
 }
 
 func getIpsetSupportedVersion() (bool, error) {
-	minVersion, err := semver.NewVersion(minIpsetVersion)
-	if err != nil {
-		return false, err
-	}
 	// Returns "vX.Y".
 	vstring, err := getIpsetVersionString()
 	if err != nil {
 		return false, err
 	}
-	// Make a dotted-tri format version string
-	vstring = vstring + ".0"
-	// Make a semver of the part after the v in "vX.X.X".
-	version, err := semver.NewVersion(vstring[1:])
-	if err != nil {
-		return false, err
-	}
-	if version.LessThan(*minVersion) {
-		return false, nil
-	}
-	return true, nil
+	// Make a dotted-tri format version string of the part after the v
+	return versionAtLeast(vstring[1:]+".0", versionPolicy.MinVersion)
 }
 
 func getIpsetVersionString() (string, error) {
-	bytes, err := exec.Command(ipsetPath, "--version").CombinedOutput()
+	if caps.Version != "" {
+		return caps.Version, nil
+	}
+	bytes, err := defaultHandle.run("--version")
 	if err != nil {
 		return "", err
 	}
-	versionMatcher := regexp.MustCompile("v[0-9]+\\.[0-9]+")
-	match := versionMatcher.FindStringSubmatch(string(bytes))
-	if match == nil {
-		return "", fmt.Errorf("no ipset version found in string: %s", bytes)
-	}
-	return match[0], nil
+	return parseVersionString(bytes)
 }
 
-func listAllSetNames() ([]string, error) {
-	out, err := exec.Command(ipsetPath, "list", "-n").CombinedOutput()
+func parseVersionString(bytes []byte) (string, error) {
+	return parse.Version(bytes)
+}
+
+func (h *Handle) listAllSetNames() ([]string, error) {
+	if !caps.NamesOnly {
+		return h.listSetNamesCompat()
+	}
+	var names []string
+	out, err := h.scanLines(func(l string) {
+		names = append(names, strings.FieldsFunc(l, fieldsFunc)...)
+	}, "list", "-n")
 	if err != nil {
-		return []string{}, fmt.Errorf("error listing all sets: %v (%s)", err, out)
+		return []string{}, fmt.Errorf("error listing all sets: %w (%s)", err, out)
 	}
-	return strings.FieldsFunc(string(out), fieldsFunc), nil
+	return names, nil
 }
 
 // use a fields function for strings.FieldsFunc() to skip all newlines and returns and thus
@@ -0,0 +1,713 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var roundTripComments = []string{
+	"plain",
+	"two words",
+	"  padded  ",
+	"a;b=c,d%20",
+	`back\slash and 'single quotes'`,
+	"tab-free unicode: naïve ✓",
+	"",
+	strings.Repeat("x", maxCommentLen),
+}
+
+func TestCommentRoundTrip(t *testing.T) {
+	for _, add := range []struct {
+		name string
+		fn   func(s *IPSet, entry, comment string) error
+	}{
+		{"AddWithOptions", func(s *IPSet, entry, comment string) error {
+			return s.AddWithOptions(entry, AddOptions{Comment: comment, Timeout: 60})
+		}},
+		{"AddOption", func(s *IPSet, entry, comment string) error {
+			return s.AddOption(entry, `comment "`+comment+`"`, 60)
+		}},
+	} {
+		t.Run(add.name, func(t *testing.T) {
+			h := stubHandle(t, saveStub)
+			s := &IPSet{Name: "comments", h: h}
+			want := make(map[string]string)
+			for i, c := range roundTripComments {
+				entry := "192.0.2." + strconv.Itoa(i+1)
+				if err := add.fn(s, entry, c); err != nil {
+					t.Fatalf("adding comment %q: %v", c, err)
+				}
+				want[entry] = c
+			}
+			entries, err := h.savedEntries(s.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(want) {
+				t.Fatalf("%d entries saved, want %d", len(entries), len(want))
+			}
+			for _, e := range entries {
+				if e.Comment != want[e.Element] {
+					t.Errorf("comment of %s saved as %q, want %q", e.Element, e.Comment, want[e.Element])
+				}
+				if e.Timeout != 60 {
+					t.Errorf("timeout of %s saved as %d, want 60", e.Element, e.Timeout)
+				}
+			}
+		})
+	}
+}
+
+func TestCommentRejected(t *testing.T) {
+	var r NoopRecorder
+	s := &IPSet{Name: "comments", h: NewHandle(WithNoopBackend(&r))}
+	for _, c := range []string{`say "hi"`, `"`, "line\nbreak", "tab\there", strings.Repeat("x", maxCommentLen+1)} {
+		if err := s.AddWithOptions("192.0.2.1", AddOptions{Comment: c}); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("comment %q: error %v, want ErrInvalidEntry", c, err)
+		}
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("rejected comments ran %v", cmds)
+	}
+}
+
+func TestEntryStringRoundTrip(t *testing.T) {
+	for _, c := range roundTripComments {
+		e := Entry{Element: "10.0.0.0/8", EntryOptions: EntryOptions{Comment: c, Nomatch: true}}
+		if got := ParseMembers([]byte("Members:\n" + e.String())); len(got) != 1 || got[0] != e {
+			t.Errorf("entry %q parsed back as %+v", e.String(), got)
+		}
+	}
+}
+
+func TestRestoreChecked(t *testing.T) {
+	denySwap := func(h *Handle, o Operation) error {
+		if o.Op == OpSwap {
+			return errors.New("no swaps")
+		}
+		return nil
+	}
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r), WithOwnerPrefix("app-"), WithDestructivePolicy(denySwap))
+	for _, c := range []struct {
+		input string
+		want  error
+	}{
+		{"create app-a hash:ip\nadd app-a 192.0.2.1\n", nil},
+		{"add app-a 192.0.2.1\nadd other 192.0.2.1\n", ErrNotOwned},
+		{"flush\n", ErrNotOwned},
+		{"destroy\n", ErrNotOwned},
+		{"rename app-a other\n", ErrNotOwned},
+		{"swap app-a app-b\n", ErrPolicyDenied},
+	} {
+		r.Reset()
+		err := h.Restore(strings.NewReader(c.input))
+		if !errors.Is(err, c.want) {
+			t.Errorf("restore %q: error %v, want %v", c.input, err, c.want)
+		}
+		if c.want != nil && len(r.Commands()) != 0 {
+			t.Errorf("refused restore %q ran %v", c.input, r.Commands())
+		}
+	}
+}
+
+type auditRecords []AuditRecord
+
+func (a *auditRecords) Audit(r AuditRecord) error {
+	*a = append(*a, r)
+	return nil
+}
+
+func TestRestoreAudited(t *testing.T) {
+	var records auditRecords
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r), WithAudit(&records, "test"))
+	input := "create app-a hash:ip\nadd app-a 192.0.2.1\ndel app-a 192.0.2.2\nswap app-a app-b\n"
+	if err := h.Restore(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range records {
+		got = append(got, strings.Join(strings.Fields(rec.Op+" "+rec.Set+" "+rec.Other+" "+strings.Join(rec.Entries, " ")), " "))
+	}
+	want := []string{"create app-a", "add app-a 192.0.2.1", "del app-a 192.0.2.2", "swap app-a app-b"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("audited %q, want %q", got, want)
+	}
+}
+
+func TestCleanupPolicy(t *testing.T) {
+	deny := func(h *Handle, o Operation) error {
+		return errors.New("keep everything")
+	}
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r), WithCleanup(CleanupDestroy), WithDestructivePolicy(deny))
+	if _, _, err := h.Create("app-a", "hash:ip", &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	r.Reset()
+	if err := h.Close(); !errors.Is(err, ErrPolicyDenied) {
+		t.Errorf("close error %v, want ErrPolicyDenied", err)
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("vetoed cleanup ran %v", cmds)
+	}
+}
+
+func TestNamespacedClosePolicy(t *testing.T) {
+	deny := func(h *Handle, o Operation) error {
+		return errors.New("keep everything")
+	}
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r), WithCleanup(CleanupDestroy), WithDestructivePolicy(deny))
+	h.netns = f
+	nh := &NamespacedHandle{h}
+	if _, _, err := nh.Create("pod-a", "hash:ip", &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	r.Reset()
+	if err := nh.Close(); !errors.Is(err, ErrPolicyDenied) {
+		t.Errorf("close error %v, want ErrPolicyDenied", err)
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("vetoed cleanup ran %v", cmds)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("namespace left open by Close")
+	}
+}
+
+func TestCloseOnSignalStopTwice(t *testing.T) {
+	var r NoopRecorder
+	stop := NewHandle(WithNoopBackend(&r)).CloseOnSignal(syscall.SIGUSR1)
+	stop()
+	stop()
+}
+
+func TestTestMissing(t *testing.T) {
+	h := stubHandle(t, `#!/bin/sh
+case "$3" in
+192.0.2.1) echo "Warning: $3 is in set $2." ;;
+192.0.2.2) echo "$3 is NOT in set $2." >&2; exit 1 ;;
+*) echo "ipset v7.15: The set with the given name does not exist" >&2; exit 1 ;;
+esac
+`)
+	for _, c := range []struct {
+		set, entry string
+		found, ok  bool
+	}{
+		{"app-a", "192.0.2.1", true, true},
+		{"app-a", "192.0.2.2", false, true},
+		{"missing", "192.0.2.3", false, false},
+	} {
+		found, err := h.Set(c.set).Test(c.entry)
+		if found != c.found || (err == nil) != c.ok {
+			t.Errorf("Test(%s) in %s = %v, %v, want %v and error %v", c.entry, c.set, found, err, c.found, !c.ok)
+		}
+	}
+}
+
+func TestCleanupAdopted(t *testing.T) {
+	h := stubHandle(t, existingStub, WithCleanup(CleanupDestroy))
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	s, created, err := h.Create("shared", "hash:ip", &Params{})
+	if err != nil || created {
+		t.Fatalf("create: created %v, error %v", created, err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadFile(log); strings.Contains(string(out), "destroy") {
+		t.Errorf("adopted set cleaned up without being retained: %s", out)
+	}
+	s.Retain()
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadFile(log); !strings.Contains(string(out), "destroy shared") {
+		t.Errorf("retained adopted set not cleaned up: %s", out)
+	}
+}
+
+func TestAdoptKeepsLease(t *testing.T) {
+	reg := NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"))
+	held := &Lease{Holder: "other/1", Renewed: time.Now(), TTL: time.Hour}
+	if err := reg.Put(SetMetadata{Set: "shared", Lease: held}); err != nil {
+		t.Fatal(err)
+	}
+	h := stubHandle(t, existingStub, WithRegistry(reg), WithLease("me/2", time.Hour))
+	if _, _, err := h.Create("shared", "hash:ip", &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	md, _, err := reg.Get("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Lease == nil || md.Lease.Holder != held.Holder {
+		t.Errorf("adopting replaced the lease of %s with %+v", held.Holder, md.Lease)
+	}
+}
+
+func TestBlueGreenSwitchReported(t *testing.T) {
+	const stub = "#!/bin/sh\ncase \"$*\" in\n*list*) printf 'Name: bg\\nType: list:set\\nMembers:\\nbg-a\\n' ;;\n*) cat >/dev/null ;;\nesac\n"
+	var records auditRecords
+	h := stubHandle(t, stub, WithAudit(&records, "test"))
+	bg := &BlueGreen{Name: "bg", h: h, sets: [2]*IPSet{{Name: "bg-a", h: h}, {Name: "bg-b", h: h}}}
+	if err := bg.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Op != "add" || records[0].Entries[0] != "bg-b" || records[1].Op != "del" || records[1].Entries[0] != "bg-a" {
+		t.Errorf("switch audited as %+v", records)
+	}
+
+	h = stubHandle(t, stub, WithOwnerPrefix("app-"))
+	bg.h = h
+	if err := bg.Rollback(); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("switch of an unowned list:set: error %v, want ErrNotOwned", err)
+	}
+}
+
+func TestNewBlueGreenReported(t *testing.T) {
+	var records auditRecords
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r), WithAudit(&records, "test"))
+	if _, err := h.NewBlueGreen("bg", "hash:ip", &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range records {
+		got = append(got, strings.Join(append([]string{rec.Op, rec.Set}, rec.Entries...), " "))
+	}
+	want := []string{"create bg-a", "create bg-b", "create bg", "add bg bg-a"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("audited %q, want %q", got, want)
+	}
+	for _, c := range r.Commands() {
+		if c.Args[0] == "create" && c.Args[1] == "bg" && strings.Join(c.Args, " ") != "create bg list:set -exist" {
+			t.Errorf("list:set created with %v", c)
+		}
+	}
+}
+
+func TestNewSetGroupRollback(t *testing.T) {
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r))
+	_, err := h.NewSetGroup("g", GroupMember{Name: "g-v4", Type: "hash:ip"}, GroupMember{Name: "g-bad", Type: "nosuch:type"})
+	if err == nil {
+		t.Fatal("group with an invalid set type created")
+	}
+	destroyed := false
+	for _, c := range r.Commands() {
+		destroyed = destroyed || c.String() == "ipset destroy g-v4"
+	}
+	if !destroyed {
+		t.Errorf("created set g-v4 left after a failure: %v", r.Commands())
+	}
+}
+
+func TestTestCacheStaleResult(t *testing.T) {
+	c := newTestCache(time.Hour, 10)
+	gen := c.gen("s")
+	c.invalidate("s")
+	c.put("s", "192.0.2.1", true, gen)
+	if _, ok := c.get("s", "192.0.2.1"); ok {
+		t.Error("result of a test run before an invalidation cached")
+	}
+	gen = c.gen("s")
+	c.invalidateAll()
+	c.put("s", "192.0.2.1", true, gen)
+	if _, ok := c.get("s", "192.0.2.1"); ok {
+		t.Error("result of a test run before invalidating all sets cached")
+	}
+	c.put("s", "192.0.2.1", true, c.gen("s"))
+	if found, ok := c.get("s", "192.0.2.1"); !ok || !found {
+		t.Error("current result not cached")
+	}
+}
+
+func TestSessionMaxRestarts(t *testing.T) {
+	// a restore process dying at once without telling which line failed
+	const stub = "#!/bin/sh\necho start >>\"$(dirname \"$0\")/log\"\nexit 1\n"
+	h := stubHandle(t, stub)
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	s := h.NewSession(SessionOptions{MaxRestarts: 2})
+	s.Add("s", Entry{Element: "192.0.2.1"})
+	if err := s.Sync(); !errors.Is(err, errSessionFailed) {
+		t.Fatalf("sync error %v, want errSessionFailed", err)
+	}
+	if err := s.Add("s", Entry{Element: "192.0.2.2"}); !errors.Is(err, errSessionFailed) {
+		t.Errorf("add after giving up: error %v, want errSessionFailed", err)
+	}
+	if hl := s.Health(); !errors.Is(hl.LastError, errSessionFailed) || hl.Running {
+		t.Errorf("health %+v after giving up", hl)
+	}
+	out, _ := ioutil.ReadFile(log)
+	if n := strings.Count(string(out), "start"); n != 3 {
+		t.Errorf("restore started %d times, want 3", n)
+	}
+}
+
+func TestAddFromFileUnknownOption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entries")
+	if err := ioutil.WriteFile(path, []byte("192.0.2.1 timeout 10\n# comment\n192.0.2.2 tiemout 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var r NoopRecorder
+	s := &IPSet{Name: "s", h: NewHandle(WithNoopBackend(&r))}
+	_, err := s.AddFromFile(path, FileFormat{})
+	if !errors.Is(err, ErrInvalidEntry) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("error %v, want an ErrInvalidEntry on line 3", err)
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("invalid file ran %v", cmds)
+	}
+}
+
+func TestDestroyAllMixedPrefixes(t *testing.T) {
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r))
+	if err := h.DestroyAll("app-", AllSets); err == nil {
+		t.Error("all sets destroyed along with a prefix")
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("rejected DestroyAll ran %v", cmds)
+	}
+}
+
+func TestQuotaCountedInPlace(t *testing.T) {
+	h := stubHandle(t, setStub, WithQuota(2, QuotaReject))
+	s, _, err := h.Create("app-a", "hash:ip", &Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	if err := ioutil.WriteFile(log, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []error{nil, nil, ErrQuotaExceeded} {
+		if err := s.Add("192.0.2."+strconv.Itoa(i+1), 0); !errors.Is(err, want) {
+			t.Errorf("add %d: error %v, want %v", i+1, err, want)
+		}
+	}
+	out, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lists []string
+	for _, c := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if !strings.HasPrefix(c, "add ") {
+			lists = append(lists, c)
+		}
+	}
+	// the count is read once, and the set listed once the quota is reached
+	if len(lists) != 2 {
+		t.Errorf("entries counted with %d commands, want 2: %q", len(lists), lists)
+	}
+}
+
+func TestQuotaReAdds(t *testing.T) {
+	h := stubHandle(t, setStub, WithQuota(3, QuotaReject))
+	s, _, err := h.Create("app-a", "hash:ip", &Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	for i := 0; i < 5; i++ {
+		for _, e := range entries {
+			if err := s.Add(e, 0); err != nil {
+				t.Fatalf("round %d: add %s: %v", i, e, err)
+			}
+		}
+		if err := s.AddAll(entries, 0); err != nil {
+			t.Fatalf("round %d: add all: %v", i, err)
+		}
+		if err := s.AddWithOptions(entries[0], AddOptions{Comment: "again"}); err != nil {
+			t.Fatalf("round %d: add with options: %v", i, err)
+		}
+		if err := s.Touch(entries, time.Minute); err != nil {
+			t.Fatalf("round %d: touch: %v", i, err)
+		}
+	}
+	if used, _, err := h.QuotaUsage(); err != nil || used > 3 {
+		t.Errorf("quota usage %d (%v), want at most 3", used, err)
+	}
+	if err := s.Add("192.0.2.4", 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("adding a fourth entry: error %v, want %v", err, ErrQuotaExceeded)
+	}
+}
+
+func TestLiveSetPolicy(t *testing.T) {
+	deny := false
+	policy := func(h *Handle, o Operation) error {
+		if deny {
+			return errors.New("keep the live set")
+		}
+		return nil
+	}
+	h := stubHandle(t, setStub, WithDestructivePolicy(policy), WithUndo(1))
+	s, _, err := h.Create("app-a", "hash:ip", &Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("192.0.2.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	snap := filepath.Join(t.TempDir(), "app-a.snap")
+	if _, err := s.Snapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Swap("app-a", "app-a"); err != nil {
+		t.Fatal(err)
+	}
+	deny = true
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	if err := ioutil.WriteFile(log, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{{Element: "192.0.2.2"}}
+	for _, tc := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"refresh", func() error { return s.Refresh([]string{"192.0.2.2"}) }},
+		{"refresh swap", func() error { return s.RefreshEntriesWith(entries, RefreshSwap) }},
+		{"refresh flush", func() error { return s.RefreshEntriesWith(entries, RefreshFlush) }},
+		{"refresh from", func() error { return s.RefreshFrom(EntriesFromSlice(entries)) }},
+		{"batch flush", func() error {
+			b := h.NewBatch()
+			b.Flush("app-a")
+			return b.Commit()
+		}},
+		{"session flush", func() error {
+			sess := h.NewSession(SessionOptions{})
+			defer sess.Close()
+			return sess.Flush("app-a")
+		}},
+		{"load snapshot", func() error { _, err := h.LoadSnapshot(snap); return err }},
+		{"undo swap", func() error { _, err := h.Undo(); return err }},
+	} {
+		if err := tc.fn(); !errors.Is(err, ErrPolicyDenied) {
+			t.Errorf("%s: error %v, want ErrPolicyDenied", tc.name, err)
+		}
+	}
+	out, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		switch fields := strings.Fields(c); {
+		case len(fields) == 0, fields[0] == "save", fields[0] == "list", fields[0] == "-t":
+			// reading the sets is allowed
+		default:
+			t.Errorf("vetoed operation ran %q", c)
+		}
+	}
+}
+
+func TestUndoKeepsOptions(t *testing.T) {
+	h := stubHandle(t, setStub, WithUndo(4))
+	s, _, err := h.Create("app-a", "hash:net", &Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddWithOptions("10.0.0.0/8", AddOptions{Nomatch: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DelWithOptions("10.0.0.0/8", DelOptions{Nomatch: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddWithOptions("192.0.2.0/24", AddOptions{Comment: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddWithOptions("192.0.2.0/24", AddOptions{Comment: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range [][]Entry{
+		{{Element: "192.0.2.0/24", EntryOptions: EntryOptions{Comment: "old"}}},
+		nil,
+		{{Element: "10.0.0.0/8", EntryOptions: EntryOptions{Nomatch: true}}},
+	} {
+		desc, err := h.Undo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := s.ListEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entries, want) {
+			t.Errorf("entries after undoing %s: %v, want %v", desc, entries, want)
+		}
+	}
+}
+
+func TestLookupMirrorInPlace(t *testing.T) {
+	h := stubHandle(t, setStub)
+	s, _, err := h.Create("app-a", "hash:ip", &Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewLookupMirror(s, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	if err := ioutil.WriteFile(log, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("192.0.2.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAll([]string{"198.51.100.0/24", "192.0.2.1"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddWithOptions("198.51.100.7", AddOptions{Nomatch: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Del("192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	for entry, want := range map[string]bool{"192.0.2.1": false, "198.51.100.7": false, "198.51.100.8": true} {
+		if got := m.Contains(entry); got != want {
+			t.Errorf("contains %s: %v, want %v", entry, got, want)
+		}
+	}
+	if m.Len() != 2 {
+		t.Errorf("mirror of %d entries, want 2", m.Len())
+	}
+	if out, _ := ioutil.ReadFile(log); strings.Contains(string(out), "save") {
+		t.Errorf("mirror reloaded after additions and deletions:\n%s", out)
+	}
+}
+
+func TestTouchTimeout(t *testing.T) {
+	h := stubHandle(t, setStub)
+	s, _, err := h.Create("app-a", "hash:ip", &Params{Timeout: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("192.0.2.1", 300); err != nil {
+		t.Fatal(err)
+	}
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		if err := s.Touch([]string{"192.0.2.1"}, timeout); err == nil {
+			t.Errorf("timeout %v accepted", timeout)
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		touch func([]string) error
+		want  int
+	}{
+		{"Touch(1.5s)", func(e []string) error { return s.Touch(e, 1500*time.Millisecond) }, 2},
+		{"MakePermanent", s.MakePermanent, TimeoutPermanent},
+	} {
+		if err := tc.touch([]string{"192.0.2.1", "192.0.2.9"}); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := s.ListEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Timeout != tc.want {
+			t.Errorf("entries after %s: %v, want timeout %d", tc.name, entries, tc.want)
+		}
+	}
+}
+
+func TestRefreshDiffCanonical(t *testing.T) {
+	h := stubHandle(t, portStub)
+	s := &IPSet{Name: "web", HashType: "hash:ip,port", h: h}
+	entries := []string{"192.0.2.1/32,80", "198.51.100.1,udp:53", "203.0.113.1,443"}
+	added, removed, err := s.Diff(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []string{"203.0.113.1,443"}) || len(removed) != 0 {
+		t.Errorf("diff added %v, removed %v", added, removed)
+	}
+	es := make([]Entry, len(entries))
+	for i, e := range entries {
+		es[i] = Entry{Element: e}
+	}
+	plan, err := s.PlanRefresh(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan, RefreshPlan{Added: es[2:]}) {
+		t.Errorf("planned %+v", plan)
+	}
+	if err := s.RefreshWith(entries, RefreshDiff); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(h.runner[0]), "log"))
+	if want := "add web 203.0.113.1,443\n"; string(out) != want {
+		t.Errorf("restored %q, want %q", out, want)
+	}
+}
+
+func TestCreateCheckedType(t *testing.T) {
+	h := stubHandle(t, helpStub)
+	revs, err := h.SupportedTypes()
+	if err != nil || len(revs) != 3 {
+		t.Fatalf("supported types %v, %v", revs, err)
+	}
+	for _, tc := range []struct {
+		settype string
+		p       Params
+		ok      bool
+	}{
+		{"hash:ip", Params{Counters: true}, true},
+		{"hash:ip", Params{Comment: true}, false},
+		{"hash:mac", Params{Comment: true, ForceAdd: true}, true},
+		{"hash:net", Params{}, false},
+	} {
+		_, err := h.New("app-a", tc.settype, &tc.p)
+		if ok := err == nil; ok != tc.ok || (!ok && !errors.Is(err, ErrTypeUnsupported)) {
+			t.Errorf("create %s with %+v: %v", tc.settype, tc.p, err)
+		}
+	}
+
+	var r NoopRecorder
+	noop := NewHandle(WithNoopBackend(&r))
+	if _, err := noop.New("app-a", "hash:ip", &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range r.Commands() {
+		if c.Args[0] == "help" {
+			t.Errorf("no-op backend ran %s", c)
+		}
+	}
+}
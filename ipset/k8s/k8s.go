@@ -0,0 +1,252 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package k8s translates Kubernetes NetworkPolicy peers into ipset specs and
+// membership updates, the layer kube-proxy style agents otherwise reimplement.
+//
+// The package does not depend on the Kubernetes API packages: IPBlock mirrors
+// networking.k8s.io/v1 IPBlock field for field, so converting is a plain copy.
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// MaxSetNameLen is the longest set name accepted by the kernel.
+const MaxSetNameLen = 31
+
+// Label keys set on every generated SetSpec.
+const (
+	LabelOwner     = "owner"
+	LabelNamespace = "namespace"
+	LabelPolicy    = "policy"
+	LabelFamily    = "family"
+)
+
+// IPBlock mirrors networking.k8s.io/v1 IPBlock.
+type IPBlock struct {
+	CIDR   string
+	Except []string
+}
+
+// Member is one entry of a generated set. Nomatch entries are the exceptions
+// of an IPBlock: hash:net sets treat them as holes in the enclosing network.
+type Member struct {
+	Entry   string
+	Nomatch bool
+}
+
+// SetSpec describes a set to create together with its desired members.
+type SetSpec struct {
+	Name    string
+	Type    string
+	Params  ipset.Params
+	Members []Member
+	// Labels record ownership of the set; store them with the owning object.
+	Labels map[string]string
+}
+
+// PolicyRef identifies the policy peer a set is generated for.
+type PolicyRef struct {
+	// Owner is the name of the controller owning the sets.
+	Owner     string
+	Namespace string
+	Policy    string
+	// Peer distinguishes the sets of one policy, e.g. "ingress-0" for the first
+	// ingress rule.
+	Peer string
+}
+
+// SetName returns a stable name of the set holding the given family ("inet" or
+// "inet6") of the peer. The name depends only on its inputs, is unique with high
+// probability and never exceeds MaxSetNameLen; prefix is truncated if needed.
+func SetName(prefix string, ref PolicyRef, family string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{ref.Owner, ref.Namespace, ref.Policy, ref.Peer, family}, "\x00")))
+	hash := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:])[:16]
+	suffix := "-4-" + hash
+	if family == "inet6" {
+		suffix = "-6-" + hash
+	}
+	if max := MaxSetNameLen - len(suffix); len(prefix) > max {
+		prefix = prefix[:max]
+	}
+	return prefix + suffix
+}
+
+func labels(ref PolicyRef, family string) map[string]string {
+	return map[string]string{
+		LabelOwner:     ref.Owner,
+		LabelNamespace: ref.Namespace,
+		LabelPolicy:    ref.Policy,
+		LabelFamily:    family,
+	}
+}
+
+func family(ip net.IP) string {
+	if ip.To4() != nil {
+		return "inet"
+	}
+	return "inet6"
+}
+
+// FromIPBlocks translates the IPBlocks of a policy peer into hash:net specs, one
+// per address family in use. Exceptions become nomatch members and must lie
+// within their block, as the API server enforces.
+func FromIPBlocks(prefix string, ref PolicyRef, blocks []IPBlock) ([]SetSpec, error) {
+	members := make(map[string][]Member)
+	for _, b := range blocks {
+		_, cidr, err := net.ParseCIDR(b.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ipBlock cidr %q: %v", b.CIDR, err)
+		}
+		fam := family(cidr.IP)
+		members[fam] = append(members[fam], Member{Entry: cidr.String()})
+		for _, e := range b.Except {
+			ip, except, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ipBlock except %q: %v", e, err)
+			}
+			if !cidr.Contains(ip) || family(ip) != fam {
+				return nil, fmt.Errorf("ipBlock except %q is not within cidr %q", e, b.CIDR)
+			}
+			members[fam] = append(members[fam], Member{Entry: except.String(), Nomatch: true})
+		}
+	}
+	return specs(prefix, ref, "hash:net", members), nil
+}
+
+// FromPodIPs translates the IPs of the pods selected by a policy peer into
+// hash:ip specs, one per address family in use.
+func FromPodIPs(prefix string, ref PolicyRef, ips []string) ([]SetSpec, error) {
+	members := make(map[string][]Member)
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid pod IP %q", s)
+		}
+		fam := family(ip)
+		members[fam] = append(members[fam], Member{Entry: ip.String()})
+	}
+	return specs(prefix, ref, "hash:ip", members), nil
+}
+
+func specs(prefix string, ref PolicyRef, hashtype string, members map[string][]Member) []SetSpec {
+	var out []SetSpec
+	for _, fam := range []string{"inet", "inet6"} {
+		m, ok := members[fam]
+		if !ok {
+			continue
+		}
+		sort.Slice(m, func(i, j int) bool { return m[i].Entry < m[j].Entry })
+		out = append(out, SetSpec{
+			Name:    SetName(prefix, ref, fam),
+			Type:    hashtype,
+			Params:  ipset.Params{HashFamily: fam},
+			Members: m,
+			Labels:  labels(ref, fam),
+		})
+	}
+	return out
+}
+
+// MembershipUpdate lists the entries to add to and delete from a set.
+type MembershipUpdate struct {
+	Set string
+	Add []string
+	Del []string
+}
+
+// PodIPUpdates computes the per-set updates turning the pod IP sets of a peer
+// from old into current, e.g. on a pod informer event. Sets without changes are
+// omitted.
+func PodIPUpdates(prefix string, ref PolicyRef, old, current []string) ([]MembershipUpdate, error) {
+	before, err := FromPodIPs(prefix, ref, old)
+	if err != nil {
+		return nil, err
+	}
+	after, err := FromPodIPs(prefix, ref, current)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]map[string]bool)
+	for _, s := range before {
+		have[s.Name] = make(map[string]bool)
+		for _, m := range s.Members {
+			have[s.Name][m.Entry] = true
+		}
+	}
+	var updates []MembershipUpdate
+	for _, fam := range []string{"inet", "inet6"} {
+		name := SetName(prefix, ref, fam)
+		u := MembershipUpdate{Set: name}
+		want := make(map[string]bool)
+		for _, s := range after {
+			if s.Name != name {
+				continue
+			}
+			for _, m := range s.Members {
+				want[m.Entry] = true
+				if !have[name][m.Entry] {
+					u.Add = append(u.Add, m.Entry)
+				}
+			}
+		}
+		for e := range have[name] {
+			if !want[e] {
+				u.Del = append(u.Del, e)
+			}
+		}
+		sort.Strings(u.Del)
+		if len(u.Add) > 0 || len(u.Del) > 0 {
+			updates = append(updates, u)
+		}
+	}
+	return updates, nil
+}
+
+// Apply creates the set described by spec with h if needed and atomically
+// replaces its content with the spec members, see ipset.IPSet.RefreshEntries.
+func Apply(h *ipset.Handle, spec SetSpec) error {
+	p := spec.Params
+	set, err := h.New(spec.Name, spec.Type, &p)
+	if err != nil {
+		return err
+	}
+	entries := make([]ipset.Entry, len(spec.Members))
+	for i, m := range spec.Members {
+		entries[i] = ipset.Entry{Element: m.Entry, EntryOptions: ipset.EntryOptions{Nomatch: m.Nomatch}}
+	}
+	return set.RefreshEntries(entries)
+}
+
+// ApplyUpdate adds and deletes the entries of u with h, in a single batch.
+func ApplyUpdate(h *ipset.Handle, u MembershipUpdate) error {
+	b := h.NewBatch()
+	for _, e := range u.Add {
+		b.Add(u.Set, ipset.Entry{Element: e})
+	}
+	for _, e := range u.Del {
+		b.Del(u.Set, e)
+	}
+	return b.Commit()
+}
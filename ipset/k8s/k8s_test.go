@@ -0,0 +1,141 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+var ref = PolicyRef{Owner: "agent", Namespace: "default", Policy: "allow-web", Peer: "ingress-0"}
+
+func TestSetName(t *testing.T) {
+	name := SetName("kp", ref, "inet")
+	if name != SetName("kp", ref, "inet") {
+		t.Error("SetName is not stable")
+	}
+	if !strings.HasPrefix(name, "kp-4-") {
+		t.Errorf("inet set name %s does not start with kp-4-", name)
+	}
+	if v6 := SetName("kp", ref, "inet6"); v6 == name || !strings.HasPrefix(v6, "kp-6-") {
+		t.Errorf("inet6 set name %s of inet set %s", v6, name)
+	}
+	other := ref
+	other.Peer = "ingress-1"
+	if SetName("kp", other, "inet") == name {
+		t.Error("different peers share a set name")
+	}
+	long := SetName(strings.Repeat("p", 40), ref, "inet")
+	if len(long) > MaxSetNameLen {
+		t.Errorf("set name %s is longer than %d characters", long, MaxSetNameLen)
+	}
+	if !strings.HasSuffix(long, strings.TrimPrefix(name, "kp")) {
+		t.Errorf("truncated set name %s lost the suffix of %s", long, name)
+	}
+}
+
+func TestFromIPBlocks(t *testing.T) {
+	specs, err := FromIPBlocks("kp", ref, []IPBlock{
+		{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}},
+		{CIDR: "2001:db8::/32"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("got %d specs, want one per family", len(specs))
+	}
+	v4, v6 := specs[0], specs[1]
+	if v4.Name != SetName("kp", ref, "inet") || v4.Type != "hash:net" || v4.Params.HashFamily != "inet" {
+		t.Errorf("inet spec %+v", v4)
+	}
+	want := []Member{{Entry: "10.0.0.0/8"}, {Entry: "10.1.0.0/16", Nomatch: true}}
+	if !reflect.DeepEqual(v4.Members, want) {
+		t.Errorf("inet members %+v, want %+v", v4.Members, want)
+	}
+	if v4.Labels[LabelPolicy] != ref.Policy || v4.Labels[LabelFamily] != "inet" {
+		t.Errorf("inet labels %v", v4.Labels)
+	}
+	if v6.Params.HashFamily != "inet6" || !reflect.DeepEqual(v6.Members, []Member{{Entry: "2001:db8::/32"}}) {
+		t.Errorf("inet6 spec %+v", v6)
+	}
+	for _, b := range []IPBlock{
+		{CIDR: "10.0.0.0"},
+		{CIDR: "10.0.0.0/8", Except: []string{"192.168.0.0/16"}},
+		{CIDR: "10.0.0.0/8", Except: []string{"bogus"}},
+	} {
+		if _, err := FromIPBlocks("kp", ref, []IPBlock{b}); err == nil {
+			t.Errorf("FromIPBlocks accepted %+v", b)
+		}
+	}
+}
+
+func TestPodIPUpdates(t *testing.T) {
+	updates, err := PodIPUpdates("kp", ref, []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"}, []string{"10.0.0.2", "10.0.0.3", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MembershipUpdate{{Set: SetName("kp", ref, "inet"), Add: []string{"10.0.0.3"}, Del: []string{"10.0.0.1"}}}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("got updates %+v, want %+v", updates, want)
+	}
+	if _, err := FromPodIPs("kp", ref, []string{"not-an-ip"}); err == nil {
+		t.Error("FromPodIPs accepted an invalid IP")
+	}
+}
+
+func TestApply(t *testing.T) {
+	var r ipset.NoopRecorder
+	h := ipset.NewHandle(ipset.WithNoopBackend(&r))
+	specs, err := FromIPBlocks("kp", ref, []IPBlock{{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(h, specs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !restored(r.Commands(), " 10.0.0.0/8\n") || !restored(r.Commands(), " 10.1.0.0/16 nomatch\n") {
+		t.Errorf("members not restored: %v", r.Commands())
+	}
+
+	r.Reset()
+	u := MembershipUpdate{Set: specs[0].Name, Add: []string{"10.0.0.1"}, Del: []string{"10.0.0.2", "10.0.0.3"}}
+	if err := ApplyUpdate(h, u); err != nil {
+		t.Fatal(err)
+	}
+	cmds := r.Commands()
+	if len(cmds) != 1 || cmds[0].Args[0] != "restore" {
+		t.Fatalf("update ran %v, want a single restore", cmds)
+	}
+	want := "add " + u.Set + " 10.0.0.1\ndel " + u.Set + " 10.0.0.2\ndel " + u.Set + " 10.0.0.3\n"
+	if cmds[0].Input != want {
+		t.Errorf("restored %q, want %q", cmds[0].Input, want)
+	}
+}
+
+// restored reports whether the input of one of the restore commands contains s.
+func restored(cmds []ipset.RecordedCommand, s string) bool {
+	for _, c := range cmds {
+		if c.Args[0] == "restore" && strings.Contains(c.Input, s) {
+			return true
+		}
+	}
+	return false
+}
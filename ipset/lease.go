@@ -0,0 +1,319 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// errNoRegistry is returned by the lease operations of a handle without a
+// metadata registry.
+var errNoRegistry = errors.New("no metadata registry")
+
+// Lease records that a live process owns a set: the owner renews it while it
+// runs, and once it is not renewed for TTL, the set is considered abandoned
+// and may be reclaimed by a Janitor.
+type Lease struct {
+	// Holder identifies the owning process, see DefaultLeaseHolder.
+	Holder string `json:"holder"`
+	// Renewed is when the lease was last renewed.
+	Renewed time.Time `json:"renewed"`
+	// TTL is how long the lease lasts without renewal.
+	TTL time.Duration `json:"ttl"`
+}
+
+// Expired reports whether the lease was not renewed for its TTL at now.
+func (l Lease) Expired(now time.Time) bool {
+	return now.Sub(l.Renewed) > l.TTL
+}
+
+// DefaultLeaseHolder returns the holder of the leases of the process,
+// "<hostname>/<pid>".
+func DefaultLeaseHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// DefaultLeaseTTL is the TTL of the leases of WithLease given none.
+const DefaultLeaseTTL = 3 * time.Minute
+
+// WithLease makes the handle record a lease of holder, DefaultLeaseHolder
+// when empty, in the metadata of the sets created through it, see
+// WithRegistry. The leases last ttl, DefaultLeaseTTL when zero, and are
+// renewed by RenewLeases, which a LeaseKeeper calls periodically. Processes
+// sharing the registry should share a lock too, see WithLock.
+func WithLease(holder string, ttl time.Duration) Option {
+	return func(h *Handle) {
+		if holder == "" {
+			holder = DefaultLeaseHolder()
+		}
+		if ttl <= 0 {
+			ttl = DefaultLeaseTTL
+		}
+		h.lease = &Lease{Holder: holder, TTL: ttl}
+	}
+}
+
+// newLease returns a lease of the handle renewed now, nil if it has none.
+func (h *Handle) newLease() *Lease {
+	if h.lease == nil {
+		return nil
+	}
+	l := *h.lease
+	l.Renewed = time.Now()
+	return &l
+}
+
+// RenewLeases renews the leases of the sets created through the handle. Sets
+// without metadata, e.g. reclaimed meanwhile, and sets leased by another
+// holder are logged and skipped. A *MultiError tells which leases could not
+// be renewed.
+func (h *Handle) RenewLeases() error {
+	if h.registry == nil {
+		return errNoRegistry
+	}
+	if h.lease == nil {
+		return nil
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	names := h.ownedSets()
+	errs := make([]error, len(names))
+	for i, name := range names {
+		errs[i] = h.renewLease(name)
+	}
+	return itemErrors("error renewing leases", names, errs)
+}
+
+func (h *Handle) renewLease(name string) error {
+	md, ok, err := h.registry.Get(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Warnf("No metadata for set %s, not renewing its lease", name)
+		return nil
+	}
+	if md.Lease != nil && md.Lease.Holder != h.lease.Holder {
+		log.Warnf("Set %s is leased by %s, not renewing its lease", name, md.Lease.Holder)
+		return nil
+	}
+	md.Lease = h.newLease()
+	return h.registry.Put(md)
+}
+
+// LeaseKeeper periodically renews the leases of the sets of a handle.
+type LeaseKeeper struct {
+	h        *Handle
+	interval time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLeaseKeeper returns a keeper renewing the leases of the handle every
+// interval once started, a third of their TTL when zero.
+func (h *Handle) NewLeaseKeeper(interval time.Duration) *LeaseKeeper {
+	if interval <= 0 && h.lease != nil {
+		interval = h.lease.TTL / 3
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &LeaseKeeper{h: h, interval: interval}
+}
+
+// Start starts renewing the leases. It does nothing if already started.
+func (k *LeaseKeeper) Start() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stop != nil {
+		return
+	}
+	k.stop, k.done = make(chan struct{}), make(chan struct{})
+	go k.loop(k.stop, k.done)
+}
+
+// Stop stops renewing the leases, which then expire unless the sets are
+// destroyed.
+func (k *LeaseKeeper) Stop() {
+	k.mu.Lock()
+	stop, done := k.stop, k.done
+	k.stop, k.done = nil, nil
+	k.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (k *LeaseKeeper) loop(stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(k.interval)
+	defer t.Stop()
+	for {
+		if err := k.h.RenewLeases(); err != nil {
+			log.Warnf("%v", err)
+		}
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Janitor destroys the sets whose lease expired, recorded in the metadata
+// registry of its handle, reclaiming the sets of owners that died. Sets
+// without a lease and sets whose owner still renews it are left alone, as are
+// the sets leased by the handle itself.
+type Janitor struct {
+	// DryRun reports the sets to reclaim without destroying them.
+	DryRun bool
+	// OnReclaim, if set, is called with the metadata of each set reclaimed.
+	OnReclaim func(SetMetadata)
+
+	h        *Handle
+	interval time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJanitor returns a janitor reclaiming the abandoned sets of the registry
+// of the handle every interval once started, every minute when zero.
+func (h *Handle) NewJanitor(interval time.Duration) *Janitor {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Janitor{h: h, interval: interval}
+}
+
+// Reclaim destroys the sets whose lease expired and returns their names. A
+// *MultiError tells which sets could not be destroyed, e.g. because a rule
+// still references them.
+func (j *Janitor) Reclaim() ([]string, error) {
+	h := j.h
+	if h.registry == nil {
+		return nil, errNoRegistry
+	}
+	// renewals take the lock too: a lease seen expired stays so
+	unlock, err := h.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	mds, err := h.registry.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing set metadata: %w", err)
+	}
+	now := time.Now()
+	var reclaimed []string
+	var errs []*ItemError
+	for _, md := range mds {
+		if md.Lease == nil || !md.Lease.Expired(now) || h.checkOwned(md.Set) != nil {
+			continue
+		}
+		if h.lease != nil && md.Lease.Holder == h.lease.Holder {
+			continue
+		}
+		if !j.DryRun {
+			if err := j.reclaim(md.Set); err != nil {
+				errs = append(errs, &ItemError{Set: md.Set, Err: err})
+				continue
+			}
+		}
+		verb := "Reclaimed"
+		if j.DryRun {
+			verb = "Would reclaim"
+		}
+		log.Infof("%s set %s of %s, lease expired %v ago", verb, md.Set, md.Lease.Holder,
+			now.Sub(md.Lease.Renewed.Add(md.Lease.TTL)).Round(time.Second))
+		if j.OnReclaim != nil {
+			j.OnReclaim(md)
+		}
+		reclaimed = append(reclaimed, md.Set)
+	}
+	if len(errs) != 0 {
+		return reclaimed, &MultiError{Msg: "error reclaiming sets", Errors: errs}
+	}
+	return reclaimed, nil
+}
+
+// reclaim destroys the named set and forgets its metadata, which is kept by
+// the registry update of a failed destroy, e.g. of a set already gone.
+func (j *Janitor) reclaim(name string) error {
+	defer j.h.lockSet(name)()
+	if err := j.h.destroyChecked(name); err != nil {
+		return err
+	}
+	return j.h.registry.Delete(name)
+}
+
+// Start starts reclaiming sets periodically. It does nothing if already
+// started.
+func (j *Janitor) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		return
+	}
+	j.stop, j.done = make(chan struct{}), make(chan struct{})
+	go j.loop(j.stop, j.done)
+}
+
+// Stop ends the periodic reclaiming.
+func (j *Janitor) Stop() {
+	j.mu.Lock()
+	stop, done := j.stop, j.done
+	j.stop, j.done = nil, nil
+	j.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (j *Janitor) loop(stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		if _, err := j.Reclaim(); err != nil {
+			log.Warnf("%v", err)
+		}
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
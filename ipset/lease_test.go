@@ -0,0 +1,148 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memRegistry is a MetadataRegistry kept in memory.
+type memRegistry struct {
+	mu  sync.Mutex
+	mds map[string]SetMetadata
+}
+
+func (r *memRegistry) Get(set string) (SetMetadata, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	md, ok := r.mds[set]
+	return md, ok, nil
+}
+
+func (r *memRegistry) Put(md SetMetadata) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mds == nil {
+		r.mds = make(map[string]SetMetadata)
+	}
+	r.mds[md.Set] = md
+	return nil
+}
+
+func (r *memRegistry) Delete(set string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.mds, set)
+	return nil
+}
+
+func (r *memRegistry) List() ([]SetMetadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []SetMetadata
+	for _, md := range r.mds {
+		list = append(list, md)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Set < list[j].Set })
+	return list, nil
+}
+
+func TestJanitorReclaim(t *testing.T) {
+	stale := time.Now().Add(-time.Hour)
+	reg := &memRegistry{}
+	for _, md := range []SetMetadata{
+		{Set: "dead", Lease: &Lease{Holder: "other/1", Renewed: stale, TTL: time.Minute}},
+		{Set: "mine", Lease: &Lease{Holder: "me/2", Renewed: stale, TTL: time.Minute}},
+		{Set: "alive", Lease: &Lease{Holder: "other/3", Renewed: time.Now(), TTL: time.Hour}},
+		{Set: "unleased"},
+	} {
+		reg.Put(md)
+	}
+	var r NoopRecorder
+	j := NewHandle(WithNoopBackend(&r), WithRegistry(reg), WithLease("me/2", time.Minute)).NewJanitor(0)
+
+	j.DryRun = true
+	reclaimed, err := j.Reclaim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reclaimed, []string{"dead"}) {
+		t.Errorf("dry run reclaimed %v, want [dead]", reclaimed)
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("dry run ran %v", cmds)
+	}
+	if _, ok, _ := reg.Get("dead"); !ok {
+		t.Error("dry run forgot the metadata of dead")
+	}
+
+	j.DryRun = false
+	if reclaimed, err = j.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reclaimed, []string{"dead"}) {
+		t.Errorf("reclaimed %v, want [dead]", reclaimed)
+	}
+	if cmds := r.Commands(); len(cmds) != 1 || cmds[0].String() != "ipset destroy dead" {
+		t.Errorf("reclaiming ran %v", cmds)
+	}
+	if _, ok, _ := reg.Get("dead"); ok {
+		t.Error("metadata of the reclaimed set kept")
+	}
+	for _, set := range []string{"mine", "alive", "unleased"} {
+		if _, ok, _ := reg.Get(set); !ok {
+			t.Errorf("metadata of %s forgotten", set)
+		}
+	}
+}
+
+func TestRenewLeases(t *testing.T) {
+	reg := &memRegistry{}
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r), WithRegistry(reg), WithLease("me/2", 3*time.Minute))
+	for _, name := range []string{"app-a", "app-b"} {
+		if _, _, err := h.Create(name, "hash:ip", &Params{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale := time.Now().Add(-time.Hour)
+	held := &Lease{Holder: "other/1", Renewed: stale, TTL: time.Minute}
+	reg.Put(SetMetadata{Set: "app-a", Lease: &Lease{Holder: "me/2", Renewed: stale, TTL: 3 * time.Minute}})
+	reg.Put(SetMetadata{Set: "app-b", Lease: held})
+
+	k := h.NewLeaseKeeper(0)
+	if k.interval != time.Minute {
+		t.Errorf("keeper interval %v, want a third of the TTL", k.interval)
+	}
+	// the keeper renews the leases as soon as started
+	k.Start()
+	k.Stop()
+	if md, _, _ := reg.Get("app-a"); md.Lease == nil || !md.Lease.Renewed.After(stale) || md.Lease.Expired(time.Now()) {
+		t.Errorf("lease of app-a not renewed: %+v", md.Lease)
+	}
+	if md, _, _ := reg.Get("app-b"); !reflect.DeepEqual(md.Lease, held) {
+		t.Errorf("lease of another holder renewed: %+v", md.Lease)
+	}
+
+	if err := NewHandle(WithNoopBackend(&r)).RenewLeases(); err != errNoRegistry {
+		t.Errorf("renewing without a registry: error %v, want %v", err, errNoRegistry)
+	}
+}
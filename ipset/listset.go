@@ -0,0 +1,60 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+// TestResult is the detailed result of testing an entry.
+type TestResult struct {
+	// Found tells whether the entry is in the set.
+	Found bool
+	// Set is the set the entry was found in: the tested set itself or, for
+	// a list:set, the first member set containing the entry, the one the
+	// kernel matches. It is empty if the entry was not found.
+	Set string
+}
+
+// TestDetailed tests whether the entry is in the set and reports where it was
+// found. The kernel only tells whether any member of a list:set matches, so
+// for a list:set the member sets are tested in the order of the list, which
+// is the order the kernel tries them in.
+func (s *IPSet) TestDetailed(entry string) (TestResult, error) {
+	h := s.handle()
+	hd, err := h.header(s.Name)
+	if err != nil {
+		return TestResult{}, err
+	}
+	if hd.Type != "list:set" {
+		found, err := s.Test(entry)
+		if err != nil || !found {
+			return TestResult{}, err
+		}
+		return TestResult{Found: true, Set: s.Name}, nil
+	}
+	members, err := s.Members()
+	if err != nil {
+		return TestResult{}, err
+	}
+	for _, member := range members {
+		found, err := (&IPSet{Name: member, h: h}).Test(entry)
+		if err != nil {
+			return TestResult{}, err
+		}
+		if found {
+			return TestResult{Found: true, Set: member}, nil
+		}
+	}
+	return TestResult{}, nil
+}
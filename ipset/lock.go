@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultLockPath is the lock file WithLock uses when given an empty path.
+const DefaultLockPath = "/run/go-ipset.lock"
+
+// mutatingCommands are the ipset commands taken under the lock of a handle.
+var mutatingCommands = map[string]bool{
+	"create":  true,
+	"destroy": true,
+	"add":     true,
+	"del":     true,
+	"flush":   true,
+	"rename":  true,
+	"swap":    true,
+	"restore": true,
+}
+
+// WithLock takes an exclusive flock(2) on the file at path, created if
+// needed, around the mutating commands of the handle and around operations
+// made of several of them, such as Refresh, so that processes sharing the
+// lock file do not interleave swaps and restores on the same sets. Cooperating
+// programs, including shell scripts using flock(1), must use the same path;
+// DefaultLockPath is used when path is empty. The lock is shared by the
+// goroutines of the process and held by a Session while its restore process
+// runs, that is until Sync.
+func WithLock(path string) Option {
+	if path == "" {
+		path = DefaultLockPath
+	}
+	return func(h *Handle) {
+		h.flock = &fileLock{path: path}
+	}
+}
+
+// fileLock is a flock on a file, counting its holders in the process so that
+// nested operations do not lock themselves out.
+type fileLock struct {
+	path string
+
+	mu      sync.Mutex
+	holders int
+	f       *os.File
+}
+
+// acquire takes the lock, blocking until no other process holds it.
+func (l *fileLock) acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders == 0 {
+		f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("error opening lock file %s: %w", l.path, err)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("error locking %s: %w", l.path, err)
+		}
+		l.f = f
+	}
+	l.holders++
+	return nil
+}
+
+// release drops the lock once its last holder in the process released it.
+func (l *fileLock) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders--; l.holders == 0 {
+		// closing the file drops the flock
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+// lock takes the lock of the handle, if any, and returns the function
+// releasing it.
+func (h *Handle) lock() (func(), error) {
+	if h.flock == nil {
+		return func() {}, nil
+	}
+	if err := h.flock.acquire(); err != nil {
+		return nil, err
+	}
+	return h.flock.release, nil
+}
+
+// lockFor takes the lock of the handle if the ipset command with the given
+// arguments changes sets.
+func (h *Handle) lockFor(args []string) (func(), error) {
+	if len(args) == 0 || !mutatingCommands[args[0]] {
+		return func() {}, nil
+	}
+	return h.lock()
+}
@@ -0,0 +1,77 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithLock(t *testing.T) {
+	if _, err := exec.LookPath("flock"); err != nil {
+		t.Skip("flock(1) not available")
+	}
+	lock := filepath.Join(t.TempDir(), "ipset.lock")
+	// the stub logs whether the lock is held while it runs
+	stub := fmt.Sprintf(`#!/bin/sh
+if flock -n %q true; then state=free; else state=locked; fi
+echo "$1 $state" >>"$(dirname "$0")/log"
+cat >/dev/null
+`, lock)
+	h := stubHandle(t, stub, WithLock(lock))
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	s := h.Set("app-a")
+	if err := s.Add("192.0.2.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.run("list", "-n"); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadFile(log); string(out) != "add locked\nlist free\n" {
+		t.Errorf("commands ran as %q, want the add under the lock and the list without", out)
+	}
+
+	// another handle sharing the lock file waits for it
+	unlock, err := h.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := stubHandle(t, stub, WithLock(lock))
+	done := make(chan error, 1)
+	go func() { done <- other.Set("app-a").Add("192.0.2.2", 0) }()
+	select {
+	case err := <-done:
+		t.Fatalf("add ran while the lock was held, error %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("add still waiting once the lock was released")
+	}
+}
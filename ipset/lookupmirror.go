@@ -0,0 +1,221 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// LookupMirror answers Contains for a hash:net (or hash:ip) set in process,
+// with the longest-prefix semantics of the kernel: an address matches the most
+// specific network of the set containing it unless that network is a nomatch
+// entry. A lookup costs one map probe per distinct prefix length in the set
+// and runs no command, so it suits code paths that cannot exec ipset.
+//
+// The networks are loaded on creation and kept up to date with the mutations
+// of the set made through its handle: additions and deletions are applied in
+// place, while swaps, refreshes, flushes and failed commands reload the set.
+// If an interval is given, the set is also reloaded periodically, to pick up
+// changes made by other processes or by the kernel (e.g. expired entries).
+type LookupMirror struct {
+	set *IPSet
+
+	mu sync.RWMutex
+	// hd is the header of the set when last loaded
+	hd       Header
+	m        *matcher
+	size     int
+	lastSync time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLookupMirror loads the networks of s and returns a LookupMirror of it. If
+// interval is positive, the set is reloaded at that interval until
+// Close is called.
+func NewLookupMirror(s *IPSet, interval time.Duration) (*LookupMirror, error) {
+	m := &LookupMirror{set: s, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := m.Resync(); err != nil {
+		return nil, err
+	}
+	s.handle().addLookupMirror(m)
+	if interval > 0 {
+		go m.loop(interval)
+	} else {
+		close(m.done)
+	}
+	return m, nil
+}
+
+func (m *LookupMirror) loop(interval time.Duration) {
+	defer close(m.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.Resync(); err != nil {
+				log.Warnf("Error resynchronizing lookup mirror of set %s: %v", m.set.Name, err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic reload and detaches the mirror from the set.
+func (m *LookupMirror) Close() {
+	m.set.handle().removeLookupMirror(m)
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	<-m.done
+}
+
+// Resync lists the set and rebuilds the lookup structure from its entries.
+func (m *LookupMirror) Resync() error {
+	hd, entries, err := m.set.handle().snapshot(m.set.Name)
+	if err != nil {
+		return err
+	}
+	m.load(hd, entries)
+	return nil
+}
+
+// load replaces the lookup structure with one of the entries.
+func (m *LookupMirror) load(hd Header, entries []Entry) {
+	mt := newMatcher(hd, entries)
+	m.mu.Lock()
+	m.hd, m.m, m.size = hd, mt, len(entries)
+	m.lastSync = time.Now()
+	m.mu.Unlock()
+}
+
+// Contains reports whether the set matches entry, an address or a network, as
+// ContainsAll does.
+func (m *LookupMirror) Contains(entry string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.m.contains(entry)
+}
+
+// Len returns the number of entries of the set, nomatch ones included.
+func (m *LookupMirror) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.size
+}
+
+// LastSync returns when the set was last loaded from the kernel.
+func (m *LookupMirror) LastSync() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastSync
+}
+
+// apply updates the mirror with a mutation of the set. The addresses and
+// networks added or deleted are applied in place, other mutations reload the
+// set.
+func (m *LookupMirror) apply(c change) {
+	m.mu.Lock()
+	switch {
+	case c.err != nil:
+		// a failed restore may have applied part of its commands
+	case c.op == opDestroy || c.op == opDestroyAll:
+		m.m, m.size = newMatcher(m.hd, nil), 0
+		m.mu.Unlock()
+		return
+	case c.op == opAdd && m.m.prefixes(c.entries):
+		nomatch := make(map[string]bool, len(c.nomatch))
+		for _, e := range c.nomatch {
+			nomatch[e] = true
+		}
+		for _, e := range c.entries {
+			if m.m.add(Entry{Element: e, EntryOptions: EntryOptions{Nomatch: nomatch[e]}}) {
+				m.size++
+			}
+		}
+		m.mu.Unlock()
+		return
+	case c.op == opDel && m.m.prefixes(c.entries):
+		for _, e := range c.entries {
+			if m.m.del(e) {
+				m.size--
+			}
+		}
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+	if err := m.Resync(); err != nil {
+		log.Warnf("Error resynchronizing lookup mirror of set %s: %v", m.set.Name, err)
+	}
+}
+
+func (h *Handle) addLookupMirror(m *LookupMirror) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lookups == nil {
+		h.lookups = make(map[string][]*LookupMirror)
+	}
+	h.lookups[m.set.Name] = append(h.lookups[m.set.Name], m)
+}
+
+func (h *Handle) removeLookupMirror(m *LookupMirror) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ms := h.lookups[m.set.Name]
+	for i := range ms {
+		if ms[i] == m {
+			h.lookups[m.set.Name] = append(ms[:i], ms[i+1:]...)
+			break
+		}
+	}
+	if len(h.lookups[m.set.Name]) == 0 {
+		delete(h.lookups, m.set.Name)
+	}
+}
+
+// updateLookupMirrors applies a mutation to the lookup mirrors of the sets it
+// involved.
+func (h *Handle) updateLookupMirrors(c change) {
+	if c.op == opCreate {
+		return
+	}
+	h.mu.Lock()
+	var ms []*LookupMirror
+	switch c.op {
+	case opDestroyAll:
+		for _, l := range h.lookups {
+			ms = append(ms, l...)
+		}
+	case opSwap:
+		ms = append(append(ms, h.lookups[c.set]...), h.lookups[c.other]...)
+	default:
+		ms = append(ms, h.lookups[c.set]...)
+	}
+	h.mu.Unlock()
+	for _, m := range ms {
+		m.apply(c)
+	}
+}
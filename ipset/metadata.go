@@ -0,0 +1,287 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// SetMetadata describes who manages a set and why. ipset itself keeps no
+// such information, it is recorded in a MetadataRegistry.
+type SetMetadata struct {
+	Set string `json:"set"`
+	// Owner is the service managing the set.
+	Owner string `json:"owner,omitempty"`
+	// Purpose tells what the set is used for.
+	Purpose string `json:"purpose,omitempty"`
+	// Source is the feed the entries of the set come from.
+	Source string `json:"source,omitempty"`
+	// Created is when the set was created, filled in by Create.
+	Created time.Time `json:"created"`
+	// Tags holds free-form labels.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Namespace and Parts are the arguments of SetName the name of the set
+	// was generated with, if any.
+	Namespace string   `json:"namespace,omitempty"`
+	Parts     []string `json:"parts,omitempty"`
+	// Lease is the lease of the process owning the set, nil if none, see
+	// WithLease.
+	Lease *Lease `json:"lease,omitempty"`
+}
+
+// MetadataRegistry stores the metadata of sets by name.
+type MetadataRegistry interface {
+	// Get returns the metadata of the set, false if none is recorded.
+	Get(set string) (SetMetadata, bool, error)
+	// Put records the metadata of md.Set, replacing any.
+	Put(md SetMetadata) error
+	// Delete forgets the metadata of the set.
+	Delete(set string) error
+	// List returns the metadata of all sets, sorted by name.
+	List() ([]SetMetadata, error)
+}
+
+// WithRegistry records the metadata of the sets created through the handle,
+// given in Params.Metadata, in r, and forgets it when they are destroyed.
+func WithRegistry(r MetadataRegistry) Option {
+	return func(h *Handle) {
+		h.registry = r
+	}
+}
+
+// Metadata returns the metadata recorded for the set by the registry of the
+// handle, false if none is or the handle has no registry.
+func (h *Handle) Metadata(set string) (SetMetadata, bool, error) {
+	if h.registry == nil {
+		return SetMetadata{}, false, nil
+	}
+	return h.registry.Get(set)
+}
+
+// ListMetadata returns the metadata recorded by the registry of the handle
+// for which match returns true, all of it if match is nil.
+func (h *Handle) ListMetadata(match func(SetMetadata) bool) ([]SetMetadata, error) {
+	if h.registry == nil {
+		return nil, nil
+	}
+	all, err := h.registry.List()
+	if err != nil || match == nil {
+		return all, err
+	}
+	var mds []SetMetadata
+	for _, md := range all {
+		if match(md) {
+			mds = append(mds, md)
+		}
+	}
+	return mds, nil
+}
+
+// putMetadata records md for the named set, created through the handle or
+// adopted by it, along with the lease of the handle if any. The creation time
+// of an adopted set is kept if known, and so is the rest of its metadata when
+// md is nil, as well as an unexpired lease of another holder, so that the
+// set is not reclaimed while its creator still runs.
+func (h *Handle) putMetadata(name string, md *SetMetadata, created bool) error {
+	if h.registry == nil || (md == nil && h.lease == nil) {
+		return nil
+	}
+	var old SetMetadata
+	known := false
+	if !created {
+		if o, ok, err := h.registry.Get(name); err == nil && ok {
+			old, known = o, true
+		}
+	}
+	rec := old
+	if md != nil {
+		rec = *md
+	}
+	rec.Set = name
+	if rec.Created.IsZero() {
+		if known {
+			rec.Created = old.Created
+		} else {
+			rec.Created = time.Now()
+		}
+	}
+	if l := h.newLease(); l != nil {
+		if known && old.Lease != nil && old.Lease.Holder != l.Holder && !old.Lease.Expired(l.Renewed) {
+			rec.Lease = old.Lease
+		} else {
+			rec.Lease = l
+		}
+	}
+	if err := h.registry.Put(rec); err != nil {
+		return fmt.Errorf("error recording metadata of set %s: %w", name, err)
+	}
+	return nil
+}
+
+// updateRegistry forgets the metadata of destroyed sets.
+func (h *Handle) updateRegistry(c change) {
+	if h.registry == nil || c.err != nil {
+		return
+	}
+	var sets []string
+	switch c.op {
+	case opDestroy:
+		sets = []string{c.set}
+	case opDestroyAll:
+		all, err := h.registry.List()
+		if err != nil {
+			log.Warnf("Error listing set metadata: %v", err)
+			return
+		}
+		for _, md := range all {
+			sets = append(sets, md.Set)
+		}
+	}
+	for _, set := range sets {
+		if err := h.registry.Delete(set); err != nil {
+			log.Warnf("Error deleting metadata of set %s: %v", set, err)
+		}
+	}
+}
+
+// FileRegistry is a MetadataRegistry kept in a JSON manifest file, rewritten
+// atomically on every change. It is safe for concurrent use within a
+// process; processes sharing the file should also share a lock, see WithLock.
+type FileRegistry struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileRegistry returns a registry kept in the file at path, created by
+// the first change.
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{path: path}
+}
+
+// Get implements MetadataRegistry.
+func (r *FileRegistry) Get(set string) (SetMetadata, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mds, err := r.load()
+	if err != nil {
+		return SetMetadata{}, false, err
+	}
+	md, ok := mds[set]
+	return md, ok, nil
+}
+
+// Put implements MetadataRegistry.
+func (r *FileRegistry) Put(md SetMetadata) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mds, err := r.load()
+	if err != nil {
+		return err
+	}
+	mds[md.Set] = md
+	return r.store(mds)
+}
+
+// Delete implements MetadataRegistry.
+func (r *FileRegistry) Delete(set string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mds, err := r.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := mds[set]; !ok {
+		return nil
+	}
+	delete(mds, set)
+	return r.store(mds)
+}
+
+// List implements MetadataRegistry.
+func (r *FileRegistry) List() ([]SetMetadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mds, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]SetMetadata, 0, len(mds))
+	for _, md := range mds {
+		list = append(list, md)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Set < list[j].Set })
+	return list, nil
+}
+
+func (r *FileRegistry) load() (map[string]SetMetadata, error) {
+	mds := make(map[string]SetMetadata)
+	data, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return mds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []SetMetadata
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing set metadata %s: %w", r.path, err)
+	}
+	for _, md := range list {
+		mds[md.Set] = md
+	}
+	return mds, nil
+}
+
+func (r *FileRegistry) store(mds map[string]SetMetadata) error {
+	list := make([]SetMetadata, 0, len(mds))
+	for _, md := range mds {
+		list = append(list, md)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Set < list[j].Set })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), r.path)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing set metadata %s: %w", r.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes the per-entry counters sampled by an
+// ipset.CounterCollector as Prometheus metrics.
+//
+//	cc := ipset.NewCounterCollector(time.Minute, blocked)
+//	cc.MaxEntries = 100
+//	cc.Start()
+//	prometheus.MustRegister(metrics.NewCollector(cc))
+package metrics
+
+import (
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	packetsDesc = prometheus.NewDesc("ipset_entry_packets_total",
+		"Packets matched by a set entry.", []string{"set", "entry"}, nil)
+	bytesDesc = prometheus.NewDesc("ipset_entry_bytes_total",
+		"Bytes matched by a set entry.", []string{"set", "entry"}, nil)
+	// the sums of the entries beyond MaxEntries drop as entries move out of
+	// them, so they are not counters
+	otherPacketsDesc = prometheus.NewDesc("ipset_other_entries_packets",
+		"Packets matched by the entries of a set not reported one by one.", []string{"set"}, nil)
+	otherBytesDesc = prometheus.NewDesc("ipset_other_entries_bytes",
+		"Bytes matched by the entries of a set not reported one by one.", []string{"set"}, nil)
+)
+
+// Collector is a prometheus.Collector reporting the latest samples of a
+// CounterCollector. The label cardinality is bounded by the MaxEntries and
+// Filter settings of the CounterCollector; the ipset.OtherEntry sample of
+// the entries beyond MaxEntries is reported as gauges of its own.
+type Collector struct {
+	cc *ipset.CounterCollector
+}
+
+// NewCollector returns a Collector for cc. Scrapes report the samples of the
+// last periodic pass of cc, so scraping never runs ipset itself.
+func NewCollector(cc *ipset.CounterCollector) *Collector {
+	return &Collector{cc: cc}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- packetsDesc
+	ch <- bytesDesc
+	ch <- otherPacketsDesc
+	ch <- otherBytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.cc.Latest() {
+		if s.Entry == ipset.OtherEntry {
+			ch <- prometheus.MustNewConstMetric(otherPacketsDesc, prometheus.GaugeValue, float64(s.Packets), s.Set)
+			ch <- prometheus.MustNewConstMetric(otherBytesDesc, prometheus.GaugeValue, float64(s.Bytes), s.Set)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(packetsDesc, prometheus.CounterValue, float64(s.Packets), s.Set, s.Entry)
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(s.Bytes), s.Set, s.Entry)
+	}
+}
@@ -0,0 +1,112 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeIpset is an ipset listing the set blocked with counters.
+const fakeIpset = `#!/bin/sh
+members='192.0.2.1 packets 5 bytes 500
+192.0.2.2 packets 7 bytes 900
+192.0.2.3 packets 1 bytes 60'
+case " $* " in
+*" --version "*|*" version "*) echo "ipset v7.1, protocol version: 7" ;;
+*" save "*)
+	echo "create blocked hash:ip family inet hashsize 1024 maxelem 65536 counters"
+	echo "$members" | sed 's/^/add blocked /'
+	;;
+*" -t "*) printf 'Name: blocked\nType: hash:ip\nRevision: 4\nHeader: family inet hashsize 1024 maxelem 65536 counters\nNumber of entries: 3\nMembers:\n' ;;
+*" list "*) printf 'Name: blocked\nType: hash:ip\nRevision: 4\nHeader: family inet hashsize 1024 maxelem 65536 counters\nNumber of entries: 3\nMembers:\n%s\n' "$members" ;;
+esac
+`
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ipset"), []byte(fakeIpset), 0755); err != nil {
+		panic(err)
+	}
+	// the package looks ipset up once, so every test runs the same one
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// sampledCollector returns a CounterCollector of the set blocked of
+// fakeIpset after one sampling pass.
+func sampledCollector(t *testing.T, maxEntries int) *ipset.CounterCollector {
+	t.Helper()
+	cc := ipset.NewCounterCollector(0, &ipset.IPSet{Name: "blocked"})
+	cc.MaxEntries = maxEntries
+	if _, err := cc.Sample(); err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector(sampledCollector(t, 0))
+	want := `
+# HELP ipset_entry_bytes_total Bytes matched by a set entry.
+# TYPE ipset_entry_bytes_total counter
+ipset_entry_bytes_total{entry="192.0.2.1",set="blocked"} 500
+ipset_entry_bytes_total{entry="192.0.2.2",set="blocked"} 900
+ipset_entry_bytes_total{entry="192.0.2.3",set="blocked"} 60
+# HELP ipset_entry_packets_total Packets matched by a set entry.
+# TYPE ipset_entry_packets_total counter
+ipset_entry_packets_total{entry="192.0.2.1",set="blocked"} 5
+ipset_entry_packets_total{entry="192.0.2.2",set="blocked"} 7
+ipset_entry_packets_total{entry="192.0.2.3",set="blocked"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorOtherEntries(t *testing.T) {
+	c := NewCollector(sampledCollector(t, 1))
+	want := `
+# HELP ipset_entry_packets_total Packets matched by a set entry.
+# TYPE ipset_entry_packets_total counter
+ipset_entry_packets_total{entry="192.0.2.2",set="blocked"} 7
+# HELP ipset_other_entries_bytes Bytes matched by the entries of a set not reported one by one.
+# TYPE ipset_other_entries_bytes gauge
+ipset_other_entries_bytes{set="blocked"} 560
+# HELP ipset_other_entries_packets Packets matched by the entries of a set not reported one by one.
+# TYPE ipset_other_entries_packets gauge
+ipset_other_entries_packets{set="blocked"} 6
+`
+	names := []string{"ipset_entry_packets_total", "ipset_other_entries_bytes", "ipset_other_entries_packets"}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// Mirror maintains an in-memory copy of the members of a set, answering
+// Contains, Len and Snapshot without running ipset. The copy is loaded on
+// creation, updated by mutations made through the handle of the set and, if
+// an interval is given, resynchronized periodically to pick up changes made
+// by other processes or by the kernel (e.g. expired entries).
+//
+// Entries are matched verbatim against the members as ipset lists them, so
+// mutations should use the canonical form (e.g. "10.0.0.0/8", not "10.0.0.1/8")
+// for the copy to stay exact between resynchronizations.
+type Mirror struct {
+	set *IPSet
+
+	mu       sync.RWMutex
+	members  map[string]struct{}
+	lastSync time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewMirror loads the members of s and returns a Mirror of it. If interval is
+// positive, the copy is resynchronized with the kernel at that interval until
+// Close is called.
+func NewMirror(s *IPSet, interval time.Duration) (*Mirror, error) {
+	m := &Mirror{set: s, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := m.Resync(); err != nil {
+		return nil, err
+	}
+	s.handle().addMirror(m)
+	if interval > 0 {
+		go m.loop(interval)
+	} else {
+		close(m.done)
+	}
+	return m, nil
+}
+
+func (m *Mirror) loop(interval time.Duration) {
+	defer close(m.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.Resync(); err != nil {
+				log.Warnf("Error resynchronizing mirror of set %s: %v", m.set.Name, err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic resynchronization and detaches the mirror from the set.
+func (m *Mirror) Close() {
+	m.set.handle().removeMirror(m)
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	<-m.done
+}
+
+// Resync reloads the members of the set.
+func (m *Mirror) Resync() error {
+	elems, err := m.set.handle().members(m.set.Name)
+	if err != nil {
+		return err
+	}
+	m.setMembers(elems)
+	return nil
+}
+
+// setMembers replaces the copy with elems.
+func (m *Mirror) setMembers(elems []string) {
+	members := make(map[string]struct{}, len(elems))
+	for _, e := range elems {
+		members[e] = struct{}{}
+	}
+	m.mu.Lock()
+	m.members = members
+	m.lastSync = time.Now()
+	m.mu.Unlock()
+}
+
+// Contains reports whether entry is a member of the set.
+func (m *Mirror) Contains(entry string) bool {
+	m.mu.RLock()
+	_, ok := m.members[entry]
+	m.mu.RUnlock()
+	return ok
+}
+
+// Len returns the number of members of the set.
+func (m *Mirror) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.members)
+}
+
+// Snapshot returns the members of the set, sorted.
+func (m *Mirror) Snapshot() []string {
+	m.mu.RLock()
+	elems := make([]string, 0, len(m.members))
+	for e := range m.members {
+		elems = append(elems, e)
+	}
+	m.mu.RUnlock()
+	sort.Strings(elems)
+	return elems
+}
+
+// LastSync returns when the copy was last loaded from the kernel.
+func (m *Mirror) LastSync() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastSync
+}
+
+// apply updates the copy with a mutation of the set.
+func (m *Mirror) apply(c change) {
+	if c.op == opSwap {
+		// the content came from another set: reload it
+		if err := m.Resync(); err != nil {
+			log.Warnf("Error resynchronizing mirror of set %s: %v", m.set.Name, err)
+		}
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch c.op {
+	case opAdd:
+		for _, e := range c.entries {
+			m.members[e] = struct{}{}
+		}
+	case opDel:
+		for _, e := range c.entries {
+			delete(m.members, e)
+		}
+	case opFlush, opDestroy, opDestroyAll:
+		m.members = make(map[string]struct{})
+	}
+}
+
+func (h *Handle) addMirror(m *Mirror) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.mirrors == nil {
+		h.mirrors = make(map[string][]*Mirror)
+	}
+	h.mirrors[m.set.Name] = append(h.mirrors[m.set.Name], m)
+}
+
+func (h *Handle) removeMirror(m *Mirror) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ms := h.mirrors[m.set.Name]
+	for i := range ms {
+		if ms[i] == m {
+			h.mirrors[m.set.Name] = append(ms[:i], ms[i+1:]...)
+			break
+		}
+	}
+	if len(h.mirrors[m.set.Name]) == 0 {
+		delete(h.mirrors, m.set.Name)
+	}
+}
+
+// updateMirrors applies a successful mutation to the mirrors of the sets involved.
+func (h *Handle) updateMirrors(c change) {
+	if c.err != nil {
+		return
+	}
+	h.mu.Lock()
+	var ms []*Mirror
+	switch c.op {
+	case opDestroyAll:
+		for _, l := range h.mirrors {
+			ms = append(ms, l...)
+		}
+	case opSwap:
+		ms = append(append(ms, h.mirrors[c.set]...), h.mirrors[c.other]...)
+	default:
+		ms = append(ms, h.mirrors[c.set]...)
+	}
+	h.mu.Unlock()
+	for _, m := range ms {
+		m.apply(c)
+	}
+}
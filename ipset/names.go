@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// setNameHashLen is the number of hex digits of the hash ending the names
+// SetName shortens.
+const setNameHashLen = 8
+
+// SetName returns the name of the set identified by parts in namespace,
+// joined with "-". A name longer than the kernel limit of 31 characters is
+// cut and ends with a hash of the full name instead, so that distinct parts
+// give distinct names; as parts are joined as is, ("a-b") and ("a", "b") do
+// not. Record the namespace and parts in the metadata of the
+// set to resolve the name back, see ResolveSetName.
+func SetName(namespace string, parts ...string) string {
+	name := strings.Join(append([]string{namespace}, parts...), "-")
+	if len(name) <= maxSetNameLen {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:maxSetNameLen-setNameHashLen-1] + "-" + hex.EncodeToString(sum[:])[:setNameHashLen]
+}
+
+// ResolveSetName returns the namespace and parts the named set was named
+// after by SetName, as recorded in the registry of the handle, false if they
+// are unknown.
+func (h *Handle) ResolveSetName(name string) (namespace string, parts []string, ok bool, err error) {
+	md, ok, err := h.Metadata(name)
+	if err != nil || !ok || md.Namespace == "" {
+		return "", nil, false, err
+	}
+	return md.Namespace, md.Parts, true, nil
+}
+
+// ListSetNames returns the names of the existing sets filter accepts, all of
+// them if filter is nil, without listing their content.
+func ListSetNames(filter func(name string) bool) ([]string, error) {
+	return defaultHandle.ListSetNames(filter)
+}
+
+// ListSetNames returns the names of the sets of the handle, see ListSetNames.
+func (h *Handle) ListSetNames(filter func(name string) bool) ([]string, error) {
+	if err := h.initCheck(); err != nil {
+		return nil, err
+	}
+	names, err := h.listAllSetNames()
+	if err != nil || filter == nil {
+		return names, err
+	}
+	kept := names[:0]
+	for _, name := range names {
+		if filter(name) {
+			kept = append(kept, name)
+		}
+	}
+	return kept, nil
+}
+
+// PrefixFilter returns a ListSetNames filter accepting the names starting
+// with any of the prefixes.
+func PrefixFilter(prefixes ...string) func(string) bool {
+	return func(name string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RegexpFilter returns a ListSetNames filter accepting the names re matches.
+func RegexpFilter(re *regexp.Regexp) func(string) bool {
+	return re.MatchString
+}
+
+// GlobFilter returns a ListSetNames filter accepting the names matching any
+// of the patterns, in the syntax of path.Match. Malformed patterns match
+// nothing.
+func GlobFilter(patterns ...string) func(string) bool {
+	return func(name string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSetName(t *testing.T) {
+	if got := SetName("fw", "tenant1", "blocked"); got != "fw-tenant1-blocked" {
+		t.Errorf("got %s, want fw-tenant1-blocked", got)
+	}
+	long := strings.Repeat("x", maxSetNameLen)
+	a, b := SetName("fw", long, "a"), SetName("fw", long, "b")
+	if len(a) > maxSetNameLen || len(b) > maxSetNameLen {
+		t.Errorf("set names %s and %s longer than %d characters", a, b, maxSetNameLen)
+	}
+	if a == b {
+		t.Errorf("distinct parts share the set name %s", a)
+	}
+	if a != SetName("fw", long, "a") {
+		t.Error("SetName is not stable")
+	}
+	if !strings.HasPrefix(a, "fw-xxx") {
+		t.Errorf("shortened set name %s does not start with the namespace", a)
+	}
+}
+
+func TestSetNameFilters(t *testing.T) {
+	for _, tc := range []struct {
+		filter func(string) bool
+		name   string
+		want   bool
+	}{
+		{PrefixFilter("fw-", "k8s-"), "k8s-web", true},
+		{PrefixFilter("fw-"), "web-fw-", false},
+		{RegexpFilter(regexp.MustCompile(`^fw-\d+$`)), "fw-12", true},
+		{RegexpFilter(regexp.MustCompile(`^fw-\d+$`)), "fw-x", false},
+		{GlobFilter("fw-*-blocked"), "fw-t1-blocked", true},
+		{GlobFilter("[", "fw-*"), "web", false},
+	} {
+		if got := tc.filter(tc.name); got != tc.want {
+			t.Errorf("filter on %s = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"os"
+)
+
+// NamespacedHandle is a Handle running every command inside a network namespace,
+// as needed by CNI plugins managing per-pod sets. Sets created through it are
+// destroyed by Close, which is meant to be called when the namespace is torn
+// down, unless WithCleanup configures another action.
+type NamespacedHandle struct {
+	*Handle
+}
+
+// NewNamespacedHandle returns a handle bound to the network namespace at path,
+// e.g. /var/run/netns/NAME or the CNI_NETNS of a plugin invocation.
+func NewNamespacedHandle(path string, opts ...Option) (*NamespacedHandle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening network namespace %s: %w", path, err)
+	}
+	return newNamespacedHandle(f, opts)
+}
+
+// NewNamespacedHandleFd returns a handle bound to the network namespace referred
+// to by the open file descriptor fd. The descriptor is owned by the handle from
+// then on and closed by Close.
+func NewNamespacedHandleFd(fd uintptr, opts ...Option) (*NamespacedHandle, error) {
+	return newNamespacedHandle(os.NewFile(fd, fmt.Sprintf("netns-fd-%d", fd)), opts)
+}
+
+func newNamespacedHandle(f *os.File, opts []Option) (*NamespacedHandle, error) {
+	if err := checkNetns(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	h := NewHandle(append([]Option{WithCleanup(CleanupDestroy)}, opts...)...)
+	h.netns = f
+	return &NamespacedHandle{h}, nil
+}
+
+// Close cleans up the sets created through the handle like Handle.Close, so
+// that WithCleanup and WithDestructivePolicy apply, and releases the namespace.
+// Sets that no longer exist, e.g. because the namespace is already gone, are
+// skipped.
+func (h *NamespacedHandle) Close() error {
+	err := h.Handle.Close()
+	if cerr := h.netns.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkNetns verifies that f refers to a network namespace.
+func checkNetns(f *os.File) error {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &st); err != nil {
+		return fmt.Errorf("error checking network namespace %s: %w", f.Name(), err)
+	}
+	if st.Type != unix.NSFS_MAGIC && st.Type != unix.PROC_SUPER_MAGIC {
+		return fmt.Errorf("%s is not a network namespace", f.Name())
+	}
+	return nil
+}
+
+// inNetns calls f, which is expected to start a command, with the calling thread
+// switched to the namespace of the handle. Child processes inherit the namespace
+// of the thread forking them, the rest of the process is unaffected.
+func (h *Handle) inNetns(f func() error) error {
+	if h.netns == nil {
+		return f()
+	}
+	runtime.LockOSThread()
+	orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("error opening current network namespace: %w", err)
+	}
+	defer orig.Close()
+	if err := unix.Setns(int(h.netns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("error entering network namespace %s: %w", h.netns.Name(), err)
+	}
+	ferr := f()
+	if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+		// the thread stays locked and is terminated when the goroutine exits
+		return fmt.Errorf("error leaving network namespace %s: %w", h.netns.Name(), err)
+	}
+	runtime.UnlockOSThread()
+	return ferr
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "os"
+
+// checkNetns always fails: network namespaces only exist on linux.
+func checkNetns(f *os.File) error {
+	return ErrUnsupportedPlatform
+}
+
+// inNetns calls f.
+func (h *Handle) inNetns(f func() error) error {
+	return f()
+}
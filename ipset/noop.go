@@ -0,0 +1,160 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// RecordedCommand is an ipset command the no-op backend did not run.
+type RecordedCommand struct {
+	Args []string
+	// Input is what the command read from its standard input, e.g. the
+	// commands of a restore.
+	Input string
+	Time  time.Time
+}
+
+// String returns the command line of the command.
+func (c RecordedCommand) String() string {
+	return "ipset " + strings.Join(c.Args, " ")
+}
+
+// NoopRecorder collects the commands of the handles using the no-op backend.
+// It is safe for concurrent use.
+type NoopRecorder struct {
+	mu     sync.Mutex
+	cmds   []RecordedCommand
+	warned bool
+}
+
+// Commands returns the recorded commands, oldest first.
+func (r *NoopRecorder) Commands() []RecordedCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedCommand(nil), r.cmds...)
+}
+
+// Reset forgets the recorded commands.
+func (r *NoopRecorder) Reset() {
+	r.mu.Lock()
+	r.cmds = nil
+	r.mu.Unlock()
+}
+
+func (r *NoopRecorder) record(args []string, input string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.warned {
+		log.Warnf("ipset no-op backend in use: commands are recorded, not executed")
+		r.warned = true
+	}
+	r.cmds = append(r.cmds, RecordedCommand{Args: append([]string(nil), args...), Input: input, Time: time.Now()})
+}
+
+// WithNoopBackend makes the handle record its commands in r instead of
+// running ipset, for developing services on machines without ipset, such
+// as macOS or Windows. Every command succeeds without output: sets are
+// listed empty and Test always finds the entry. It must never be used in
+// production, where nothing would be filtered.
+func WithNoopBackend(r *NoopRecorder) Option {
+	return func(h *Handle) {
+		h.noop = r
+	}
+}
+
+// initCheck initializes the package for the handle; nothing needs to be
+// found for the no-op backend or a runner.
+func (h *Handle) initCheck() error {
+	if h.noop != nil || len(h.runner) != 0 {
+		return nil
+	}
+	return initCheck()
+}
+
+// run executes the ipset utility with the given arguments and returns its combined output.
+func (h *Handle) run(args ...string) ([]byte, error) {
+	if h.noop == nil {
+		defer h.acquireProc()()
+		return h.execRun(args...)
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	h.noop.record(h.args(args), "")
+	return nil, nil
+}
+
+// runIO executes the ipset utility connecting stdin and stdout to the given
+// reader and writer, either of which may be nil. The standard error is returned.
+func (h *Handle) runIO(stdin io.Reader, stdout io.Writer, args ...string) ([]byte, error) {
+	if h.noop == nil {
+		defer h.acquireProc()()
+		return h.execRunIO(stdin, stdout, args...)
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	var input []byte
+	if stdin != nil {
+		var err error
+		if input, err = ioutil.ReadAll(stdin); err != nil {
+			return nil, err
+		}
+	}
+	h.noop.record(h.args(args), string(input))
+	return nil, nil
+}
+
+// start starts the ipset utility reading stdin, with the standard error
+// written to stderr, and returns the function waiting for it to exit. A stdin
+// that is an *os.File is closed once the process no longer needs it.
+func (h *Handle) start(stdin io.Reader, stderr io.Writer, args ...string) (func() error, error) {
+	if h.noop == nil {
+		return h.execStart(stdin, stderr, args...)
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	// read the input as it comes, as the process would, so writers never block
+	var input bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		if stdin == nil {
+			done <- nil
+			return
+		}
+		_, err := io.Copy(&input, stdin)
+		if f, ok := stdin.(*os.File); ok {
+			f.Close()
+		}
+		done <- err
+	}()
+	return func() error {
+		err := <-done
+		h.noop.record(h.args(args), input.String())
+		return err
+	}, nil
+}
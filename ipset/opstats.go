@@ -0,0 +1,130 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sort"
+	"time"
+)
+
+// OpStats summarizes the recent operations of the handle on a set, e.g. for
+// dashboards. The counts start when the handle first changes the set and
+// are dropped when it is destroyed.
+type OpStats struct {
+	Set string `json:"set"`
+	// Added and Removed count the entries of successful add and del
+	// commands, including entries already in or missing from the set.
+	Added   uint64 `json:"added"`
+	Removed uint64 `json:"removed"`
+	Flushes uint64 `json:"flushes"`
+	// Failures counts the failed commands, LastError is the last error.
+	Failures  uint64 `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+	// Refreshes and RefreshFailures count the refreshes of the set.
+	Refreshes       uint64 `json:"refreshes"`
+	RefreshFailures uint64 `json:"refresh_failures"`
+	// LastRefresh is when the last refresh ended, LastRefreshDuration how
+	// long it took and LastRefreshEntries how many entries it set.
+	LastRefresh         time.Time     `json:"last_refresh,omitempty"`
+	LastRefreshDuration time.Duration `json:"last_refresh_duration,omitempty"`
+	LastRefreshEntries  int           `json:"last_refresh_entries,omitempty"`
+}
+
+// OpStats returns the operation statistics of the named set, false if the
+// handle did not change it.
+func (h *Handle) OpStats(set string) (OpStats, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st, ok := h.opStats[set]
+	if !ok {
+		return OpStats{}, false
+	}
+	return *st, true
+}
+
+// AllOpStats returns the operation statistics of all sets the handle
+// changed, sorted by set name.
+func (h *Handle) AllOpStats() []OpStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	all := make([]OpStats, 0, len(h.opStats))
+	for _, st := range h.opStats {
+		all = append(all, *st)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Set < all[j].Set })
+	return all
+}
+
+// statsOf returns the statistics of the set, created if needed. h.mu must be held.
+func (h *Handle) statsOf(set string) *OpStats {
+	if h.opStats == nil {
+		h.opStats = make(map[string]*OpStats)
+	}
+	st := h.opStats[set]
+	if st == nil {
+		st = &OpStats{Set: set}
+		h.opStats[set] = st
+	}
+	return st
+}
+
+// updateOpStats accounts for a mutation.
+func (h *Handle) updateOpStats(c change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case c.op == opDestroyAll && c.err == nil:
+		h.opStats = nil
+		return
+	case c.op == opDestroy && c.err == nil:
+		delete(h.opStats, c.set)
+		return
+	case c.set == "":
+		return
+	}
+	st := h.statsOf(c.set)
+	if c.err != nil {
+		st.Failures++
+		st.LastError = c.err.Error()
+		return
+	}
+	switch c.op {
+	case opAdd:
+		st.Added += uint64(len(c.entries))
+	case opDel:
+		st.Removed += uint64(len(c.entries))
+	case opFlush:
+		st.Flushes++
+	}
+}
+
+// recordRefresh accounts for a refresh of the set with n entries, started at start.
+func (h *Handle) recordRefresh(set string, n int, start time.Time, err error) {
+	h.observeRefresh(set, n, time.Since(start), err)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.statsOf(set)
+	st.Refreshes++
+	if err != nil {
+		st.RefreshFailures++
+		st.LastError = err.Error()
+		return
+	}
+	st.LastRefresh = time.Now()
+	st.LastRefreshDuration = st.LastRefresh.Sub(start)
+	st.LastRefreshEntries = n
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotOwned is returned when a handle restricted by WithOwnerPrefix is
+// asked to change a set outside of its prefix.
+var ErrNotOwned = errors.New("set is not owned by the handle")
+
+// WithOwnerPrefix restricts the handle to the sets whose name starts with
+// prefix: creating, changing or destroying other sets fails with ErrNotOwned
+// unless they are allowed explicitly with Allow, and DestroyAll only
+// destroys sets within the prefix, even when asked for all sets.
+func WithOwnerPrefix(prefix string) Option {
+	return func(h *Handle) {
+		h.ownerPrefix = prefix
+	}
+}
+
+// Allow lets a handle restricted by WithOwnerPrefix change the named sets
+// outside of its prefix. DestroyAll still skips them.
+func (h *Handle) Allow(names ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.allowed == nil {
+		h.allowed = make(map[string]bool)
+	}
+	for _, name := range names {
+		h.allowed[name] = true
+	}
+}
+
+// owns reports whether the named set is within the prefix of the handle.
+func (h *Handle) owns(name string) bool {
+	return strings.HasPrefix(name, h.ownerPrefix)
+}
+
+// checkOwned returns ErrNotOwned if one of the named sets may not be changed
+// through the handle.
+func (h *Handle) checkOwned(names ...string) error {
+	if h.ownerPrefix == "" {
+		return nil
+	}
+	for _, name := range names {
+		if h.owns(name) {
+			continue
+		}
+		h.mu.Lock()
+		allowed := h.allowed[name]
+		h.mu.Unlock()
+		if !allowed {
+			return fmt.Errorf("%w: %s is outside of prefix %s", ErrNotOwned, name, h.ownerPrefix)
+		}
+	}
+	return nil
+}
+
+// checkArgs checks the ownership of the sets an ipset command with the given
+// arguments changes. The sets changed by restore are checked by its callers.
+func (h *Handle) checkArgs(args []string) error {
+	if h.ownerPrefix == "" || len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "create", "add", "del", "flush", "destroy":
+		if len(args) == 1 {
+			// flush or destroy all sets
+			return fmt.Errorf("%w: refusing to %s all sets outside of prefix %s", ErrNotOwned, args[0], h.ownerPrefix)
+		}
+		return h.checkOwned(args[1])
+	case "rename", "swap":
+		if len(args) < 3 {
+			return nil
+		}
+		return h.checkOwned(args[1], args[2])
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// The parsers below are the ones the package applies to the output of the
+// ipset utility, as pure functions on that output, so that fixtures captured
+// from new ipset releases can be checked without running ipset.
+
+// ParseVersion returns the "vX.Y" version found in `ipset --version` output.
+func ParseVersion(out []byte) (string, error) {
+	return parse.Version(out)
+}
+
+// ParseHeader parses the header of a set from `ipset list -t NAME` output,
+// or from the lines before the members in `ipset list NAME` output.
+func ParseHeader(out []byte) Header {
+	return parseHeader(headerLines(out))
+}
+
+// ParseStats parses the statistics of a set from `ipset list -t NAME` output.
+func ParseStats(out []byte) (Stats, error) {
+	return parseListTerse(headerLines(out))
+}
+
+// ParseMembers parses the entries of a set from `ipset list NAME` output.
+func ParseMembers(out []byte) []Entry {
+	var entries []Entry
+	inMembers := false
+	for _, l := range lines(out) {
+		if !inMembers {
+			inMembers = parse.IsMembersHeader(l)
+			continue
+		}
+		if fields := parse.Fields(l); len(fields) > 0 {
+			entries = append(entries, parseEntry(fields))
+		}
+	}
+	return entries
+}
+
+// SavedSet is a set as written by `ipset save`.
+type SavedSet struct {
+	Header  Header
+	Entries []Entry
+}
+
+// ParseSave parses `ipset save` output, returning the sets in the order of
+// their create lines. An add line for a set not created before is an error;
+// other commands, such as those of a hand-written restore file, are skipped.
+func ParseSave(out []byte) ([]SavedSet, error) {
+	var sets []SavedSet
+	index := make(map[string]int)
+	for n, l := range lines(out) {
+		fields := parse.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "create":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: create without set type", n+1)
+			}
+			hd := Header{Name: fields[1], Type: fields[2]}
+			hd.parseHeaderOptions(fields[3:])
+			index[hd.Name] = len(sets)
+			sets = append(sets, SavedSet{Header: hd})
+		case "add":
+			i, ok := index[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("line %d: add to set %s not created before", n+1, fields[1])
+			}
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: add without element", n+1)
+			}
+			sets[i].Entries = append(sets[i].Entries, parseEntry(fields[2:]))
+		}
+	}
+	return sets, nil
+}
+
+// lines splits output into lines without their line breaks.
+func lines(out []byte) []string {
+	return strings.Split(strings.TrimRight(strings.Replace(string(out), "\r\n", "\n", -1), "\n"), "\n")
+}
+
+// headerLines returns the lines of list output before the members.
+func headerLines(out []byte) []string {
+	ls := lines(out)
+	for i, l := range ls {
+		if parse.IsMembersHeader(l) {
+			return ls[:i]
+		}
+	}
+	return ls
+}
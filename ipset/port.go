@@ -0,0 +1,184 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Proto is the protocol of the port dimension of the hash:*port* set types.
+type Proto string
+
+// Protocols accepted in port dimensions.
+const (
+	ProtoTCP     Proto = "tcp"
+	ProtoUDP     Proto = "udp"
+	ProtoSCTP    Proto = "sctp"
+	ProtoUDPLite Proto = "udplite"
+	ProtoICMP    Proto = "icmp"
+	ProtoICMPv6  Proto = "icmpv6"
+)
+
+// portProtos lists the protocols ipset accepts in port dimensions.
+var portProtos = map[Proto]bool{
+	ProtoTCP: true, ProtoUDP: true, ProtoSCTP: true, ProtoUDPLite: true, ProtoICMP: true, ProtoICMPv6: true,
+}
+
+// IsICMP reports whether the protocol is icmp or icmpv6, whose port dimension
+// is an ICMP type and code rather than a port.
+func (p Proto) IsICMP() bool {
+	return p == ProtoICMP || p == ProtoICMPv6
+}
+
+// Port is the port dimension of the hash:*port* set types: a port, or with
+// Last set the range of ports from Port to Last, which ipset expands on add
+// and del. An empty Proto stands for tcp, the ipset default. For icmp and
+// icmpv6, Port holds the ICMP type in its high byte and the code in its low
+// byte, as ipset stores them; use ICMPPort to build one.
+type Port struct {
+	Proto Proto
+	Port  uint16
+	Last  uint16
+}
+
+// ICMPPort returns the port dimension matching an ICMP type and code of proto,
+// icmp or icmpv6.
+func ICMPPort(proto Proto, typ, code uint8) Port {
+	return Port{Proto: proto, Port: uint16(typ)<<8 | uint16(code)}
+}
+
+// ICMPType returns the ICMP type of an icmp or icmpv6 port dimension.
+func (p Port) ICMPType() uint8 {
+	return uint8(p.Port >> 8)
+}
+
+// ICMPCode returns the ICMP code of an icmp or icmpv6 port dimension.
+func (p Port) ICMPCode() uint8 {
+	return uint8(p.Port)
+}
+
+// ParsePort parses a port dimension, e.g. "80", "udp:53", "8000-8100",
+// "tcp:https", "icmp:echo-request" or "icmpv6:1/4". Service names are
+// resolved with /etc/services, where the Go resolver only finds tcp and udp
+// services: udplite ports, which share the port numbers of udp, are named
+// after the udp services, and sctp ports after the tcp ones. As with ipset, a
+// name containing a dash is enclosed in brackets, e.g. "[http-alt]-9000".
+func ParsePort(s string) (Port, error) {
+	return ParsePortWith(s, nil)
+}
+
+// ParsePortWith parses a port dimension like ParsePort, resolving service
+// names with services before falling back to /etc/services.
+func ParsePortWith(s string, services map[string]uint16) (Port, error) {
+	var p Port
+	spec := s
+	if i := strings.Index(s, ":"); i >= 0 {
+		p.Proto, spec = Proto(s[:i]), s[i+1:]
+		if !portProtos[p.Proto] {
+			return Port{}, fmt.Errorf("invalid port %s: unknown protocol %s", s, p.Proto)
+		}
+	}
+	if p.Proto.IsICMP() {
+		return parseICMP(p.Proto, spec)
+	}
+	first, last := splitPortRange(spec)
+	var err error
+	if p.Port, err = p.resolve(first, services); err != nil {
+		return Port{}, fmt.Errorf("invalid port %s: %w", s, err)
+	}
+	if last != "" {
+		if p.Last, err = p.resolve(last, services); err != nil {
+			return Port{}, fmt.Errorf("invalid port %s: %w", s, err)
+		}
+		if p.Last < p.Port {
+			return Port{}, fmt.Errorf("invalid port %s: start after end", s)
+		}
+	}
+	return p, nil
+}
+
+// splitPortRange splits a port range at the dash not enclosed in brackets.
+func splitPortRange(spec string) (string, string) {
+	depth := 0
+	for i, c := range spec {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '-' && depth == 0 && i > 0:
+			return spec[:i], spec[i+1:]
+		}
+	}
+	return spec, ""
+}
+
+// resolve returns the number of a port given as a number or a service name.
+func (p Port) resolve(port string, services map[string]uint16) (uint16, error) {
+	if n, err := strconv.ParseUint(port, 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	port = strings.TrimSuffix(strings.TrimPrefix(port, "["), "]")
+	if n, ok := services[port]; ok {
+		return n, nil
+	}
+	proto := p.Proto
+	if proto == "" {
+		proto = ProtoTCP
+	}
+	n, err := net.LookupPort(string(serviceProto(proto)), port)
+	if err != nil {
+		return 0, fmt.Errorf("unknown %s service %s", proto, port)
+	}
+	return uint16(n), nil
+}
+
+// serviceProto returns the protocol whose services name the ports of proto,
+// see ParsePort.
+func serviceProto(proto Proto) Proto {
+	switch proto {
+	case ProtoUDPLite:
+		return ProtoUDP
+	case ProtoSCTP:
+		return ProtoTCP
+	}
+	return proto
+}
+
+// IsRange reports whether the port dimension spans more than one port.
+func (p Port) IsRange() bool {
+	return p.Last > p.Port
+}
+
+// String returns the port in the form ipset lists it, ICMP types and codes
+// by name when they have one.
+func (p Port) String() string {
+	if p.Proto.IsICMP() {
+		return string(p.Proto) + ":" + icmpString(p.Proto, p.ICMPType(), p.ICMPCode())
+	}
+	s := strconv.Itoa(int(p.Port))
+	if p.IsRange() {
+		s += "-" + strconv.Itoa(int(p.Last))
+	}
+	if p.Proto == "" {
+		return s
+	}
+	return string(p.Proto) + ":" + s
+}
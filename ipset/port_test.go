@@ -0,0 +1,78 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParsePort(t *testing.T) {
+	services := map[string]uint16{"web": 8080, "http-alt": 8008}
+	for _, tc := range []struct {
+		in   string
+		want Port
+	}{
+		{"80", Port{Port: 80}},
+		{"udp:53", Port{Proto: "udp", Port: 53}},
+		{"8000-8100", Port{Port: 8000, Last: 8100}},
+		{"sctp:web", Port{Proto: "sctp", Port: 8080}},
+		{"[http-alt]-9000", Port{Port: 8008, Last: 9000}},
+	} {
+		got, err := ParsePortWith(tc.in, services)
+		if err != nil || got != tc.want {
+			t.Errorf("ParsePortWith(%q) = %+v, %v, want %+v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"ftp:21", "70000", "100-90", "tcp:nosuchservice", "8000-nosuchservice"} {
+		if p, err := ParsePortWith(in, services); err == nil {
+			t.Errorf("ParsePortWith(%q) = %+v, want an error", in, p)
+		}
+	}
+}
+
+func TestParsePortString(t *testing.T) {
+	for _, in := range []string{"80", "udp:53", "tcp:8000-8100"} {
+		p, err := ParsePort(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := p.String(); s != in {
+			t.Errorf("port %q formatted as %q", in, s)
+		}
+	}
+}
+
+func TestParsePortServiceNames(t *testing.T) {
+	for _, tc := range []struct {
+		in, services string
+	}{
+		{"tcp:domain", "tcp"},
+		{"udp:domain", "udp"},
+		{"udplite:domain", "udp"},
+		{"sctp:domain", "tcp"},
+	} {
+		want, err := net.LookupPort(tc.services, "domain")
+		if err != nil {
+			t.Skipf("no services database: %v", err)
+		}
+		p, err := ParsePort(tc.in)
+		if err != nil || int(p.Port) != want {
+			t.Errorf("ParsePort(%q) = %+v, %v, want port %d", tc.in, p, err, want)
+		}
+	}
+}
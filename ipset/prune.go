@@ -0,0 +1,172 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// ZeroHitPruner periodically removes the entries of sets created with
+// Params.Counters whose packet and byte counters stayed at zero for a whole
+// observation window, e.g. to shrink deny sets filled with addresses that
+// never show up. An entry is observed from the first pass finding it without
+// hits, so nothing is pruned before the pruner ran for a window. Sets without
+// counters are skipped. What is pruned is logged.
+type ZeroHitPruner struct {
+	// DryRun logs and reports the entries that would be pruned without
+	// deleting them.
+	DryRun bool
+	// OnPrune, if set, is called with the entries pruned from a set by a pass.
+	OnPrune func(set string, pruned []Entry)
+
+	window   time.Duration
+	interval time.Duration
+	sets     []*IPSet
+
+	mu sync.Mutex
+	// idle holds when each entry of each set was first seen without hits
+	idle map[string]map[string]time.Time
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewZeroHitPruner returns a pruner removing the entries of the sets without
+// hits for window, an hour when zero, checking them every interval once
+// started, every minute when zero.
+func NewZeroHitPruner(window, interval time.Duration, sets ...*IPSet) *ZeroHitPruner {
+	if window <= 0 {
+		// a window of zero would prune every entry on the first pass
+		window = time.Hour
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &ZeroHitPruner{window: window, interval: interval, sets: sets, idle: make(map[string]map[string]time.Time)}
+}
+
+// Prune checks the sets once and removes the entries without hits for the
+// window, returning how many it removed (or would remove with DryRun). Sets
+// failing are logged and skipped; the error of the last failure is returned.
+func (p *ZeroHitPruner) Prune() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	var lastErr error
+	for _, s := range p.sets {
+		pruned, err := p.pruneSet(s, time.Now())
+		if err != nil {
+			log.Warnf("Error pruning set %s: %v", s.Name, err)
+			lastErr = err
+			continue
+		}
+		n += len(pruned)
+		if len(pruned) != 0 && p.OnPrune != nil {
+			p.OnPrune(s.Name, pruned)
+		}
+	}
+	return n, lastErr
+}
+
+func (p *ZeroHitPruner) pruneSet(s *IPSet, now time.Time) ([]Entry, error) {
+	hd, err := s.Header()
+	if err != nil {
+		return nil, err
+	}
+	if !hd.Counters {
+		log.Debugf("Not pruning set %s: created without counters", s.Name)
+		return nil, nil
+	}
+	seen := p.idle[s.Name]
+	idle := make(map[string]time.Time)
+	var pruned []Entry
+	err = s.handle().eachSavedEntry(s.Name, func(e Entry) {
+		if e.Packets != 0 || e.Bytes != 0 {
+			return
+		}
+		since, ok := seen[e.Element]
+		if !ok {
+			since = now
+		}
+		// pruned entries stay idle in case they are not deleted
+		idle[e.Element] = since
+		if now.Sub(since) >= p.window {
+			pruned = append(pruned, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pruned) != 0 && !p.DryRun {
+		b := s.handle().NewBatch()
+		for _, e := range pruned {
+			b.Del(s.Name, e.Element)
+		}
+		if err := b.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range pruned {
+		if p.DryRun {
+			log.Infof("Would prune %s from set %s: no hits for %v", e.Element, s.Name, p.window)
+			continue
+		}
+		delete(idle, e.Element)
+		log.Infof("Pruned %s from set %s: no hits for %v", e.Element, s.Name, p.window)
+	}
+	p.idle[s.Name] = idle
+	return pruned, nil
+}
+
+// Start checks the sets every interval until Stop is called.
+func (p *ZeroHitPruner) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return
+	}
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	go p.loop(p.stop, p.done)
+}
+
+// Stop ends the periodic pruning.
+func (p *ZeroHitPruner) Stop() {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (p *ZeroHitPruner) loop(stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		p.Prune()
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// counterStub is an ipset with a hash:ip set with counters holding an entry
+// without hits and one with hits, logging the other commands and their input
+// in a file next to it.
+const counterStub = `#!/bin/sh
+case "$1" in
+-t) printf 'Name: %s\nType: hash:ip\nHeader: family inet hashsize 1024 maxelem 65536 counters\nMembers:\n' "$3" ;;
+save)
+	echo "create $2 hash:ip family inet hashsize 1024 maxelem 65536 counters"
+	echo "add $2 192.0.2.1 packets 0 bytes 0"
+	echo "add $2 192.0.2.2 packets 3 bytes 180"
+	;;
+*) log="$(dirname "$0")/log"; echo "$@" >>"$log"; cat >>"$log" ;;
+esac
+`
+
+func TestZeroHitPrunerDefaults(t *testing.T) {
+	p := NewZeroHitPruner(0, -time.Second)
+	if p.window != time.Hour || p.interval != time.Minute {
+		t.Errorf("window %v, interval %v, want 1h0m0s and 1m0s", p.window, p.interval)
+	}
+}
+
+func TestZeroHitPruner(t *testing.T) {
+	h := stubHandle(t, counterStub)
+	log := filepath.Join(filepath.Dir(h.runner[0]), "log")
+	s := h.Set("deny")
+	p := NewZeroHitPruner(time.Hour, 0, s)
+	p.DryRun = true
+	start := time.Now()
+	for _, c := range []struct {
+		after time.Duration
+		want  int
+	}{
+		// the first pass only starts observing the entry without hits
+		{0, 0},
+		{time.Hour - time.Second, 0},
+		{time.Hour, 1},
+	} {
+		pruned, err := p.pruneSet(s, start.Add(c.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pruned) != c.want {
+			t.Fatalf("after %v: pruned %v, want %d entries", c.after, pruned, c.want)
+		}
+	}
+	if out, _ := ioutil.ReadFile(log); len(out) != 0 {
+		t.Errorf("dry run ran %s", out)
+	}
+	p.DryRun = false
+	pruned, err := p.pruneSet(s, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0].Element != "192.0.2.1" {
+		t.Fatalf("pruned %v, want 192.0.2.1", pruned)
+	}
+	out, _ := ioutil.ReadFile(log)
+	if !strings.Contains(string(out), "del deny 192.0.2.1") || strings.Contains(string(out), "192.0.2.2") {
+		t.Errorf("pruning ran %s", out)
+	}
+	if _, idle := p.idle["deny"]["192.0.2.1"]; idle {
+		t.Error("pruned entry still observed")
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+// EntriesWhere returns the entries of the set, with their options, for which
+// match returns true. The set is read from a streaming `ipset save` and the
+// entries are matched as they are read, so only the matching ones are held in
+// memory, e.g. to report the entries of a large set without hits:
+//
+//	idle, err := s.EntriesWhere(PacketsAtMost(0))
+func (s *IPSet) EntriesWhere(match func(Entry) bool) ([]Entry, error) {
+	var entries []Entry
+	err := s.handle().eachSavedEntry(s.Name, func(e Entry) {
+		if match(e) {
+			entries = append(entries, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// PacketsAtLeast matches the entries whose packet counter is at least n.
+// Counters are only kept by sets created with Params.Counters.
+func PacketsAtLeast(n uint64) func(Entry) bool {
+	return func(e Entry) bool { return e.Packets >= n }
+}
+
+// BytesAtLeast matches the entries whose byte counter is at least n.
+func BytesAtLeast(n uint64) func(Entry) bool {
+	return func(e Entry) bool { return e.Bytes >= n }
+}
+
+// PacketsAtMost matches the entries whose packet counter is at most n.
+func PacketsAtMost(n uint64) func(Entry) bool {
+	return func(e Entry) bool { return e.Packets <= n }
+}
+
+// BytesAtMost matches the entries whose byte counter is at most n.
+func BytesAtMost(n uint64) func(Entry) bool {
+	return func(e Entry) bool { return e.Bytes <= n }
+}
+
+// MatchAll matches the entries every one of the filters matches.
+func MatchAll(filters ...func(Entry) bool) func(Entry) bool {
+	return func(e Entry) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
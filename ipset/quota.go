@@ -0,0 +1,309 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// ErrQuotaExceeded is returned when adding entries would exceed the quota of
+// a handle, see WithQuota.
+var ErrQuotaExceeded = errors.New("entry quota exceeded")
+
+// QuotaMode selects what happens to additions beyond a quota.
+type QuotaMode int
+
+const (
+	// QuotaReject fails them with ErrQuotaExceeded.
+	QuotaReject QuotaMode = iota
+	// QuotaWarn logs a warning and lets them through.
+	QuotaWarn
+)
+
+// WithQuota limits the total number of entries of the sets created through
+// the handle to max. Adds, batches, refreshes and sessions are checked before
+// they run; a RefreshFrom, whose size is unknown beforehand, is not checked,
+// and neither is Touch, which only re-adds entries already in the set. The
+// entry count of a set is read from ipset once, then kept up to date in place
+// by the additions, flushes and swaps made through the handle, and read again
+// after a deletion, a failure or a destruction. An addition counts all its
+// entries as new until the count would exceed the quota; the sets are then
+// listed so that only the entries not already in them are counted. The check
+// and the addition are not atomic, and changes by other processes are only
+// seen when a count is read again, so processes sharing sets may together
+// exceed the quota.
+func WithQuota(max int, mode QuotaMode) Option {
+	return func(h *Handle) {
+		h.quota = &quota{max: max, mode: mode, counts: make(map[string]int), fresh: make(map[string]int)}
+	}
+}
+
+type quota struct {
+	max  int
+	mode QuotaMode
+
+	mu sync.Mutex
+	// counts caches the number of entries of the owned sets, missing when
+	// it must be read again; it may exceed the actual number when entries
+	// already in a set were added again
+	counts map[string]int
+	// fresh holds the number of new entries of the additions whose check
+	// listed their set, by set, until the addition is reported
+	fresh map[string]int
+}
+
+// QuotaUsage returns the number of entries of the sets created through the
+// handle and its quota, 0 if it has none.
+func (h *Handle) QuotaUsage() (used, max int, err error) {
+	if h.quota == nil {
+		return 0, 0, nil
+	}
+	used, err = h.quotaUsed()
+	return used, h.quota.max, err
+}
+
+// quotaUsed sums the entries of the owned sets.
+func (h *Handle) quotaUsed() (int, error) {
+	used := 0
+	for _, name := range h.ownedSets() {
+		n, err := h.quotaCount(name)
+		if err != nil {
+			return 0, err
+		}
+		used += n
+	}
+	return used, nil
+}
+
+func (h *Handle) quotaCount(name string) (int, error) {
+	q := h.quota
+	q.mu.Lock()
+	n, ok := q.counts[name]
+	q.mu.Unlock()
+	if ok {
+		return n, nil
+	}
+	stats, err := (&IPSet{Name: name, h: h}).Statistics()
+	if err != nil {
+		return 0, fmt.Errorf("error counting entries of set %s: %w", name, err)
+	}
+	q.mu.Lock()
+	q.counts[name] = int(stats.Entries)
+	q.mu.Unlock()
+	return int(stats.Entries), nil
+}
+
+// checkQuota checks that adding n new entries to the named set keeps the
+// handle within its quota. With replace, the entries replace those of the set.
+func (h *Handle) checkQuota(set string, n int, replace bool) error {
+	var replaced []string
+	if replace {
+		replaced = []string{set}
+	}
+	return h.checkQuotaAdds(map[string]int{set: n}, replaced...)
+}
+
+// checkQuotaAdd checks the entries added to the named set, see
+// checkQuotaEntries.
+func (h *Handle) checkQuotaAdd(set string, entries []string) error {
+	return h.checkQuotaEntries(map[string][]string{set: entries})
+}
+
+// checkQuotaAdds checks that adding the given numbers of new entries to the
+// sets keeps the handle within its quota. The entries of the sets named
+// replaced, if any, are replaced rather than added to.
+func (h *Handle) checkQuotaAdds(adds map[string]int, replaced ...string) error {
+	if h.quota == nil {
+		return nil
+	}
+	used, n, err := h.quotaNeeded(adds, replaced)
+	if err != nil || used+n <= h.quota.max {
+		return err
+	}
+	return h.quotaExceeded(adds, used, n)
+}
+
+// checkQuotaEntries checks that adding the entries to the sets keeps the
+// handle within its quota, not counting those already in a set. These are
+// only told apart, by listing the sets, when counting all the entries would
+// exceed the quota.
+func (h *Handle) checkQuotaEntries(adds map[string][]string) error {
+	if h.quota == nil {
+		return nil
+	}
+	counts := make(map[string]int, len(adds))
+	for set, entries := range adds {
+		counts[set] = len(entries)
+	}
+	used, n, err := h.quotaNeeded(counts, nil)
+	if err != nil || used+n <= h.quota.max {
+		return err
+	}
+	for set, entries := range adds {
+		if len(entries) != 0 && h.isOwned(set) {
+			if counts[set], err = h.quotaNew(set, entries); err != nil {
+				return err
+			}
+		}
+	}
+	if used, n, err = h.quotaNeeded(counts, nil); err != nil {
+		return err
+	}
+	if used+n > h.quota.max {
+		if err := h.quotaExceeded(counts, used, n); err != nil {
+			return err
+		}
+	}
+	for set := range adds {
+		if h.isOwned(set) {
+			h.quotaFresh(set, counts[set])
+		}
+	}
+	return nil
+}
+
+// quotaFresh records that the next addition reported for the set adds n new
+// entries to it.
+func (h *Handle) quotaFresh(set string, n int) {
+	if h.quota == nil {
+		return
+	}
+	h.quota.mu.Lock()
+	h.quota.fresh[set] = n
+	h.quota.mu.Unlock()
+}
+
+// quotaNew lists the set to count the entries not already in it, and its
+// entries along the way. All the entries are new to a set yet to be created.
+func (h *Handle) quotaNew(set string, entries []string) (int, error) {
+	current, err := h.savedEntries(set)
+	if err != nil && !errors.Is(err, ErrSetNotFound) {
+		return 0, fmt.Errorf("error counting entries of set %s: %w", set, err)
+	}
+	seen := make(map[string]bool, len(current)+len(entries))
+	for _, e := range current {
+		seen[canonicalElement(e.Element)] = true
+	}
+	if err == nil {
+		h.quota.mu.Lock()
+		h.quota.counts[set] = len(current)
+		h.quota.mu.Unlock()
+	}
+	n := 0
+	for _, entry := range entries {
+		if elem := canonicalElement(entry); !seen[elem] {
+			seen[elem] = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+// quotaNeeded returns the number of entries used by the handle and the number
+// of entries the additions add to it.
+func (h *Handle) quotaNeeded(adds map[string]int, replaced []string) (used, n int, err error) {
+	for set, k := range adds {
+		if k != 0 && h.isOwned(set) {
+			n += k
+		}
+	}
+	if n == 0 {
+		return 0, 0, nil
+	}
+	if used, err = h.quotaUsed(); err != nil {
+		return 0, 0, err
+	}
+	for _, set := range replaced {
+		if !h.isOwned(set) {
+			continue
+		}
+		cur, err := h.quotaCount(set)
+		if err != nil {
+			return 0, 0, err
+		}
+		used -= cur
+	}
+	return used, n, nil
+}
+
+// quotaExceeded returns the error of additions exceeding the quota, or logs
+// it with QuotaWarn.
+func (h *Handle) quotaExceeded(adds map[string]int, used, n int) error {
+	var sets []string
+	for set, k := range adds {
+		if k != 0 && h.isOwned(set) {
+			sets = append(sets, set)
+		}
+	}
+	sort.Strings(sets)
+	target := "set " + sets[0]
+	if len(sets) > 1 {
+		target = "sets " + strings.Join(sets, ", ")
+	}
+	if h.quota.mode == QuotaWarn {
+		log.Warnf("Adding %d entries to %s exceeds the quota: %d of %d entries used", n, target, used, h.quota.max)
+		return nil
+	}
+	return fmt.Errorf("%w: adding %d entries to %s, %d of %d entries used", ErrQuotaExceeded, n, target, used, h.quota.max)
+}
+
+// updateQuota updates the counts of changed sets, or forgets them when the
+// change does not tell the new count, which is then read again.
+func (h *Handle) updateQuota(c change) {
+	if h.quota == nil {
+		return
+	}
+	q := h.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if c.op == opDestroyAll {
+		q.counts = make(map[string]int)
+		q.fresh = make(map[string]int)
+		return
+	}
+	n, known := q.counts[c.set]
+	added, checked := q.fresh[c.set]
+	delete(q.fresh, c.set)
+	switch {
+	case c.err != nil:
+	case c.op == opAdd && known:
+		if !checked {
+			// entries already in the set are counted again until a check
+			// lists it
+			added = len(c.entries)
+		}
+		q.counts[c.set] = n + added
+		return
+	case c.op == opFlush:
+		q.counts[c.set] = 0
+		return
+	case c.op == opSwap:
+		m, otherKnown := q.counts[c.other]
+		if known && otherKnown {
+			q.counts[c.set], q.counts[c.other] = m, n
+			return
+		}
+	}
+	delete(q.counts, c.set)
+	delete(q.counts, c.other)
+}
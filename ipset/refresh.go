@@ -0,0 +1,272 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// RefreshStrategy selects how RefreshWith replaces the content of a set.
+type RefreshStrategy int
+
+const (
+	// RefreshSwap fills a temporary set and swaps it with the set, so that
+	// the set changes at once. It needs room for two copies of the set.
+	RefreshSwap RefreshStrategy = iota
+	// RefreshFlush flushes the set and fills it again in a single `ipset
+	// restore`. The set is briefly empty or partially filled while the
+	// batch runs, but no temporary set is needed.
+	RefreshFlush
+	// RefreshDiff lists the set and only deletes and adds the entries that
+	// differ, in a single `ipset restore`. It is the cheapest when few
+	// entries change, and entries that stay are never missing.
+	RefreshDiff
+)
+
+func (st RefreshStrategy) String() string {
+	switch st {
+	case RefreshSwap:
+		return "swap"
+	case RefreshFlush:
+		return "flush"
+	case RefreshDiff:
+		return "diff"
+	}
+	return fmt.Sprintf("RefreshStrategy(%d)", int(st))
+}
+
+// RefreshWith overwrites the set with the specified entries using the given
+// strategy. RefreshSwap behaves like Refresh.
+func (s *IPSet) RefreshWith(entries []string, strategy RefreshStrategy) error {
+	if strategy == RefreshSwap {
+		return s.Refresh(entries)
+	}
+	es := make([]Entry, len(entries))
+	for i, e := range entries {
+		es[i] = Entry{Element: e}
+	}
+	return s.RefreshEntriesWith(es, strategy)
+}
+
+// RefreshEntries overwrites the set with the specified entries, each added
+// with its own options, by hot swapping it with a temporary set filled in a
+// single `ipset restore`. Unlike Refresh, an invalid entry fails the refresh
+// and leaves the set untouched.
+func (s *IPSet) RefreshEntries(entries []Entry) error {
+	return s.RefreshEntriesWith(entries, RefreshSwap)
+}
+
+// RefreshEntriesWith overwrites the set with the specified entries, each added
+// with its own options, using the given strategy. With RefreshDiff, entries
+// already in the set are added again when they carry options, so that their
+// options are updated.
+func (s *IPSet) RefreshEntriesWith(entries []Entry, strategy RefreshStrategy) (err error) {
+	defer s.handle().lockSet(s.Name)()
+	defer func(start time.Time) { s.handle().recordRefresh(s.Name, len(entries), start, err) }(time.Now())
+	if err := validateEntries(entries); err != nil {
+		return err
+	}
+	if err := s.handle().checkOwned(s.Name); err != nil {
+		return err
+	}
+	unlock, err := s.handle().lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer s.handle().undoable("refresh", s.Name, &err)()
+	if err := s.handle().checkQuota(s.Name, len(entries), true); err != nil {
+		return err
+	}
+	switch strategy {
+	case RefreshSwap:
+		return s.refreshSwap(entries)
+	case RefreshFlush:
+		return s.refreshFlush(entries)
+	case RefreshDiff:
+		return s.refreshDiff(entries)
+	}
+	return fmt.Errorf("unknown refresh strategy %v", strategy)
+}
+
+func (s *IPSet) refreshSwap(entries []Entry) error {
+	h := s.handle()
+	tempName := tempSetName(s.Name, "-temp")
+	if err := h.checkPolicy(Operation{Op: OpSwap, Set: tempName, Other: s.Name}); err != nil {
+		return err
+	}
+	if err := s.createHashSet(tempName); err != nil {
+		return err
+	}
+	var batch bytes.Buffer
+	batch.WriteString("flush " + tempName + "\n")
+	writeAdds(&batch, tempName, entries)
+	if err := h.restore(&batch); err != nil {
+		if derr := h.destroyIPSet(tempName); derr != nil {
+			log.Warnf("Error destroying set %s: %v", tempName, derr)
+		}
+		return err
+	}
+	if err := h.swap(tempName, s.Name); err != nil {
+		return err
+	}
+	return h.destroyIPSet(tempName)
+}
+
+func (s *IPSet) refreshFlush(entries []Entry) error {
+	h := s.handle()
+	if err := h.checkPolicy(Operation{Op: OpFlush, Set: s.Name}); err != nil {
+		return err
+	}
+	var batch bytes.Buffer
+	batch.WriteString("flush " + s.Name + "\n")
+	writeAdds(&batch, s.Name, entries)
+	err := h.restore(&batch)
+	h.changed(change{op: opFlush, set: s.Name, err: err})
+	h.changed(change{op: opAdd, set: s.Name, entries: elements(entries), nomatch: nomatchElements(entries), err: err})
+	return err
+}
+
+func (s *IPSet) refreshDiff(entries []Entry) error {
+	current, err := s.handle().members(s.Name)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(current))
+	for _, m := range current {
+		have[memberKey(m)] = true
+	}
+	want := make(map[string]bool, len(entries))
+	var added []Entry
+	for _, e := range entries {
+		key := memberKey(e.Element)
+		want[key] = true
+		if !have[key] || e.EntryOptions != (EntryOptions{}) {
+			added = append(added, e)
+		}
+	}
+	var removed []string
+	for _, m := range current {
+		if !want[memberKey(m)] {
+			removed = append(removed, m)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	var batch bytes.Buffer
+	for _, e := range removed {
+		batch.WriteString("del " + s.Name + " " + e + "\n")
+	}
+	writeAdds(&batch, s.Name, added)
+	h := s.handle()
+	err = h.restore(&batch)
+	h.changed(change{op: opDel, set: s.Name, entries: removed, err: err})
+	h.changed(change{op: opAdd, set: s.Name, entries: elements(added), nomatch: nomatchElements(added), err: err})
+	return err
+}
+
+// writeAdds writes the restore commands adding the entries to the set.
+func writeAdds(batch *bytes.Buffer, set string, entries []Entry) {
+	for _, e := range entries {
+		batch.WriteString("add " + set + " " + e.String() + "\n")
+	}
+}
+
+// elements returns the elements of the entries.
+func elements(entries []Entry) []string {
+	elems := make([]string, len(entries))
+	for i, e := range entries {
+		elems[i] = e.Element
+	}
+	return elems
+}
+
+// nomatchElements returns the elements of the nomatch entries.
+func nomatchElements(entries []Entry) []string {
+	var elems []string
+	for _, e := range entries {
+		if e.Nomatch {
+			elems = append(elems, e.Element)
+		}
+	}
+	return elems
+}
+
+// RefreshPlan is the difference a refresh would make to a set.
+type RefreshPlan struct {
+	// Added are the entries missing from the set.
+	Added []Entry
+	// Removed are the members of the set not in the entries.
+	Removed []string
+	// Changed are the entries already in the set with other options.
+	Changed []Entry
+}
+
+// Empty tells whether the refresh would leave the set as it is.
+func (p RefreshPlan) Empty() bool {
+	return len(p.Added) == 0 && len(p.Removed) == 0 && len(p.Changed) == 0
+}
+
+// PlanRefresh returns the entries RefreshEntries would add, remove and change,
+// without modifying the set, e.g. to preview a feed update. The options of an
+// entry are compared except for its timeout and counters, which a refresh
+// resets anyway. Invalid entries fail it as they would fail the refresh.
+func (s *IPSet) PlanRefresh(entries []Entry) (RefreshPlan, error) {
+	if err := validateEntries(entries); err != nil {
+		return RefreshPlan{}, err
+	}
+	current, err := s.handle().listEntries(s.Name)
+	if err != nil {
+		return RefreshPlan{}, err
+	}
+	have := make(map[string]EntryOptions, len(current))
+	for _, m := range current {
+		have[memberKey(m.Element)] = m.EntryOptions
+	}
+	var plan RefreshPlan
+	want := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		key := memberKey(e.Element)
+		if want[key] {
+			continue
+		}
+		want[key] = true
+		if opts, ok := have[key]; !ok {
+			plan.Added = append(plan.Added, e)
+		} else if stateful(opts) != stateful(e.EntryOptions) {
+			plan.Changed = append(plan.Changed, e)
+		}
+	}
+	for _, m := range current {
+		if !want[memberKey(m.Element)] {
+			plan.Removed = append(plan.Removed, m.Element)
+		}
+	}
+	return plan, nil
+}
+
+// stateful returns the options without the timeout and counters, which
+// change by themselves.
+func stateful(o EntryOptions) EntryOptions {
+	o.Timeout, o.Packets, o.Bytes = 0, 0, 0
+	return o
+}
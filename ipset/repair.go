@@ -0,0 +1,115 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RepairPolicy selects which differences found by Verify Repair fixes.
+type RepairPolicy int
+
+const (
+	// RepairConverge adds the missing entries, re-adds the mismatched ones
+	// with the expected options and removes the unexpected ones.
+	RepairConverge RepairPolicy = iota
+	// RepairAddMissing only adds the missing entries.
+	RepairAddMissing
+	// RepairRemoveUnexpected only removes the unexpected entries.
+	RepairRemoveUnexpected
+)
+
+func (p RepairPolicy) String() string {
+	switch p {
+	case RepairConverge:
+		return "converge"
+	case RepairAddMissing:
+		return "add-missing"
+	case RepairRemoveUnexpected:
+		return "remove-unexpected"
+	}
+	return fmt.Sprintf("RepairPolicy(%d)", int(p))
+}
+
+// Repair brings the set back to the expected entries as allowed by policy,
+// in a single `ipset restore`, and returns the differences it fixed. The
+// differences are found as by Verify; the commands are reported to the audit
+// sink of the handle, if any, like any other mutation.
+func (s *IPSet) Repair(expected []Entry, policy RepairPolicy) (Report, error) {
+	h := s.handle()
+	defer h.lockSet(s.Name)()
+	if err := validateEntries(expected); err != nil {
+		return Report{}, err
+	}
+	if err := h.checkOwned(s.Name); err != nil {
+		return Report{}, err
+	}
+	switch policy {
+	case RepairConverge, RepairAddMissing, RepairRemoveUnexpected:
+	default:
+		return Report{}, fmt.Errorf("unknown repair policy %v", policy)
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return Report{}, err
+	}
+	defer unlock()
+	current, err := h.savedEntries(s.Name)
+	if err != nil {
+		return Report{}, err
+	}
+	r := verify(current, expected)
+	var fixed Report
+	if policy != RepairRemoveUnexpected {
+		fixed.Missing = r.Missing
+	}
+	if policy == RepairConverge {
+		fixed.Mismatched = r.Mismatched
+	}
+	if policy != RepairAddMissing {
+		fixed.Unexpected = r.Unexpected
+	}
+	if fixed.Consistent() {
+		return fixed, nil
+	}
+	if err := h.checkQuota(s.Name, len(fixed.Missing)-len(fixed.Unexpected), false); err != nil {
+		return Report{}, err
+	}
+
+	added := append([]Entry(nil), fixed.Missing...)
+	for _, m := range fixed.Mismatched {
+		added = append(added, m.Expected)
+	}
+	removed := elements(fixed.Unexpected)
+	var batch bytes.Buffer
+	for _, e := range removed {
+		batch.WriteString("del " + s.Name + " " + e + "\n")
+	}
+	writeAdds(&batch, s.Name, added)
+	err = h.restore(&batch)
+	if len(removed) != 0 {
+		h.changed(change{op: opDel, set: s.Name, entries: removed, err: err})
+	}
+	if len(added) != 0 {
+		h.changed(change{op: opAdd, set: s.Name, entries: elements(added), nomatch: nomatchElements(added), err: err})
+	}
+	if err != nil {
+		return Report{}, err
+	}
+	return fixed, nil
+}
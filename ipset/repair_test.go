@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	var r NoopRecorder
+	s := &IPSet{Name: "blocked", h: NewHandle(WithNoopBackend(&r))}
+	expected := []Entry{{Element: "192.0.2.1"}, {Element: "192.0.2.2", EntryOptions: EntryOptions{Timeout: 60}}}
+	// the no-op backend lists sets empty, so every expected entry is missing
+	fixed, err := s.Repair(expected, RepairConverge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fixed.Missing, expected) || len(fixed.Unexpected) != 0 || len(fixed.Mismatched) != 0 {
+		t.Errorf("fixed %+v", fixed)
+	}
+	var restore *RecordedCommand
+	for _, c := range r.Commands() {
+		if c.Args[0] == "restore" {
+			c := c
+			restore = &c
+		}
+	}
+	if restore == nil {
+		t.Fatalf("no restore among %v", r.Commands())
+	}
+	if want := "add blocked 192.0.2.1\nadd blocked 192.0.2.2 timeout 60\n"; restore.Input != want {
+		t.Errorf("restored %q, want %q", restore.Input, want)
+	}
+
+	r.Reset()
+	if fixed, err := s.Repair(expected, RepairRemoveUnexpected); err != nil || !fixed.Consistent() {
+		t.Errorf("RepairRemoveUnexpected fixed %+v, %v", fixed, err)
+	}
+	for _, c := range r.Commands() {
+		if c.Args[0] == "restore" {
+			t.Errorf("RepairRemoveUnexpected ran %s", c)
+		}
+	}
+	if _, err := s.Repair(expected, RepairPolicy(42)); err == nil {
+		t.Error("unknown policy accepted")
+	}
+	if _, err := s.Repair([]Entry{{Element: "192.0.2.1 timeout 0"}}, RepairConverge); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("invalid entry: got %v, want ErrInvalidEntry", err)
+	}
+}
+
+func TestRepairPolicyString(t *testing.T) {
+	for p, want := range map[RepairPolicy]string{
+		RepairConverge:         "converge",
+		RepairAddMissing:       "add-missing",
+		RepairRemoveUnexpected: "remove-unexpected",
+		RepairPolicy(7):        "RepairPolicy(7)",
+	} {
+		if got := p.String(); got != want {
+			t.Errorf("policy %d formatted as %s, want %s", int(p), got, want)
+		}
+	}
+}
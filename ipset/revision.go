@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// Revision returns the revision of the set type the set was created with.
+func (s *IPSet) Revision() (int, error) {
+	hd, err := s.Header()
+	if err != nil {
+		return 0, err
+	}
+	return hd.Revision, nil
+}
+
+// MaxRevision returns the newest revision of the set type the ipset utility
+// supports, or ErrTypeUnsupported if it does not know the type.
+func MaxRevision(settype string) (int, error) {
+	revs, err := SupportedTypes()
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range revs {
+		if r.Type == settype {
+			// newest first
+			return r.Revision, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrTypeUnsupported, settype)
+}
+
+// KernelRevision returns the newest revision of the set type both the kernel
+// and the ipset utility support, i.e. the one sets of the type are created
+// with. It is found by creating and destroying a probe set.
+func KernelRevision(settype string) (int, error) {
+	return defaultHandle.KernelRevision(settype)
+}
+
+// KernelRevision returns the revision sets of the type are created with in
+// the network namespace of the handle.
+func (h *Handle) KernelRevision(settype string) (int, error) {
+	if err := h.initCheck(); err != nil {
+		return 0, err
+	}
+	probe := tempSetName("goipset-rev-"+strconv.Itoa(os.Getpid()), "")
+	args := []string{"create", probe, settype}
+	switch settype {
+	case "bitmap:port":
+		args = append(args, "range", "0-1")
+	case "bitmap:ip", "bitmap:ip,mac":
+		args = append(args, "range", "192.0.2.0/30")
+	}
+	out, err := h.run(args...)
+	if err != nil {
+		if terr := typeUnsupportedError(settype, out); terr != nil {
+			return 0, terr
+		}
+		return 0, fmt.Errorf("error creating probe set of type %s: %w (%s)", settype, err, out)
+	}
+	defer func() {
+		if out, err := h.run("destroy", probe); err != nil {
+			log.Warnf("Error destroying probe set %s: %v (%s)", probe, err, out)
+		}
+	}()
+	hd, err := h.header(probe)
+	if err != nil {
+		return 0, err
+	}
+	return hd.Revision, nil
+}
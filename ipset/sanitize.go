@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidEntry is returned for an entry that could smuggle options or
+// commands into ipset, e.g. an element "1.2.3.4 timeout 0". Options must be
+// given through EntryOptions.
+var ErrInvalidEntry = errors.New("invalid entry")
+
+// ErrInvalidSetName is returned for a set name ipset does not accept or that
+// could smuggle commands into an `ipset restore`, e.g. "a\ndestroy b".
+var ErrInvalidSetName = errors.New("invalid set name")
+
+// ValidateSetName checks that name is a single ipset token of at most 31
+// bytes: not empty, without whitespace, control characters or quotes, and
+// not starting with '-'.
+func ValidateSetName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidSetName)
+	}
+	if len(name) > maxSetNameLen {
+		return fmt.Errorf("%w: name %q longer than %d bytes", ErrInvalidSetName, name, maxSetNameLen)
+	}
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("%w: name %q starts with '-'", ErrInvalidSetName, name)
+	}
+	if i := strings.IndexFunc(name, unsafeRune); i >= 0 {
+		return fmt.Errorf("%w: name %q contains %q", ErrInvalidSetName, name, name[i])
+	}
+	return nil
+}
+
+// ValidateElement checks that elem is a single ipset token: not empty, without
+// whitespace, control characters or quotes, and not starting with '-'.
+func ValidateElement(elem string) error {
+	if elem == "" {
+		return fmt.Errorf("%w: empty element", ErrInvalidEntry)
+	}
+	if strings.HasPrefix(elem, "-") {
+		return fmt.Errorf("%w: element %q starts with '-'", ErrInvalidEntry, elem)
+	}
+	if i := strings.IndexFunc(elem, unsafeRune); i >= 0 {
+		return fmt.Errorf("%w: element %q contains %q", ErrInvalidEntry, elem, elem[i])
+	}
+	return nil
+}
+
+// ValidateEntry checks the element of e, see ValidateElement, and that its
+// options cannot break out of their field in the restore format.
+func ValidateEntry(e Entry) error {
+	if err := ValidateElement(e.Element); err != nil {
+		return err
+	}
+	if strings.IndexFunc(e.Comment, func(r rune) bool { return r == '"' || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("%w: comment %q of %s contains a quote or control character", ErrInvalidEntry, e.Comment, e.Element)
+	}
+	if len(e.Comment) > maxCommentLen {
+		return fmt.Errorf("%w: comment of %s longer than %d bytes", ErrInvalidEntry, e.Element, maxCommentLen)
+	}
+	for _, v := range []string{e.SkbMark, e.SkbPrio, e.SkbQueue} {
+		if strings.IndexFunc(v, unsafeRune) >= 0 {
+			return fmt.Errorf("%w: skbinfo value %q of %s", ErrInvalidEntry, v, e.Element)
+		}
+	}
+	return nil
+}
+
+// validateEntries checks each entry, see ValidateEntry.
+func validateEntries(entries []Entry) error {
+	for _, e := range entries {
+		if err := ValidateEntry(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateElements checks each element, see ValidateElement.
+func validateElements(elems []string) error {
+	for _, e := range elems {
+		if err := ValidateElement(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unsafeRune(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r) || r == '"'
+}
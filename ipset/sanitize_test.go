@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateElement(t *testing.T) {
+	for _, elem := range []string{"192.0.2.1", "10.0.0.0/8", "192.0.2.1,tcp:80", "2001:db8::1", "eth0"} {
+		if err := ValidateElement(elem); err != nil {
+			t.Errorf("ValidateElement(%q) = %v", elem, err)
+		}
+	}
+	for _, elem := range []string{"", "-exist", "192.0.2.1 timeout 0", "192.0.2.1\nflush", "192.0.2.1\t", `192.0.2.1"`} {
+		if err := ValidateElement(elem); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("ValidateElement(%q) = %v, want ErrInvalidEntry", elem, err)
+		}
+	}
+}
+
+func TestValidateEntry(t *testing.T) {
+	for _, e := range []Entry{
+		{Element: "192.0.2.1"},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{Comment: "blocked by ops"}},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{SkbMark: "0x10/0xff", SkbPrio: "1:10", SkbQueue: "2"}},
+	} {
+		if err := ValidateEntry(e); err != nil {
+			t.Errorf("ValidateEntry(%+v) = %v", e, err)
+		}
+	}
+	for _, e := range []Entry{
+		{Element: "192.0.2.1 timeout 0"},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{Comment: `say "hi"`}},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{Comment: "line\nbreak"}},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{Comment: strings.Repeat("x", maxCommentLen+1)}},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{SkbMark: "0x10 nomatch"}},
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{SkbQueue: "2\n"}},
+	} {
+		if err := ValidateEntry(e); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("ValidateEntry(%+v) = %v, want ErrInvalidEntry", e, err)
+		}
+	}
+	if err := validateEntries([]Entry{{Element: "192.0.2.1"}, {Element: "-"}}); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("validateEntries accepted an invalid entry: %v", err)
+	}
+	if err := validateElements([]string{"192.0.2.1", "192.0.2.2 -exist"}); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("validateElements accepted an invalid element: %v", err)
+	}
+}
+
+func TestValidateSetName(t *testing.T) {
+	for _, name := range []string{"blocked", "tenant-a.web_1", strings.Repeat("n", maxSetNameLen)} {
+		if err := ValidateSetName(name); err != nil {
+			t.Errorf("ValidateSetName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-exist", "a b", "a\nflush b", "a\t", `a"`, strings.Repeat("n", maxSetNameLen+1)} {
+		if err := ValidateSetName(name); !errors.Is(err, ErrInvalidSetName) {
+			t.Errorf("ValidateSetName(%q) = %v, want ErrInvalidSetName", name, err)
+		}
+	}
+}
+
+func TestBatchInvalidSetName(t *testing.T) {
+	var r NoopRecorder
+	b := NewHandle(WithNoopBackend(&r)).NewBatch()
+	injected := "a 192.0.2.1\ndestroy victim\nadd a"
+	for _, queue := range []func(){
+		func() { b.Add(injected, Entry{Element: "192.0.2.2"}) },
+		func() { b.AddTimeout(injected, "192.0.2.2", 60) },
+		func() { b.Del(injected, "192.0.2.2") },
+		func() { b.Flush(injected) },
+	} {
+		b.Add("a", Entry{Element: "192.0.2.3"})
+		queue()
+		if err := b.Commit(); !errors.Is(err, ErrInvalidSetName) {
+			t.Errorf("commit of an invalid set name: %v, want ErrInvalidSetName", err)
+		}
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("batches with an invalid set name ran %v", cmds)
+	}
+}
+
+func TestSessionInvalidSetName(t *testing.T) {
+	var r NoopRecorder
+	s := NewHandle(WithNoopBackend(&r)).NewSession(SessionOptions{})
+	defer s.Close()
+	injected := "a 192.0.2.1\ndestroy victim\nadd a"
+	for _, err := range []error{
+		s.Add(injected, Entry{Element: "192.0.2.2"}),
+		s.AddTimeout(injected, "192.0.2.2", 60),
+		s.Del(injected, "192.0.2.2"),
+		s.Flush(injected),
+	} {
+		if !errors.Is(err, ErrInvalidSetName) {
+			t.Errorf("session command on an invalid set name: %v, want ErrInvalidSetName", err)
+		}
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range r.Commands() {
+		if strings.Contains(c.Input, "victim") {
+			t.Errorf("session ran %s with input %q", c, c.Input)
+		}
+	}
+}
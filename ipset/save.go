@@ -0,0 +1,281 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// Save writes the `ipset save` representation of the named set to w.
+// The name may be the constant ipset.AllSets to save every existing set.
+func Save(name string, w io.Writer) error {
+	return defaultHandle.Save(name, w)
+}
+
+// Save writes the `ipset save` representation of the named set to w with the
+// handle, see Save.
+func (h *Handle) Save(name string, w io.Writer) error {
+	return h.save(name, w)
+}
+
+func (h *Handle) save(name string, w io.Writer) error {
+	if err := h.initCheck(); err != nil {
+		return err
+	}
+	args := []string{"save"}
+	if name != AllSets {
+		args = append(args, name)
+	}
+	if out, err := h.runIO(nil, w, args...); err != nil {
+		return fmt.Errorf("error saving set %s: %w (%s)", name, err, out)
+	}
+	return nil
+}
+
+// Restore feeds r, in the format produced by Save, to `ipset restore`.
+// Sets and entries that already exist are not treated as errors.
+func Restore(r io.Reader) error {
+	return defaultHandle.Restore(r)
+}
+
+// Restore feeds r to `ipset restore` with the handle, see Restore. The
+// commands are read first and checked like the other operations of the
+// handle: the sets they name must be owned by it, the flushes, destroys and
+// swaps must pass its destructive policies and the additions its quota.
+// Once ipset ran, the changes are reported to the mirrors, the audit log and
+// the like, a rename as the destruction of the old name and the creation of
+// the new one, and recorded as a single mutation for Undo.
+func (h *Handle) Restore(r io.Reader) error {
+	if err := h.initCheck(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	var changes []change
+	sc := bufio.NewScanner(io.TeeReader(r, &buf))
+	sc.Buffer(nil, 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		cs, err := h.restoreChanges(parse.Fields(sc.Text()))
+		if err != nil {
+			return fmt.Errorf("error restoring sets: line %d: %w", n, err)
+		}
+		changes = append(changes, cs...)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("error reading sets to restore: %w", err)
+	}
+	adds := make(map[string][]string)
+	for _, c := range changes {
+		if c.op == opAdd {
+			adds[c.set] = append(adds[c.set], c.entries...)
+		}
+	}
+	if err := h.checkQuotaEntries(adds); err != nil {
+		return err
+	}
+	saved, err := h.undoSavedSets(changes)
+	if err != nil {
+		return err
+	}
+	err = h.restore(&buf)
+	for _, c := range changes {
+		c.err = err
+		h.changed(c)
+	}
+	if err == nil {
+		h.journalSets("restore", saved)
+	}
+	return err
+}
+
+// restoreCommands maps the commands and aliases accepted by `ipset restore`
+// to the commands checked by Restore.
+var restoreCommands = map[string]string{
+	"create": "create", "n": "create", "-N": "create",
+	"add": "add", "a": "add", "-A": "add",
+	"del": "del", "d": "del", "-D": "del",
+	"flush": "flush", "f": "flush", "-F": "flush",
+	"destroy": "destroy", "x": "destroy", "-X": "destroy",
+	"rename": "rename", "e": "rename", "-E": "rename",
+	"swap": "swap", "w": "swap", "-W": "swap",
+}
+
+// restoreChanges checks a command of a restore stream, split in fields, and
+// returns the changes it makes. Flushing or destroying all sets is checked
+// and reported set by set.
+func (h *Handle) restoreChanges(fields []string) ([]change, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	cmd := restoreCommands[fields[0]]
+	args := append([]string{cmd}, fields[1:]...)
+	if cmd == "" || (len(args) < 2 && cmd != "flush" && cmd != "destroy") {
+		// nothing changed, or the command is left to ipset to reject
+		return nil, nil
+	}
+	if err := h.checkArgs(args); err != nil {
+		return nil, err
+	}
+	set := ""
+	if len(args) > 1 {
+		set = args[1]
+	}
+	switch cmd {
+	case "create":
+		return []change{{op: opCreate, set: set}}, nil
+	case "add", "del":
+		c := change{op: opAdd, set: set}
+		if cmd == "del" {
+			c.op = opDel
+		}
+		if len(args) > 2 {
+			c.entries = []string{args[2]}
+		}
+		if c.op == opAdd && parseEntry(args[2:]).Nomatch {
+			c.nomatch = c.entries
+		}
+		return []change{c}, nil
+	case "flush", "destroy":
+		op, c := OpFlush, change{op: opFlush}
+		if cmd == "destroy" {
+			op, c = OpDestroy, change{op: opDestroy}
+		}
+		names := []string{set}
+		if set == "" {
+			if cmd == "destroy" {
+				op = OpDestroyAll
+			}
+			var err error
+			if names, err = h.listAllSetNames(); err != nil {
+				return nil, err
+			}
+		}
+		var cs []change
+		for _, name := range names {
+			if err := h.checkPolicy(Operation{Op: op, Set: name}); err != nil {
+				return nil, err
+			}
+			c.set = name
+			cs = append(cs, c)
+		}
+		return cs, nil
+	case "rename", "swap":
+		if len(args) < 3 {
+			return nil, nil
+		}
+		if cmd == "rename" {
+			return []change{{op: opDestroy, set: set}, {op: opCreate, set: args[2]}}, nil
+		}
+		if err := h.checkPolicy(Operation{Op: OpSwap, Set: set, Other: args[2]}); err != nil {
+			return nil, err
+		}
+		return []change{{op: opSwap, set: set, other: args[2]}}, nil
+	}
+	return nil, nil
+}
+
+func (h *Handle) restore(r io.Reader) error {
+	if err := h.initCheck(); err != nil {
+		return err
+	}
+	if out, err := h.runIO(r, nil, "restore", "-exist"); err != nil {
+		return fmt.Errorf("error restoring sets: %w (%s)", err, out)
+	}
+	return nil
+}
+
+// Save writes the `ipset save` representation of the set to w.
+func (s *IPSet) Save(w io.Writer) error {
+	return s.handle().save(s.Name, w)
+}
+
+// Diff compares the members of the set with entries, in the canonical form
+// ipset lists them in. It returns the entries missing from the set and the
+// members of the set that are not in entries.
+func (s *IPSet) Diff(entries []string) (added, removed []string, err error) {
+	current, err := s.handle().members(s.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	have := make(map[string]bool, len(current))
+	for _, m := range current {
+		have[memberKey(m)] = true
+	}
+	want := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		key := memberKey(e)
+		if want[key] {
+			continue
+		}
+		want[key] = true
+		if !have[key] {
+			added = append(added, e)
+		}
+	}
+	for _, m := range current {
+		if !want[memberKey(m)] {
+			removed = append(removed, m)
+		}
+	}
+	return added, removed, nil
+}
+
+// members returns the elements of a set without the per-entry options
+// (timeout, counters, comment) that `ipset list` prints after them.
+func (h *Handle) members(set string) ([]string, error) {
+	entries, err := h.listEntries(set)
+	if err != nil {
+		return []string{}, err
+	}
+	return elements(entries), nil
+}
+
+// savedEntries returns the entries of the set from its `ipset save` output,
+// one canonical add line per entry. Unlike the list output, it round-trips the
+// options of the entries exactly.
+func (h *Handle) savedEntries(set string) ([]Entry, error) {
+	var entries []Entry
+	err := h.eachSavedEntry(set, func(e Entry) {
+		entries = append(entries, e)
+	})
+	return entries, err
+}
+
+// eachSavedEntry calls fn with each entry of the `ipset save` output of the
+// set as it is read.
+func (h *Handle) eachSavedEntry(set string, fn func(Entry)) error {
+	if err := h.initCheck(); err != nil {
+		return err
+	}
+	out, err := h.scanLines(func(l string) {
+		if !strings.HasPrefix(l, "add ") {
+			return
+		}
+		if fields := parse.Fields(l); len(fields) > 2 && fields[1] == set {
+			fn(parseEntry(fields[2:]))
+		}
+	}, "save", set)
+	if err != nil {
+		return fmt.Errorf("error saving set %s: %w (%s)", set, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bufio"
+	"io"
+)
+
+// maxLineLen is the longest output line scanLines accepts, long comments included.
+const maxLineLen = 1024 * 1024
+
+// scanLines runs the ipset utility and calls fn with each line of its
+// standard output as it is read, without holding the whole output in memory.
+// The standard error is returned.
+func (h *Handle) scanLines(fn func(line string), args ...string) ([]byte, error) {
+	pr, pw := io.Pipe()
+	var stderr []byte
+	var runErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stderr, runErr = h.runIO(nil, pw, args...)
+		pw.CloseWithError(runErr)
+	}()
+	sc := bufio.NewScanner(pr)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineLen)
+	for sc.Scan() {
+		fn(sc.Text())
+	}
+	scanErr := sc.Err()
+	// let the command run to completion if scanning stopped early
+	pr.CloseWithError(scanErr)
+	<-done
+	if runErr != nil {
+		return stderr, runErr
+	}
+	return stderr, scanErr
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// selfTestEntry is a documentation address (RFC 5737) used as the scratch entry.
+const selfTestEntry = "192.0.2.1"
+
+// SelfTestResult is the structured outcome of SelfTest.
+type SelfTestResult struct {
+	// BinaryFound reports whether the ipset utility could be located.
+	BinaryFound bool
+	// Path is the location of the ipset utility.
+	Path string
+	// Version is the version reported by the utility, empty for BusyBox.
+	Version string
+	// BusyBox is set when running against the BusyBox applet.
+	BusyBox bool
+	// Privileged reports whether the process may manipulate sets.
+	Privileged bool
+	// KernelSupport reports whether the kernel accepted the scratch set.
+	KernelSupport bool
+	// Duration is the time taken by the whole check.
+	Duration time.Duration
+}
+
+// SelfTest checks that sets can be managed on this host. It creates a uniquely named
+// scratch set, adds, tests and deletes an entry and finally destroys the set.
+// The returned error is nil only if every step succeeded, which makes SelfTest
+// suitable as a readiness probe; the result is filled in as far as the check got.
+func SelfTest() (res SelfTestResult, err error) {
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	if err = initCheck(); err != nil {
+		if errors.Is(err, errIpsetNotSupported) {
+			res.BinaryFound, res.Path = true, ipsetPath
+		}
+		return res, err
+	}
+	res.BinaryFound = true
+	res.Path = ipsetPath
+	res.Version = caps.Version
+	res.BusyBox = caps.BusyBox
+
+	name := fmt.Sprintf("selftest-%d-%d", os.Getpid(), start.UnixNano()%1000000)
+	s, err := New(name, "hash:ip", &Params{})
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrPermission):
+			return res, fmt.Errorf("self test: insufficient privileges: %w", err)
+		case errors.Is(err, ErrTypeUnsupported), errors.Is(err, ErrProtocol):
+			res.Privileged = true
+			return res, fmt.Errorf("self test: kernel does not support ipset: %w", err)
+		}
+		return res, fmt.Errorf("self test: %w", err)
+	}
+	res.Privileged = true
+	res.KernelSupport = true
+	defer func() {
+		if derr := s.Destroy(); derr != nil && err == nil {
+			err = fmt.Errorf("self test: %w", derr)
+		}
+	}()
+
+	if err = s.Add(selfTestEntry, 0); err != nil {
+		return res, fmt.Errorf("self test: %w", err)
+	}
+	found, err := s.Test(selfTestEntry)
+	if err != nil {
+		return res, fmt.Errorf("self test: %w", err)
+	}
+	if !found {
+		return res, fmt.Errorf("self test: entry %s not found after add", selfTestEntry)
+	}
+	if err = s.Del(selfTestEntry); err != nil {
+		return res, fmt.Errorf("self test: %w", err)
+	}
+	if found, err = s.Test(selfTestEntry); err != nil {
+		return res, fmt.Errorf("self test: %w", err)
+	}
+	if found {
+		return res, fmt.Errorf("self test: entry %s still found after delete", selfTestEntry)
+	}
+	return res, nil
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// refreshChunkSize is the number of entries Refresh sends per stream message.
+const refreshChunkSize = 1024
+
+// IPSetClient is the client API of the service.
+type IPSetClient interface {
+	Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Empty, error)
+	Destroy(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Empty, error)
+	Flush(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Empty, error)
+	Swap(ctx context.Context, in *SwapRequest, opts ...grpc.CallOption) (*Empty, error)
+	Add(ctx context.Context, in *EntryRequest, opts ...grpc.CallOption) (*Empty, error)
+	Del(ctx context.Context, in *EntryRequest, opts ...grpc.CallOption) (*Empty, error)
+	Test(ctx context.Context, in *EntryRequest, opts ...grpc.CallOption) (*TestResponse, error)
+	RefreshStream(ctx context.Context, opts ...grpc.CallOption) (IPSet_RefreshStreamClient, error)
+	ListStream(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (IPSet_ListStreamClient, error)
+	AddStream(ctx context.Context, opts ...grpc.CallOption) (IPSet_AddStreamClient, error)
+}
+
+// IPSet_RefreshStreamClient is the client side of a RefreshStream call.
+type IPSet_RefreshStreamClient interface {
+	Send(*RefreshChunk) error
+	CloseAndRecv() (*Empty, error)
+	grpc.ClientStream
+}
+
+// IPSet_AddStreamClient is the client side of an AddStream call.
+type IPSet_AddStreamClient interface {
+	Send(*AddChunk) error
+	CloseAndRecv() (*AddResponse, error)
+	grpc.ClientStream
+}
+
+// IPSet_ListStreamClient is the client side of a ListStream call.
+type IPSet_ListStreamClient interface {
+	Recv() (*Member, error)
+	grpc.ClientStream
+}
+
+type ipsetClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewIPSetClient returns a client of the service using the connection cc.
+// Every call is made with the JSON codec of the service.
+func NewIPSetClient(cc grpc.ClientConnInterface) IPSetClient {
+	return &ipsetClient{cc}
+}
+
+// callOpts prepends the codec of the service to opts. It is announced by its
+// content-subtype but forced, which spares clients the codec registration.
+func callOpts(opts []grpc.CallOption) []grpc.CallOption {
+	return append([]grpc.CallOption{grpc.CallContentSubtype(CodecName), grpc.ForceCodec(jsonCodec{})}, opts...)
+}
+
+func (c *ipsetClient) invoke(ctx context.Context, method string, in, out interface{}, opts []grpc.CallOption) error {
+	return c.cc.Invoke(ctx, "/"+ServiceName+"/"+method, in, out, callOpts(opts)...)
+}
+
+func (c *ipsetClient) Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Create", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) Destroy(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Destroy", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) Flush(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Flush", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) Swap(ctx context.Context, in *SwapRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Swap", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) Add(ctx context.Context, in *EntryRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Add", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) Del(ctx context.Context, in *EntryRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Del", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) Test(ctx context.Context, in *EntryRequest, opts ...grpc.CallOption) (*TestResponse, error) {
+	out := new(TestResponse)
+	if err := c.invoke(ctx, "Test", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ipsetClient) RefreshStream(ctx context.Context, opts ...grpc.CallOption) (IPSet_RefreshStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/RefreshStream", callOpts(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return &refreshStreamClient{stream}, nil
+}
+
+type refreshStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *refreshStreamClient) Send(m *RefreshChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *refreshStreamClient) CloseAndRecv() (*Empty, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ipsetClient) ListStream(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (IPSet_ListStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[1], "/"+ServiceName+"/ListStream", callOpts(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	x := &listStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type listStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *listStreamClient) Recv() (*Member, error) {
+	m := new(Member)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ipsetClient) AddStream(ctx context.Context, opts ...grpc.CallOption) (IPSet_AddStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[2], "/"+ServiceName+"/AddStream", callOpts(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return &addStreamClient{stream}, nil
+}
+
+type addStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *addStreamClient) Send(m *AddChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *addStreamClient) CloseAndRecv() (*AddResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(AddResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Refresh replaces the content of the remote set with entries, streaming them
+// in chunks so no single message grows with the size of the set.
+func Refresh(ctx context.Context, c IPSetClient, set string, entries []string) error {
+	stream, err := c.RefreshStream(ctx)
+	if err != nil {
+		return err
+	}
+	chunk := &RefreshChunk{Set: set}
+	for {
+		n := len(entries)
+		if n > refreshChunkSize {
+			n = refreshChunkSize
+		}
+		chunk.Entries = entries[:n]
+		if err := stream.Send(chunk); err != nil {
+			if err == io.EOF {
+				// the server ended the call, the status tells why
+				break
+			}
+			return err
+		}
+		if entries = entries[n:]; len(entries) == 0 {
+			break
+		}
+		chunk = &RefreshChunk{}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// AddAll adds entries to the remote set with the given timeout, streaming
+// them in chunks like Refresh. It returns the number of entries added.
+func AddAll(ctx context.Context, c IPSetClient, set string, entries []string, timeout int) (int, error) {
+	stream, err := c.AddStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	chunk := &AddChunk{Set: set, Timeout: timeout}
+	for {
+		n := len(entries)
+		if n > refreshChunkSize {
+			n = refreshChunkSize
+		}
+		chunk.Entries = entries[:n]
+		if err := stream.Send(chunk); err != nil {
+			if err == io.EOF {
+				// the server ended the call, the status tells why
+				break
+			}
+			return 0, err
+		}
+		if entries = entries[n:]; len(entries) == 0 {
+			break
+		}
+		chunk = &AddChunk{Timeout: timeout}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	return resp.Added, nil
+}
+
+// List returns the members of the remote set.
+func List(ctx context.Context, c IPSetClient, set string) ([]string, error) {
+	stream, err := c.ListStream(ctx, &SetRequest{Name: set})
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m.Entry)
+	}
+}
+
+// tokenCredentials sends a bearer token with every call.
+type tokenCredentials string
+
+// TokenCredentials returns the credentials authenticating the calls of a
+// client with token, for use with grpc.WithPerRPCCredentials. They are also
+// sent over connections without TLS, such as unix sockets.
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool { return false }
@@ -0,0 +1,378 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server exposes the ipset library over gRPC, so that a privileged agent
+// on each host can serve unprivileged local or remote controllers.
+//
+// The service is described by hand rather than generated from a protobuf schema:
+// messages are the plain Go structs of this package and travel as JSON
+// (content-type "application/grpc+json"). NewIPSetClient selects the codec
+// automatically; clients in other languages must send that content-subtype.
+//
+// A minimal agent:
+//
+//	lis, _ := net.Listen("unix", "/run/goipset.sock")
+//	g := grpc.NewServer()
+//	server.RegisterIPSetServer(g, server.NewServer())
+//	g.Serve(lis)
+//
+// WithPolicy makes the server authenticate its clients, with a bearer token
+// in the "authorization" metadata or a TLS client certificate, and check the
+// per-set permissions of every call, confining each client to the sets of
+// its tenant if the policy has Tenants; see the auth package.
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"github.com/intuitivelabs/go-ipset/ipset/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements IPSetServer on top of the ipset package.
+type Server struct {
+	mu sync.Mutex
+	// sets created through the server, needed to re-create temporary sets on refresh
+	sets   map[string]*ipset.IPSet
+	policy *auth.Policy
+	handle *ipset.Handle
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithPolicy makes the server authenticate and authorize every call with p.
+// Without it, any client able to connect may do anything.
+func WithPolicy(p *auth.Policy) Option {
+	return func(s *Server) { s.policy = p }
+}
+
+// WithHandle makes the server run every operation through h, so that the
+// options of the handle, such as its owner prefix, quota, destructive
+// policies, lock and undo journal, apply to the calls. Without it, the server
+// uses a handle of its own without options.
+func WithHandle(h *ipset.Handle) Option {
+	return func(s *Server) { s.handle = h }
+}
+
+// NewServer returns a Server managing the sets of the local host.
+func NewServer(opts ...Option) *Server {
+	s := &Server{sets: make(map[string]*ipset.IPSet), handle: ipset.NewHandle()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// authorize checks that the client of the call holds perm on the sets.
+func (s *Server) authorize(ctx context.Context, perm auth.Permission, sets ...string) error {
+	if s.policy == nil {
+		return nil
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) != 0 {
+			token = auth.BearerToken(v[0])
+		}
+	}
+	var chains [][]*x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chains = info.State.VerifiedChains
+		}
+	}
+	principal, err := s.policy.Authenticate(token, chains)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := s.policy.Authorize(principal, perm, sets...); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+func (s *Server) lookup(name string) *ipset.IPSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if set, ok := s.sets[name]; ok {
+		return set
+	}
+	return s.handle.Set(name)
+}
+
+// toStatus maps an error of the ipset package to the gRPC status of its
+// class, codes.Internal for the others.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ipset.ErrInvalidEntry), errors.Is(err, ipset.ErrInvalidSetName):
+		code = codes.InvalidArgument
+	case errors.Is(err, ipset.ErrSetNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ipset.ErrNotOwned), errors.Is(err, auth.ErrPermissionDenied):
+		code = codes.PermissionDenied
+	case errors.Is(err, ipset.ErrPolicyDenied):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ipset.ErrQuotaExceeded):
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, err.Error())
+}
+
+// Create creates the set, or adopts it if it already exists.
+func (s *Server) Create(ctx context.Context, in *CreateRequest) (*Empty, error) {
+	if err := s.authorize(ctx, auth.Mutate, in.Name); err != nil {
+		return nil, err
+	}
+	if in.Name == "" || in.Type == "" {
+		return nil, status.Error(codes.InvalidArgument, "set name and type are required")
+	}
+	set, err := s.handle.New(in.Name, in.Type, &ipset.Params{
+		HashFamily: in.Family,
+		HashSize:   in.HashSize,
+		MaxElem:    in.MaxElem,
+		Timeout:    in.Timeout,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	s.mu.Lock()
+	s.sets[in.Name] = set
+	s.mu.Unlock()
+	return &Empty{}, nil
+}
+
+// Destroy destroys the set.
+func (s *Server) Destroy(ctx context.Context, in *SetRequest) (*Empty, error) {
+	if err := s.authorize(ctx, auth.Destroy, in.Name); err != nil {
+		return nil, err
+	}
+	if err := s.lookup(in.Name).Destroy(); err != nil {
+		return nil, toStatus(err)
+	}
+	s.mu.Lock()
+	delete(s.sets, in.Name)
+	s.mu.Unlock()
+	return &Empty{}, nil
+}
+
+// Flush removes all entries of the set.
+func (s *Server) Flush(ctx context.Context, in *SetRequest) (*Empty, error) {
+	if err := s.authorize(ctx, auth.Mutate, in.Name); err != nil {
+		return nil, err
+	}
+	return &Empty{}, toStatus(s.lookup(in.Name).Flush())
+}
+
+// Swap exchanges the content of two sets.
+func (s *Server) Swap(ctx context.Context, in *SwapRequest) (*Empty, error) {
+	if err := s.authorize(ctx, auth.Mutate, in.From, in.To); err != nil {
+		return nil, err
+	}
+	return &Empty{}, toStatus(s.handle.Swap(in.From, in.To))
+}
+
+// options returns the options of the entry added by Add.
+func (in *EntryRequest) options() ipset.AddOptions {
+	return ipset.AddOptions{Timeout: in.Timeout, Comment: in.Comment, Packets: in.Packets, Bytes: in.Bytes, Nomatch: in.Nomatch}
+}
+
+// Add adds an entry, with its options, to the set.
+func (s *Server) Add(ctx context.Context, in *EntryRequest) (*Empty, error) {
+	if err := s.authorize(ctx, auth.Mutate, in.Set); err != nil {
+		return nil, err
+	}
+	return &Empty{}, toStatus(s.lookup(in.Set).AddWithOptions(in.Entry, in.options()))
+}
+
+// Del deletes an entry from the set.
+func (s *Server) Del(ctx context.Context, in *EntryRequest) (*Empty, error) {
+	if err := s.authorize(ctx, auth.Mutate, in.Set); err != nil {
+		return nil, err
+	}
+	return &Empty{}, toStatus(s.lookup(in.Set).Del(in.Entry))
+}
+
+// Test checks whether an entry is in the set.
+func (s *Server) Test(ctx context.Context, in *EntryRequest) (*TestResponse, error) {
+	if err := s.authorize(ctx, auth.Read, in.Set); err != nil {
+		return nil, err
+	}
+	found, err := s.lookup(in.Set).Test(in.Entry)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &TestResponse{Found: found}, nil
+}
+
+// RefreshStream replaces the content of a set with the entries of the stream,
+// feeding them to `ipset restore` as they arrive rather than holding them in
+// memory, see ipset.IPSet.RefreshFrom. An invalid entry fails the refresh and
+// leaves the set untouched. Only sets created through the server can be
+// refreshed, since the temporary set must be created with the same
+// parameters.
+func (s *Server) RefreshStream(stream IPSet_RefreshStreamServer) error {
+	chunk, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "empty refresh stream")
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkSetName(chunk.Set); err != nil {
+		return err
+	}
+	if err := s.authorize(stream.Context(), auth.Mutate, chunk.Set); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	set, ok := s.sets[chunk.Set]
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.FailedPrecondition, "set %q was not created through this server", chunk.Set)
+	}
+	name := chunk.Set
+	var serr error
+	seq := func(yield func(ipset.Entry, error) bool) {
+		for {
+			for _, e := range chunk.Entries {
+				if !yield(ipset.Entry{Element: e}, nil) {
+					return
+				}
+			}
+			if chunk, err = stream.Recv(); err != nil {
+				if err != io.EOF {
+					yield(ipset.Entry{}, err)
+				}
+				return
+			}
+			if serr = checkChunkSet(name, chunk.Set); serr != nil {
+				yield(ipset.Entry{}, serr)
+				return
+			}
+		}
+	}
+	if err := set.RefreshFrom(seq); err != nil {
+		if serr != nil {
+			return serr
+		}
+		return toStatus(err)
+	}
+	return stream.SendAndClose(&Empty{})
+}
+
+// AddStream adds the entries of the stream to a set, committing them in
+// `ipset restore` batches of ipset.DefaultChunkSize entries as they arrive.
+// On failure, the entries of the batches committed before remain added.
+func (s *Server) AddStream(stream IPSet_AddStreamServer) error {
+	chunk, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "empty add stream")
+	}
+	if err != nil {
+		return err
+	}
+	set := chunk.Set
+	if err := checkSetName(set); err != nil {
+		return err
+	}
+	if err := s.authorize(stream.Context(), auth.Mutate, set); err != nil {
+		return err
+	}
+	b := s.handle.NewBatch()
+	added := 0
+	commit := func() error {
+		n := b.Len()
+		if err := b.Commit(); err != nil {
+			return toStatus(err)
+		}
+		added += n
+		return nil
+	}
+	for {
+		for _, e := range chunk.Entries {
+			if chunk.Timeout != 0 {
+				b.AddTimeout(set, e, chunk.Timeout)
+			} else {
+				b.Add(set, ipset.Entry{Element: e})
+			}
+		}
+		if b.Len() >= ipset.DefaultChunkSize {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+		if chunk, err = stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := checkChunkSet(set, chunk.Set); err != nil {
+			return err
+		}
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	return stream.SendAndClose(&AddResponse{Added: added})
+}
+
+// checkSetName rejects the set name of a stream if it is not a valid ipset
+// name, which would inject commands into the restore the stream feeds.
+func checkSetName(name string) error {
+	if err := ipset.ValidateSetName(name); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// checkChunkSet checks that a chunk after the first one of a stream about the
+// named set names the same set or none, as only that one was authorized.
+func checkChunkSet(name, set string) error {
+	if set != "" && set != name {
+		return status.Errorf(codes.InvalidArgument, "chunk for set %q in a stream for set %q", set, name)
+	}
+	return nil
+}
+
+// ListStream sends the members of a set.
+func (s *Server) ListStream(in *SetRequest, stream IPSet_ListStreamServer) error {
+	if err := s.authorize(stream.Context(), auth.Read, in.Name); err != nil {
+		return err
+	}
+	members, err := s.lookup(in.Name).Members()
+	if err != nil {
+		return toStatus(err)
+	}
+	for _, m := range members {
+		if err := stream.Send(&Member{Entry: m}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
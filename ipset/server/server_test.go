@@ -0,0 +1,233 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"github.com/intuitivelabs/go-ipset/ipset/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dial serves srv on an in-memory listener and returns a client of it.
+func dial(t *testing.T, srv IPSetServer, opts ...grpc.DialOption) IPSetClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	RegisterIPSetServer(g, srv)
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	cc, err := grpc.Dial("bufnet", append([]grpc.DialOption{grpc.WithContextDialer(dialer), grpc.WithInsecure()}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return NewIPSetClient(cc)
+}
+
+func TestServerRejectsInvalidCalls(t *testing.T) {
+	c := dial(t, NewServer())
+	ctx := context.Background()
+	if _, err := c.Create(ctx, &CreateRequest{Name: "blocked"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("create without type: got %v, want InvalidArgument", err)
+	}
+	stream, err := c.RefreshStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty refresh stream: got %v, want InvalidArgument", err)
+	}
+	if err := Refresh(ctx, c, "unmanaged", []string{"192.0.2.1", "192.0.2.2"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("refresh of a set not created through the server: got %v, want FailedPrecondition", err)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	var codec jsonCodec
+	data, err := codec.Marshal(&RefreshChunk{Set: "blocked", Entries: []string{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunk RefreshChunk
+	if err := codec.Unmarshal(data, &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Set != "blocked" || len(chunk.Entries) != 1 || chunk.Entries[0] != "192.0.2.1" {
+		t.Errorf("chunk decoded as %+v", chunk)
+	}
+	if codec.Name() != CodecName {
+		t.Errorf("codec named %s, want %s", codec.Name(), CodecName)
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	srv := NewServer(WithPolicy(&auth.Policy{
+		Tokens: map[string]string{"feed-token": "feed"},
+		Rules:  []auth.Rule{{Principal: "feed", Sets: "blocklist-*", Allow: auth.Mutate}},
+	}))
+	ctx := context.Background()
+	anonymous := dial(t, srv)
+	if _, err := anonymous.Create(ctx, &CreateRequest{Name: "blocklist-a"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without token: got %v, want Unauthenticated", err)
+	}
+	feed := dial(t, srv, grpc.WithPerRPCCredentials(TokenCredentials("feed-token")))
+	// an authorized call fails validation only after the policy check
+	if _, err := feed.Create(ctx, &CreateRequest{Name: "blocklist-a"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("authorized create without type: got %v, want InvalidArgument", err)
+	}
+	for name, call := range map[string]func() error{
+		"create": func() error {
+			_, err := feed.Create(ctx, &CreateRequest{Name: "allowlist", Type: "hash:ip"})
+			return err
+		},
+		"destroy": func() error {
+			_, err := feed.Destroy(ctx, &SetRequest{Name: "blocklist-a"})
+			return err
+		},
+		"swap": func() error {
+			_, err := feed.Swap(ctx, &SwapRequest{From: "blocklist-a", To: "allowlist"})
+			return err
+		},
+		"refresh": func() error { return Refresh(ctx, feed, "allowlist", []string{"192.0.2.1"}) },
+	} {
+		if err := call(); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("unauthorized %s: got %v, want PermissionDenied", name, err)
+		}
+	}
+}
+
+func TestServerStreamStaysOnItsSet(t *testing.T) {
+	c := dial(t, NewServer(WithPolicy(&auth.Policy{
+		Tokens: map[string]string{"feed-token": "feed"},
+		Rules:  []auth.Rule{{Principal: "feed", Sets: "blocklist-*", Allow: auth.Mutate}},
+	})), grpc.WithPerRPCCredentials(TokenCredentials("feed-token")))
+	stream, err := c.AddStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first chunk authorizes the stream for blocklist-a only
+	for _, chunk := range []*AddChunk{
+		{Set: "blocklist-a", Entries: []string{"192.0.2.1"}},
+		{Set: "allowlist", Entries: []string{"192.0.2.2"}},
+	} {
+		if err := stream.Send(chunk); err != nil {
+			break
+		}
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("chunk for another set: got %v, want InvalidArgument", err)
+	}
+	if err := checkChunkSet("blocklist-a", ""); err != nil {
+		t.Errorf("chunk without set rejected: %v", err)
+	}
+	if err := checkChunkSet("blocklist-a", "blocklist-a"); err != nil {
+		t.Errorf("chunk for the same set rejected: %v", err)
+	}
+}
+
+func TestServerStreamSetNameInjection(t *testing.T) {
+	var r ipset.NoopRecorder
+	h := ipset.NewHandle(ipset.WithNoopBackend(&r), ipset.WithOwnerPrefix("tenant-a-"))
+	c := dial(t, NewServer(WithHandle(h)))
+	injected := "tenant-a-x 1.1.1.1\ndestroy tenant-b-victim\nadd tenant-a-x"
+	add, err := c.AddStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	add.Send(&AddChunk{Set: injected, Entries: []string{"192.0.2.1"}})
+	if _, err := add.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("add stream to an injected set name: got %v, want InvalidArgument", err)
+	}
+	refresh, err := c.RefreshStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh.Send(&RefreshChunk{Set: injected, Entries: []string{"192.0.2.1"}})
+	if _, err := refresh.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("refresh stream of an injected set name: got %v, want InvalidArgument", err)
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("injected set name ran %v", cmds)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	if err := toStatus(nil); err != nil {
+		t.Errorf("toStatus(nil) = %v", err)
+	}
+	for _, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{fmt.Errorf("add: %w", ipset.ErrInvalidEntry), codes.InvalidArgument},
+		{fmt.Errorf("add: %w", ipset.ErrInvalidSetName), codes.InvalidArgument},
+		{fmt.Errorf("list: %w", ipset.ErrSetNotFound), codes.NotFound},
+		{fmt.Errorf("del: %w", ipset.ErrNotOwned), codes.PermissionDenied},
+		{auth.ErrWrongTenant, codes.PermissionDenied},
+		{fmt.Errorf("destroy: %w", ipset.ErrPolicyDenied), codes.FailedPrecondition},
+		{fmt.Errorf("add: %w", ipset.ErrQuotaExceeded), codes.ResourceExhausted},
+		{errors.New("exit status 1"), codes.Internal},
+	} {
+		if code := status.Code(toStatus(tc.err)); code != tc.code {
+			t.Errorf("toStatus(%v) = %v, want %v", tc.err, code, tc.code)
+		}
+	}
+}
+
+func TestServerQuota(t *testing.T) {
+	var r ipset.NoopRecorder
+	c := dial(t, NewServer(WithHandle(ipset.NewHandle(ipset.WithNoopBackend(&r), ipset.WithQuota(0, ipset.QuotaReject)))))
+	ctx := context.Background()
+	if _, err := c.Create(ctx, &CreateRequest{Name: "blocked", Type: "hash:ip"}); err != nil {
+		t.Fatal(err)
+	}
+	// the no-op backend lists the set empty, so any entry is over a quota of zero
+	if _, err := c.Add(ctx, &EntryRequest{Set: "blocked", Entry: "192.0.2.1"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("add over the quota: got %v, want ResourceExhausted", err)
+	}
+}
+
+func TestServerHandle(t *testing.T) {
+	var r ipset.NoopRecorder
+	h := ipset.NewHandle(ipset.WithNoopBackend(&r), ipset.WithDestructivePolicy(func(_ *ipset.Handle, o ipset.Operation) error {
+		if o.Op == ipset.OpFlush {
+			return errors.New("flushes are forbidden")
+		}
+		return nil
+	}))
+	c := dial(t, NewServer(WithHandle(h)))
+	ctx := context.Background()
+	if _, err := c.Add(ctx, &EntryRequest{Set: "blocked", Entry: "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := r.Commands(); len(cmds) != 1 || cmds[0].Args[0] != "add" {
+		t.Errorf("add ran %v through the handle", cmds)
+	}
+	if _, err := c.Flush(ctx, &SetRequest{Name: "blocked"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("flush denied by the handle policy: got %v, want FailedPrecondition", err)
+	}
+}
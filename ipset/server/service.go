@@ -0,0 +1,276 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// ServiceName is the fully qualified name of the gRPC service.
+const ServiceName = "goipset.v1.IPSet"
+
+// CodecName is the content-subtype of the JSON codec the service uses on the wire,
+// i.e. requests are sent as "application/grpc+json".
+const CodecName = "json"
+
+var registerCodec sync.Once
+
+// jsonCodec encodes the plain Go messages of the service as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return CodecName }
+
+// Empty is the message of requests and responses without payload.
+type Empty struct{}
+
+// CreateRequest asks for the creation of a set, see ipset.New.
+type CreateRequest struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Family   string `json:"family,omitempty"`
+	HashSize int    `json:"hashsize,omitempty"`
+	MaxElem  int    `json:"maxelem,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+// SetRequest names the set an operation applies to.
+type SetRequest struct {
+	Name string `json:"name"`
+}
+
+// EntryRequest names a set and an entry. The options are only used by Add, see
+// ipset.AddOptions: a zero Timeout applies the default timeout of the set and
+// ipset.TimeoutPermanent stores the entry permanently.
+type EntryRequest struct {
+	Set     string `json:"set"`
+	Entry   string `json:"entry"`
+	Timeout int    `json:"timeout,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	Packets uint64 `json:"packets,omitempty"`
+	Bytes   uint64 `json:"bytes,omitempty"`
+	Nomatch bool   `json:"nomatch,omitempty"`
+}
+
+// TestResponse reports whether the tested entry is in the set.
+type TestResponse struct {
+	Found bool `json:"found"`
+}
+
+// SwapRequest names the sets exchanged by Swap.
+type SwapRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RefreshChunk is one message of a RefreshStream. The set name is taken from
+// the first message; the following ones must name the same set or none. The
+// entries of all messages make up the new content.
+type RefreshChunk struct {
+	Set     string   `json:"set,omitempty"`
+	Entries []string `json:"entries"`
+}
+
+// AddChunk is one message of an AddStream. The set name is taken from the
+// first message; the following ones must name the same set or none. The
+// entries of all messages are added to the set.
+type AddChunk struct {
+	Set     string   `json:"set,omitempty"`
+	Entries []string `json:"entries"`
+	// Timeout is the timeout of the entries of the chunk, 0 for the default
+	// of the set.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// AddResponse reports the number of entries an AddStream added.
+type AddResponse struct {
+	Added int `json:"added"`
+}
+
+// Member is one message of a ListStream.
+type Member struct {
+	Entry string `json:"entry"`
+}
+
+// IPSetServer is the server API of the service.
+type IPSetServer interface {
+	Create(context.Context, *CreateRequest) (*Empty, error)
+	Destroy(context.Context, *SetRequest) (*Empty, error)
+	Flush(context.Context, *SetRequest) (*Empty, error)
+	Swap(context.Context, *SwapRequest) (*Empty, error)
+	Add(context.Context, *EntryRequest) (*Empty, error)
+	Del(context.Context, *EntryRequest) (*Empty, error)
+	Test(context.Context, *EntryRequest) (*TestResponse, error)
+	RefreshStream(IPSet_RefreshStreamServer) error
+	ListStream(*SetRequest, IPSet_ListStreamServer) error
+	AddStream(IPSet_AddStreamServer) error
+}
+
+// IPSet_RefreshStreamServer is the server side of a RefreshStream call.
+type IPSet_RefreshStreamServer interface {
+	SendAndClose(*Empty) error
+	Recv() (*RefreshChunk, error)
+	grpc.ServerStream
+}
+
+// IPSet_AddStreamServer is the server side of an AddStream call.
+type IPSet_AddStreamServer interface {
+	SendAndClose(*AddResponse) error
+	Recv() (*AddChunk, error)
+	grpc.ServerStream
+}
+
+// IPSet_ListStreamServer is the server side of a ListStream call.
+type IPSet_ListStreamServer interface {
+	Send(*Member) error
+	grpc.ServerStream
+}
+
+// RegisterIPSetServer registers srv with the gRPC server s, along with the JSON
+// codec of the service, which grpc keeps in a process-wide registry: it
+// replaces any other codec of content-subtype CodecName.
+func RegisterIPSetServer(s *grpc.Server, srv IPSetServer) {
+	registerCodec.Do(func() { encoding.RegisterCodec(jsonCodec{}) })
+	s.RegisterService(&serviceDesc, srv)
+}
+
+func unaryHandler(method string, newReq func() interface{}, call func(IPSetServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newReq()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(IPSetServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(IPSetServer), ctx, req)
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*IPSetServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Create", func() interface{} { return new(CreateRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Create(ctx, in.(*CreateRequest))
+		}),
+		unaryHandler("Destroy", func() interface{} { return new(SetRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Destroy(ctx, in.(*SetRequest))
+		}),
+		unaryHandler("Flush", func() interface{} { return new(SetRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Flush(ctx, in.(*SetRequest))
+		}),
+		unaryHandler("Swap", func() interface{} { return new(SwapRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Swap(ctx, in.(*SwapRequest))
+		}),
+		unaryHandler("Add", func() interface{} { return new(EntryRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Add(ctx, in.(*EntryRequest))
+		}),
+		unaryHandler("Del", func() interface{} { return new(EntryRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Del(ctx, in.(*EntryRequest))
+		}),
+		unaryHandler("Test", func() interface{} { return new(EntryRequest) }, func(s IPSetServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Test(ctx, in.(*EntryRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RefreshStream",
+			Handler:       refreshStreamHandler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ListStream",
+			Handler:       listStreamHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "AddStream",
+			Handler:       addStreamHandler,
+			ClientStreams: true,
+		},
+	},
+}
+
+func refreshStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IPSetServer).RefreshStream(&refreshStreamServer{stream})
+}
+
+type refreshStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *refreshStreamServer) SendAndClose(m *Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *refreshStreamServer) Recv() (*RefreshChunk, error) {
+	m := new(RefreshChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func listStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IPSetServer).ListStream(m, &listStreamServer{stream})
+}
+
+type listStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *listStreamServer) Send(m *Member) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func addStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IPSetServer).AddStream(&addStreamServer{stream})
+}
+
+type addStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *addStreamServer) SendAndClose(m *AddResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *addStreamServer) Recv() (*AddChunk, error) {
+	m := new(AddChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// SessionOptions configures a Session.
+type SessionOptions struct {
+	// MaxPending is the number of unacknowledged commands after which the
+	// session synchronizes on its own, 10000 when zero.
+	MaxPending int
+	// MaxRestarts is how many times the restore process is restarted after
+	// dying before its commands are acknowledged, 3 when zero. The session
+	// then gives up: its operations fail with its LastError.
+	MaxRestarts int
+}
+
+// SessionHealth reports the state of a Session.
+type SessionHealth struct {
+	// Running tells whether a restore process is currently running.
+	Running bool
+	// Pending is the number of commands not acknowledged yet.
+	Pending int
+	// Restarts is the number of times the restore process was restarted
+	// after it died.
+	Restarts int
+	// LastError is the last error of the restore process, nil if none.
+	LastError error
+}
+
+// errSessionClosed is returned by the operations of a closed session.
+var errSessionClosed = errors.New("ipset session closed")
+
+// errSessionFailed is returned by the operations of a session that gave up
+// restarting its restore process, see SessionOptions.MaxRestarts.
+var errSessionFailed = errors.New("ipset session gave up restarting ipset restore")
+
+// Session feeds commands to a long-running `ipset restore` process as they
+// are issued, avoiding the start of an ipset process per command. Commands
+// are acknowledged when the process exits successfully, which Sync causes;
+// until then they are kept. Should the process die, the session restarts it
+// and replays the unacknowledged commands, which is safe as add, del and flush
+// are idempotent under -exist. A command ipset rejects is dropped and its
+// error returned by the operation noticing it. A Session is safe for
+// concurrent use.
+type Session struct {
+	h    *Handle
+	opts SessionOptions
+
+	mu       sync.Mutex
+	proc     *restoreProc
+	pending  []sessionCmd
+	restarts int
+	lastErr  error
+	closed   bool
+}
+
+type sessionCmd struct {
+	line string
+	c    change
+}
+
+// restoreProc is a running `ipset restore` process.
+type restoreProc struct {
+	w      *os.File
+	stderr limitedBuffer
+	// written counts the lines written to the process
+	written int
+	done    chan struct{}
+	err     error
+}
+
+// NewSession returns a session run by the default handle.
+func NewSession(opts SessionOptions) *Session {
+	return defaultHandle.NewSession(opts)
+}
+
+// NewSession returns a session run by the handle. The restore process is
+// started by the first command.
+func (h *Handle) NewSession(opts SessionOptions) *Session {
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 10000
+	}
+	if opts.MaxRestarts <= 0 {
+		opts.MaxRestarts = 3
+	}
+	s := &Session{h: h, opts: opts}
+	h.addSession(s)
+	return s
+}
+
+// Add queues adding the entry to the set.
+func (s *Session) Add(set string, e Entry) error {
+	if err := ValidateEntry(e); err != nil {
+		return err
+	}
+	return s.send("add "+set+" "+e.String(), change{op: opAdd, set: set, entries: []string{e.Element}, nomatch: nomatchElements([]Entry{e})})
+}
+
+// AddTimeout queues adding the entry to the set with a timeout, as Add of IPSet does.
+func (s *Session) AddTimeout(set, entry string, timeout int) error {
+	if err := ValidateElement(entry); err != nil {
+		return err
+	}
+	return s.send("add "+set+" "+entry+" timeout "+strconv.Itoa(timeout),
+		change{op: opAdd, set: set, entries: []string{entry}})
+}
+
+// Del queues deleting the entry from the set.
+func (s *Session) Del(set, entry string) error {
+	if err := ValidateElement(entry); err != nil {
+		return err
+	}
+	return s.send("del "+set+" "+entry, change{op: opDel, set: set, entries: []string{entry}})
+}
+
+// Flush queues removing all entries from the set, once allowed by the
+// destructive policies of the handle.
+func (s *Session) Flush(set string) error {
+	if err := s.h.checkPolicy(Operation{Op: OpFlush, Set: set}); err != nil {
+		return err
+	}
+	return s.send("flush "+set, change{op: opFlush, set: set})
+}
+
+// Sync waits until the queued commands are applied: the restore process is
+// stopped, restarted and replayed as needed, and a new one is started by the
+// next command.
+func (s *Session) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSessionClosed
+	}
+	return s.syncLocked()
+}
+
+// Close synchronizes the session and detaches it from its handle.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	err := s.syncLocked()
+	s.closed = true
+	s.h.removeSession(s)
+	return err
+}
+
+// Health returns the state of the session.
+func (s *Session) Health() SessionHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionHealth{
+		Running:   s.proc != nil,
+		Pending:   len(s.pending),
+		Restarts:  s.restarts,
+		LastError: s.lastErr,
+	}
+}
+
+func (s *Session) send(line string, c change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSessionClosed
+	}
+	// a line break in the name would inject commands in the stream
+	if err := ValidateSetName(c.set); err != nil {
+		return err
+	}
+	if err := s.h.checkOwned(c.set); err != nil {
+		return err
+	}
+	if c.op == opAdd {
+		if err := s.h.checkQuotaAdd(c.set, c.entries); err != nil {
+			return err
+		}
+	}
+	s.pending = append(s.pending, sessionCmd{line: line, c: c})
+	if err := s.deliver(); err != nil {
+		return err
+	}
+	if len(s.pending) >= s.opts.MaxPending {
+		return s.syncLocked()
+	}
+	return nil
+}
+
+// deliver writes the pending commands the current process has not seen yet,
+// starting or restarting it as needed.
+func (s *Session) deliver() error {
+	var rejected error
+	for {
+		if s.proc == nil {
+			if err := s.startLocked(); err != nil {
+				return err
+			}
+		}
+		p := s.proc
+		var err error
+		for p.written < len(s.pending) && err == nil {
+			if _, err = io.WriteString(p.w, s.pending[p.written].line+"\n"); err == nil {
+				p.written++
+			}
+		}
+		if err == nil {
+			return rejected
+		}
+		// the process died: find out why and replay
+		<-p.done
+		if rerr := s.recoverLocked(p); rerr != nil {
+			rejected = rerr
+		}
+	}
+}
+
+// syncLocked stops the current process and waits for it to apply the
+// pending commands, replaying them in new processes if it fails.
+func (s *Session) syncLocked() error {
+	var rejected error
+	for len(s.pending) != 0 {
+		err := s.deliver()
+		p := s.proc
+		if p == nil {
+			// no process could be started
+			return err
+		}
+		if err != nil {
+			rejected = err
+		}
+		p.w.Close()
+		<-p.done
+		if p.err == nil {
+			for _, cmd := range s.pending {
+				s.h.changed(cmd.c)
+			}
+			s.pending = nil
+			s.proc = nil
+			s.restarts = 0
+			return rejected
+		}
+		if rerr := s.recoverLocked(p); rerr != nil {
+			rejected = rerr
+		}
+	}
+	return rejected
+}
+
+// recoverLocked handles the exit of the current process before its commands
+// were acknowledged. If ipset reported a failing line, the commands before it
+// are applied and acknowledged, the failing one is dropped and its error
+// returned; the others are kept for the next process.
+func (s *Session) recoverLocked(p *restoreProc) error {
+	if s.proc != p {
+		return nil
+	}
+	s.proc = nil
+	s.restarts++
+	s.lastErr = fmt.Errorf("%v (%s)", p.err, p.stderr.Bytes())
+	n, ok := parse.ErrorLine(p.stderr.Bytes())
+	if !ok || n < 1 || n > p.written {
+		log.Warnf("ipset restore process died, replaying %d commands: %v", len(s.pending), s.lastErr)
+		return nil
+	}
+	for _, cmd := range s.pending[:n-1] {
+		s.h.changed(cmd.c)
+	}
+	failed := s.pending[n-1]
+	argv := append([]string{ipsetPath}, s.h.args([]string{"restore", "-exist"})...)
+	failed.c.err = commandError(argv, p.err, p.stderr.Bytes())
+	s.h.changed(failed.c)
+	s.pending = s.pending[n:]
+	// the process did not crash: a rejected command is not a restart
+	s.restarts--
+	return fmt.Errorf("error executing %q: %w (%s)", failed.line, failed.c.err, p.stderr.Bytes())
+}
+
+// startLocked starts a restore process and a goroutine noticing its exit,
+// unless the session gave up.
+func (s *Session) startLocked() error {
+	if err := s.giveUpLocked(); err != nil {
+		return err
+	}
+	// a pipe of our own rather than an io.Reader, so that exec does not copy
+	// the input and writes to a process that died fail right away
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("error starting ipset restore: %w", err)
+	}
+	p := &restoreProc{w: w, stderr: limitedBuffer{max: 64 * 1024}, done: make(chan struct{})}
+	// start closes r
+	wait, err := s.h.start(r, &p.stderr, "restore", "-exist")
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("error starting ipset restore: %w", err)
+	}
+	s.proc = p
+	go func() {
+		p.err = wait()
+		close(p.done)
+		if p.err != nil {
+			s.mu.Lock()
+			if s.proc == p && !s.closed {
+				if err := s.recoverLocked(p); err != nil {
+					log.Warnf("ipset session: %v", err)
+				}
+				if err := s.deliver(); err != nil {
+					log.Warnf("ipset session: %v", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// giveUpLocked returns the error of the session once the restore process was
+// restarted more than MaxRestarts times since the commands were last
+// acknowledged, recording it as the last error.
+func (s *Session) giveUpLocked() error {
+	if s.restarts <= s.opts.MaxRestarts {
+		return nil
+	}
+	if !errors.Is(s.lastErr, errSessionFailed) {
+		s.lastErr = fmt.Errorf("%w after %d restarts: %v", errSessionFailed, s.opts.MaxRestarts, s.lastErr)
+	}
+	return s.lastErr
+}
+
+func (h *Handle) addSession(s *Session) {
+	h.mu.Lock()
+	h.sessions = append(h.sessions, s)
+	h.mu.Unlock()
+}
+
+func (h *Handle) removeSession(s *Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.sessions {
+		if h.sessions[i] == s {
+			h.sessions = append(h.sessions[:i], h.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// SessionHealth returns the state of the open sessions of the handle.
+func (h *Handle) SessionHealth() []SessionHealth {
+	h.mu.Lock()
+	sessions := append([]*Session(nil), h.sessions...)
+	h.mu.Unlock()
+	health := make([]SessionHealth, len(sessions))
+	for i, s := range sessions {
+		health[i] = s.Health()
+	}
+	return health
+}
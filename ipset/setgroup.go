@@ -0,0 +1,301 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// GroupMember describes a set of a SetGroup.
+type GroupMember struct {
+	Name   string
+	Type   string
+	Params *Params
+}
+
+// SetGroup manages related sets as a unit, e.g. the IPv4 and IPv6 sets and
+// the port set of one policy: they are created, refreshed, swapped and
+// destroyed together. The refresh and swap of a group run as a single
+// `ipset restore`, so its sets change together.
+type SetGroup struct {
+	// Name names the group in errors and logs.
+	Name string
+	h    *Handle
+	sets []*IPSet
+}
+
+// GroupStatus describes the sets of a group.
+type GroupStatus struct {
+	Name string `json:"name"`
+	// Sets holds the status of each set, in the order of the group.
+	Sets []GroupSetStatus `json:"sets"`
+	// Entries is the number of entries of all the sets.
+	Entries uint64 `json:"entries"`
+}
+
+// GroupSetStatus describes a set of a group.
+type GroupSetStatus struct {
+	Name string `json:"name"`
+	// Exists is false if the set is missing, e.g. destroyed behind the back
+	// of the group.
+	Exists bool  `json:"exists"`
+	Stats  Stats `json:"stats"`
+}
+
+// Healthy reports whether every set of the group exists.
+func (st GroupStatus) Healthy() bool {
+	for _, s := range st.Sets {
+		if !s.Exists {
+			return false
+		}
+	}
+	return true
+}
+
+// NewSetGroup creates the sets of a group, see Handle.NewSetGroup.
+func NewSetGroup(name string, members ...GroupMember) (*SetGroup, error) {
+	return defaultHandle.NewSetGroup(name, members...)
+}
+
+// NewSetGroup creates, or adopts, the sets of the members as Create does and
+// returns the group of them. The sets are created in parallel, see
+// WithConcurrency; a *MultiError tells which could not be. The sets created
+// before a failure are destroyed again, the adopted ones left alone.
+func (h *Handle) NewSetGroup(name string, members ...GroupMember) (*SetGroup, error) {
+	g := &SetGroup{Name: name, h: h, sets: make([]*IPSet, len(members))}
+	names := make([]string, len(members))
+	seen := make(map[string]bool, len(members))
+	for i, m := range members {
+		if seen[m.Name] {
+			return nil, fmt.Errorf("set %s is twice in group %s", m.Name, name)
+		}
+		seen[m.Name] = true
+		names[i] = m.Name
+	}
+	errs := make([]error, len(members))
+	created := make([]bool, len(members))
+	h.parallel(len(members), func(i int) {
+		p := members[i].Params
+		if p == nil {
+			p = &Params{}
+		}
+		g.sets[i], created[i], errs[i] = h.Create(members[i].Name, members[i].Type, p)
+	})
+	if err := itemErrors("error creating the sets of group "+name, names, errs); err != nil {
+		for i, s := range g.sets {
+			if created[i] {
+				if derr := h.destroyIPSet(s.Name); derr != nil {
+					log.Warnf("Error destroying set %s of group %s: %v", s.Name, name, derr)
+				}
+			}
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+// Sets returns the sets of the group.
+func (g *SetGroup) Sets() []*IPSet {
+	return append([]*IPSet(nil), g.sets...)
+}
+
+// Set returns the named set of the group, nil if it has none.
+func (g *SetGroup) Set(name string) *IPSet {
+	for _, s := range g.sets {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (g *SetGroup) names() []string {
+	names := make([]string, len(g.sets))
+	for i, s := range g.sets {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Refresh overwrites every set of the group with its entries in entries,
+// keyed by set name; sets without entries are emptied. Temporary sets are
+// filled and swapped with the sets in a single `ipset restore`, so the sets
+// change together, and an invalid entry leaves them all untouched.
+func (g *SetGroup) Refresh(entries map[string][]Entry) (err error) {
+	for name, es := range entries {
+		if g.Set(name) == nil {
+			return fmt.Errorf("set %s is not in group %s", name, g.Name)
+		}
+		if err := validateEntries(es); err != nil {
+			return err
+		}
+	}
+	h := g.h
+	defer h.lockSets(append(g.names(), g.Name)...)()
+	defer func(start time.Time) {
+		for _, s := range g.sets {
+			h.recordRefresh(s.Name, len(entries[s.Name]), start, err)
+		}
+	}(time.Now())
+	for _, s := range g.sets {
+		if err := h.checkOwned(s.Name); err != nil {
+			return err
+		}
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	counts := make(map[string]int, len(g.sets))
+	for _, s := range g.sets {
+		counts[s.Name] = len(entries[s.Name])
+	}
+	if err := h.checkQuotaAdds(counts, g.names()...); err != nil {
+		return err
+	}
+
+	tmps := make([]string, len(g.sets))
+	for i, s := range g.sets {
+		tmps[i] = tempSetName(s.Name, "-grp")
+		if err := h.checkPolicy(Operation{Op: OpSwap, Set: tmps[i], Other: s.Name}); err != nil {
+			return err
+		}
+	}
+	var batch bytes.Buffer
+	for i, s := range g.sets {
+		batch.WriteString(strings.Join(s.createArgs(tmps[i]), " ") + "\n")
+		batch.WriteString("flush " + tmps[i] + "\n")
+		writeAdds(&batch, tmps[i], entries[s.Name])
+	}
+	for i, s := range g.sets {
+		batch.WriteString("swap " + tmps[i] + " " + s.Name + "\n")
+	}
+	for _, tmp := range tmps {
+		batch.WriteString("destroy " + tmp + "\n")
+	}
+	err = h.restore(&batch)
+	for i, s := range g.sets {
+		h.changed(change{op: opSwap, set: tmps[i], other: s.Name, err: err})
+	}
+	if err != nil {
+		for _, tmp := range tmps {
+			if derr := h.destroyIPSet(tmp); derr != nil {
+				log.Warnf("Error destroying set %s: %v", tmp, derr)
+			}
+		}
+		return fmt.Errorf("error refreshing group %s: %w", g.Name, err)
+	}
+	return nil
+}
+
+// RefreshFrom overwrites the sets of the group with the entries of a
+// combined source, route telling which set of the group each entry goes to,
+// e.g. by address family. The entries are collected before Refresh applies
+// them; an entry routed to a set outside of the group fails the refresh.
+func (g *SetGroup) RefreshFrom(seq EntrySeq, route func(Entry) string) error {
+	entries := make(map[string][]Entry, len(g.sets))
+	var err error
+	seq(func(e Entry, serr error) bool {
+		if serr != nil {
+			err = serr
+			return false
+		}
+		name := route(e)
+		if g.Set(name) == nil {
+			err = fmt.Errorf("entry %s routed to set %q outside of group %s", e.Element, name, g.Name)
+			return false
+		}
+		entries[name] = append(entries[name], e)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return g.Refresh(entries)
+}
+
+// Swap swaps the content of each set of the group with the set at the same
+// position in other, in a single `ipset restore`, e.g. to switch between a
+// live and a staging bundle. The groups must be of the same size and their
+// sets of matching types.
+func (g *SetGroup) Swap(other *SetGroup) error {
+	if len(g.sets) != len(other.sets) {
+		return fmt.Errorf("cannot swap group %s of %d sets with group %s of %d sets", g.Name, len(g.sets), other.Name, len(other.sets))
+	}
+	h := g.h
+	defer h.lockSets(append(append(g.names(), other.names()...), g.Name, other.Name)...)()
+	for i := range g.sets {
+		if err := h.checkOwned(g.sets[i].Name); err != nil {
+			return err
+		}
+		if err := h.checkOwned(other.sets[i].Name); err != nil {
+			return err
+		}
+		if err := h.checkPolicy(Operation{Op: OpSwap, Set: g.sets[i].Name, Other: other.sets[i].Name}); err != nil {
+			return err
+		}
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var batch bytes.Buffer
+	for i := range g.sets {
+		batch.WriteString("swap " + g.sets[i].Name + " " + other.sets[i].Name + "\n")
+	}
+	err = h.restore(&batch)
+	for i := range g.sets {
+		h.changed(change{op: opSwap, set: g.sets[i].Name, other: other.sets[i].Name, err: err})
+	}
+	if err != nil {
+		return fmt.Errorf("error swapping group %s with %s: %w", g.Name, other.Name, err)
+	}
+	return nil
+}
+
+// Destroy destroys every set of the group, trying all of them; a *MultiError
+// tells which could not be destroyed, e.g. as rules still reference them.
+func (g *SetGroup) Destroy() error {
+	errs := make([]error, len(g.sets))
+	for i, s := range g.sets {
+		errs[i] = s.Destroy()
+	}
+	return itemErrors("error destroying the sets of group "+g.Name, g.names(), errs)
+}
+
+// Status returns the statistics of the sets of the group, read from a single
+// listing of all sets.
+func (g *SetGroup) Status() (GroupStatus, error) {
+	all, err := g.h.AllStatistics()
+	if err != nil {
+		return GroupStatus{}, err
+	}
+	st := GroupStatus{Name: g.Name, Sets: make([]GroupSetStatus, len(g.sets))}
+	for i, s := range g.sets {
+		stats, ok := all[s.Name]
+		st.Sets[i] = GroupSetStatus{Name: s.Name, Exists: ok, Stats: stats}
+		st.Entries += stats.Entries
+	}
+	return st, nil
+}
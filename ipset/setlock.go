@@ -0,0 +1,77 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"sort"
+	"sync"
+)
+
+// setLock serializes the mutations of one set within the process.
+type setLock struct {
+	mu sync.Mutex
+	// refs counts the goroutines holding or waiting for the lock, guarded by
+	// the mutex of the handle
+	refs int
+}
+
+// lockSet serializes the mutating operations of the handle on the named set
+// and returns the function ending the critical section. Operations on other
+// sets are not blocked. The lock is not reentrant: operations taking it must
+// not call each other.
+func (h *Handle) lockSet(name string) func() {
+	h.mu.Lock()
+	if h.setLocks == nil {
+		h.setLocks = make(map[string]*setLock)
+	}
+	l := h.setLocks[name]
+	if l == nil {
+		l = &setLock{}
+		h.setLocks[name] = l
+	}
+	l.refs++
+	h.mu.Unlock()
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		h.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(h.setLocks, name)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// lockSets takes the locks of the named sets, in a consistent order so that
+// operations on overlapping sets cannot deadlock, and returns the function
+// releasing them.
+func (h *Handle) lockSets(names ...string) func() {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	var unlocks []func()
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		unlocks = append(unlocks, h.lockSet(name))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// snapshotMagic starts the gzip header comment of snapshot files.
+const snapshotMagic = "go-ipset snapshot sha256="
+
+// maxSetNameLen is the longest set name accepted by the kernel.
+const maxSetNameLen = 31
+
+// SnapshotInfo describes a snapshot file.
+type SnapshotInfo struct {
+	// Set is the name of the saved set.
+	Set string
+	// Time is when the snapshot was taken.
+	Time time.Time
+	// Checksum is the SHA-256 of the saved content, verified on load.
+	Checksum string
+	// Path is the location of the snapshot file.
+	Path string
+}
+
+// Snapshot saves the set header and members, with their options, to a gzip
+// compressed file at path. The content is plain `ipset save` output, so
+// `zcat path | ipset restore` works too; the set name, the time and a checksum
+// are kept in the gzip header. The file is replaced atomically.
+func (s *IPSet) Snapshot(path string) (SnapshotInfo, error) {
+	var buf bytes.Buffer
+	if err := s.handle().save(s.Name, &buf); err != nil {
+		return SnapshotInfo{}, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	info := SnapshotInfo{Set: s.Name, Time: time.Now(), Checksum: hex.EncodeToString(sum[:]), Path: path}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.Remove(f.Name())
+	zw := gzip.NewWriter(f)
+	zw.Name = info.Set
+	zw.ModTime = info.Time
+	zw.Comment = snapshotMagic + info.Checksum
+	_, err = zw.Write(buf.Bytes())
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error writing snapshot of set %s to %s: %w", s.Name, path, err)
+	}
+	return info, nil
+}
+
+// ReadSnapshotInfo returns the description of the snapshot file at path.
+func ReadSnapshotInfo(path string) (SnapshotInfo, error) {
+	info, _, err := readSnapshot(path, false)
+	return info, err
+}
+
+// readSnapshot opens a snapshot and, if content is set, returns its verified content.
+func readSnapshot(path string, content bool) (SnapshotInfo, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SnapshotInfo{}, nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return SnapshotInfo{}, nil, fmt.Errorf("error reading snapshot %s: %w", path, err)
+	}
+	defer zr.Close()
+	if !strings.HasPrefix(zr.Comment, snapshotMagic) {
+		return SnapshotInfo{}, nil, fmt.Errorf("%s is not a set snapshot", path)
+	}
+	info := SnapshotInfo{Set: zr.Name, Time: zr.ModTime, Checksum: strings.TrimPrefix(zr.Comment, snapshotMagic), Path: path}
+	if !content {
+		return info, nil, nil
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return SnapshotInfo{}, nil, fmt.Errorf("error reading snapshot %s: %w", path, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != info.Checksum {
+		return SnapshotInfo{}, nil, fmt.Errorf("snapshot %s is corrupted: checksum mismatch", path)
+	}
+	return info, data, nil
+}
+
+// LoadSnapshot restores the set saved at path, see Handle.LoadSnapshot.
+func LoadSnapshot(path string) (SnapshotInfo, error) {
+	return defaultHandle.LoadSnapshot(path)
+}
+
+// LoadSnapshot restores the set saved at path by Snapshot. The snapshot is loaded
+// into a temporary set which is then swapped with the saved set, created first
+// if missing, so the set changes atomically. The whole operation runs as a single
+// `ipset restore` batch.
+func (h *Handle) LoadSnapshot(path string) (SnapshotInfo, error) {
+	info, data, err := readSnapshot(path, true)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := h.restoreSwapped(info.Set, data); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error loading snapshot %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// restoreSwapped restores `ipset save` output of the named set through a
+// temporary set swapped in at the end. The set itself is only created if it
+// does not exist, as an existing set whose hash grew since would not match
+// the saved header.
+func (h *Handle) restoreSwapped(name string, saved []byte) error {
+	if err := h.checkOwned(name); err != nil {
+		return err
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	exists, err := h.setExists(name)
+	if err != nil {
+		return err
+	}
+	tmp := tempSetName(name, "-snap")
+	if exists {
+		if err := h.checkPolicy(Operation{Op: OpSwap, Set: tmp, Other: name}); err != nil {
+			return err
+		}
+	}
+	var batch bytes.Buffer
+	var create []string
+	sc := bufio.NewScanner(bytes.NewReader(saved))
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		fields := parse.Fields(sc.Text())
+		if len(fields) < 2 || fields[1] != name {
+			continue
+		}
+		switch fields[0] {
+		case "create":
+			create = append([]string(nil), fields...)
+			fields[1] = tmp
+			batch.WriteString(strings.Join(fields, " ") + "\n")
+			batch.WriteString("flush " + tmp + "\n")
+		case "add":
+			fields[1] = tmp
+			batch.WriteString(strings.Join(fields, " ") + "\n")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if create == nil {
+		return fmt.Errorf("no header for set %s", name)
+	}
+	if !exists {
+		batch.WriteString(strings.Join(create, " ") + "\n")
+	}
+	batch.WriteString("swap " + tmp + " " + name + "\n")
+	batch.WriteString("destroy " + tmp + "\n")
+	err = h.restore(&batch)
+	h.changed(change{op: opSwap, set: tmp, other: name, err: err})
+	return err
+}
+
+// tempSetName derives a temporary set name from name and suffix. A name too
+// long for the result to fit the kernel limit on set names is cut and ends
+// with a hash of the full name, as done by SetName, so that names sharing a
+// prefix keep distinct temporary sets.
+func tempSetName(name, suffix string) string {
+	max := maxSetNameLen - len(suffix)
+	if len(name) <= max {
+		return name + suffix
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:max-setNameHashLen-1] + "-" + hex.EncodeToString(sum[:])[:setNameHashLen] + suffix
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTempSetName(t *testing.T) {
+	if got := tempSetName("blocked", "-temp"); got != "blocked-temp" {
+		t.Errorf("short name: got %s, want blocked-temp", got)
+	}
+	prefix := strings.Repeat("a", maxSetNameLen-len("-temp"))
+	seen := make(map[string]string)
+	for _, name := range []string{prefix + "-1", prefix + "-2", prefix + "x", prefix[:20] + "-other-suffix"} {
+		tmp := tempSetName(name, "-temp")
+		if len(tmp) > maxSetNameLen {
+			t.Errorf("temporary set %s of %s is longer than %d characters", tmp, name, maxSetNameLen)
+		}
+		if !strings.HasSuffix(tmp, "-temp") {
+			t.Errorf("temporary set %s of %s lost its suffix", tmp, name)
+		}
+		if other, ok := seen[tmp]; ok {
+			t.Errorf("sets %s and %s share the temporary set %s", other, name, tmp)
+		}
+		seen[tmp] = name
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// SnapshotManager keeps the most recent snapshots of sets in a directory and
+// rolls sets back to one of them, e.g. to revert a bad feed push.
+type SnapshotManager struct {
+	// Dir is the directory holding the snapshot files.
+	Dir string
+	// Keep is the number of snapshots retained per set; 0 keeps all of them.
+	Keep int
+	// Handle restores the snapshots, nil for the default handle.
+	Handle *Handle
+}
+
+// NewSnapshotManager returns a manager keeping the last keep snapshots of each set in dir.
+func NewSnapshotManager(dir string, keep int) *SnapshotManager {
+	return &SnapshotManager{Dir: dir, Keep: keep}
+}
+
+func (m *SnapshotManager) handle() *Handle {
+	if m.Handle == nil {
+		return defaultHandle
+	}
+	return m.Handle
+}
+
+// Take snapshots s into the directory and removes the snapshots of s beyond the
+// retention count.
+func (m *SnapshotManager) Take(s *IPSet) (SnapshotInfo, error) {
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return SnapshotInfo{}, err
+	}
+	path := filepath.Join(m.Dir, fmt.Sprintf("%s.%d.snap.gz", s.Name, time.Now().UnixNano()))
+	info, err := s.Snapshot(path)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return info, m.rotate(s.Name)
+}
+
+// rotate removes the oldest snapshots of the named set beyond the retention count.
+func (m *SnapshotManager) rotate(set string) error {
+	if m.Keep <= 0 {
+		return nil
+	}
+	snaps, err := m.List(set)
+	if err != nil {
+		return err
+	}
+	for _, info := range snaps[min(m.Keep, len(snaps)):] {
+		if err := os.Remove(info.Path); err != nil {
+			return err
+		}
+		log.Debugf("Removed snapshot %s of set %s", info.Path, set)
+	}
+	return nil
+}
+
+// List returns the snapshots of the named set, newest first.
+func (m *SnapshotManager) List(set string) ([]SnapshotInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(m.Dir, globEscape(set)+".*.snap.gz"))
+	if err != nil {
+		return nil, err
+	}
+	var snaps []SnapshotInfo
+	for _, p := range paths {
+		info, err := ReadSnapshotInfo(p)
+		if err != nil {
+			log.Warnf("Skipping unreadable snapshot %s: %v", p, err)
+			continue
+		}
+		// the glob also matches sets whose name starts with set and a dot
+		if info.Set == set {
+			snaps = append(snaps, info)
+		}
+	}
+	// gzip headers keep whole seconds: the nanosecond file names break ties
+	sort.Slice(snaps, func(i, j int) bool {
+		if !snaps[i].Time.Equal(snaps[j].Time) {
+			return snaps[i].Time.After(snaps[j].Time)
+		}
+		return snaps[i].Path > snaps[j].Path
+	})
+	return snaps, nil
+}
+
+// RollbackTo restores the named set from its newest snapshot taken at or before t,
+// swapping it in atomically, and returns the snapshot used.
+func (m *SnapshotManager) RollbackTo(set string, t time.Time) (SnapshotInfo, error) {
+	snaps, err := m.List(set)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	for _, info := range snaps {
+		if !info.Time.After(t) {
+			return m.handle().LoadSnapshot(info.Path)
+		}
+	}
+	return SnapshotInfo{}, fmt.Errorf("no snapshot of set %s taken at or before %s", set, t.Format(time.RFC3339))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// globEscape escapes the glob metacharacters of s.
+func globEscape(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', '\\':
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,126 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapStub is an ipset saving the set app-a from a file next to it and
+// writing the input of restores to another one.
+const snapStub = `#!/bin/sh
+dir="$(dirname "$0")"
+case "$*" in
+"list -n") echo app-a ;;
+save*) cat "$dir/state" ;;
+restore*) cat >"$dir/restored" ;;
+*) cat >/dev/null ;;
+esac
+`
+
+// writeOldSnapshot writes a snapshot of the saved set app-a taken at t to dir.
+func writeOldSnapshot(t *testing.T, dir, saved string, at time.Time) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("app-a.%d.snap.gz", at.UnixNano())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sum := sha256.Sum256([]byte(saved))
+	zw := gzip.NewWriter(f)
+	zw.Name, zw.ModTime, zw.Comment = "app-a", at, snapshotMagic+hex.EncodeToString(sum[:])
+	if _, err := zw.Write([]byte(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotManager(t *testing.T) {
+	h := stubHandle(t, snapStub)
+	stub := filepath.Dir(h.runner[0])
+	dir := t.TempDir()
+	m := NewSnapshotManager(dir, 2)
+	m.Handle = h
+	const header = "create app-a hash:ip family inet hashsize 1024 maxelem 65536\n"
+	writeOldSnapshot(t, dir, header+"add app-a 192.0.2.1\n", time.Now().Add(-time.Hour))
+
+	s := h.Set("app-a")
+	for _, entries := range []string{"add app-a 192.0.2.2\n", "add app-a 192.0.2.3\n"} {
+		if err := ioutil.WriteFile(filepath.Join(stub, "state"), []byte(header+entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Take(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snaps, err := m.List("app-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the old snapshot was rotated out by the second one taken
+	if len(snaps) != 2 || !snaps[0].Time.After(time.Now().Add(-time.Minute)) || !snaps[1].Time.After(time.Now().Add(-time.Minute)) {
+		t.Fatalf("kept %+v, want the two recent snapshots", snaps)
+	}
+
+	info, err := m.RollbackTo("app-a", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Path != snaps[0].Path {
+		t.Errorf("rolled back to %s, want the newest snapshot %s", info.Path, snaps[0].Path)
+	}
+	restored, _ := ioutil.ReadFile(filepath.Join(stub, "restored"))
+	if !strings.Contains(string(restored), "192.0.2.3") || strings.Contains(string(restored), "192.0.2.2") ||
+		!strings.Contains(string(restored), "swap app-a-snap app-a") {
+		t.Errorf("rollback restored %q", restored)
+	}
+
+	if _, err := m.RollbackTo("app-a", time.Now().Add(-time.Minute)); err == nil {
+		t.Error("rolled back to a time before every snapshot")
+	}
+}
+
+func TestSnapshotManagerRollbackTo(t *testing.T) {
+	h := stubHandle(t, snapStub)
+	dir := t.TempDir()
+	m := &SnapshotManager{Dir: dir, Handle: h}
+	const header = "create app-a hash:ip family inet hashsize 1024 maxelem 65536\n"
+	now := time.Now()
+	writeOldSnapshot(t, dir, header+"add app-a 192.0.2.1\n", now.Add(-2*time.Hour))
+	writeOldSnapshot(t, dir, header+"add app-a 192.0.2.2\n", now.Add(-time.Hour))
+	if _, err := m.RollbackTo("app-a", now.Add(-90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(h.runner[0]), "restored"))
+	if !strings.Contains(string(restored), "192.0.2.1") || strings.Contains(string(restored), "192.0.2.2") {
+		t.Errorf("rollback to the older snapshot restored %q", restored)
+	}
+}
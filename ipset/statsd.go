@@ -0,0 +1,96 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// WithStatsd sends metrics about the ipset commands of the handle over UDP
+// to the statsd server at addr, "host:port", each name starting with prefix:
+//
+//	<prefix>.command.<command>          timing of each ipset command
+//	<prefix>.command.<command>.errors   counter of failed commands
+//	<prefix>.refresh.<set>              timing of each refresh
+//	<prefix>.set.<set>.entries          gauge of the entries set by a refresh
+//
+// Metrics are sent without waiting and lost if the server is unreachable.
+func WithStatsd(addr, prefix string) Option {
+	return func(h *Handle) {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			log.Warnf("Error connecting to statsd server %s, metrics disabled: %v", addr, err)
+			return
+		}
+		h.statsd = &statsd{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}
+	}
+}
+
+// statsd emits metrics in the statsd line protocol.
+type statsd struct {
+	conn   net.Conn
+	prefix string
+}
+
+// statsdName replaces the characters of the statsd protocol in a metric
+// name component.
+var statsdName = strings.NewReplacer(":", "_", "|", "_", "@", "_", ".", "_", " ", "_", "\n", "_")
+
+func (s *statsd) send(name, value, kind string) {
+	if s == nil {
+		return
+	}
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+	// errors are ignored: metrics must never fail an operation
+	s.conn.Write([]byte(fmt.Sprintf("%s:%s|%s", name, value, kind)))
+}
+
+func (s *statsd) timing(name string, d time.Duration) {
+	s.send(name, fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond)), "ms")
+}
+
+// observeCommand reports an ipset command with the given arguments, started
+// at start, which failed if err is set.
+func (h *Handle) observeCommand(args []string, start time.Time, err error) {
+	if h.statsd == nil || len(args) == 0 {
+		return
+	}
+	name := "command." + statsdName.Replace(args[0])
+	h.statsd.timing(name, time.Since(start))
+	if err != nil {
+		h.statsd.send(name+".errors", "1", "c")
+	}
+}
+
+// observeRefresh reports a refresh of the set with n entries.
+func (h *Handle) observeRefresh(set string, n int, d time.Duration, err error) {
+	if h.statsd == nil {
+		return
+	}
+	set = statsdName.Replace(set)
+	h.statsd.timing("refresh."+set, d)
+	if err == nil {
+		h.statsd.send("set."+set+".entries", fmt.Sprint(n), "g")
+	}
+}
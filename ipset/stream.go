@@ -0,0 +1,152 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// EntrySeq is a sequence of entries: it calls yield with each entry until
+// yield returns false. A source failing midway yields its error, which ends
+// the sequence. It has the shape of an iter.Seq2[Entry, error].
+type EntrySeq func(yield func(Entry, error) bool)
+
+// EntriesFromSlice returns the sequence of the entries.
+func EntriesFromSlice(entries []Entry) EntrySeq {
+	return func(yield func(Entry, error) bool) {
+		for _, e := range entries {
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
+// EntriesFromChan returns the sequence of the entries received from ch until
+// it is closed.
+func EntriesFromChan(ch <-chan Entry) EntrySeq {
+	return func(yield func(Entry, error) bool) {
+		for e := range ch {
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
+// EntriesFromReader returns the sequence of the entries read from r in the
+// given format, one at a time. Plain lines may carry options after the element,
+// in the syntax of `ipset add`; an option that is unknown or has an invalid
+// value ends the sequence with an ErrInvalidEntry naming its line.
+func EntriesFromReader(r io.Reader, format FileFormat) EntrySeq {
+	return func(yield func(Entry, error) bool) {
+		more := true
+		err := scanEntries(r, format, func(n int, l string) bool {
+			e, err := parseEntryStrict(parse.Fields(l))
+			if err != nil {
+				yield(Entry{}, fmt.Errorf("%s: %w", format.position(n), err))
+				more = false
+				return false
+			}
+			more = yield(e, nil)
+			return more
+		})
+		if err != nil && more {
+			yield(Entry{}, err)
+		}
+	}
+}
+
+// RefreshFrom overwrites the set with the entries of seq, like RefreshEntries,
+// streaming them into `ipset restore` as they are produced instead of holding
+// them in memory. If seq yields an error, or restore fails, the set is left
+// untouched and the error is returned.
+func (s *IPSet) RefreshFrom(seq EntrySeq) (err error) {
+	h := s.handle()
+	defer h.lockSet(s.Name)()
+	n := 0
+	defer func(start time.Time) { h.recordRefresh(s.Name, n, start, err) }(time.Now())
+	if err := h.checkOwned(s.Name); err != nil {
+		return err
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer h.undoable("refresh", s.Name, &err)()
+	tempName := tempSetName(s.Name, "-temp")
+	if err := h.checkPolicy(Operation{Op: OpSwap, Set: tempName, Other: s.Name}); err != nil {
+		return err
+	}
+	if err := s.createHashSet(tempName); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	var srcErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := bufio.NewWriter(pw)
+		_, werr := w.WriteString("flush " + tempName + "\n")
+		seq(func(e Entry, err error) bool {
+			if err == nil {
+				err = ValidateEntry(e)
+			}
+			if err != nil {
+				srcErr = err
+				return false
+			}
+			if werr == nil {
+				_, werr = w.WriteString("add " + tempName + " " + e.String() + "\n")
+				n++
+			}
+			return werr == nil
+		})
+		if werr == nil {
+			werr = w.Flush()
+		}
+		if srcErr != nil {
+			pw.CloseWithError(srcErr)
+			return
+		}
+		pw.CloseWithError(werr)
+	}()
+	err = h.restore(pr)
+	// unblock the producer if restore stopped reading early
+	pr.Close()
+	<-done
+	if srcErr == nil && err == nil {
+		if err := h.swap(tempName, s.Name); err != nil {
+			return err
+		}
+		return h.destroyIPSet(tempName)
+	}
+	if derr := h.destroyIPSet(tempName); derr != nil {
+		log.Warnf("Error destroying set %s: %v", tempName, derr)
+	}
+	if srcErr != nil {
+		return srcErr
+	}
+	return err
+}
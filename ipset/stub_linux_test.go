@@ -0,0 +1,142 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// nullStub is an ipset succeeding without output, so that the cost of
+// running a process per command is measured without a kernel.
+const nullStub = "#!/bin/sh\ncat >/dev/null\n"
+
+// saveStub is an ipset keeping the entries added in a file next to it and
+// printing them back on save, quoting comments as ipset does.
+const saveStub = `#!/bin/sh
+state="$(dirname "$0")/state"
+case "$1" in
+add)
+	line="add $2"
+	shift 2
+	while [ $# -gt 0 ]; do
+		case "$1" in
+		-exist) ;;
+		comment) line="$line comment \"$2\""; shift ;;
+		*) line="$line $1" ;;
+		esac
+		shift
+	done
+	printf '%s\n' "$line" >>"$state"
+	;;
+save) cat "$state" 2>/dev/null ;;
+*) cat >/dev/null ;;
+esac
+`
+
+// existingStub is an ipset where the set shared exists, logging the other
+// commands in a file next to it.
+const existingStub = `#!/bin/sh
+case "$*" in
+"list -n") echo shared ;;
+*list*) printf 'Name: shared\nType: hash:ip\nHeader: family inet hashsize 1024 maxelem 65536\nMembers:\n' ;;
+*) echo "$@" >>"$(dirname "$0")/log"; cat >/dev/null ;;
+esac
+`
+
+// setStub is an ipset with a single set, created by the first create and
+// keeping its entries in a file next to it, each element once with the
+// options it was last added with. The commands are logged in another file.
+const setStub = `#!/bin/sh
+dir="$(dirname "$0")"
+state="$dir/state"
+echo "$@" >>"$dir/log"
+del() { awk -v e="$1" '$1 != e' "$state" >"$state.new" && mv "$state.new" "$state"; }
+add() {
+	del "$1"
+	line=
+	for a in "$@"; do [ "$a" = -exist ] || line="$line $a"; done
+	echo "${line# }" >>"$state"
+}
+case "$*" in
+create*) echo "$2" >"$dir/name"; touch "$state" ;;
+add*) shift 2; add "$@" ;;
+del*) del "$3" ;;
+restore*)
+	while read -r cmd set rest; do
+		case "$cmd" in
+		add) add $rest ;;
+		del) del "${rest%% *}" ;;
+		esac
+	done
+	;;
+"list -n") cat "$dir/name" 2>/dev/null || true ;;
+save*)
+	if [ -f "$state" ]; then
+		echo "create $2 hash:ip family inet hashsize 1024 maxelem 65536"
+		sed "s/^/add $2 /" "$state"
+	fi
+	;;
+"-t list "*)
+	if [ ! -f "$state" ]; then
+		echo "ipset v7.15: The set with the given name does not exist" >&2
+		exit 1
+	fi
+	printf 'Name: %s\nType: hash:ip\nHeader: family inet hashsize 1024 maxelem 65536\nNumber of entries: %d\nMembers:\n' "$3" "$(wc -l <"$state")"
+	;;
+*) cat >/dev/null ;;
+esac
+`
+
+// portStub is an ipset with a hash:ip,port set holding entries in the
+// canonical form ipset lists them in, logging the restored commands in a file
+// next to it.
+const portStub = `#!/bin/sh
+case "$1" in
+save)
+	echo "create $2 hash:ip,port family inet hashsize 1024 maxelem 65536"
+	echo "add $2 192.0.2.1,tcp:80"
+	echo "add $2 198.51.100.1,udp:53"
+	;;
+restore) cat >>"$(dirname "$0")/log" ;;
+*) cat >/dev/null ;;
+esac
+`
+
+// helpStub is an ipset whose help lists an old hash:ip without comment
+// support and a hash:mac naming no feature, logging the other commands in a
+// file next to it.
+const helpStub = `#!/bin/sh
+case "$1" in
+help) printf 'ipset v6.20\n\nSupported set types:\n    hash:ip\t\t1\tcounters support\n    hash:ip\t\t0\tInitial revision\n    hash:mac\t\t0\tInitial revision\n' ;;
+*) echo "$@" >>"$(dirname "$0")/log"; cat >/dev/null ;;
+esac
+`
+
+// stubHandle returns a handle running script as ipset.
+func stubHandle(tb testing.TB, script string, opts ...Option) *Handle {
+	tb.Helper()
+	stub := filepath.Join(tb.TempDir(), "ipset")
+	if err := ioutil.WriteFile(stub, []byte(script), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	return NewHandle(append([]Option{WithRunner(stub)}, opts...)...)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrTemplateNotFound is returned when instantiating a template that was not
+// registered.
+var ErrTemplateNotFound = errors.New("set template not found")
+
+// SetTemplate describes a family of similarly shaped sets, e.g. the deny set
+// of every tenant, so that they are all created with the same type and
+// parameters, extensions such as counters and comments included.
+type SetTemplate struct {
+	// Name identifies the template.
+	Name string
+	Type string
+	// Params are the parameters of the sets, copied for each of them.
+	Params Params
+	// NameFormat derives the name of a set from the instance identifier,
+	// substituted for "{}", e.g. "deny-{}"; "<Name>-{}" when empty.
+	NameFormat string
+}
+
+// SetName returns the name of the set of the template for instance. It fails
+// if the name exceeds the 31 characters ipset allows, rather than truncating
+// it into a name other instances may share.
+func (t SetTemplate) SetName(instance string) (string, error) {
+	format := t.NameFormat
+	if format == "" {
+		format = t.Name + "-{}"
+	}
+	name := strings.Replace(format, "{}", instance, -1)
+	if len(name) > maxSetNameLen {
+		return "", fmt.Errorf("name %s of the set of template %s for %s is longer than %d characters", name, t.Name, instance, maxSetNameLen)
+	}
+	if err := ValidateElement(name); err != nil {
+		return "", fmt.Errorf("invalid name %q of the set of template %s for %s", name, t.Name, instance)
+	}
+	return name, nil
+}
+
+// RegisterTemplate registers a template with the default handle.
+func RegisterTemplate(t SetTemplate) error {
+	return defaultHandle.RegisterTemplate(t)
+}
+
+// RegisterTemplate registers a template with the handle for NewFromTemplate,
+// replacing any template of the same name.
+func (h *Handle) RegisterTemplate(t SetTemplate) error {
+	if t.Name == "" || t.Type == "" {
+		return errors.New("set template name and type are required")
+	}
+	if t.NameFormat != "" && !strings.Contains(t.NameFormat, "{}") {
+		return fmt.Errorf("name format %q of set template %s lacks {}", t.NameFormat, t.Name)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.templates == nil {
+		h.templates = make(map[string]SetTemplate)
+	}
+	h.templates[t.Name] = t
+	return nil
+}
+
+// Template returns the named template of the handle, false if none.
+func (h *Handle) Template(name string) (SetTemplate, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.templates[name]
+	return t, ok
+}
+
+// Templates returns the templates of the handle, sorted by name.
+func (h *Handle) Templates() []SetTemplate {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ts := make([]SetTemplate, 0, len(h.templates))
+	for _, t := range h.templates {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+	return ts
+}
+
+// NewFromTemplate creates the set of a template registered with the default
+// handle, see Handle.NewFromTemplate.
+func NewFromTemplate(template, instance string) (*IPSet, error) {
+	return defaultHandle.NewFromTemplate(template, instance)
+}
+
+// NewFromTemplate creates, or adopts, the set of the named template for
+// instance, e.g. NewFromTemplate("tenant-deny", tenantID), as New does with
+// the type and parameters of the template.
+func (h *Handle) NewFromTemplate(template, instance string) (*IPSet, error) {
+	t, ok := h.Template(template)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, template)
+	}
+	name, err := t.SetName(instance)
+	if err != nil {
+		return nil, err
+	}
+	p := t.Params
+	return h.New(name, t.Type, &p)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewFromTemplate(t *testing.T) {
+	var r NoopRecorder
+	h := NewHandle(WithNoopBackend(&r))
+	if err := h.RegisterTemplate(SetTemplate{Name: "deny", Type: "hash:ip", NameFormat: "deny"}); err == nil {
+		t.Error("name format without {} accepted")
+	}
+	if err := h.RegisterTemplate(SetTemplate{Name: "deny", Type: "hash:ip", Params: Params{Timeout: 300, Counters: true}}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := h.NewFromTemplate("deny", "tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "deny-tenant1" {
+		t.Errorf("set name %s, want deny-tenant1", s.Name)
+	}
+	var create string
+	for _, c := range r.Commands() {
+		if c.Args[0] == "create" {
+			create = c.String()
+		}
+	}
+	for _, want := range []string{"create deny-tenant1 hash:ip", "timeout 300", "counters"} {
+		if !strings.Contains(create, want) {
+			t.Errorf("created with %q, lacking %q", create, want)
+		}
+	}
+
+	if _, err := h.NewFromTemplate("allow", "tenant1"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("unknown template: error %v, want ErrTemplateNotFound", err)
+	}
+	r.Reset()
+	for _, instance := range []string{strings.Repeat("x", 30), "a b"} {
+		if _, err := h.NewFromTemplate("deny", instance); err == nil {
+			t.Errorf("instance %q accepted", instance)
+		}
+	}
+	if cmds := r.Commands(); len(cmds) != 0 {
+		t.Errorf("invalid instances ran %v", cmds)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// WithTestCache enables an in-process cache of Test results for the sets of the
+// handle. Results are kept for at most ttl and the size most recently used ones
+// are retained. Any mutation of a set made through the handle (Add, Del, Refresh,
+// Flush, Swap, Destroy) drops the cached results of that set, since e.g. adding a
+// network to a hash:net set changes the result for every address it contains.
+// Changes made by other processes are only seen once the cached results expire.
+func WithTestCache(ttl time.Duration, size int) Option {
+	return func(h *Handle) {
+		if ttl > 0 && size > 0 {
+			h.testCache = newTestCache(ttl, size)
+		}
+	}
+}
+
+type testCacheKey struct {
+	set   string
+	entry string
+}
+
+type testCacheItem struct {
+	key     testCacheKey
+	found   bool
+	gen     uint64
+	expires time.Time
+}
+
+// testCache is a size bounded LRU cache of Test results with expiry.
+type testCache struct {
+	ttl  time.Duration
+	size int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[testCacheKey]*list.Element
+	// gens holds a generation per set, bumped to invalidate all its results
+	gens map[string]uint64
+	// all is bumped to invalidate the results of every set
+	all uint64
+}
+
+func newTestCache(ttl time.Duration, size int) *testCache {
+	return &testCache{
+		ttl:   ttl,
+		size:  size,
+		lru:   list.New(),
+		items: make(map[testCacheKey]*list.Element),
+		gens:  make(map[string]uint64),
+	}
+}
+
+func (c *testCache) get(set, entry string) (found, ok bool) {
+	if c == nil {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[testCacheKey{set, entry}]
+	if !ok {
+		return false, false
+	}
+	item := el.Value.(*testCacheItem)
+	if item.gen != c.genLocked(set) || time.Now().After(item.expires) {
+		c.lru.Remove(el)
+		delete(c.items, item.key)
+		return false, false
+	}
+	c.lru.MoveToFront(el)
+	return item.found, true
+}
+
+// gen returns the generation of the results of the set, to be read before
+// running the test whose result is put.
+func (c *testCache) gen(set string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.genLocked(set)
+}
+
+func (c *testCache) genLocked(set string) uint64 {
+	return c.gens[set] + c.all
+}
+
+// put caches the result of a test run at generation gen of the set. The
+// result is dropped if the set was invalidated since, as the test may have
+// run before the mutation.
+func (c *testCache) put(set, entry string, found bool, gen uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.genLocked(set) {
+		return
+	}
+	key := testCacheKey{set, entry}
+	item := &testCacheItem{key: key, found: found, gen: gen, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = item
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(item)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*testCacheItem).key)
+	}
+}
+
+// invalidate drops the cached results of the given sets.
+func (c *testCache) invalidate(sets ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	for _, set := range sets {
+		c.gens[set]++
+	}
+	c.mu.Unlock()
+}
+
+// invalidateAll drops every cached result.
+func (c *testCache) invalidateAll() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.all++
+	c.lru.Init()
+	c.items = make(map[testCacheKey]*list.Element)
+	c.mu.Unlock()
+}
@@ -0,0 +1,108 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+	"golang.org/x/sys/unix"
+)
+
+// Netns creates a network namespace for the test and returns a handle,
+// configured by opts, running every command of the ipset utility of the host
+// in it. The sets of the namespace cannot be seen by, nor clash with, those
+// of the host or of other tests; they are destroyed along with the namespace
+// when the test and its subtests complete. The test is skipped without the
+// privilege to create namespaces or without ipset.
+func Netns(t testing.TB, opts ...ipset.Option) *ipset.NamespacedHandle {
+	t.Helper()
+	if err := ipset.Init(""); err != nil {
+		t.Skipf("ipset is not usable: %v", err)
+	}
+	fd, err := newNetns()
+	if errors.Is(err, unix.EPERM) {
+		t.Skipf("no privilege to create a network namespace: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := ipset.NewNamespacedHandleFd(uintptr(fd), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := h.Close(); err != nil {
+			t.Logf("error cleaning up the network namespace of the test: %v", err)
+		}
+	})
+	return h
+}
+
+// newNetns returns a descriptor of a new, unnamed network namespace, which
+// exists until the descriptor and the sets in it are gone.
+func newNetns() (int, error) {
+	type result struct {
+		fd  int
+		err error
+	}
+	ch := make(chan result, 1)
+	// a thread of its own, so that a thread stuck in the new namespace
+	// terminates with the goroutine
+	go func() {
+		runtime.LockOSThread()
+		fd, err := unshareNetns()
+		if err == nil || !errors.Is(err, errStuck) {
+			runtime.UnlockOSThread()
+		}
+		ch <- result{fd, err}
+	}()
+	r := <-ch
+	return r.fd, r.err
+}
+
+var errStuck = errors.New("thread stuck in the new network namespace")
+
+func unshareNetns() (int, error) {
+	self := fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid())
+	orig, err := os.Open(self)
+	if err != nil {
+		return -1, fmt.Errorf("error opening current network namespace: %w", err)
+	}
+	defer orig.Close()
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		return -1, fmt.Errorf("error creating network namespace: %w", err)
+	}
+	fd, err := unix.Open(self, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if serr := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); serr != nil {
+		if err == nil {
+			unix.Close(fd)
+		}
+		return -1, fmt.Errorf("%w: %v", errStuck, serr)
+	}
+	if err != nil {
+		return -1, fmt.Errorf("error opening new network namespace: %w", err)
+	}
+	return fd, nil
+}
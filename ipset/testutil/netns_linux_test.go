@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// TestNetns runs where the host has ipset and the privilege to create
+// network namespaces, and is skipped elsewhere.
+func TestNetns(t *testing.T) {
+	t.Run("netns", func(t *testing.T) {
+		h := Netns(t)
+		s, err := h.New("goipset-netns-test", "hash:ip", &ipset.Params{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Add("192.0.2.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := s.Test("192.0.2.1"); err != nil || !found {
+			t.Errorf("Test() = %v, %v in the namespace", found, err)
+		}
+		// the set is not visible from the host
+		names, err := ipset.ListSetNames(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range names {
+			if n == s.Name {
+				t.Errorf("set %s of the namespace listed on the host", s.Name)
+			}
+		}
+	})
+}
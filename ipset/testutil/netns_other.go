@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// Netns skips the test: network namespaces are a Linux facility.
+func Netns(t testing.TB, opts ...ipset.Option) *ipset.NamespacedHandle {
+	t.Helper()
+	t.Skip("network namespaces are only available on Linux")
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testutil runs integration tests of code using the ipset package
+// against a real ipset, isolated from the sets of the host.
+//
+// Container starts a privileged container with ipset installed, whose
+// network namespace holds the sets, and returns a handle running ipset in
+// it; the container is removed when the test ends:
+//
+//	func TestBlocklist(t *testing.T) {
+//		h := testutil.Container(t, testutil.ContainerOptions{})
+//		s, err := h.New("blocklist", "hash:ip", &ipset.Params{})
+//		...
+//	}
+//
+// Netns runs the ipset utility of the host in a network namespace created
+// for the test instead, which is cheaper but needs the privilege to create
+// namespaces:
+//
+//	h := testutil.Netns(t)
+//
+// Tests are skipped when no container engine is available, or without the
+// privilege, respectively.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// DefaultImage is the image of the container when ContainerOptions.Image and
+// the GOIPSET_TEST_IMAGE environment variable are empty.
+const DefaultImage = "alpine:3"
+
+// defaultSetup installs ipset in DefaultImage.
+const defaultSetup = "apk add --no-cache ipset"
+
+// ContainerOptions configures the container of Container.
+type ContainerOptions struct {
+	// Engine is the container engine command, defaulting to the
+	// GOIPSET_TEST_ENGINE environment variable, then docker or podman,
+	// whichever is found first.
+	Engine string
+	// Image is the image of the container, defaulting to the
+	// GOIPSET_TEST_IMAGE environment variable, then DefaultImage.
+	Image string
+	// Setup is the shell command installing ipset in the container, run
+	// before the tests. It defaults to installing the package of
+	// DefaultImage with that image, and to nothing with another one,
+	// which must then ship ipset.
+	Setup string
+	// Timeout bounds the start of the container, including Setup, one
+	// minute when zero.
+	Timeout time.Duration
+}
+
+// Container starts a privileged container with ipset and returns a handle,
+// configured by opts, running every command in it. The container, and thus
+// its sets, is removed when the test and its subtests complete. The test is
+// skipped if no container engine is found, and fails if the container does
+// not start.
+func Container(t testing.TB, co ContainerOptions, opts ...ipset.Option) *ipset.Handle {
+	t.Helper()
+	engine, err := findEngine(co.Engine)
+	if err != nil {
+		t.Skip(err)
+	}
+	image, setup := co.Image, co.Setup
+	if image == "" {
+		image = os.Getenv("GOIPSET_TEST_IMAGE")
+	}
+	if image == "" {
+		image = DefaultImage
+		if setup == "" {
+			setup = defaultSetup
+		}
+	}
+	timeout := co.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// the container idles until removed; the sets live in its network namespace
+	script := "sleep 2147483647"
+	if setup != "" {
+		script = setup + " >&2 && touch /tmp/goipset-ready && " + script
+	} else {
+		script = "touch /tmp/goipset-ready && " + script
+	}
+	out, err := command(ctx, engine, "run", "-d", "--rm", "--privileged", image, "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("error starting ipset test container: %v (%s)", err, out)
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		if out, err := command(context.Background(), engine, "rm", "-f", id); err != nil {
+			t.Logf("error removing ipset test container %s: %v (%s)", id, err, out)
+		}
+	})
+	for {
+		if _, err := command(ctx, engine, "exec", id, "test", "-e", "/tmp/goipset-ready"); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			logs, _ := command(context.Background(), engine, "logs", id)
+			t.Fatalf("ipset test container %s not ready after %v (%s)", id, timeout, logs)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	h := ipset.NewHandle(append([]ipset.Option{ipset.WithRunner(engine, "exec", "-i", id, "ipset")}, opts...)...)
+	if out, err := command(ctx, engine, "exec", id, "ipset", "list", "-n"); err != nil {
+		t.Fatalf("ipset does not run in test container %s: %v (%s)", id, err, out)
+	}
+	return h
+}
+
+// findEngine returns the container engine to run.
+func findEngine(engine string) (string, error) {
+	if engine == "" {
+		engine = os.Getenv("GOIPSET_TEST_ENGINE")
+	}
+	candidates := []string{"docker", "podman"}
+	if engine != "" {
+		candidates = []string{engine}
+	}
+	for _, c := range candidates {
+		if path, err := exec.LookPath(c); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no container engine found among %s", strings.Join(candidates, ", "))
+}
+
+// command runs the container engine and returns its standard output, or its
+// standard error on failure.
+func command(ctx context.Context, engine string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, engine, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stderr.Bytes(), err
+	}
+	return stdout.Bytes(), nil
+}
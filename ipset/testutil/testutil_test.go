@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/intuitivelabs/go-ipset/ipset"
+)
+
+// engineStub is a container engine logging its arguments next to it and
+// starting container c0ffee.
+const engineStub = `#!/bin/sh
+printf '%s\n' "$*" >>"$(dirname "$0")/calls"
+if [ "$1" = run ]; then
+	echo c0ffee
+fi
+`
+
+func TestContainerNoop(t *testing.T) {
+	dir := t.TempDir()
+	engine := filepath.Join(dir, "engine")
+	if err := ioutil.WriteFile(engine, []byte(engineStub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	calls := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	var r ipset.NoopRecorder
+	t.Run("container", func(t *testing.T) {
+		h := Container(t, ContainerOptions{Engine: engine, Image: "ipset:test"}, ipset.WithNoopBackend(&r))
+		s, err := h.New("blocklist", "hash:ip", &ipset.Params{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Add("192.0.2.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		c := calls()
+		if !strings.Contains(c, "run -d --rm --privileged ipset:test sh -c touch /tmp/goipset-ready") {
+			t.Errorf("container not started as expected:\n%s", c)
+		}
+		if strings.Contains(c, "rm -f") {
+			t.Errorf("container removed while the test runs:\n%s", c)
+		}
+	})
+	if c := calls(); !strings.HasSuffix(c, "rm -f c0ffee\n") {
+		t.Errorf("container not removed after the test:\n%s", c)
+	}
+	var cmds []string
+	for _, c := range r.Commands() {
+		cmds = append(cmds, c.String())
+	}
+	for _, want := range []string{"ipset create blocklist hash:ip", "ipset add blocklist 192.0.2.1 timeout 0 -exist"} {
+		found := false
+		for _, c := range cmds {
+			found = found || strings.HasPrefix(c, want)
+		}
+		if !found {
+			t.Errorf("no %q among the commands run: %q", want, cmds)
+		}
+	}
+}
+
+func TestFindEngine(t *testing.T) {
+	if _, err := findEngine(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing engine found")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// ErrEntryNotFound is returned when an entry looked up in a set is not a member.
+var ErrEntryNotFound = errors.New("entry not found in set")
+
+// TTL returns how long the entry has left before the kernel expires it. The
+// boolean is false for an entry without expiry, i.e. a permanent entry or a set
+// without timeout support. An entry missing from the set yields ErrEntryNotFound.
+func (s *IPSet) TTL(entry string) (time.Duration, bool, error) {
+	entries, err := s.ListEntries()
+	if err != nil {
+		return 0, false, err
+	}
+	want := canonicalElement(entry)
+	for _, e := range entries {
+		if canonicalElement(e.Element) != want {
+			continue
+		}
+		if e.Timeout > 0 {
+			return time.Duration(e.Timeout) * time.Second, true, nil
+		}
+		return 0, false, nil
+	}
+	return 0, false, fmt.Errorf("%w: %s in %s", ErrEntryNotFound, entry, s.Name)
+}
+
+// ExpiringWithin returns the entries whose remaining timeout is below d, the
+// soonest to expire first, e.g. to review or extend blocks before they lapse
+// with Touch. Permanent entries are never returned.
+func (s *IPSet) ExpiringWithin(d time.Duration) ([]Entry, error) {
+	entries, err := s.ListEntries()
+	if err != nil {
+		return nil, err
+	}
+	var expiring []Entry
+	for _, e := range entries {
+		if e.Timeout > 0 && time.Duration(e.Timeout)*time.Second < d {
+			expiring = append(expiring, e)
+		}
+	}
+	sort.SliceStable(expiring, func(i, j int) bool { return expiring[i].Timeout < expiring[j].Timeout })
+	return expiring, nil
+}
+
+// canonicalElement returns the form ipset lists a single address or network
+// in, e.g. "10.0.0.0/8" for "10.1.2.3/8" and "192.0.2.1" for "192.0.2.1/32",
+// and MAC addresses in upper case. Other elements are returned as is.
+func canonicalElement(elem string) string {
+	if ip := net.ParseIP(elem); ip != nil {
+		return ip.String()
+	}
+	if _, n, err := net.ParseCIDR(elem); err == nil {
+		if ones, bits := n.Mask.Size(); ones == bits {
+			return n.IP.String()
+		}
+		return n.String()
+	}
+	if hw, err := ParseMAC(elem); err == nil {
+		return FormatMAC(hw)
+	}
+	if e, err := ParseIPMAC(elem); err == nil {
+		return e.String()
+	}
+	return elem
+}
+
+// Touch extends the lifetime of existing entries by re-adding them with the
+// given timeout, rounded up to a second, in a single `ipset restore` batch.
+// The kernel resets the comment, skbinfo, nomatch and wildcard flags of an
+// entry re-added with -exist, so their current values are read first and
+// passed along; counters are kept by the kernel. Entries that are not in the
+// set, e.g. because they already expired, are ignored rather than re-created.
+// A zero or negative timeout is rejected; see MakePermanent to drop the
+// timeout of entries.
+func (s *IPSet) Touch(entries []string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("invalid timeout %v", timeout)
+	}
+	return s.touch(entries, int((timeout+time.Second-1)/time.Second))
+}
+
+// MakePermanent re-adds existing entries without expiry, keeping their
+// options as Touch does, so that the kernel no longer times them out.
+// Entries that are not in the set are ignored.
+func (s *IPSet) MakePermanent(entries []string) error {
+	return s.touch(entries, TimeoutPermanent)
+}
+
+// touch re-adds the entries of the set among entries with the timeout secs,
+// in seconds or TimeoutPermanent.
+func (s *IPSet) touch(entries []string, secs int) error {
+	defer s.handle().lockSet(s.Name)()
+	current, err := s.ListEntries()
+	if err != nil {
+		return err
+	}
+	byElem := make(map[string]Entry, len(current))
+	for _, e := range current {
+		byElem[canonicalElement(e.Element)] = e
+	}
+	var touched []Entry
+	for _, entry := range entries {
+		e, ok := byElem[canonicalElement(entry)]
+		if !ok {
+			continue
+		}
+		opts := EntryOptions{
+			Timeout:  secs,
+			Comment:  e.Comment,
+			Nomatch:  e.Nomatch,
+			Wildcard: e.Wildcard,
+			SkbMark:  e.SkbMark,
+			SkbPrio:  e.SkbPrio,
+			SkbQueue: e.SkbQueue,
+		}
+		touched = append(touched, Entry{Element: e.Element, EntryOptions: opts})
+	}
+	if len(touched) == 0 {
+		return nil
+	}
+	// the entries are in the set already, so the quota is not checked
+	return s.handle().restoreAdds(s.Name, touched, false)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// TestTuple checks whether the entry is in the set, like Test, formatting its
+// element the way `ipset test` expects it: addresses, MAC addresses and
+// protocol-prefixed ports, ICMP names included, are brought to canonical form
+// and dimensions ipset only accepts on add, port and address ranges, are
+// rejected. With Nomatch set, the test is for a nomatch exception entry of
+// the element rather than for a match, other options are ignored.
+func (s *IPSet) TestTuple(t Entry) (bool, error) {
+	elem, err := testElement(t.Element)
+	if err != nil {
+		return false, err
+	}
+	args := []string{"test", s.Name, elem}
+	key := elem
+	if t.Nomatch {
+		args = append(args, "nomatch")
+		key += " nomatch"
+	}
+	cache := s.handle().testCache
+	if found, ok := cache.get(s.Name, key); ok {
+		return found, nil
+	}
+	gen := cache.gen(s.Name)
+	out, err := s.handle().run(args...)
+	if err != nil && !parse.NotInSet(out) {
+		return false, fmt.Errorf("error testing entry %s: %w (%s)", key, err, out)
+	}
+	found := !parse.NotInSet(out)
+	cache.put(s.Name, key, found, gen)
+	return found, nil
+}
+
+// testElement returns the element with its dimensions in canonical form, or
+// an error for a dimension `ipset test` does not accept.
+func testElement(elem string) (string, error) {
+	if elem == "" {
+		return "", fmt.Errorf("invalid entry: empty element")
+	}
+	dims := strings.Split(elem, ",")
+	for i, d := range dims {
+		switch {
+		case strings.Contains(d, ":") && portProtos[Proto(d[:strings.Index(d, ":")])]:
+			p, err := ParsePort(d)
+			if err != nil {
+				return "", err
+			}
+			if p.IsRange() {
+				return "", fmt.Errorf("invalid entry %s: ipset cannot test port range %s", elem, d)
+			}
+			dims[i] = p.String()
+		case isAddrRange(d):
+			return "", fmt.Errorf("invalid entry %s: ipset cannot test address range %s", elem, d)
+		default:
+			dims[i] = canonicalElement(d)
+		}
+	}
+	return strings.Join(dims, ","), nil
+}
+
+// memberKey returns the form ipset lists the element in, to compare elements
+// given by the caller with the members of a set: the dimensions are brought to
+// canonical form as by testElement, and a numeric port after the first
+// dimension gets the tcp protocol ipset lists it with. Elements testElement
+// rejects, such as ranges, are returned as is.
+func memberKey(elem string) string {
+	key, err := testElement(elem)
+	if err != nil {
+		return elem
+	}
+	dims := strings.Split(key, ",")
+	for i := 1; i < len(dims); i++ {
+		if isNumericPort(dims[i]) {
+			dims[i] = string(ProtoTCP) + ":" + dims[i]
+		}
+	}
+	return strings.Join(dims, ",")
+}
+
+// isNumericPort reports whether the dimension is a port number without
+// protocol.
+func isNumericPort(d string) bool {
+	if d == "" {
+		return false
+	}
+	for _, c := range d {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isAddrRange reports whether the dimension is an address range "ip1-ip2".
+func isAddrRange(d string) bool {
+	i := strings.Index(d, "-")
+	if i < 0 {
+		return false
+	}
+	return net.ParseIP(d[:i]) != nil && net.ParseIP(d[i+1:]) != nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestTestElement(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"192.0.2.1/32", "192.0.2.1"},
+		{"192.0.2.1,80", "192.0.2.1,80"},
+		{"192.0.2.1,udp:53", "192.0.2.1,udp:53"},
+		{"192.0.2.1,icmp:8/0", "192.0.2.1,icmp:echo-request"},
+		{"2001:db8::1,tcp:443", "2001:db8::1,tcp:443"},
+		{"2001:0db8::0001,tcp:443,2001:db8:0::/32", "2001:db8::1,tcp:443,2001:db8::/32"},
+		{"10.1.2.3/8,eth0", "10.0.0.0/8,eth0"},
+		{"192.0.2.1,00:1a:2b:3c:4d:5e", "192.0.2.1,00:1A:2B:3C:4D:5E"},
+	} {
+		if got, err := testElement(tc.in); err != nil || got != tc.want {
+			t.Errorf("testElement(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "192.0.2.1,tcp:80-90", "192.0.2.1-192.0.2.9", "192.0.2.1,192.0.2.2-192.0.2.9"} {
+		if got, err := testElement(in); err == nil {
+			t.Errorf("testElement(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestTestElementServiceName(t *testing.T) {
+	if _, err := net.LookupPort("tcp", "http"); err != nil {
+		t.Skipf("no services database: %v", err)
+	}
+	if got, err := testElement("192.0.2.1,tcp:http"); err != nil || got != "192.0.2.1,tcp:80" {
+		t.Errorf("testElement(192.0.2.1,tcp:http) = %q, %v", got, err)
+	}
+	if a, b := memberKey("192.0.2.1,80"), memberKey("192.0.2.1,tcp:http"); a != b {
+		t.Errorf("member keys %q and %q differ", a, b)
+	}
+}
+
+func TestMemberKey(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"80", "80"},
+		{"192.0.2.1/32,80", "192.0.2.1,tcp:80"},
+		{"192.0.2.1,udp:53", "192.0.2.1,udp:53"},
+		{"10.0.0.0/8,eth0", "10.0.0.0/8,eth0"},
+		{"00:1a:2b:3c:4d:5e", "00:1A:2B:3C:4D:5E"},
+		{"192.0.2.1-192.0.2.9", "192.0.2.1-192.0.2.9"},
+	} {
+		if got := memberKey(tc.in); got != tc.want {
+			t.Errorf("memberKey(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTestTupleNomatch(t *testing.T) {
+	var r NoopRecorder
+	s := &IPSet{Name: "blocked", h: NewHandle(WithNoopBackend(&r))}
+	if _, err := s.TestTuple(Entry{Element: "10.1.2.3/8", EntryOptions: EntryOptions{Nomatch: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.TestTuple(Entry{Element: "10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	cmds := r.Commands()
+	if len(cmds) != 2 {
+		t.Fatalf("ran %v", cmds)
+	}
+	if want := []string{"test", "blocked", "10.0.0.0/8", "nomatch"}; !reflect.DeepEqual(cmds[0].Args, want) {
+		t.Errorf("nomatch test ran %v, want %v", cmds[0].Args, want)
+	}
+	if want := []string{"test", "blocked", "10.0.0.0/8"}; !reflect.DeepEqual(cmds[1].Args, want) {
+		t.Errorf("test ran %v, want %v", cmds[1].Args, want)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intuitivelabs/go-ipset/ipset/internal/parse"
+)
+
+// ErrTypeUnsupported is returned when creating a set of a type the installed
+// ipset utility or the kernel does not support.
+var ErrTypeUnsupported = errors.New("set type not supported")
+
+// TypeRevision is a revision of a set type known to the ipset utility, as
+// listed by `ipset help`.
+type TypeRevision struct {
+	Type     string
+	Revision int
+	// Description tells what the revision added, e.g. "skbinfo support".
+	Description string
+}
+
+// SupportedTypes returns the revisions of the set types the ipset utility
+// supports, as listed by `ipset help`, newest revision first for each type.
+// The kernel may support fewer of them.
+func SupportedTypes() ([]TypeRevision, error) {
+	return defaultHandle.SupportedTypes()
+}
+
+// SupportedTypes returns the revisions of the set types the ipset utility
+// run by the handle supports, listed once and cached by the handle. The no-op
+// backend lists none.
+func (h *Handle) SupportedTypes() ([]TypeRevision, error) {
+	if err := h.initCheck(); err != nil {
+		return nil, err
+	}
+	h.typesOnce.Do(func() {
+		if h.noop != nil || caps.BusyBox {
+			h.typesErr = fmt.Errorf("%w: listing set types", ErrNotSupported)
+			return
+		}
+		// help exits with status 0, but older versions may not; go by the output
+		out, err := h.run("help")
+		h.types = parseSupportedTypes(string(out))
+		if len(h.types) == 0 {
+			h.typesErr = fmt.Errorf("error listing supported set types: %v (%s)", err, out)
+		}
+	})
+	return h.types, h.typesErr
+}
+
+// parseSupportedTypes parses the "Supported set types:" section of `ipset help`.
+func parseSupportedTypes(help string) []TypeRevision {
+	var revs []TypeRevision
+	inTypes := false
+	for _, l := range strings.Split(help, "\n") {
+		if !inTypes {
+			inTypes = strings.HasPrefix(l, "Supported set types:")
+			continue
+		}
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		rev, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		revs = append(revs, TypeRevision{Type: fields[0], Revision: rev, Description: strings.Join(fields[2:], " ")})
+	}
+	sort.SliceStable(revs, func(i, j int) bool {
+		if revs[i].Type != revs[j].Type {
+			return revs[i].Type < revs[j].Type
+		}
+		return revs[i].Revision > revs[j].Revision
+	})
+	return revs
+}
+
+// typeFeatures maps the parameters needing a revision of their own to the
+// word naming it in the revision descriptions of `ipset help`.
+var typeFeatures = []struct {
+	name string
+	set  func(p *Params) bool
+}{
+	{"counters", func(p *Params) bool { return p.Counters }},
+	{"comment", func(p *Params) bool { return p.Comment }},
+	{"forceadd", func(p *Params) bool { return p.ForceAdd }},
+}
+
+// checkType returns ErrTypeUnsupported if the ipset utility of the handle does
+// not know the set type, or no revision of it supporting the features
+// requested by p. Types whose revisions name none of the features, those
+// added once every type had them, are assumed to support them all. Nothing is
+// checked when the supported types cannot be listed.
+func (h *Handle) checkType(settype string, p *Params) error {
+	revs, err := h.SupportedTypes()
+	if err != nil {
+		return nil
+	}
+	var known []string
+	var typeRevs []TypeRevision
+	for _, r := range revs {
+		if r.Type == settype {
+			typeRevs = append(typeRevs, r)
+		}
+		if len(known) == 0 || known[len(known)-1] != r.Type {
+			known = append(known, r.Type)
+		}
+	}
+	if len(typeRevs) == 0 {
+		return fmt.Errorf("%w: %s is unknown to ipset %s, which supports %s; upgrade ipset or pick a supported type",
+			ErrTypeUnsupported, settype, caps.Version, strings.Join(known, ", "))
+	}
+	named := false
+	for _, f := range typeFeatures {
+		named = named || hasFeature(typeRevs, f.name)
+	}
+	for _, f := range typeFeatures {
+		if named && f.set(p) && !hasFeature(typeRevs, f.name) {
+			return fmt.Errorf("%w: no revision of %s known to ipset %s supports %s (newest is %d); upgrade ipset or drop %s",
+				ErrTypeUnsupported, settype, caps.Version, f.name, typeRevs[0].Revision, f.name)
+		}
+	}
+	return nil
+}
+
+// hasFeature reports whether one of the revisions introduced the feature.
+func hasFeature(revs []TypeRevision, feature string) bool {
+	for _, r := range revs {
+		if strings.Contains(strings.ToLower(r.Description), feature) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeUnsupportedError returns an ErrTypeUnsupported error if the output of a
+// failed create tells that the kernel lacks the set type or the revision
+// needed by the requested options, nil otherwise.
+func typeUnsupportedError(settype string, out []byte) error {
+	if !parse.TypeUnsupported(out) {
+		return nil
+	}
+	return fmt.Errorf("%w: the kernel does not support %s with the requested options; load the ip_set_%s module or upgrade the kernel (%s)",
+		ErrTypeUnsupported, settype, strings.NewReplacer(":", "_", ",", "").Replace(settype), strings.TrimSpace(string(out)))
+}
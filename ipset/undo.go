@@ -0,0 +1,315 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// ErrNothingToUndo is returned by Undo when the journal is empty.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// undoJournal holds the inverses of the last mutations of a handle.
+type undoJournal struct {
+	max  int
+	recs []undoRecord
+}
+
+// undoRecord reverts a mutation of sets.
+type undoRecord struct {
+	// desc describes the mutation reverted, e.g. "add 3 entries to blocked"
+	desc string
+	sets []string
+	fn   func() error
+}
+
+// WithUndo makes the handle record how to revert its last depth mutations,
+// for Undo: an add is reverted by deleting the entries it added and adding
+// back those it merely updated with the options they had, a delete by adding
+// the entries back with the nomatch and wildcard flags they were deleted with
+// but without their other options, a swap by swapping again, and a flush,
+// refresh or destroy by restoring the content the set had before. The
+// entries an add updates and the content of a set are saved just before the
+// mutation. Mutations by batches, sessions and set groups are not recorded.
+func WithUndo(depth int) Option {
+	return func(h *Handle) {
+		if depth <= 0 {
+			h.undo = nil
+			return
+		}
+		h.undo = &undoJournal{max: depth}
+	}
+}
+
+// Undo reverts the most recent mutation recorded by the handle, see
+// WithUndo, and returns its description. A failed undo is kept in the
+// journal so that it can be retried.
+func (h *Handle) Undo() (string, error) {
+	h.mu.Lock()
+	if h.undo == nil || len(h.undo.recs) == 0 {
+		h.mu.Unlock()
+		return "", ErrNothingToUndo
+	}
+	rec := h.undo.recs[len(h.undo.recs)-1]
+	h.undo.recs = h.undo.recs[:len(h.undo.recs)-1]
+	h.mu.Unlock()
+
+	err := func() error {
+		defer h.lockSets(rec.sets...)()
+		return rec.fn()
+	}()
+	if err != nil {
+		h.mu.Lock()
+		h.undo.recs = append(h.undo.recs, rec)
+		h.mu.Unlock()
+		return rec.desc, fmt.Errorf("error undoing %s: %w", rec.desc, err)
+	}
+	return rec.desc, nil
+}
+
+// UndoHistory returns the descriptions of the mutations Undo can revert,
+// most recent first.
+func (h *Handle) UndoHistory() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.undo == nil {
+		return nil
+	}
+	descs := make([]string, len(h.undo.recs))
+	for i, rec := range h.undo.recs {
+		descs[len(descs)-1-i] = rec.desc
+	}
+	return descs
+}
+
+func (h *Handle) journal(rec undoRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.undo == nil {
+		return
+	}
+	if len(h.undo.recs) == h.undo.max {
+		h.undo.recs = append(h.undo.recs[:0], h.undo.recs[1:]...)
+	}
+	h.undo.recs = append(h.undo.recs, rec)
+}
+
+func (h *Handle) undoEnabled() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.undo != nil
+}
+
+// entriesDesc describes a number of entries, naming a single one.
+func entriesDesc(entries []string) string {
+	if len(entries) == 1 {
+		return "entry " + entries[0]
+	}
+	return fmt.Sprintf("%d entries", len(entries))
+}
+
+// undoPrior returns the entries of the set about to be added again, by
+// canonical element, nil if undo is disabled or the set cannot be saved.
+func (h *Handle) undoPrior(set string, entries []string) map[string]Entry {
+	if !h.undoEnabled() {
+		return nil
+	}
+	current, err := h.savedEntries(set)
+	if err != nil {
+		log.Warnf("Error saving set %s for undo: %v", set, err)
+		return nil
+	}
+	adding := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		adding[canonicalElement(e)] = true
+	}
+	prior := make(map[string]Entry)
+	for _, e := range current {
+		if elem := canonicalElement(e.Element); adding[elem] {
+			prior[elem] = e
+		}
+	}
+	return prior
+}
+
+// journalAdds records that the entries were added to the set, which held the
+// prior ones returned by undoPrior beforehand.
+func (h *Handle) journalAdds(set string, prior map[string]Entry, entries ...string) {
+	if !h.undoEnabled() {
+		return
+	}
+	h.journal(undoRecord{
+		desc: "add " + entriesDesc(entries) + " to " + set,
+		sets: []string{set},
+		fn: func() error {
+			b := h.NewBatch()
+			for _, e := range entries {
+				if old, ok := prior[canonicalElement(e)]; ok {
+					b.Add(set, old)
+				} else {
+					b.Del(set, e)
+				}
+			}
+			return b.Commit()
+		},
+	})
+}
+
+// journalDels records that the entries were deleted from the set with opts.
+func (h *Handle) journalDels(set string, opts DelOptions, entries ...string) {
+	if !h.undoEnabled() {
+		return
+	}
+	h.journal(undoRecord{
+		desc: "del " + entriesDesc(entries) + " from " + set,
+		sets: []string{set},
+		fn: func() error {
+			b := h.NewBatch()
+			flags := EntryOptions{Nomatch: opts.Nomatch, Wildcard: opts.Wildcard}
+			for _, e := range entries {
+				b.Add(set, Entry{Element: e, EntryOptions: flags})
+			}
+			return b.Commit()
+		},
+	})
+}
+
+// journalSwap records that the sets were swapped.
+func (h *Handle) journalSwap(from, to string) {
+	if !h.undoEnabled() {
+		return
+	}
+	h.journal(undoRecord{
+		desc: "swap " + from + " " + to,
+		sets: []string{from, to},
+		fn: func() error {
+			if err := h.checkPolicy(Operation{Op: OpSwap, Set: from, Other: to}); err != nil {
+				return err
+			}
+			return h.swap(from, to)
+		},
+	})
+}
+
+// undoSaved returns the content of the set before a mutation replacing it,
+// nil if undo is disabled or the set cannot be saved.
+func (h *Handle) undoSaved(set string) []byte {
+	if !h.undoEnabled() {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := h.save(set, &buf); err != nil {
+		log.Warnf("Error saving set %s for undo: %v", set, err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// journalRestore records that the set saved beforehand was replaced by op,
+// e.g. "flush".
+func (h *Handle) journalRestore(op, set string, saved []byte) {
+	if saved == nil {
+		return
+	}
+	h.journal(undoRecord{
+		desc: strings.TrimSpace(op + " " + set),
+		sets: []string{set},
+		fn:   func() error { return h.restoreSwapped(set, saved) },
+	})
+}
+
+// undoable saves the set before a mutation replacing it, op, and returns a
+// function journaling its undo if *err is nil by then, to be deferred.
+func (h *Handle) undoable(op, set string, err *error) func() {
+	saved := h.undoSaved(set)
+	return func() {
+		if *err == nil {
+			h.journalRestore(op, set, saved)
+		}
+	}
+}
+
+// undoSavedSets saves the sets the changes touch before they are made, an
+// existing set with its content and a missing one as nil. It returns nil if
+// undo is disabled.
+func (h *Handle) undoSavedSets(changes []change) (map[string][]byte, error) {
+	if !h.undoEnabled() || len(changes) == 0 {
+		return nil, nil
+	}
+	names, err := h.listAllSetNames()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = true
+	}
+	saved := make(map[string][]byte)
+	for _, c := range changes {
+		for _, set := range []string{c.set, c.other} {
+			if _, ok := saved[set]; ok || set == "" {
+				continue
+			}
+			saved[set] = nil
+			if exists[set] {
+				if saved[set] = h.undoSaved(set); saved[set] == nil {
+					// cannot be reverted, do not record a partial undo
+					return nil, nil
+				}
+			}
+		}
+	}
+	return saved, nil
+}
+
+// journalSets records that the sets saved by undoSavedSets were changed by
+// op: the existing ones are restored and the others destroyed.
+func (h *Handle) journalSets(op string, saved map[string][]byte) {
+	if len(saved) == 0 {
+		return
+	}
+	sets := make([]string, 0, len(saved))
+	for set := range saved {
+		sets = append(sets, set)
+	}
+	sort.Strings(sets)
+	desc := op + " of " + sets[0]
+	if len(sets) > 1 {
+		desc = fmt.Sprintf("%s of %d sets", op, len(sets))
+	}
+	h.journal(undoRecord{
+		desc: desc,
+		sets: sets,
+		fn: func() error {
+			var errs []error
+			for _, set := range sets {
+				if saved[set] == nil {
+					errs = append(errs, h.destroyChecked(set))
+				} else {
+					errs = append(errs, h.restoreSwapped(set, saved[set]))
+				}
+			}
+			return itemErrors("error undoing "+op, sets, errs)
+		},
+	})
+}
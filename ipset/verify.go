@@ -0,0 +1,84 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+// Report tells how the content of a set differs from the expected one, as
+// found by Verify.
+type Report struct {
+	// Missing lists the expected entries not in the set.
+	Missing []Entry
+	// Unexpected lists the entries of the set not expected, with their options.
+	Unexpected []Entry
+	// Mismatched lists the entries in the set whose options differ from the
+	// expected ones.
+	Mismatched []Mismatch
+}
+
+// Mismatch is an entry whose options differ from the expected ones.
+type Mismatch struct {
+	// Expected is the entry as expected, Actual as found in the set.
+	Expected, Actual Entry
+	// Fields names the options differing, e.g. "timeout" or "comment".
+	Fields []string
+}
+
+// Consistent reports whether the set holds the expected entries only.
+func (r Report) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Unexpected) == 0 && len(r.Mismatched) == 0
+}
+
+// Verify compares the set with the expected entries and reports the missing,
+// unexpected and mismatched ones, e.g. to detect changes made behind the back
+// of a controller. Options are compared as by EnsureEntry: a zero Timeout is
+// not checked, a remaining timeout not exceeding the expected one matches and
+// counters are ignored. Elements are compared in their canonical form. The
+// set is read from a single `ipset save`.
+func (s *IPSet) Verify(expected []Entry) (Report, error) {
+	current, err := s.handle().savedEntries(s.Name)
+	if err != nil {
+		return Report{}, err
+	}
+	return verify(current, expected), nil
+}
+
+// verify compares the entries of a set with the expected ones.
+func verify(current, expected []Entry) Report {
+	have := make(map[string]Entry, len(current))
+	for _, e := range current {
+		have[e.Element] = e
+	}
+	var r Report
+	want := make(map[string]bool, len(expected))
+	for _, e := range expected {
+		elem := canonicalElement(e.Element)
+		want[elem] = true
+		cur, ok := have[elem]
+		if !ok {
+			r.Missing = append(r.Missing, e)
+			continue
+		}
+		if d := drift(cur.EntryOptions, e.EntryOptions); len(d) != 0 {
+			r.Mismatched = append(r.Mismatched, Mismatch{Expected: e, Actual: cur, Fields: d})
+		}
+	}
+	for _, e := range current {
+		if !want[e.Element] {
+			r.Unexpected = append(r.Unexpected, e)
+		}
+	}
+	return r
+}
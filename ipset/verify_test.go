@@ -0,0 +1,65 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	current := []Entry{
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{Timeout: 30}},
+		{Element: "192.0.2.2", EntryOptions: EntryOptions{Comment: "old"}},
+		{Element: "192.0.2.3"},
+		{Element: "10.0.0.0/8", EntryOptions: EntryOptions{Nomatch: true}},
+		{Element: "198.51.100.7"},
+	}
+	expected := []Entry{
+		{Element: "192.0.2.1", EntryOptions: EntryOptions{Timeout: 60}},
+		{Element: "192.0.2.2", EntryOptions: EntryOptions{Comment: "new"}},
+		{Element: "192.0.2.3/32", EntryOptions: EntryOptions{Timeout: TimeoutPermanent}},
+		{Element: "10.0.0.0/8"},
+		{Element: "203.0.113.1"},
+	}
+	r := verify(current, expected)
+	if r.Consistent() {
+		t.Fatal("differing sets reported consistent")
+	}
+	if want := []Entry{expected[4]}; !reflect.DeepEqual(r.Missing, want) {
+		t.Errorf("missing %+v, want %+v", r.Missing, want)
+	}
+	if want := []Entry{current[4]}; !reflect.DeepEqual(r.Unexpected, want) {
+		t.Errorf("unexpected %+v, want %+v", r.Unexpected, want)
+	}
+	want := []Mismatch{
+		{Expected: expected[1], Actual: current[1], Fields: []string{"comment"}},
+		{Expected: expected[3], Actual: current[3], Fields: []string{"nomatch"}},
+	}
+	if !reflect.DeepEqual(r.Mismatched, want) {
+		t.Errorf("mismatched %+v, want %+v", r.Mismatched, want)
+	}
+	if r := verify(current[:1], []Entry{{Element: "192.0.2.1", EntryOptions: EntryOptions{Timeout: 10}}}); len(r.Mismatched) != 1 || r.Mismatched[0].Fields[0] != "timeout" {
+		t.Errorf("remaining timeout above the expected one not reported: %+v", r)
+	}
+	if r := verify(current[:1], []Entry{{Element: "192.0.2.1", EntryOptions: EntryOptions{Timeout: TimeoutPermanent}}}); len(r.Mismatched) != 1 {
+		t.Errorf("expiring entry expected permanent not reported: %+v", r)
+	}
+	if r := verify(current, current); !r.Consistent() {
+		t.Errorf("identical sets reported inconsistent: %+v", r)
+	}
+}
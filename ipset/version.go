@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// VersionPolicy tells which ipset utility versions are accepted.
+type VersionPolicy struct {
+	// MinVersion is the oldest accepted version, e.g. "7.11". Defaults to 6.0.
+	MinVersion string
+	// Strict makes a version that cannot be detected an error instead of a
+	// warning, including the BusyBox applet which does not report one.
+	Strict bool
+}
+
+var (
+	versionPolicy = VersionPolicy{MinVersion: minIpsetVersion}
+	// versionErr is the outcome of the version check of the utility found
+	versionErr error
+)
+
+// SetVersionPolicy replaces the policy checked when the ipset utility is first
+// used. If it is already in use, its version is checked again and an error
+// returned by every operation from then on if it does not comply.
+func SetVersionPolicy(p VersionPolicy) error {
+	if p.MinVersion == "" {
+		p.MinVersion = minIpsetVersion
+	}
+	v := strings.TrimPrefix(p.MinVersion, "v")
+	if strings.Count(v, ".") == 1 {
+		v += ".0"
+	}
+	if err := validVersion(v); err != nil {
+		return fmt.Errorf("invalid minimum ipset version %s: %w", p.MinVersion, err)
+	}
+	p.MinVersion = v
+	versionPolicy = p
+	if ipsetPath == "" {
+		return nil
+	}
+	versionErr = checkVersion()
+	return versionErr
+}
+
+// checkVersion checks the version of the utility at ipsetPath against the policy.
+func checkVersion() error {
+	if caps.BusyBox {
+		// the BusyBox applet reports the BusyBox version, not the ipset one
+		if versionPolicy.Strict {
+			return fmt.Errorf("%w: BusyBox ipset applet at %s does not report its version", errIpsetNotSupported, ipsetPath)
+		}
+		log.Warnf("BusyBox ipset applet detected at %s, running in compatibility mode", ipsetPath)
+		return nil
+	}
+	supportedVersion, err := getIpsetSupportedVersion()
+	if err != nil {
+		if versionPolicy.Strict {
+			return fmt.Errorf("error checking ipset version: %w", err)
+		}
+		log.Warnf("Error checking ipset version, assuming version at least %s: %v", versionPolicy.MinVersion, err)
+		return nil
+	}
+	if !supportedVersion {
+		return fmt.Errorf("%w, requiring version >= %s", errIpsetNotSupported, versionPolicy.MinVersion)
+	}
+	return nil
+}
+
+// versionFeatures maps the first ipset version providing a feature to the
+// capability it enables.
+var versionFeatures = []struct {
+	major, minor int
+	enable       func(*Capabilities)
+}{
+	{6, 22, func(c *Capabilities) { c.FileOption = true }},
+	{7, 11, func(c *Capabilities) { c.BucketSize = true }},
+	{7, 16, func(c *Capabilities) { c.JSONOutput = true }},
+}
+
+// setVersionFeatures enables the capabilities provided by the version of c,
+// of the form "vX.Y" as returned by parseVersionString.
+func (c *Capabilities) setVersionFeatures() {
+	var major, minor int
+	if _, err := fmt.Sscanf(c.Version, "v%d.%d", &major, &minor); err != nil {
+		return
+	}
+	for _, f := range versionFeatures {
+		if major > f.major || (major == f.major && minor >= f.minor) {
+			f.enable(c)
+		}
+	}
+}
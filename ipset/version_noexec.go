@@ -0,0 +1,57 @@
+//go:build ipset_noexec
+// +build ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "fmt"
+
+// validVersion checks that v is a dotted-tri version.
+func validVersion(v string) error {
+	_, err := versionNumbers(v)
+	return err
+}
+
+// versionAtLeast reports whether the dotted-tri version v is min or newer.
+func versionAtLeast(v, min string) (bool, error) {
+	version, err := versionNumbers(v)
+	if err != nil {
+		return false, err
+	}
+	minVersion, err := versionNumbers(min)
+	if err != nil {
+		return false, err
+	}
+	for i := range version {
+		if version[i] != minVersion[i] {
+			return version[i] > minVersion[i], nil
+		}
+	}
+	return true, nil
+}
+
+// versionNumbers parses the numbers of a dotted-tri version, without the
+// go-semver dependency of the exec backend.
+func versionNumbers(v string) ([3]int, error) {
+	var n [3]int
+	var rest string
+	if c, _ := fmt.Sscanf(v, "%d.%d.%d%s", &n[0], &n[1], &n[2], &rest); c < 3 || rest != "" {
+		return n, fmt.Errorf("invalid version %q", v)
+	}
+	return n, nil
+}
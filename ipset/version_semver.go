@@ -0,0 +1,41 @@
+//go:build !ipset_noexec
+// +build !ipset_noexec
+
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "github.com/coreos/go-semver/semver"
+
+// validVersion checks that v is a dotted-tri version.
+func validVersion(v string) error {
+	_, err := semver.NewVersion(v)
+	return err
+}
+
+// versionAtLeast reports whether the dotted-tri version v is min or newer.
+func versionAtLeast(v, min string) (bool, error) {
+	minVersion, err := semver.NewVersion(min)
+	if err != nil {
+		return false, err
+	}
+	version, err := semver.NewVersion(v)
+	if err != nil {
+		return false, err
+	}
+	return !version.LessThan(*minVersion), nil
+}
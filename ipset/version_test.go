@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import "testing"
+
+// distroVersions holds the `ipset version` output of the ipset packages of
+// major distributions.
+var distroVersions = []struct {
+	distro string
+	out    string
+	want   Capabilities
+}{
+	{"Debian 10", "ipset v7.1, protocol version: 7\n",
+		Capabilities{Version: "v7.1", FileOption: true}},
+	{"Debian 11", "ipset v7.10, protocol version: 7\n",
+		Capabilities{Version: "v7.10", FileOption: true}},
+	{"Debian 12", "ipset v7.17, protocol version: 7\n",
+		Capabilities{Version: "v7.17", FileOption: true, BucketSize: true, JSONOutput: true}},
+	{"RHEL 7", "ipset v7.1, protocol version: 7\n",
+		Capabilities{Version: "v7.1", FileOption: true}},
+	{"RHEL 9", "ipset v7.11, protocol version: 7\n",
+		Capabilities{Version: "v7.11", FileOption: true, BucketSize: true}},
+	{"Alpine 3.18", "ipset v7.17, protocol version: 7\n",
+		Capabilities{Version: "v7.17", FileOption: true, BucketSize: true, JSONOutput: true}},
+	{"Ubuntu 18.04", "ipset v6.34, protocol version: 6\n",
+		Capabilities{Version: "v6.34", FileOption: true}},
+	{"Ubuntu 20.04", "ipset v7.5, protocol version: 7\n",
+		Capabilities{Version: "v7.5", FileOption: true}},
+	{"Ubuntu 22.04 on an older kernel",
+		"ipset v7.15, protocol version: 7\nWarning: Kernel support protocol versions 6-6 while userspace supports protocol versions 6-7\n",
+		Capabilities{Version: "v7.15", FileOption: true, BucketSize: true}},
+	{"Ubuntu 24.04", "ipset v7.19, protocol version: 7\n",
+		Capabilities{Version: "v7.19", FileOption: true, BucketSize: true, JSONOutput: true}},
+}
+
+func TestDistroVersions(t *testing.T) {
+	for _, tt := range distroVersions {
+		v, err := parseVersionString([]byte(tt.out))
+		if err != nil {
+			t.Errorf("%s: %v", tt.distro, err)
+			continue
+		}
+		c := Capabilities{Version: v}
+		c.setVersionFeatures()
+		if c != tt.want {
+			t.Errorf("%s: capabilities %+v, want %+v", tt.distro, c, tt.want)
+		}
+		ok, err := versionAtLeast(v[1:]+".0", minIpsetVersion)
+		if err != nil || !ok {
+			t.Errorf("%s: version %s not accepted: %v", tt.distro, v, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2015 Jan Broer All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/intuitivelabs/go-ipset/ipset/internal/logger"
+)
+
+// NewMirrorFromCache returns a Mirror of s loaded from the snapshot at path,
+// as written by IPSet.Snapshot (e.g. on shutdown), rather than from the
+// kernel, so that a restarting daemon serves lookups right away. The snapshot
+// is validated in the background against the Checksum of the set, the mirror
+// being resynchronized if they differ, then the mirror is resynchronized at
+// interval as NewMirror does. If the snapshot cannot be read or is not one of
+// s, the mirror is loaded from the kernel instead.
+func NewMirrorFromCache(s *IPSet, path string, interval time.Duration) (*Mirror, error) {
+	_, entries, info, sum, err := readCache(s, path)
+	if err != nil {
+		log.Debugf("Not warm starting mirror of set %s: %v", s.Name, err)
+		return NewMirror(s, interval)
+	}
+	m := &Mirror{set: s, stop: make(chan struct{}), done: make(chan struct{})}
+	m.setMembers(elements(entries))
+	m.lastSync = info.Time
+	s.handle().addMirror(m)
+	go func() {
+		if !cacheValid(s, sum) {
+			if err := m.Resync(); err != nil {
+				log.Warnf("Error resynchronizing mirror of set %s: %v", s.Name, err)
+			}
+		}
+		if interval > 0 {
+			m.loop(interval)
+		} else {
+			close(m.done)
+		}
+	}()
+	return m, nil
+}
+
+// NewLookupMirrorFromCache returns a LookupMirror of s loaded from the
+// snapshot at path, as NewMirrorFromCache does.
+func NewLookupMirrorFromCache(s *IPSet, path string, interval time.Duration) (*LookupMirror, error) {
+	hd, entries, info, sum, err := readCache(s, path)
+	if err != nil {
+		log.Debugf("Not warm starting lookup mirror of set %s: %v", s.Name, err)
+		return NewLookupMirror(s, interval)
+	}
+	m := &LookupMirror{set: s, stop: make(chan struct{}), done: make(chan struct{})}
+	m.load(hd, entries)
+	m.lastSync = info.Time
+	s.handle().addLookupMirror(m)
+	go func() {
+		if !cacheValid(s, sum) {
+			if err := m.Resync(); err != nil {
+				log.Warnf("Error resynchronizing lookup mirror of set %s: %v", s.Name, err)
+			}
+		}
+		if interval > 0 {
+			m.loop(interval)
+		} else {
+			close(m.done)
+		}
+	}()
+	return m, nil
+}
+
+// readCache returns the header and the entries of s saved in the snapshot at
+// path, the description of the snapshot and the checksum of its content.
+func readCache(s *IPSet, path string) (Header, []Entry, SnapshotInfo, string, error) {
+	info, data, err := readSnapshot(path, true)
+	if err != nil {
+		return Header{}, nil, SnapshotInfo{}, "", err
+	}
+	if info.Set != s.Name {
+		return Header{}, nil, SnapshotInfo{}, "", fmt.Errorf("snapshot %s is of set %s", path, info.Set)
+	}
+	sets, err := ParseSave(data)
+	if err != nil {
+		return Header{}, nil, SnapshotInfo{}, "", fmt.Errorf("error reading snapshot %s: %w", path, err)
+	}
+	sum, err := saveChecksum(data)
+	if err != nil {
+		return Header{}, nil, SnapshotInfo{}, "", fmt.Errorf("error reading snapshot %s: %w", path, err)
+	}
+	for _, saved := range sets {
+		if saved.Header.Name == s.Name {
+			return saved.Header, saved.Entries, info, sum, nil
+		}
+	}
+	return Header{}, nil, SnapshotInfo{}, "", fmt.Errorf("snapshot %s does not hold set %s", path, s.Name)
+}
+
+// cacheValid reports whether the set still has the checksum of a cache.
+func cacheValid(s *IPSet, sum string) bool {
+	have, err := s.Checksum()
+	if err != nil {
+		log.Warnf("Error validating the cached copy of set %s: %v", s.Name, err)
+		return false
+	}
+	return have == sum
+}